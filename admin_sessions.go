@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// sessionAdmin serves the admin API to list and revoke server side sessions.
+// Requests are authenticated with the admin token as a bearer token rather
+// than with a session.
+//
+//	GET    <path>?user=<user>  lists the sessions of the user
+//	GET    <path>              lists every user with a session
+//	DELETE <path>?user=<user>  revokes every session of the user
+//	DELETE <path>/<id>         revokes a single session
+type sessionAdmin struct {
+	path  string
+	token []byte
+	store sessionsapi.SessionStore
+}
+
+// newSessionAdmin creates the admin API handler for the sessions under the
+// path. It returns nil when no admin token is configured.
+func newSessionAdmin(path string, token string, store sessionsapi.SessionStore) *sessionAdmin {
+	if token == "" {
+		return nil
+	}
+	return &sessionAdmin{
+		path:  path,
+		token: []byte(token),
+		store: store,
+	}
+}
+
+// matches checks whether the request path is handled by the admin API
+func (a *sessionAdmin) matches(path string) bool {
+	return path == a.path || strings.HasPrefix(path, a.path+"/")
+}
+
+func (a *sessionAdmin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !a.authenticated(req) {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Invalid admin token for %s", req.URL.Path)
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	admin, ok := a.store.(sessionsapi.SessionAdministrator)
+	if !ok {
+		http.Error(rw, "session administration requires a server side session store", http.StatusNotImplemented)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, a.path), "/")
+	user := req.URL.Query().Get("user")
+	switch {
+	case req.Method == http.MethodGet && id == "" && user == "":
+		a.listUsers(rw, req, admin)
+	case req.Method == http.MethodGet && id == "":
+		a.listSessions(rw, req, admin, user)
+	case req.Method == http.MethodDelete && id != "":
+		a.revokeSession(rw, req, admin, id)
+	case req.Method == http.MethodDelete && user != "":
+		a.revokeUserSessions(rw, req, admin, user)
+	case req.Method == http.MethodGet || req.Method == http.MethodDelete:
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	default:
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticated checks the bearer token of the request against the admin
+// token in constant time
+func (a *sessionAdmin) authenticated(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	return subtle.ConstantTimeCompare(token, a.token) == 1
+}
+
+func (a *sessionAdmin) listUsers(rw http.ResponseWriter, req *http.Request, admin sessionsapi.SessionAdministrator) {
+	users, err := admin.ListUsers(req.Context())
+	if errors.Is(err, sessionsapi.ErrListingNotSupported) {
+		http.Error(rw, "listing every user is not supported by the session store, set the user parameter", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		logger.Printf("Error listing users with sessions: %v", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminJSON(rw, http.StatusOK, struct {
+		Users []string `json:"users"`
+	}{Users: users})
+}
+
+func (a *sessionAdmin) listSessions(rw http.ResponseWriter, req *http.Request, admin sessionsapi.SessionAdministrator, user string) {
+	infos, err := admin.ListSessions(req.Context(), user)
+	if err != nil {
+		logger.Printf("Error listing sessions of %s: %v", user, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminJSON(rw, http.StatusOK, struct {
+		User     string                    `json:"user"`
+		Sessions []sessionsapi.SessionInfo `json:"sessions"`
+	}{User: user, Sessions: infos})
+}
+
+func (a *sessionAdmin) revokeSession(rw http.ResponseWriter, req *http.Request, admin sessionsapi.SessionAdministrator, id string) {
+	err := admin.RevokeSession(req.Context(), id)
+	if errors.Is(err, sessionsapi.ErrInvalidSessionID) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Printf("Error revoking session %s: %v", id, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("Revoked session %s through the admin API", id)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *sessionAdmin) revokeUserSessions(rw http.ResponseWriter, req *http.Request, admin sessionsapi.SessionAdministrator, user string) {
+	revoked, err := admin.RevokeUserSessions(req.Context(), user)
+	if err != nil {
+		logger.Printf("Error revoking sessions of %s: %v", user, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Printf("Revoked %d sessions of %s through the admin API", revoked, user)
+	writeAdminJSON(rw, http.StatusOK, struct {
+		User    string `json:"user"`
+		Revoked int    `json:"revoked"`
+	}{User: user, Revoked: revoked})
+}
+
+// writeAdminJSON writes the value as the JSON response
+func writeAdminJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(code)
+	err := json.NewEncoder(rw).Encode(v)
+	if err != nil {
+		logger.Printf("Error encoding admin API response: %v", err)
+	}
+}
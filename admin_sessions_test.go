@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	adminSessionsPath = "/oauth2/admin/sessions"
+	adminToken        = "admin-token-0123456789"
+)
+
+// fakeSessionAdministrator is a session store holding the sessions of users
+// by ID
+type fakeSessionAdministrator struct {
+	sessionsapi.SessionStore
+
+	canList  bool
+	sessions map[string][]sessionsapi.SessionInfo
+}
+
+func (f *fakeSessionAdministrator) ListUsers(_ context.Context) ([]string, error) {
+	if !f.canList {
+		return nil, sessionsapi.ErrListingNotSupported
+	}
+	users := []string{}
+	for user := range f.sessions {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (f *fakeSessionAdministrator) ListSessions(_ context.Context, user string) ([]sessionsapi.SessionInfo, error) {
+	if user == "error@example.com" {
+		return nil, errors.New("store unavailable")
+	}
+	return append([]sessionsapi.SessionInfo{}, f.sessions[user]...), nil
+}
+
+func (f *fakeSessionAdministrator) RevokeSession(_ context.Context, id string) error {
+	for user, infos := range f.sessions {
+		for i, info := range infos {
+			if info.ID == id {
+				f.sessions[user] = append(infos[:i], infos[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return sessionsapi.ErrInvalidSessionID
+}
+
+func (f *fakeSessionAdministrator) RevokeUserSessions(_ context.Context, user string) (int, error) {
+	revoked := len(f.sessions[user])
+	delete(f.sessions, user)
+	return revoked, nil
+}
+
+func newFakeSessionAdministrator(canList bool) *fakeSessionAdministrator {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &fakeSessionAdministrator{
+		canList: canList,
+		sessions: map[string][]sessionsapi.SessionInfo{
+			"john.doe@example.com": {
+				{ID: "_oauth2_proxy-0001", SavedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{ID: "_oauth2_proxy-0002", SavedAt: now, ExpiresAt: now.Add(time.Hour)},
+			},
+		},
+	}
+}
+
+func TestSessionAdmin(t *testing.T) {
+	testCases := map[string]struct {
+		method       string
+		path         string
+		token        string
+		canList      bool
+		expectedCode int
+		expectedBody string
+		remaining    int
+	}{
+		"Without a token": {
+			method:       http.MethodGet,
+			path:         adminSessionsPath + "?user=john.doe@example.com",
+			expectedCode: http.StatusUnauthorized,
+			remaining:    2,
+		},
+		"With an invalid token": {
+			method:       http.MethodGet,
+			path:         adminSessionsPath + "?user=john.doe@example.com",
+			token:        "not-the-admin-token",
+			expectedCode: http.StatusUnauthorized,
+			remaining:    2,
+		},
+		"Listing the sessions of a user": {
+			method:       http.MethodGet,
+			path:         adminSessionsPath + "?user=john.doe@example.com",
+			token:        adminToken,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user":"john.doe@example.com","sessions":[{"id":"_oauth2_proxy-0001"},{"id":"_oauth2_proxy-0002"}]}`,
+			remaining:    2,
+		},
+		"Listing the sessions of a user when the store fails": {
+			method:       http.MethodGet,
+			path:         adminSessionsPath + "?user=error@example.com",
+			token:        adminToken,
+			expectedCode: http.StatusInternalServerError,
+			remaining:    2,
+		},
+		"Listing every user": {
+			method:       http.MethodGet,
+			path:         adminSessionsPath,
+			token:        adminToken,
+			canList:      true,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"users":["john.doe@example.com"]}`,
+			remaining:    2,
+		},
+		"Listing every user when the store can't list": {
+			method:       http.MethodGet,
+			path:         adminSessionsPath,
+			token:        adminToken,
+			expectedCode: http.StatusNotImplemented,
+			remaining:    2,
+		},
+		"Revoking a session": {
+			method:       http.MethodDelete,
+			path:         adminSessionsPath + "/_oauth2_proxy-0001",
+			token:        adminToken,
+			expectedCode: http.StatusNoContent,
+			remaining:    1,
+		},
+		"Revoking an invalid session": {
+			method:       http.MethodDelete,
+			path:         adminSessionsPath + "/_oauth2_proxy-user-0001",
+			token:        adminToken,
+			expectedCode: http.StatusBadRequest,
+			remaining:    2,
+		},
+		"Revoking every session of a user": {
+			method:       http.MethodDelete,
+			path:         adminSessionsPath + "?user=john.doe@example.com",
+			token:        adminToken,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user":"john.doe@example.com","revoked":2}`,
+			remaining:    0,
+		},
+		"Deleting without a session or user": {
+			method:       http.MethodDelete,
+			path:         adminSessionsPath,
+			token:        adminToken,
+			expectedCode: http.StatusNotFound,
+			remaining:    2,
+		},
+		"With an unsupported method": {
+			method:       http.MethodPost,
+			path:         adminSessionsPath + "?user=john.doe@example.com",
+			token:        adminToken,
+			expectedCode: http.StatusMethodNotAllowed,
+			remaining:    2,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			store := newFakeSessionAdministrator(tc.canList)
+			admin := newSessionAdmin(adminSessionsPath, adminToken, store)
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rw := httptest.NewRecorder()
+			admin.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, withoutSessionTimes(t, rw.Body.Bytes()))
+			}
+			assert.Len(t, store.sessions["john.doe@example.com"], tc.remaining)
+		})
+	}
+}
+
+// withoutSessionTimes removes the times from the sessions in an admin API
+// response so that it can be compared
+func withoutSessionTimes(t *testing.T, body []byte) string {
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &response))
+	if infos, ok := response["sessions"].([]interface{}); ok {
+		for _, info := range infos {
+			delete(info.(map[string]interface{}), "savedAt")
+			delete(info.(map[string]interface{}), "expiresAt")
+		}
+	}
+	stripped, err := json.Marshal(response)
+	assert.NoError(t, err)
+	return string(stripped)
+}
+
+func TestSessionAdminWithoutToken(t *testing.T) {
+	assert.Nil(t, newSessionAdmin(adminSessionsPath, "", newFakeSessionAdministrator(true)))
+}
+
+func TestOAuthProxySessionAdmin(t *testing.T) {
+	test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.AdminToken = adminToken
+		opts.Session.Type = options.MemorySessionStoreType
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Now()
+	err = test.SaveSession(&sessionsapi.SessionState{
+		Email: "john.doe@example.com", AccessToken: "my_access_token", CreatedAt: &created})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodDelete, adminSessionsPath+"?user=john.doe@example.com", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	rw := httptest.NewRecorder()
+	test.proxy.ServeHTTP(rw, req)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.JSONEq(t, `{"user":"john.doe@example.com","revoked":1}`, rw.Body.String())
+
+	session, err := test.LoadCookiedSession()
+	assert.Error(t, err)
+	assert.Nil(t, session)
+}
@@ -77,6 +77,13 @@ func (s *Server) ServeHTTPS() {
 		logger.Fatalf("FATAL: loading tls config (%s, %s) failed - %s", s.Opts.TLSCertFile, s.Opts.TLSKeyFile, err)
 	}
 
+	// Client certificates are requested, but not required so that other
+	// clients can still sign in. Front proxies forward them in a header.
+	if pool := s.Opts.GetClientCAPool(); pool != nil && s.Opts.ClientTLS.Header == "" {
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		logger.Fatalf("FATAL: listen (%s) failed - %s", addr, err)
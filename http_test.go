@@ -1,7 +1,20 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -37,3 +50,143 @@ func TestGracefulShutdown(t *testing.T) {
 
 	assert.Len(t, stop, 0) // check if stop chan is empty
 }
+
+func TestServeHTTPSClientCertificates(t *testing.T) {
+	ca, caKey := newTestTLSCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	serverCert, serverKey := newTestTLSCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	clientCert, clientKey := newTestTLSCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	otherCA, otherCAKey := newTestTLSCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	untrustedCert, untrustedKey := newTestTLSCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "untrusted"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, otherCA, otherCAKey)
+
+	tempDir, err := ioutil.TempDir("", "https-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	assert.NoError(t, err)
+	certFile := filepath.Join(tempDir, "tls.crt")
+	keyFile := filepath.Join(tempDir, "tls.key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Raw}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	// Reserve a free port for the server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	assert.NoError(t, ln.Close())
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	opts := options.NewOptions()
+	opts.HTTPSAddress = addr
+	opts.TLSCertFile = certFile
+	opts.TLSKeyFile = keyFile
+	opts.SetClientCAPool(clientCAs)
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) > 0 {
+			rw.Write([]byte(req.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+	})
+	stop := make(chan struct{}, 1)
+	srv := Server{Handler: handler, Opts: opts, stop: stop}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.ServeHTTPS()
+	}()
+	defer func() {
+		stop <- struct{}{}
+		<-done
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(cert *tls.Certificate) (string, error) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs: roots,
+					// Present the certificate even if the server would not
+					// accept its issuer
+					GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+						if cert == nil {
+							return &tls.Certificate{}, nil
+						}
+						return cert, nil
+					},
+				},
+			},
+			Timeout: time.Second,
+		}
+		defer client.CloseIdleConnections()
+
+		var resp *http.Response
+		var err error
+		// Wait for the server to start listening
+		for i := 0; i < 50; i++ {
+			resp, err = client.Get("https://" + addr + "/")
+			if err == nil || !strings.Contains(err.Error(), "connection refused") {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	body, err := get(&tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey})
+	assert.NoError(t, err)
+	assert.Equal(t, "client", body)
+
+	// Client certificates are optional
+	body, err = get(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", body)
+
+	_, err = get(&tls.Certificate{Certificate: [][]byte{untrustedCert.Raw}, PrivateKey: untrustedKey})
+	assert.Error(t, err)
+}
+
+// newTestTLSCertificate creates a certificate from the template, signed by the
+// parent or self-signed if the parent is nil
+func newTestTLSCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert, key
+}
@@ -23,6 +23,7 @@ func main() {
 	flagSet := options.NewFlagSet()
 
 	config := flagSet.String("config", "", "path to config file")
+	alphaConfig := flagSet.String("alpha-config", "", "path to alpha config file in JSON format (the structure of this file may change between minor releases)")
 	showVersion := flagSet.Bool("version", false, "print version string")
 
 	flagSet.Parse(os.Args[1:])
@@ -45,12 +46,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *alphaConfig != "" {
+		alphaOpts := &options.AlphaOptions{}
+		err = options.LoadJSON(*alphaConfig, alphaOpts)
+		if err != nil {
+			logger.Printf("ERROR: Failed to load alpha config: %v", err)
+			os.Exit(1)
+		}
+		alphaOpts.MergeInto(opts)
+	}
+
 	err = validation.Validate(opts)
 	if err != nil {
 		logger.Printf("%s", err)
 		os.Exit(1)
 	}
 
+	if keyring := opts.Session.Cookie.GetKeyring(); keyring != nil {
+		// Keys can be added to and retired from the keyring without a restart
+		WatchForUpdates(opts.Session.Cookie.KeyringFile, nil, func() {
+			if err := keyring.Load(); err != nil {
+				logger.Printf("error reloading session cookie keyring %s: %v", opts.Session.Cookie.KeyringFile, err)
+			}
+		})
+	}
+
 	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
 	oauthproxy, err := NewOAuthProxy(opts, validator)
 	if err != nil {
@@ -23,6 +23,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/authentication/basic"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/cookies"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/header"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/middleware"
@@ -36,6 +37,9 @@ const (
 	httpsScheme = "https"
 
 	applicationJSON = "application/json"
+
+	// backChannelLogoutEvent is the event a back-channel logout token must contain
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
 )
 
 var (
@@ -49,17 +53,18 @@ var (
 
 // OAuthProxy is the main authentication proxy
 type OAuthProxy struct {
-	CookieSeed     string
-	CookieName     string
-	CSRFCookieName string
-	CookieDomains  []string
-	CookiePath     string
-	CookieSecure   bool
-	CookieHTTPOnly bool
-	CookieExpire   time.Duration
-	CookieRefresh  time.Duration
-	CookieSameSite string
-	Validator      func(string) bool
+	CookieSeed          string
+	CookiePreviousSeeds []string
+	CookieName          string
+	CSRFCookieName      string
+	CookieDomains       []string
+	CookiePath          string
+	CookieSecure        bool
+	CookieHTTPOnly      bool
+	CookieExpire        time.Duration
+	CookieRefresh       time.Duration
+	CookieSameSite      string
+	Validator           func(string) bool
 
 	RobotsPath        string
 	SignInPath        string
@@ -69,9 +74,18 @@ type OAuthProxy struct {
 	AuthOnlyPath      string
 	UserInfoPath      string
 
+	BackChannelLogoutPath string
+	AdminSessionsPath     string
+	SAMLMetadataPath      string
+
 	redirectURL             *url.URL // the url to receive requests at
 	whitelistDomains        []string
+	allowedGroups           map[string]struct{}
+	upstreamAuthorizer      *upstreamAuthorizer
+	requestHeaderInjector   header.Injector
+	responseHeaderInjector  header.Injector
 	provider                providers.Provider
+	providers               providerSet
 	providerNameOverride    string
 	sessionStore            sessionsapi.SessionStore
 	ProxyPrefix             string
@@ -83,6 +97,8 @@ type OAuthProxy struct {
 	PassBasicAuth           bool
 	SetBasicAuth            bool
 	SkipProviderButton      bool
+	ProviderLogout          bool
+	CodeChallengeMethod     string
 	PassUserHeaders         bool
 	BasicAuthPassword       string
 	PassAccessToken         bool
@@ -92,8 +108,11 @@ type OAuthProxy struct {
 	skipAuthRegex           []string
 	skipAuthPreflight       bool
 	skipAuthStripHeaders    bool
+	clientCertHeader        string
 	skipJwtBearerTokens     bool
 	mainJwtBearerVerifier   *oidc.IDTokenVerifier
+	oidcIssuers             []oidcIssuer
+	sessionAdmin            *sessionAdmin
 	extraJwtBearerVerifiers []*oidc.IDTokenVerifier
 	compiledRegex           []*regexp.Regexp
 	templates               *template.Template
@@ -102,7 +121,8 @@ type OAuthProxy struct {
 	Banner                  string
 	Footer                  string
 
-	sessionChain alice.Chain
+	sessionChain  alice.Chain
+	sessionBinder *middleware.SessionBinder
 }
 
 // NewOAuthProxy creates a new instance of OAuthProxy from the options provided
@@ -119,6 +139,15 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		return nil, fmt.Errorf("error initialising upstream proxy: %v", err)
 	}
 
+	requestHeaderInjector, err := header.NewInjector(opts.InjectRequestHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising request header injector: %v", err)
+	}
+	responseHeaderInjector, err := header.NewInjector(opts.InjectResponseHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising response header injector: %v", err)
+	}
+
 	for _, u := range opts.GetCompiledRegex() {
 		logger.Printf("compiled skip-auth-regex => %q", u)
 	}
@@ -129,12 +158,22 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 			logger.Printf("Skipping JWT tokens from extra JWT issuer: %q", issuer)
 		}
 	}
+	if opts.Introspection.Enabled {
+		logger.Printf("Introspecting bearer tokens with: %s", opts.GetProvider().Data().IntrospectionURL)
+	}
 	redirectURL := opts.GetRedirectURL()
 	if redirectURL.Path == "" {
 		redirectURL.Path = fmt.Sprintf("%s/callback", opts.ProxyPrefix)
 	}
 
-	logger.Printf("OAuthProxy configured for %s Client ID: %s", opts.GetProvider().Data().ProviderName, opts.ClientID)
+	signInProviders := newProviderSet(opts)
+	if len(signInProviders) > 1 {
+		for _, provider := range signInProviders {
+			logger.Printf("OAuthProxy configured for %s (%s) Client ID: %s", provider.Data().ProviderName, provider.Data().ID, provider.Data().ClientID)
+		}
+	} else {
+		logger.Printf("OAuthProxy configured for %s Client ID: %s", opts.GetProvider().Data().ProviderName, opts.ClientID)
+	}
 	refresh := "disabled"
 	if opts.Cookie.Refresh != time.Duration(0) {
 		refresh = fmt.Sprintf("after %s", opts.Cookie.Refresh)
@@ -160,21 +199,36 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 			return nil, fmt.Errorf("could not load htpasswdfile: %v", err)
 		}
 	}
+	if opts.LDAP.URL != "" {
+		logger.Printf("using LDAP server: %s", opts.LDAP.URL)
+		var err error
+		basicAuthValidator, err = basic.NewLDAPValidator(opts.LDAP)
+		if err != nil {
+			return nil, fmt.Errorf("could not configure LDAP: %v", err)
+		}
+	}
+
+	allowedGroups := make(map[string]struct{}, len(opts.AllowedGroups))
+	for _, group := range opts.AllowedGroups {
+		allowedGroups[group] = struct{}{}
+	}
 
-	sessionChain := buildSessionChain(opts, sessionStore, basicAuthValidator)
+	sessionBinder := middleware.NewSessionBinder(opts.Session.Binding, opts.GetRealClientIPParser())
+	sessionChain := buildSessionChain(opts, signInProviders, sessionStore, sessionBinder, basicAuthValidator)
 
 	return &OAuthProxy{
-		CookieName:     opts.Cookie.Name,
-		CSRFCookieName: fmt.Sprintf("%v_%v", opts.Cookie.Name, "csrf"),
-		CookieSeed:     opts.Cookie.Secret,
-		CookieDomains:  opts.Cookie.Domains,
-		CookiePath:     opts.Cookie.Path,
-		CookieSecure:   opts.Cookie.Secure,
-		CookieHTTPOnly: opts.Cookie.HTTPOnly,
-		CookieExpire:   opts.Cookie.Expire,
-		CookieRefresh:  opts.Cookie.Refresh,
-		CookieSameSite: opts.Cookie.SameSite,
-		Validator:      validator,
+		CookieName:          opts.Cookie.Name,
+		CSRFCookieName:      fmt.Sprintf("%v_%v", opts.Cookie.Name, "csrf"),
+		CookieSeed:          opts.Cookie.Secret,
+		CookiePreviousSeeds: opts.Cookie.PreviousSecrets,
+		CookieDomains:       opts.Cookie.Domains,
+		CookiePath:          opts.Cookie.Path,
+		CookieSecure:        opts.Cookie.Secure,
+		CookieHTTPOnly:      opts.Cookie.HTTPOnly,
+		CookieExpire:        opts.Cookie.Expire,
+		CookieRefresh:       opts.Cookie.Refresh,
+		CookieSameSite:      opts.Cookie.SameSite,
+		Validator:           validator,
 
 		RobotsPath:        "/robots.txt",
 		SignInPath:        fmt.Sprintf("%s/sign_in", opts.ProxyPrefix),
@@ -184,18 +238,30 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		AuthOnlyPath:      fmt.Sprintf("%s/auth", opts.ProxyPrefix),
 		UserInfoPath:      fmt.Sprintf("%s/userinfo", opts.ProxyPrefix),
 
+		BackChannelLogoutPath: fmt.Sprintf("%s/backchannel-logout", opts.ProxyPrefix),
+		AdminSessionsPath:     fmt.Sprintf("%s/admin/sessions", opts.ProxyPrefix),
+		SAMLMetadataPath:      fmt.Sprintf("%s/saml/metadata", opts.ProxyPrefix),
+
 		ProxyPrefix:             opts.ProxyPrefix,
 		provider:                opts.GetProvider(),
+		providers:               signInProviders,
 		providerNameOverride:    opts.ProviderName,
 		sessionStore:            sessionStore,
 		serveMux:                upstreamProxy,
 		redirectURL:             redirectURL,
 		whitelistDomains:        opts.WhitelistDomains,
+		allowedGroups:           allowedGroups,
+		upstreamAuthorizer:      newUpstreamAuthorizer(opts.UpstreamServers),
+		requestHeaderInjector:   requestHeaderInjector,
+		responseHeaderInjector:  responseHeaderInjector,
 		skipAuthRegex:           opts.SkipAuthRegex,
 		skipAuthPreflight:       opts.SkipAuthPreflight,
 		skipAuthStripHeaders:    opts.SkipAuthStripHeaders,
+		clientCertHeader:        opts.ClientTLS.Header,
 		skipJwtBearerTokens:     opts.SkipJwtBearerTokens,
 		mainJwtBearerVerifier:   opts.GetOIDCVerifier(),
+		oidcIssuers:             newOIDCIssuers(opts, signInProviders),
+		sessionAdmin:            newSessionAdmin(fmt.Sprintf("%s/admin/sessions", opts.ProxyPrefix), opts.AdminToken, sessionStore),
 		extraJwtBearerVerifiers: opts.GetJWTBearerVerifiers(),
 		compiledRegex:           opts.GetCompiledRegex(),
 		realClientIPParser:      opts.GetRealClientIPParser(),
@@ -209,6 +275,8 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		PassAuthorization:       opts.PassAuthorization,
 		PreferEmailToUser:       opts.PreferEmailToUser,
 		SkipProviderButton:      opts.SkipProviderButton,
+		ProviderLogout:          opts.ProviderLogout,
+		CodeChallengeMethod:     opts.CodeChallengeMethod,
 		templates:               templates,
 		trustedIPs:              trustedIPs,
 		Banner:                  opts.Banner,
@@ -217,18 +285,21 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		basicAuthValidator:  basicAuthValidator,
 		displayHtpasswdForm: basicAuthValidator != nil,
 		sessionChain:        sessionChain,
+		sessionBinder:       sessionBinder,
 	}, nil
 }
 
-func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionStore, validator basic.Validator) alice.Chain {
+func buildSessionChain(opts *options.Options, signInProviders providerSet, sessionStore sessionsapi.SessionStore, sessionBinder *middleware.SessionBinder, validator basic.Validator) alice.Chain {
 	chain := alice.New(middleware.NewScope())
 
 	if opts.SkipJwtBearerTokens {
 		sessionLoaders := []middlewareapi.TokenToSessionLoader{}
-		if opts.GetOIDCVerifier() != nil {
+		// Bearer tokens of every OIDC provider are accepted, the session is
+		// created by the provider of the first verifier the token passes
+		for _, issuer := range newOIDCIssuers(opts, signInProviders) {
 			sessionLoaders = append(sessionLoaders, middlewareapi.TokenToSessionLoader{
-				Verifier:       opts.GetOIDCVerifier(),
-				TokenToSession: opts.GetProvider().CreateSessionStateFromBearerToken,
+				Verifier:       issuer.verifier,
+				TokenToSession: issuer.tokenToSession,
 			})
 		}
 
@@ -241,15 +312,37 @@ func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionSt
 		chain = chain.Append(middleware.NewJwtSessionLoader(sessionLoaders))
 	}
 
+	if opts.Introspection.Enabled {
+		// Bearer tokens are introspected with the default provider
+		provider := opts.GetProvider().Data()
+		chain = chain.Append(middleware.NewIntrospectionSessionLoader(&middleware.IntrospectionSessionLoaderOptions{
+			IntrospectionURL: provider.IntrospectionURL.String(),
+			ClientID:         provider.ClientID,
+			GetClientSecret:  provider.GetClientSecret,
+			RequiredScopes:   opts.Introspection.RequiredScopes,
+			Audiences:        opts.Introspection.Audiences,
+			CacheTTL:         opts.Introspection.CacheTTL,
+			CacheMaxEntries:  opts.Introspection.CacheMaxEntries,
+		}))
+	}
+
 	if validator != nil {
 		chain = chain.Append(middleware.NewBasicAuthSessionLoader(validator))
 	}
 
+	if len(opts.ClientTLS.CAFiles) > 0 {
+		chain = chain.Append(middleware.NewClientCertSessionLoader(opts.ClientTLS, opts.GetClientCAPool()))
+	}
+
 	chain = chain.Append(middleware.NewStoredSessionLoader(&middleware.StoredSessionLoaderOptions{
 		SessionStore:           sessionStore,
 		RefreshPeriod:          opts.Cookie.Refresh,
-		RefreshSessionIfNeeded: opts.GetProvider().RefreshSessionIfNeeded,
-		ValidateSessionState:   opts.GetProvider().ValidateSessionState,
+		MaxLifetime:            opts.Session.MaxLifetime,
+		IdleTimeout:            opts.Session.IdleTimeout,
+		ActivityUpdateInterval: opts.Session.ActivityUpdateInterval,
+		SessionBinder:          sessionBinder,
+		RefreshSessionIfNeeded: signInProviders.RefreshSessionIfNeeded,
+		ValidateSessionState:   signInProviders.ValidateSessionState,
 	}))
 
 	return chain
@@ -274,29 +367,37 @@ func (p *OAuthProxy) GetRedirectURI(host string) string {
 	return u.String()
 }
 
-func (p *OAuthProxy) redeemCode(ctx context.Context, host, code string) (s *sessionsapi.SessionState, err error) {
+func (p *OAuthProxy) redeemCode(ctx context.Context, provider providers.Provider, host, code, codeVerifier, nonce string) (s *sessionsapi.SessionState, err error) {
 	if code == "" {
 		return nil, errors.New("missing code")
 	}
 	redirectURI := p.GetRedirectURI(host)
-	s, err = p.provider.Redeem(ctx, redirectURI, code)
+	s, err = provider.Redeem(ctx, redirectURI, code, codeVerifier, nonce)
 	if err != nil {
 		return
 	}
+	s.ProviderID = provider.Data().ID
 
 	if s.Email == "" {
-		s.Email, err = p.provider.GetEmailAddress(ctx, s)
+		s.Email, err = provider.GetEmailAddress(ctx, s)
 	}
 
 	if s.PreferredUsername == "" {
-		s.PreferredUsername, err = p.provider.GetPreferredUsername(ctx, s)
+		s.PreferredUsername, err = provider.GetPreferredUsername(ctx, s)
 		if err != nil && err.Error() == "not implemented" {
 			err = nil
 		}
 	}
 
 	if s.User == "" {
-		s.User, err = p.provider.GetUserName(ctx, s)
+		s.User, err = provider.GetUserName(ctx, s)
+		if err != nil && err.Error() == "not implemented" {
+			err = nil
+		}
+	}
+
+	if len(s.Groups) == 0 {
+		s.Groups, err = provider.GetGroups(ctx, s)
 		if err != nil && err.Error() == "not implemented" {
 			err = nil
 		}
@@ -304,11 +405,30 @@ func (p *OAuthProxy) redeemCode(ctx context.Context, host, code string) (s *sess
 	return
 }
 
-// MakeCSRFCookie creates a cookie for CSRF
+// MakeCSRFCookie creates a cookie for CSRF, signing the value if present
 func (p *OAuthProxy) MakeCSRFCookie(req *http.Request, value string, expiration time.Duration, now time.Time) *http.Cookie {
+	if value != "" {
+		value = encryption.SignedValueForPurpose(encryption.CSRFKey, p.CookieSeed, p.CSRFCookieName, []byte(value), now)
+	}
 	return p.makeCookie(req, p.CSRFCookieName, value, expiration, now)
 }
 
+// loadCSRFCookieValue returns the value of the CSRF cookie once its signature
+// has been checked against the current and previous cookie secrets
+func (p *OAuthProxy) loadCSRFCookieValue(req *http.Request) (string, error) {
+	c, err := req.Cookie(p.CSRFCookieName)
+	if err != nil {
+		return "", err
+	}
+
+	seeds := append([]string{p.CookieSeed}, p.CookiePreviousSeeds...)
+	val, _, _, ok := encryption.ValidateWithSeedsForPurpose(encryption.CSRFKey, c, seeds, p.CookieExpire)
+	if !ok {
+		return "", errors.New("CSRF cookie signature not valid")
+	}
+	return string(val), nil
+}
+
 func (p *OAuthProxy) makeCookie(req *http.Request, name string, value string, expiration time.Duration, now time.Time) *http.Cookie {
 	cookieDomain := cookies.GetCookieDomain(req, p.CookieDomains)
 
@@ -340,6 +460,58 @@ func (p *OAuthProxy) ClearCSRFCookie(rw http.ResponseWriter, req *http.Request)
 	http.SetCookie(rw, p.MakeCSRFCookie(req, "", time.Hour*-1, time.Now()))
 }
 
+// csrfCookieValue joins the CSRF nonce with the OIDC nonce sent in the
+// authentication request, the PKCE code verifier, if any, and the ID of the
+// provider the flow was started with, so they are kept for the duration of
+// the OAuth flow
+func csrfCookieValue(nonce, oidcNonce, codeVerifier, providerID string) string {
+	return strings.Join([]string{nonce, oidcNonce, codeVerifier, providerID}, ":")
+}
+
+// parseCSRFCookieValue splits a CSRF cookie value into the CSRF nonce, the
+// OIDC nonce, the PKCE code verifier and the provider ID
+func parseCSRFCookieValue(value string) (nonce, oidcNonce, codeVerifier, providerID string) {
+	parts := strings.SplitN(value, ":", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2], parts[3]
+}
+
+// oauthState joins the CSRF nonce, the ID of the provider the user signs in
+// with and the redirect into the OAuth state. The provider ID is only added
+// when the provider has one, so the state of the legacy provider keeps the
+// `<nonce>:<redirect>` format.
+func oauthState(nonce, providerID, redirect string) string {
+	if providerID != "" {
+		nonce = nonce + "@" + providerID
+	}
+	return nonce + ":" + redirect
+}
+
+// parseOAuthState splits the OAuth state into the CSRF nonce, the provider
+// ID and the redirect
+func parseOAuthState(state string) (nonce, providerID, redirect string, err error) {
+	s := strings.SplitN(state, ":", 2)
+	if len(s) != 2 {
+		return "", "", "", errors.New("invalid length")
+	}
+	nonce = s[0]
+	if i := strings.Index(nonce, "@"); i >= 0 {
+		nonce, providerID = nonce[:i], nonce[i+1:]
+	}
+	return nonce, providerID, s[1], nil
+}
+
+// getProvider returns the provider with the ID, or the default provider when
+// the ID is empty
+func (p *OAuthProxy) getProvider(id string) (providers.Provider, error) {
+	if id == "" || id == p.provider.Data().ID {
+		return p.provider, nil
+	}
+	return p.providers.get(id)
+}
+
 // SetCSRFCookie adds a CSRF cookie to the response
 func (p *OAuthProxy) SetCSRFCookie(rw http.ResponseWriter, req *http.Request, val string) {
 	http.SetCookie(rw, p.MakeCSRFCookie(req, val, p.CookieExpire, time.Now()))
@@ -358,6 +530,21 @@ func (p *OAuthProxy) LoadCookiedSession(req *http.Request) (*sessionsapi.Session
 
 // SaveSession creates a new session cookie value and sets this on the response
 func (p *OAuthProxy) SaveSession(rw http.ResponseWriter, req *http.Request, s *sessionsapi.SessionState) error {
+	// Record the sign in so that the maximum lifetime and idle timeout of the
+	// session can be enforced
+	now := time.Now()
+	if s.AuthenticatedAt == nil {
+		s.AuthenticatedAt = &now
+	}
+	if s.LastActivity == nil {
+		s.LastActivity = &now
+	}
+	if p.sessionBinder != nil {
+		err := p.sessionBinder.Bind(req, s)
+		if err != nil {
+			return fmt.Errorf("error binding session to the client: %v", err)
+		}
+	}
 	return p.sessionStore.Save(rw, req, s)
 }
 
@@ -401,6 +588,7 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 
 	t := struct {
 		ProviderName  string
+		Providers     []signInProvider
 		SignInMessage template.HTML
 		CustomLogin   bool
 		Redirect      string
@@ -419,9 +607,30 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 	if p.providerNameOverride != "" {
 		t.ProviderName = p.providerNameOverride
 	}
+	if len(p.providers) > 1 {
+		for _, provider := range p.providers {
+			t.Providers = append(t.Providers, signInProvider{
+				ID:   provider.Data().ID,
+				Name: provider.Data().ProviderName,
+			})
+		}
+	}
 	p.templates.ExecuteTemplate(rw, "sign_in.html", t)
 }
 
+// signInProvider is a provider button of the sign in page
+type signInProvider struct {
+	ID   string
+	Name string
+}
+
+// skipSignInPage checks whether users are sent to the provider directly
+// rather than to the sign in page. The sign in page is always shown when
+// users choose between providers.
+func (p *OAuthProxy) skipSignInPage() bool {
+	return p.SkipProviderButton && len(p.providers) <= 1
+}
+
 // ManualSignIn handles basic auth logins to the proxy
 func (p *OAuthProxy) ManualSignIn(rw http.ResponseWriter, req *http.Request) (string, bool) {
 	if req.Method != "POST" || p.basicAuthValidator == nil {
@@ -434,10 +643,10 @@ func (p *OAuthProxy) ManualSignIn(rw http.ResponseWriter, req *http.Request) (st
 	}
 	// check auth
 	if p.basicAuthValidator.Validate(user, passwd) {
-		logger.PrintAuthf(user, req, logger.AuthSuccess, "Authenticated via HtpasswdFile")
+		logger.PrintAuthf(user, req, logger.AuthSuccess, "Authenticated via sign in form")
 		return user, true
 	}
-	logger.PrintAuthf(user, req, logger.AuthFailure, "Invalid authentication via HtpasswdFile")
+	logger.PrintAuthf(user, req, logger.AuthFailure, "Invalid authentication via sign in form")
 	return "", false
 }
 
@@ -618,6 +827,12 @@ func (p *OAuthProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.AuthenticateOnly(rw, req)
 	case path == p.UserInfoPath:
 		p.UserInfo(rw, req)
+	case path == p.BackChannelLogoutPath:
+		p.BackChannelLogout(rw, req)
+	case path == p.SAMLMetadataPath:
+		p.SAMLMetadata(rw, req)
+	case p.sessionAdmin != nil && p.sessionAdmin.matches(path):
+		p.sessionAdmin.ServeHTTP(rw, req)
 	default:
 		p.Proxy(rw, req)
 	}
@@ -638,7 +853,7 @@ func (p *OAuthProxy) SignIn(rw http.ResponseWriter, req *http.Request) {
 		p.SaveSession(rw, req, session)
 		http.Redirect(rw, req, redirect, http.StatusFound)
 	} else {
-		if p.SkipProviderButton {
+		if p.skipSignInPage() {
 			p.OAuthStart(rw, req)
 		} else {
 			p.SignInPage(rw, req, http.StatusOK)
@@ -646,7 +861,7 @@ func (p *OAuthProxy) SignIn(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-//UserInfo endpoint outputs session email and preferred username in JSON format
+//UserInfo endpoint outputs session email, preferred username and groups in JSON format
 func (p *OAuthProxy) UserInfo(rw http.ResponseWriter, req *http.Request) {
 
 	session, err := p.getAuthenticatedSession(rw, req)
@@ -655,11 +870,13 @@ func (p *OAuthProxy) UserInfo(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	userInfo := struct {
-		Email             string `json:"email"`
-		PreferredUsername string `json:"preferredUsername,omitempty"`
+		Email             string   `json:"email"`
+		PreferredUsername string   `json:"preferredUsername,omitempty"`
+		Groups            []string `json:"groups,omitempty"`
 	}{
 		Email:             session.Email,
 		PreferredUsername: session.PreferredUsername,
+		Groups:            session.Groups,
 	}
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
@@ -674,20 +891,227 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, 500, "Internal Error", err.Error())
 		return
 	}
+
+	if p.ProviderLogout {
+		// The session is needed for the id_token_hint, load it before clearing
+		session, err := p.LoadCookiedSession(req)
+		if err != nil {
+			session = nil
+		}
+		provider := p.provider
+		if session != nil {
+			provider, err = p.getProvider(session.ProviderID)
+		}
+		if err == nil {
+			logoutURL := provider.GetLogoutURL(session, p.getPostLogoutRedirectURI(req, redirect))
+			if logoutURL != "" {
+				redirect = logoutURL
+			}
+		}
+	}
+
 	p.ClearSessionCookie(rw, req)
 	http.Redirect(rw, req, redirect, http.StatusFound)
 }
 
+// getPostLogoutRedirectURI returns the redirect as an absolute URL for the
+// provider to return the user to after logout, or an empty string when the
+// redirect is not valid
+func (p *OAuthProxy) getPostLogoutRedirectURI(req *http.Request, redirect string) string {
+	if !p.IsValidRedirect(redirect) {
+		return ""
+	}
+	if !strings.HasPrefix(redirect, "/") {
+		return redirect
+	}
+
+	u := url.URL{
+		Scheme: httpScheme,
+		Host:   cookies.GetRequestHost(req),
+	}
+	if p.CookieSecure {
+		u.Scheme = httpsScheme
+	}
+	return u.String() + redirect
+}
+
+// BackChannelLogout clears the server side sessions identified by an OIDC
+// back-channel logout token sent directly from the provider
+func (p *OAuthProxy) BackChannelLogout(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	revoker, ok := p.sessionStore.(sessionsapi.OIDCSessionRevoker)
+	if !ok || len(p.oidcIssuers) == 0 {
+		http.Error(rw, "back-channel logout is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	claims, providerID, err := p.verifyLogoutToken(req.Context(), req.FormValue("logout_token"))
+	if err != nil {
+		logger.Printf("Error verifying back-channel logout token: %v", err)
+		http.Error(rw, "invalid logout token", http.StatusBadRequest)
+		return
+	}
+
+	err = revoker.ClearOIDCSessions(req.Context(), providerID, claims.SessionID, claims.Subject)
+	if err != nil {
+		logger.Printf("Error clearing sessions for back-channel logout: %v", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logger.Printf("Cleared sessions for back-channel logout: provider:%q sid:%q sub:%q", providerID, claims.SessionID, claims.Subject)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// SAMLMetadata serves the service provider metadata of the first saml
+// provider, or of the saml provider with the ID in the provider parameter
+func (p *OAuthProxy) SAMLMetadata(rw http.ResponseWriter, req *http.Request) {
+	providerID := req.FormValue("provider")
+	for _, provider := range p.providers {
+		samlProvider, ok := provider.(*providers.SAMLProvider)
+		if !ok || (providerID != "" && providerID != provider.Data().ID) {
+			continue
+		}
+
+		metadata, err := samlProvider.ServiceProvider.Metadata(p.GetRedirectURI(req.Host))
+		if err != nil {
+			logger.Printf("Error creating SAML metadata: %v", err)
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/samlmetadata+xml")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(metadata)
+		return
+	}
+	http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+// logoutTokenClaims are the claims of an OIDC back-channel logout token
+type logoutTokenClaims struct {
+	Subject   string                 `json:"sub"`
+	SessionID string                 `json:"sid"`
+	Events    map[string]interface{} `json:"events"`
+	Nonce     *string                `json:"nonce"`
+}
+
+// verifyLogoutToken verifies the logout token signature, issuer and audience
+// with the verifier of the provider of its issuer and checks the claims
+// required by OIDC back-channel logout. It returns the ID of the provider the
+// token was issued for.
+func (p *OAuthProxy) verifyLogoutToken(ctx context.Context, rawToken string) (*logoutTokenClaims, string, error) {
+	if rawToken == "" {
+		return nil, "", errors.New("missing logout_token")
+	}
+
+	iss, err := unverifiedIssuer(rawToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Providers may share an issuer with different client IDs, the audience
+	// tells them apart
+	var token *oidc.IDToken
+	var providerID string
+	err = fmt.Errorf("no provider for the logout token issuer %q", iss)
+	for _, issuer := range p.oidcIssuers {
+		if !issuer.issues(iss) {
+			continue
+		}
+		token, err = issuer.verifier.Verify(ctx, rawToken)
+		if err == nil {
+			providerID = issuer.provider.Data().ID
+			break
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	claims := &logoutTokenClaims{}
+	if err := token.Claims(claims); err != nil {
+		return nil, "", fmt.Errorf("failed to parse logout token claims: %v", err)
+	}
+
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return nil, "", errors.New("logout token does not contain a back-channel logout event")
+	}
+	if claims.Nonce != nil {
+		return nil, "", errors.New("logout token must not contain a nonce")
+	}
+	if claims.SessionID == "" && claims.Subject == "" {
+		return nil, "", errors.New("logout token must contain a sid or sub claim")
+	}
+	return claims, providerID, nil
+}
+
+// unverifiedIssuer reads the iss claim of a JWT without verifying it, to
+// choose the verifier of the token
+func unverifiedIssuer(rawToken string) (string, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed jwt: expected 3 parts")
+	}
+	payload, err := b64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwt payload: %v", err)
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("failed to parse jwt claims: %v", err)
+	}
+	return claims.Issuer, nil
+}
+
 // OAuthStart starts the OAuth2 authentication flow
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 	prepareNoCache(rw)
+
+	// The provider is only chosen on the sign in page when there is more
+	// than one, otherwise the parameter is left to the upstream
+	var providerID string
+	if len(p.providers) > 1 {
+		providerID = req.FormValue("provider")
+	}
+	provider, err := p.getProvider(providerID)
+	if err != nil {
+		logger.Printf("Error obtaining provider: %s", err.Error())
+		p.ErrorPage(rw, 400, "Bad Request", err.Error())
+		return
+	}
+	providerID = provider.Data().ID
+
 	nonce, err := encryption.Nonce()
 	if err != nil {
 		logger.Printf("Error obtaining nonce: %s", err.Error())
 		p.ErrorPage(rw, 500, "Internal Error", err.Error())
 		return
 	}
-	p.SetCSRFCookie(rw, req, nonce)
+
+	oidcNonce, err := encryption.Nonce()
+	if err != nil {
+		logger.Printf("Error obtaining nonce: %s", err.Error())
+		p.ErrorPage(rw, 500, "Internal Error", err.Error())
+		return
+	}
+
+	var codeVerifier, codeChallenge string
+	if p.CodeChallengeMethod != "" {
+		codeVerifier, err = encryption.GenerateCodeVerifier()
+		if err != nil {
+			logger.Printf("Error creating code verifier: %s", err.Error())
+			p.ErrorPage(rw, 500, "Internal Error", err.Error())
+			return
+		}
+		codeChallenge = encryption.GenerateCodeChallenge(codeVerifier)
+	}
+
+	p.SetCSRFCookie(rw, req, csrfCookieValue(nonce, oidcNonce, codeVerifier, providerID))
 	redirect, err := p.GetRedirect(req)
 	if err != nil {
 		logger.Printf("Error obtaining redirect: %s", err.Error())
@@ -695,7 +1119,7 @@ func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	redirectURI := p.GetRedirectURI(req.Host)
-	http.Redirect(rw, req, p.provider.GetLoginURL(redirectURI, fmt.Sprintf("%v:%v", nonce, redirect)), http.StatusFound)
+	http.Redirect(rw, req, provider.GetLoginURL(redirectURI, oauthState(nonce, providerID, redirect), codeChallenge, oidcNonce), http.StatusFound)
 }
 
 // OAuthCallback is the OAuth2 authentication flow callback that finishes the
@@ -717,29 +1141,50 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	session, err := p.redeemCode(req.Context(), req.Host, req.Form.Get("code"))
+	// SAML IdPs post the response with the state as the relay state
+	code, state := req.Form.Get("code"), req.Form.Get("state")
+	if samlResponse := req.PostForm.Get("SAMLResponse"); samlResponse != "" {
+		code, state = samlResponse, req.PostForm.Get("RelayState")
+	}
+
+	nonce, providerID, redirect, err := parseOAuthState(state)
 	if err != nil {
-		logger.Printf("Error redeeming code during OAuth2 callback: %s ", err.Error())
-		p.ErrorPage(rw, 500, "Internal Error", "Internal Error")
+		logger.Printf("Error while parsing OAuth2 state: %s", err.Error())
+		p.ErrorPage(rw, 500, "Internal Error", "Invalid State")
 		return
 	}
-
-	s := strings.SplitN(req.Form.Get("state"), ":", 2)
-	if len(s) != 2 {
-		logger.Printf("Error while parsing OAuth2 state: invalid length")
+	provider, err := p.getProvider(providerID)
+	if err != nil {
+		logger.Printf("Error while parsing OAuth2 state: %s", err.Error())
 		p.ErrorPage(rw, 500, "Internal Error", "Invalid State")
 		return
 	}
-	nonce := s[0]
-	redirect := s[1]
-	c, err := req.Cookie(p.CSRFCookieName)
+
+	// The OIDC nonce and PKCE code verifier are needed to redeem the code, the
+	// CSRF nonce is checked once the code has been redeemed. The code is only
+	// sent to the provider the flow was started with.
+	csrfValue, csrfErr := p.loadCSRFCookieValue(req)
+	csrfNonce, oidcNonce, codeVerifier, csrfProviderID := parseCSRFCookieValue(csrfValue)
+	if csrfErr == nil && csrfProviderID != providerID {
+		logger.Printf("Error while parsing OAuth2 state: provider %q does not match the provider the flow was started with", providerID)
+		p.ErrorPage(rw, 403, "Permission Denied", "csrf failed")
+		return
+	}
+
+	session, err := p.redeemCode(req.Context(), provider, req.Host, code, codeVerifier, oidcNonce)
 	if err != nil {
+		logger.Printf("Error redeeming code during OAuth2 callback: %s ", err.Error())
+		p.ErrorPage(rw, 500, "Internal Error", "Internal Error")
+		return
+	}
+
+	if csrfErr != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unable too obtain CSRF cookie")
-		p.ErrorPage(rw, 403, "Permission Denied", err.Error())
+		p.ErrorPage(rw, 403, "Permission Denied", csrfErr.Error())
 		return
 	}
 	p.ClearCSRFCookie(rw, req)
-	if c.Value != nonce {
+	if csrfNonce != nonce {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: csrf token mismatch, potential attack")
 		p.ErrorPage(rw, 403, "Permission Denied", "csrf failed")
 		return
@@ -750,7 +1195,7 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// set cookie, or deny
-	if p.Validator(session.Email) && p.provider.ValidateGroup(session.Email) {
+	if p.Validator(session.Email) && provider.ValidateGroup(session.Email) && p.validateGroups(session.Groups) {
 		logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via OAuth2: %s", session)
 		err := p.SaveSession(rw, req, session)
 		if err != nil {
@@ -773,7 +1218,12 @@ func (p *OAuthProxy) AuthenticateOnly(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	// we are authenticated
+	// we are authenticated, check we are authorized for the original upstream
+	if !p.upstreamAuthorizer.IsAuthorizedForOriginalRequest(req, session) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authorization via session: not authorized for original URI %q", originalURI(req))
+		http.Error(rw, "forbidden request", http.StatusForbidden)
+		return
+	}
 	p.addHeadersForProxying(rw, req, session)
 	rw.WriteHeader(http.StatusAccepted)
 }
@@ -783,6 +1233,10 @@ func (p *OAuthProxy) SkipAuthProxy(rw http.ResponseWriter, req *http.Request) {
 	if p.skipAuthStripHeaders {
 		p.stripAuthHeaders(req)
 	}
+	// Forwarded client certificates are only verified by the session chain
+	if p.clientCertHeader != "" {
+		req.Header.Del(p.clientCertHeader)
+	}
 	p.serveMux.ServeHTTP(rw, req)
 }
 
@@ -792,7 +1246,12 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	session, err := p.getAuthenticatedSession(rw, req)
 	switch err {
 	case nil:
-		// we are authenticated
+		// we are authenticated, check we are authorized for this upstream
+		if !p.upstreamAuthorizer.IsAuthorized(req, session) {
+			logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authorization via session: not authorized for upstream path %s", req.URL.Path)
+			p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "Unauthorized")
+			return
+		}
 		p.addHeadersForProxying(rw, req, session)
 		p.serveMux.ServeHTTP(rw, req)
 
@@ -804,7 +1263,7 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		if p.SkipProviderButton {
+		if p.skipSignInPage() {
 			p.OAuthStart(rw, req)
 		} else {
 			p.SignInPage(rw, req, http.StatusForbidden)
@@ -841,9 +1300,31 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 		return nil, ErrNeedsLogin
 	}
 
+	if !p.validateGroups(session.Groups) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via session: not a member of an allowed group %s", session)
+		// Invalid session, clear it
+		p.ClearSessionCookie(rw, req)
+		return nil, ErrNeedsLogin
+	}
+
 	return session, nil
 }
 
+// validateGroups checks that at least one of the given groups is allowed.
+// When no allowed groups are configured, all sessions are valid.
+func (p *OAuthProxy) validateGroups(groups []string) bool {
+	if len(p.allowedGroups) == 0 {
+		return true
+	}
+
+	for _, group := range groups {
+		if _, ok := p.allowedGroups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // addHeadersForProxying adds the appropriate headers the request / response for proxying
 func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) {
 	if p.PassBasicAuth {
@@ -865,6 +1346,11 @@ func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, req *http.Req
 		} else {
 			req.Header.Del("X-Forwarded-Preferred-Username")
 		}
+		if len(session.Groups) > 0 {
+			req.Header["X-Forwarded-Groups"] = []string{strings.Join(session.Groups, ",")}
+		} else {
+			req.Header.Del("X-Forwarded-Groups")
+		}
 	}
 
 	if p.PassUserHeaders {
@@ -885,6 +1371,11 @@ func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, req *http.Req
 		} else {
 			req.Header.Del("X-Forwarded-Preferred-Username")
 		}
+		if len(session.Groups) > 0 {
+			req.Header["X-Forwarded-Groups"] = []string{strings.Join(session.Groups, ",")}
+		} else {
+			req.Header.Del("X-Forwarded-Groups")
+		}
 	}
 
 	if p.SetXAuthRequest {
@@ -899,6 +1390,11 @@ func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, req *http.Req
 		} else {
 			rw.Header().Del("X-Auth-Request-Preferred-Username")
 		}
+		if len(session.Groups) > 0 {
+			rw.Header().Set("X-Auth-Request-Groups", strings.Join(session.Groups, ","))
+		} else {
+			rw.Header().Del("X-Auth-Request-Groups")
+		}
 
 		if p.PassAccessToken {
 			if session.AccessToken != "" {
@@ -952,6 +1448,9 @@ func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, req *http.Req
 	} else {
 		rw.Header().Set("GAP-Auth", session.Email)
 	}
+
+	p.requestHeaderInjector.Inject(req.Header, session)
+	p.responseHeaderInjector.Inject(rw.Header(), session)
 }
 
 // stripAuthHeaders removes Auth headers for whitelisted routes from skipAuthRegex
@@ -960,6 +1459,7 @@ func (p *OAuthProxy) stripAuthHeaders(req *http.Request) {
 		req.Header.Del("X-Forwarded-User")
 		req.Header.Del("X-Forwarded-Email")
 		req.Header.Del("X-Forwarded-Preferred-Username")
+		req.Header.Del("X-Forwarded-Groups")
 		req.Header.Del("Authorization")
 	}
 
@@ -967,6 +1467,7 @@ func (p *OAuthProxy) stripAuthHeaders(req *http.Request) {
 		req.Header.Del("X-Forwarded-User")
 		req.Header.Del("X-Forwarded-Email")
 		req.Header.Del("X-Forwarded-Preferred-Username")
+		req.Header.Del("X-Forwarded-Groups")
 	}
 
 	if p.PassAccessToken {
@@ -976,6 +1477,8 @@ func (p *OAuthProxy) stripAuthHeaders(req *http.Request) {
 	if p.PassAuthorization {
 		req.Header.Del("Authorization")
 	}
+
+	p.requestHeaderInjector.Strip(req.Header)
 }
 
 // isAjax checks if a request is an ajax request
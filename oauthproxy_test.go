@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -21,8 +22,11 @@ import (
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	sessionscookie "github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/cookie"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+	sessionstests "github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/upstream"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/validation"
 	"github.com/oauth2-proxy/oauth2-proxy/providers"
@@ -611,6 +615,7 @@ func TestStripAuthHeaders(t *testing.T) {
 				"X-Forwarded-User":               true,
 				"X-Forwarded-Email":              true,
 				"X-Forwarded-Preferred-Username": true,
+				"X-Forwarded-Groups":             true,
 				"X-Forwarded-Access-Token":       false,
 				"Authorization":                  true,
 			},
@@ -625,6 +630,7 @@ func TestStripAuthHeaders(t *testing.T) {
 				"X-Forwarded-User":               true,
 				"X-Forwarded-Email":              true,
 				"X-Forwarded-Preferred-Username": true,
+				"X-Forwarded-Groups":             true,
 				"X-Forwarded-Access-Token":       true,
 				"Authorization":                  true,
 			},
@@ -639,6 +645,7 @@ func TestStripAuthHeaders(t *testing.T) {
 				"X-Forwarded-User":               true,
 				"X-Forwarded-Email":              true,
 				"X-Forwarded-Preferred-Username": true,
+				"X-Forwarded-Groups":             true,
 				"X-Forwarded-Access-Token":       true,
 				"Authorization":                  false,
 			},
@@ -653,6 +660,7 @@ func TestStripAuthHeaders(t *testing.T) {
 				"X-Forwarded-User":               false,
 				"X-Forwarded-Email":              false,
 				"X-Forwarded-Preferred-Username": false,
+				"X-Forwarded-Groups":             false,
 				"X-Forwarded-Access-Token":       false,
 				"Authorization":                  true,
 			},
@@ -667,6 +675,7 @@ func TestStripAuthHeaders(t *testing.T) {
 				"X-Forwarded-User":               false,
 				"X-Forwarded-Email":              false,
 				"X-Forwarded-Preferred-Username": false,
+				"X-Forwarded-Groups":             false,
 				"X-Forwarded-Access-Token":       false,
 				"Authorization":                  false,
 			},
@@ -681,6 +690,7 @@ func TestStripAuthHeaders(t *testing.T) {
 				"X-Forwarded-User":               false,
 				"X-Forwarded-Email":              false,
 				"X-Forwarded-Preferred-Username": false,
+				"X-Forwarded-Groups":             false,
 				"X-Forwarded-Access-Token":       false,
 				"Authorization":                  false,
 			},
@@ -691,6 +701,7 @@ func TestStripAuthHeaders(t *testing.T) {
 		"X-Forwarded-User":               "9fcab5c9b889a557",
 		"X-Forwarded-Email":              "john.doe@example.com",
 		"X-Forwarded-Preferred-Username": "john.doe",
+		"X-Forwarded-Groups":             "admins,developers",
 		"X-Forwarded-Access-Token":       "AccessToken",
 		"Authorization":                  "bearer IDToken",
 	}
@@ -902,6 +913,32 @@ func TestStaticProxyUpstream(t *testing.T) {
 	assert.Equal(t, "Authenticated", payload)
 }
 
+func TestStaticProxyUpstreamUnauthorized(t *testing.T) {
+	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
+		PassAccessToken: true,
+		ProxyUpstream: options.Upstream{
+			ID:                  "static-proxy",
+			Path:                "/static-proxy",
+			Static:              true,
+			AllowedEmailDomains: []string{"admins.example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(patTest.Close)
+
+	code, cookie := patTest.getCallbackEndpoint()
+	if code != 302 {
+		t.Fatalf("expected 302; got %d", code)
+	}
+	assert.NotEqual(t, nil, cookie)
+
+	// The session is valid, but the upstream does not allow the email domain.
+	code, _ = patTest.getEndpointWithCookie(cookie, "/static-proxy")
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
 func TestDoNotForwardAccessTokenUpstream(t *testing.T) {
 	patTest, err := NewPassAccessTokenTest(PassAccessTokenTestOptions{
 		PassAccessToken: false,
@@ -1259,6 +1296,160 @@ func NewAuthOnlyEndpointTest(modifiers ...OptionsModifier) (*ProcessCookieTest,
 	return pcTest, nil
 }
 
+func TestSignOutProviderLogout(t *testing.T) {
+	testCases := map[string]struct {
+		providerLogout   bool
+		logoutURL        *url.URL
+		rd               string
+		expectedLocation string
+	}{
+		"Provider logout disabled": {
+			providerLogout:   false,
+			logoutURL:        &url.URL{Scheme: "https", Host: "idp.example.com", Path: "/logout"},
+			rd:               "/app",
+			expectedLocation: "/app",
+		},
+		"Provider without logout URL": {
+			providerLogout:   true,
+			logoutURL:        &url.URL{},
+			rd:               "/app",
+			expectedLocation: "/app",
+		},
+		"Relative redirect": {
+			providerLogout:   true,
+			logoutURL:        &url.URL{Scheme: "https", Host: "idp.example.com", Path: "/logout"},
+			rd:               "/app",
+			expectedLocation: "https://idp.example.com/logout?id_token_hint=id_token&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2Fapp",
+		},
+		"Invalid redirect": {
+			providerLogout:   true,
+			logoutURL:        &url.URL{Scheme: "https", Host: "idp.example.com", Path: "/logout"},
+			rd:               "https://evil.com/",
+			expectedLocation: "https://idp.example.com/logout?id_token_hint=id_token&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+				opts.ProviderLogout = tc.providerLogout
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.proxy.provider = &TestProvider{
+				ProviderData: &providers.ProviderData{LogoutURL: tc.logoutURL},
+			}
+
+			created := time.Now()
+			err = test.SaveSession(&sessions.SessionState{
+				Email: "john.doe@example.com", IDToken: "id_token", CreatedAt: &created})
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "http://example.com/oauth2/sign_out?rd="+url.QueryEscape(tc.rd), nil)
+			for _, c := range test.req.Cookies() {
+				req.AddCookie(c)
+			}
+			rw := httptest.NewRecorder()
+			test.proxy.ServeHTTP(rw, req)
+
+			assert.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+		})
+	}
+}
+
+func TestOAuthStartPKCE(t *testing.T) {
+	test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.CodeChallengeMethod = encryption.CodeChallengeMethodS256
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.proxy.provider = NewTestProvider(&url.URL{Host: "idp.example.com"}, "")
+
+	req := httptest.NewRequest("GET", "http://example.com/oauth2/start?rd=%2Fapp", nil)
+	rw := httptest.NewRecorder()
+	test.proxy.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusFound, rw.Code)
+
+	location, err := url.Parse(rw.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, encryption.CodeChallengeMethodS256, location.Query().Get("code_challenge_method"))
+
+	var csrf *http.Cookie
+	for _, c := range rw.Result().Cookies() {
+		if c.Name == test.proxy.CSRFCookieName {
+			csrf = c
+		}
+	}
+	require.NotNil(t, csrf)
+
+	callback := httptest.NewRequest("GET", "http://example.com/oauth2/callback", nil)
+	callback.AddCookie(csrf)
+	csrfValue, err := test.proxy.loadCSRFCookieValue(callback)
+	require.NoError(t, err)
+
+	nonce, oidcNonce, codeVerifier, _ := parseCSRFCookieValue(csrfValue)
+	assert.Equal(t, nonce+":/app", location.Query().Get("state"))
+	assert.NotEqual(t, "", oidcNonce)
+	assert.Equal(t, oidcNonce, location.Query().Get("nonce"))
+	assert.NotEqual(t, "", codeVerifier)
+	assert.Equal(t, encryption.GenerateCodeChallenge(codeVerifier), location.Query().Get("code_challenge"))
+}
+
+func TestLoadCSRFCookieValue(t *testing.T) {
+	const previousCookieSecret = "previous+secret+thirtytwo+bytes!"
+	test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.Cookie.PreviousSecrets = []string{previousCookieSecret}
+	})
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		secret      string
+		expectError bool
+	}{
+		"signed with the cookie secret": {
+			secret:      rawCookieSecret,
+			expectError: false,
+		},
+		"signed with a previous cookie secret": {
+			secret:      previousCookieSecret,
+			expectError: false,
+		},
+		"signed with an unknown secret": {
+			secret:      "an-unknown-secret-for-the-cookie",
+			expectError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/oauth2/callback", nil)
+			req.AddCookie(&http.Cookie{
+				Name:  test.proxy.CSRFCookieName,
+				Value: encryption.SignedValueForPurpose(encryption.CSRFKey, tc.secret, test.proxy.CSRFCookieName, []byte("nonce::"), time.Now()),
+			})
+
+			value, err := test.proxy.loadCSRFCookieValue(req)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "nonce::", value)
+		})
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/oauth2/callback", nil)
+		req.AddCookie(&http.Cookie{Name: test.proxy.CSRFCookieName, Value: "nonce::"})
+
+		_, err := test.proxy.loadCSRFCookieValue(req)
+		assert.Error(t, err)
+	})
+}
+
 func TestAuthOnlyEndpointAccepted(t *testing.T) {
 	test, err := NewAuthOnlyEndpointTest()
 	if err != nil {
@@ -1277,6 +1468,79 @@ func TestAuthOnlyEndpointAccepted(t *testing.T) {
 	assert.Equal(t, "", string(bodyBytes))
 }
 
+func TestAuthOnlyEndpointUpstreamRules(t *testing.T) {
+	testCases := map[string]struct {
+		headers      map[string]string
+		email        string
+		expectedCode int
+	}{
+		"Allowed by the rule of the original URI": {
+			headers:      map[string]string{"X-Original-URI": "/admin/users?page=2"},
+			email:        "michael.bland@admins.example.com",
+			expectedCode: http.StatusAccepted,
+		},
+		"Denied by the rule of the original URI": {
+			headers:      map[string]string{"X-Original-URI": "/admin/users"},
+			email:        "michael.bland@gsa.gov",
+			expectedCode: http.StatusForbidden,
+		},
+		"Denied by the rule of the forwarded URI": {
+			headers:      map[string]string{"X-Forwarded-Uri": "/admin/"},
+			email:        "michael.bland@gsa.gov",
+			expectedCode: http.StatusForbidden,
+		},
+		"Original URI without a rule": {
+			headers:      map[string]string{"X-Original-URI": "/public"},
+			email:        "michael.bland@gsa.gov",
+			expectedCode: http.StatusAccepted,
+		},
+		"Missing original URI": {
+			email:        "michael.bland@admins.example.com",
+			expectedCode: http.StatusForbidden,
+		},
+		"Invalid original URI": {
+			headers:      map[string]string{"X-Original-URI": "admin"},
+			email:        "michael.bland@admins.example.com",
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test, err := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+				opts.UpstreamServers = options.Upstreams{
+					{
+						ID:   "public",
+						Path: "/",
+						URI:  "http://127.0.0.1:8080",
+					},
+					{
+						ID:                  "admin",
+						Path:                "/admin/",
+						URI:                 "http://127.0.0.1:8081",
+						AllowedEmailDomains: []string{"admins.example.com"},
+					},
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tc.headers {
+				test.req.Header.Set(key, value)
+			}
+
+			created := time.Now()
+			startSession := &sessions.SessionState{
+				Email: tc.email, AccessToken: "my_access_token", CreatedAt: &created}
+			err = test.SaveSession(startSession)
+			assert.NoError(t, err)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedCode, test.rw.Code)
+		})
+	}
+}
+
 func TestAuthOnlyEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
 	test, err := NewAuthOnlyEndpointTest()
 	if err != nil {
@@ -1309,6 +1573,95 @@ func TestAuthOnlyEndpointUnauthorizedOnExpiration(t *testing.T) {
 	assert.Equal(t, "unauthorized request\n", string(bodyBytes))
 }
 
+func TestAuthOnlyEndpointSessionLifetime(t *testing.T) {
+	testCases := map[string]struct {
+		authenticatedAt time.Duration
+		lastActivity    time.Duration
+		expectedCode    int
+	}{
+		"Within the maximum lifetime and idle timeout": {
+			authenticatedAt: 2 * time.Hour,
+			lastActivity:    10 * time.Minute,
+			expectedCode:    http.StatusAccepted,
+		},
+		"Exceeding the maximum lifetime": {
+			authenticatedAt: 13 * time.Hour,
+			lastActivity:    10 * time.Minute,
+			expectedCode:    http.StatusUnauthorized,
+		},
+		"Exceeding the idle timeout": {
+			authenticatedAt: 2 * time.Hour,
+			lastActivity:    45 * time.Minute,
+			expectedCode:    http.StatusUnauthorized,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			test, err := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+				opts.Session.MaxLifetime = 12 * time.Hour
+				opts.Session.IdleTimeout = 30 * time.Minute
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			created := time.Now()
+			authenticatedAt := created.Add(-tc.authenticatedAt)
+			lastActivity := created.Add(-tc.lastActivity)
+			startSession := &sessions.SessionState{
+				Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created,
+				AuthenticatedAt: &authenticatedAt, LastActivity: &lastActivity}
+			err = test.SaveSession(startSession)
+			assert.NoError(t, err)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedCode, test.rw.Code)
+		})
+	}
+}
+
+func TestAuthOnlyEndpointSessionBinding(t *testing.T) {
+	testCases := map[string]struct {
+		userAgent    string
+		expectedCode int
+	}{
+		"From the client that signed in": {
+			userAgent:    "Browser/1.0",
+			expectedCode: http.StatusAccepted,
+		},
+		"From another client": {
+			userAgent:    "Stolen/1.0",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			test, err := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+				opts.Session.Binding.IP = options.ExactIPSessionBinding
+				opts.Session.Binding.UserAgent = true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.req.RemoteAddr = "192.0.2.10:1234"
+			test.req.Header.Set("User-Agent", "Browser/1.0")
+			created := time.Now()
+			startSession := &sessions.SessionState{
+				Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created}
+			err = test.SaveSession(startSession)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, startSession.Binding)
+
+			test.req.Header.Set("User-Agent", tc.userAgent)
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedCode, test.rw.Code)
+		})
+	}
+}
+
 func TestAuthOnlyEndpointUnauthorizedOnEmailValidationFailure(t *testing.T) {
 	test, err := NewAuthOnlyEndpointTest()
 	if err != nil {
@@ -1328,6 +1681,55 @@ func TestAuthOnlyEndpointUnauthorizedOnEmailValidationFailure(t *testing.T) {
 	assert.Equal(t, "unauthorized request\n", string(bodyBytes))
 }
 
+func TestAuthOnlyEndpointAllowedGroups(t *testing.T) {
+	testCases := map[string]struct {
+		allowedGroups  []string
+		sessionGroups  []string
+		expectedStatus int
+	}{
+		"No allowed groups configured": {
+			allowedGroups:  []string{},
+			sessionGroups:  []string{},
+			expectedStatus: http.StatusAccepted,
+		},
+		"Session in an allowed group": {
+			allowedGroups:  []string{"a", "b"},
+			sessionGroups:  []string{"b", "c"},
+			expectedStatus: http.StatusAccepted,
+		},
+		"Session not in an allowed group": {
+			allowedGroups:  []string{"a", "b"},
+			sessionGroups:  []string{"c"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"Session without groups": {
+			allowedGroups:  []string{"a"},
+			sessionGroups:  []string{},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test, err := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+				opts.AllowedGroups = tc.allowedGroups
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			created := time.Now()
+			startSession := &sessions.SessionState{
+				Email: "michael.bland@gsa.gov", Groups: tc.sessionGroups, AccessToken: "my_access_token", CreatedAt: &created}
+			err = test.SaveSession(startSession)
+			assert.NoError(t, err)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedStatus, test.rw.Code)
+		})
+	}
+}
+
 func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 	var pcTest ProcessCookieTest
 
@@ -1354,7 +1756,8 @@ func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 
 	created := time.Now()
 	startSession := &sessions.SessionState{
-		User: "oauth_user", Email: "oauth_user@example.com", AccessToken: "oauth_token", CreatedAt: &created}
+		User: "oauth_user", Email: "oauth_user@example.com", Groups: []string{"oauth_group1", "oauth_group2"},
+		AccessToken: "oauth_token", CreatedAt: &created}
 	err = pcTest.SaveSession(startSession)
 	assert.NoError(t, err)
 
@@ -1362,6 +1765,47 @@ func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 	assert.Equal(t, http.StatusAccepted, pcTest.rw.Code)
 	assert.Equal(t, "oauth_user", pcTest.rw.Header().Get("X-Auth-Request-User"))
 	assert.Equal(t, "oauth_user@example.com", pcTest.rw.Header().Get("X-Auth-Request-Email"))
+	assert.Equal(t, "oauth_group1,oauth_group2", pcTest.rw.Header().Get("X-Auth-Request-Groups"))
+}
+
+func TestAuthOnlyEndpointInjectResponseHeaders(t *testing.T) {
+	test, err := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+		opts.InjectResponseHeaders = []options.Header{
+			{
+				Name:   "X-Auth-Request-Tenant",
+				Values: []options.HeaderValue{{Claim: "tenant"}},
+			},
+			{
+				Name:   "X-Auth-Request-Departments",
+				Values: []options.HeaderValue{{Claim: "departments", Prefix: "dept:"}},
+				Join:   ",",
+			},
+			{
+				Name:   "X-Auth-Request-Subject",
+				Values: []options.HeaderValue{{SessionField: "user"}},
+			},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Now()
+	startSession := &sessions.SessionState{
+		User: "oauth_user", Email: "oauth_user@example.com", AccessToken: "oauth_token", CreatedAt: &created,
+		Claims: map[string]interface{}{
+			"tenant":      "tenant-a",
+			"departments": []interface{}{"sales", "marketing"},
+		},
+	}
+	err = test.SaveSession(startSession)
+	assert.NoError(t, err)
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusAccepted, test.rw.Code)
+	assert.Equal(t, "tenant-a", test.rw.Header().Get("X-Auth-Request-Tenant"))
+	assert.Equal(t, "dept:sales,dept:marketing", test.rw.Header().Get("X-Auth-Request-Departments"))
+	assert.Equal(t, "oauth_user", test.rw.Header().Get("X-Auth-Request-Subject"))
 }
 
 func TestAuthOnlyEndpointSetBasicAuthTrueRequestHeaders(t *testing.T) {
@@ -1917,6 +2361,167 @@ func TestGetJwtSession(t *testing.T) {
 	assert.Equal(t, test.rw.Header().Get("X-Auth-Request-Email"), "john@example.com")
 }
 
+func newTestLogoutToken(claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	signature := base64.RawURLEncoding.EncodeToString([]byte("signature"))
+	return fmt.Sprintf("%s.%s.%s", header, base64.RawURLEncoding.EncodeToString(payload), signature)
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":    "https://issuer.example.com",
+			"aud":    "https://test.myapp.com",
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Minute).Unix(),
+			"sid":    "idp-session",
+			"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+		}
+	}
+
+	testCases := map[string]struct {
+		method        string
+		claims        func() map[string]interface{}
+		expectedCode  int
+		expectCleared bool
+	}{
+		"Valid logout token with sid": {
+			method:        http.MethodPost,
+			claims:        validClaims,
+			expectedCode:  http.StatusOK,
+			expectCleared: true,
+		},
+		"Valid logout token with sub": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				delete(claims, "sid")
+				claims["sub"] = "123456789"
+				return claims
+			},
+			expectedCode:  http.StatusOK,
+			expectCleared: true,
+		},
+		"Logout token for another session": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				claims["sid"] = "other-session"
+				return claims
+			},
+			expectedCode:  http.StatusOK,
+			expectCleared: false,
+		},
+		"Logout token without event": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				delete(claims, "events")
+				return claims
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectCleared: false,
+		},
+		"Logout token with nonce": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				claims["nonce"] = "nonce"
+				return claims
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectCleared: false,
+		},
+		"Logout token for another audience": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				claims["aud"] = "https://other.myapp.com"
+				return claims
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectCleared: false,
+		},
+		"Logout token of another provider": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				claims["iss"] = "https://other.example.com"
+				return claims
+			},
+			expectedCode:  http.StatusOK,
+			expectCleared: false,
+		},
+		"Logout token of an unknown issuer": {
+			method: http.MethodPost,
+			claims: func() map[string]interface{} {
+				claims := validClaims()
+				claims["iss"] = "https://unknown.example.com"
+				return claims
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectCleared: false,
+		},
+		"GET request": {
+			method:        http.MethodGet,
+			claims:        validClaims,
+			expectedCode:  http.StatusMethodNotAllowed,
+			expectCleared: false,
+		},
+	}
+
+	newIssuer := func(url, providerID string) oidcIssuer {
+		return oidcIssuer{
+			url:      url,
+			provider: &TestProvider{ProviderData: &providers.ProviderData{ID: providerID}},
+			verifier: oidc.NewVerifier(url, NoOpKeySet{}, &oidc.Config{ClientID: "https://test.myapp.com"}),
+		}
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test, err := NewProcessCookieTestWithDefaults()
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.proxy.oidcIssuers = []oidcIssuer{
+				newIssuer("https://issuer.example.com", ""),
+				newIssuer("https://other.example.com", "other"),
+			}
+			store := persistence.NewManager(sessionstests.NewMockStore(), &test.opts.Session, &test.opts.Cookie)
+			test.proxy.sessionStore = store
+
+			created := time.Now()
+			err = test.SaveSession(&sessions.SessionState{
+				Email:         "john.doe@example.com",
+				User:          "123456789",
+				IDToken:       "id_token",
+				OIDCSessionID: "idp-session",
+				CreatedAt:     &created,
+			})
+			assert.NoError(t, err)
+
+			form := url.Values{}
+			form.Set("logout_token", newTestLogoutToken(tc.claims()))
+			req := httptest.NewRequest(tc.method, "/oauth2/backchannel-logout", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rw := httptest.NewRecorder()
+			test.proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+
+			session, err := store.Load(test.req)
+			if tc.expectCleared {
+				assert.Error(t, err)
+				assert.Nil(t, session)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "john.doe@example.com", session.Email)
+			}
+		})
+	}
+}
+
 func Test_prepareNoCache(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		prepareNoCache(w)
@@ -2199,3 +2804,165 @@ func TestTrustedIPs(t *testing.T) {
 		})
 	}
 }
+
+func TestMultipleProviders(t *testing.T) {
+	opts := baseTestOptions()
+	opts.Providers = options.Providers{
+		{ID: "employees", Type: "azure", Name: "Employees", ClientID: "azure-client", ClientSecret: "azure-secret"},
+		{ID: "contractors", Type: "github", ClientID: "github-client", ClientSecret: "github-secret"},
+	}
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	// The sign in page has a button for every provider
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/sign_in", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), `name="provider" value="employees">Sign in with Employees</button>`)
+	assert.Contains(t, rw.Body.String(), `name="provider" value="contractors">Sign in with GitHub</button>`)
+
+	// Starting the flow redirects to the chosen provider with its ID in the state
+	rw = httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/start?provider=contractors&rd=%2Fapp", nil))
+	assert.Equal(t, http.StatusFound, rw.Code)
+	location, err := url.Parse(rw.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "github.com", location.Host)
+	assert.Equal(t, "github-client", location.Query().Get("client_id"))
+	assert.Regexp(t, "^[0-9a-f]+@contractors:/app$", location.Query().Get("state"))
+
+	rw = httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/start?provider=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestMultipleProvidersCallback(t *testing.T) {
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	defer providerServer.Close()
+	providerURL, _ := url.Parse(providerServer.URL)
+
+	opts := baseTestOptions()
+	require.NoError(t, validation.Validate(opts))
+
+	employees := NewTestProvider(providerURL, "employee@example.com")
+	employees.ID = "employees"
+	contractors := NewTestProvider(providerURL, "contractor@example.com")
+	contractors.ID = "contractors"
+	opts.SetProvider(employees)
+	opts.SetProviders([]providers.Provider{employees, contractors})
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	startFlow := func(providerID string) (*http.Cookie, string) {
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/start?rd=%2Fapp&provider="+providerID, nil))
+		require.Equal(t, http.StatusFound, rw.Code)
+		location, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		for _, c := range rw.Result().Cookies() {
+			if c.Name == proxy.CSRFCookieName {
+				return c, location.Query().Get("state")
+			}
+		}
+		t.Fatal("CSRF cookie not set")
+		return nil, ""
+	}
+
+	// The session is created by the provider in the state
+	csrf, state := startFlow("contractors")
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/oauth2/callback?code=callback_code&state="+url.QueryEscape(state), nil)
+	req.AddCookie(csrf)
+	proxy.ServeHTTP(rw, req)
+	require.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/app", rw.Header().Get("Location"))
+
+	req = httptest.NewRequest("GET", "/", nil)
+	for _, c := range rw.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	session, err := proxy.LoadCookiedSession(req)
+	require.NoError(t, err)
+	assert.Equal(t, "contractors", session.ProviderID)
+	assert.Equal(t, "contractor@example.com", session.Email)
+
+	// The state can't switch to a provider other than the one the flow was
+	// started with
+	csrf, state = startFlow("contractors")
+	rw = httptest.NewRecorder()
+	switched := strings.Replace(state, "@contractors:", "@employees:", 1)
+	req = httptest.NewRequest("GET", "/oauth2/callback?code=callback_code&state="+url.QueryEscape(switched), nil)
+	req.AddCookie(csrf)
+	proxy.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+}
+
+func TestSAMLProvider(t *testing.T) {
+	opts := baseTestOptions()
+	opts.ProviderType = "saml"
+	opts.ClientID = "oauth2-proxy"
+	opts.ClientSecret = ""
+	opts.SAMLIdPMetadataFile = "pkg/saml/testdata/idp_metadata.xml"
+	opts.RawRedirectURL = "https://proxy.example.com/oauth2/callback"
+	require.NoError(t, validation.Validate(opts))
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+
+	// The metadata has the callback as the assertion consumer service
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/saml/metadata", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/samlmetadata+xml", rw.Header().Get("Content-Type"))
+	assert.Contains(t, rw.Body.String(), `entityID="oauth2-proxy"`)
+	assert.Contains(t, rw.Body.String(), `Location="https://proxy.example.com/oauth2/callback"`)
+
+	// Starting the flow sends an AuthnRequest with the state as relay state
+	rw = httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/start?rd=%2Fapp", nil))
+	assert.Equal(t, http.StatusFound, rw.Code)
+	location, err := url.Parse(rw.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "idp.example.com", location.Host)
+	assert.NotEmpty(t, location.Query().Get("SAMLRequest"))
+	assert.Regexp(t, "^[0-9a-f]+:/app$", location.Query().Get("RelayState"))
+
+	// The fixture responds to the AuthnRequest for the nonce "request1"
+	postResponse := func(requestNonce string) *httptest.ResponseRecorder {
+		samlResponse, err := ioutil.ReadFile("pkg/saml/testdata/response_signed_assertion.xml")
+		require.NoError(t, err)
+		form := url.Values{
+			"SAMLResponse": {base64.StdEncoding.EncodeToString(samlResponse)},
+			"RelayState":   {oauthState("0123abcd", "", "/app")},
+		}
+		req := httptest.NewRequest("POST", "/oauth2/callback", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(proxy.MakeCSRFCookie(req, csrfCookieValue("0123abcd", requestNonce, "", ""), time.Hour, time.Now()))
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw = postResponse("request1")
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/app", rw.Header().Get("Location"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rw.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	session, err := proxy.LoadCookiedSession(req)
+	require.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", session.Email)
+	assert.Equal(t, "jane.doe@example.com", session.User)
+	assert.Equal(t, []string{"admins", "developers & testers"}, session.Groups)
+
+	// Responses to the AuthnRequests of other browsers are rejected
+	rw = postResponse("request2")
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+}
@@ -0,0 +1,36 @@
+package options
+
+// AlphaOptions contains structured configuration that cannot be expressed
+// as flags. These options are loaded from the JSON file given by the
+// `--alpha-config` flag and their structure may change between minor
+// releases.
+type AlphaOptions struct {
+	// Upstreams is used to configure upstream servers.
+	// When set, these replace any upstreams configured by the legacy
+	// `--upstream` flag.
+	Upstreams Upstreams `json:"upstreams,omitempty"`
+
+	// InjectRequestHeaders is used to configure headers that should be added
+	// to requests to upstream servers.
+	InjectRequestHeaders []Header `json:"injectRequestHeaders,omitempty"`
+
+	// InjectResponseHeaders is used to configure headers that should be added
+	// to responses from the proxy, eg. the auth_request response.
+	InjectResponseHeaders []Header `json:"injectResponseHeaders,omitempty"`
+
+	// Providers is used to configure the identity providers users can sign
+	// in with. When set, these replace the provider configured by the legacy
+	// `--provider` flag.
+	Providers Providers `json:"providers,omitempty"`
+}
+
+// MergeInto replaces the structured options in opts with those configured
+// in the AlphaOptions.
+func (a *AlphaOptions) MergeInto(opts *Options) {
+	if len(a.Upstreams) > 0 {
+		opts.UpstreamServers = a.Upstreams
+	}
+	opts.InjectRequestHeaders = a.InjectRequestHeaders
+	opts.InjectResponseHeaders = a.InjectResponseHeaders
+	opts.Providers = a.Providers
+}
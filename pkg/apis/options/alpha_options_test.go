@@ -0,0 +1,120 @@
+package options
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AlphaOptions", func() {
+	var configFileName string
+
+	AfterEach(func() {
+		if configFileName != "" {
+			Expect(os.Remove(configFileName)).To(Succeed())
+			configFileName = ""
+		}
+	})
+
+	writeConfig := func(config string) {
+		f, err := ioutil.TempFile("", "oauth2-proxy-alpha-config-test")
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		configFileName = f.Name()
+
+		_, err = f.WriteString(config)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("loads and merges the alpha config", func() {
+		writeConfig(`{
+			"upstreams": [
+				{"id": "app", "path": "/", "uri": "http://localhost:8080", "allowedGroups": ["admins"]}
+			],
+			"injectRequestHeaders": [
+				{"name": "X-Tenant", "values": [{"claim": "tenant"}]}
+			],
+			"injectResponseHeaders": [
+				{"name": "X-Auth-Request-Groups", "values": [{"sessionField": "groups", "prefix": "group:"}], "join": ","}
+			],
+			"providers": [
+				{"id": "employees", "provider": "azure", "name": "Employees", "clientID": "azure-client", "clientSecret": "azure-secret", "azureTenant": "example"},
+				{"id": "contractors", "provider": "github", "clientID": "github-client", "clientSecretFile": "/etc/github-secret", "githubOrg": "example"}
+			]
+		}`)
+
+		alphaOpts := &AlphaOptions{}
+		Expect(LoadJSON(configFileName, alphaOpts)).To(Succeed())
+
+		opts := NewOptions()
+		opts.UpstreamServers = Upstreams{{ID: "legacy", Path: "/legacy", URI: "http://localhost:9090"}}
+		alphaOpts.MergeInto(opts)
+
+		Expect(opts.UpstreamServers).To(Equal(Upstreams{
+			{
+				ID:            "app",
+				Path:          "/",
+				URI:           "http://localhost:8080",
+				AllowedGroups: []string{"admins"},
+			},
+		}))
+		Expect(opts.InjectRequestHeaders).To(Equal([]Header{
+			{
+				Name:   "X-Tenant",
+				Values: []HeaderValue{{Claim: "tenant"}},
+			},
+		}))
+		Expect(opts.InjectResponseHeaders).To(Equal([]Header{
+			{
+				Name:   "X-Auth-Request-Groups",
+				Values: []HeaderValue{{SessionField: "groups", Prefix: "group:"}},
+				Join:   ",",
+			},
+		}))
+		Expect(opts.Providers).To(Equal(Providers{
+			{
+				ID:           "employees",
+				Type:         "azure",
+				Name:         "Employees",
+				ClientID:     "azure-client",
+				ClientSecret: "azure-secret",
+				AzureTenant:  "example",
+			},
+			{
+				ID:               "contractors",
+				Type:             "github",
+				ClientID:         "github-client",
+				ClientSecretFile: "/etc/github-secret",
+				GitHubOrg:        "example",
+			},
+		}))
+	})
+
+	It("keeps the legacy upstreams when none are configured", func() {
+		writeConfig(`{"injectRequestHeaders": []}`)
+
+		alphaOpts := &AlphaOptions{}
+		Expect(LoadJSON(configFileName, alphaOpts)).To(Succeed())
+
+		opts := NewOptions()
+		legacyUpstreams := Upstreams{{ID: "legacy", Path: "/legacy", URI: "http://localhost:9090"}}
+		opts.UpstreamServers = legacyUpstreams
+		alphaOpts.MergeInto(opts)
+
+		Expect(opts.UpstreamServers).To(Equal(legacyUpstreams))
+	})
+
+	It("rejects unknown keys", func() {
+		writeConfig(`{"unknown": true}`)
+
+		err := LoadJSON(configFileName, &AlphaOptions{})
+		Expect(err).To(MatchError(`error unmarshalling config: json: unknown field "unknown"`))
+	})
+
+	It("returns an error for a missing file", func() {
+		err := LoadJSON("/does/not/exist.json", &AlphaOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,57 @@
+package options
+
+import "github.com/spf13/pflag"
+
+// ClientTLS contains configuration options for authenticating clients with
+// X.509 client certificates, presented to the HTTPS listener or forwarded by
+// a trusted front proxy
+type ClientTLS struct {
+	CAFiles        []string `flag:"tls-client-ca-file" cfg:"tls_client_ca_files"`
+	UserField      string   `flag:"tls-client-cert-user" cfg:"tls_client_cert_user"`
+	Header         string   `flag:"tls-client-cert-header" cfg:"tls_client_cert_header"`
+	HeaderFormat   string   `flag:"tls-client-cert-header-format" cfg:"tls_client_cert_header_format"`
+	TrustedProxies []string `flag:"tls-client-cert-trusted-proxy" cfg:"tls_client_cert_trusted_proxies"`
+}
+
+// ClientCertUserCommonName is used to indicate the user of client certificate
+// sessions is the common name of the subject.
+var ClientCertUserCommonName = "cn"
+
+// ClientCertUserEmail is used to indicate the user of client certificate
+// sessions is the first email address SAN.
+var ClientCertUserEmail = "email"
+
+// ClientCertUserURI is used to indicate the user of client certificate
+// sessions is the first URI SAN.
+var ClientCertUserURI = "uri"
+
+// PEMClientCertHeader is used to indicate the client certificate header is a
+// URL encoded PEM certificate, optionally followed by its intermediates, eg.
+// nginx's $ssl_client_escaped_cert.
+var PEMClientCertHeader = "pem"
+
+// XFCCClientCertHeader is used to indicate the client certificate header is
+// an X-Forwarded-Client-Cert header, as set by Envoy, with the Cert or Chain
+// of the client.
+var XFCCClientCertHeader = "xfcc"
+
+func clientTLSFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("clienttls", pflag.ExitOnError)
+
+	flagSet.StringSlice("tls-client-ca-file", []string{}, "authenticate clients presenting a certificate issued by one of these CAs (may be given multiple times)")
+	flagSet.String("tls-client-cert-user", "cn", "the field of client certificates sessions take the user from (one of: cn, email, uri)")
+	flagSet.String("tls-client-cert-header", "", "read client certificates from this header set by a trusted front proxy instead of the TLS connection; requires --tls-client-cert-trusted-proxy")
+	flagSet.String("tls-client-cert-header-format", "pem", "the format of the client certificate header (one of: pem, xfcc)")
+	flagSet.StringSlice("tls-client-cert-trusted-proxy", []string{}, "IPs or CIDR ranges of the front proxies allowed to set the client certificate header, matched against the address of the connection (may be given multiple times)")
+
+	return flagSet
+}
+
+// clientTLSDefaults creates a ClientTLS populating each field with its
+// default value
+func clientTLSDefaults() ClientTLS {
+	return ClientTLS{
+		UserField:    "cn",
+		HeaderFormat: "pem",
+	}
+}
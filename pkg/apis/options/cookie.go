@@ -8,15 +8,16 @@ import (
 
 // Cookie contains configuration options relating to Cookie configuration
 type Cookie struct {
-	Name     string        `flag:"cookie-name" cfg:"cookie_name"`
-	Secret   string        `flag:"cookie-secret" cfg:"cookie_secret"`
-	Domains  []string      `flag:"cookie-domain" cfg:"cookie_domains"`
-	Path     string        `flag:"cookie-path" cfg:"cookie_path"`
-	Expire   time.Duration `flag:"cookie-expire" cfg:"cookie_expire"`
-	Refresh  time.Duration `flag:"cookie-refresh" cfg:"cookie_refresh"`
-	Secure   bool          `flag:"cookie-secure" cfg:"cookie_secure"`
-	HTTPOnly bool          `flag:"cookie-httponly" cfg:"cookie_httponly"`
-	SameSite string        `flag:"cookie-samesite" cfg:"cookie_samesite"`
+	Name            string        `flag:"cookie-name" cfg:"cookie_name"`
+	Secret          string        `flag:"cookie-secret" cfg:"cookie_secret"`
+	PreviousSecrets []string      `flag:"cookie-previous-secret" cfg:"cookie_previous_secrets"`
+	Domains         []string      `flag:"cookie-domain" cfg:"cookie_domains"`
+	Path            string        `flag:"cookie-path" cfg:"cookie_path"`
+	Expire          time.Duration `flag:"cookie-expire" cfg:"cookie_expire"`
+	Refresh         time.Duration `flag:"cookie-refresh" cfg:"cookie_refresh"`
+	Secure          bool          `flag:"cookie-secure" cfg:"cookie_secure"`
+	HTTPOnly        bool          `flag:"cookie-httponly" cfg:"cookie_httponly"`
+	SameSite        string        `flag:"cookie-samesite" cfg:"cookie_samesite"`
 }
 
 func cookieFlagSet() *pflag.FlagSet {
@@ -24,6 +25,7 @@ func cookieFlagSet() *pflag.FlagSet {
 
 	flagSet.String("cookie-name", "_oauth2_proxy", "the name of the cookie that the oauth_proxy creates")
 	flagSet.String("cookie-secret", "", "the seed string for secure cookies (optionally base64 encoded)")
+	flagSet.StringSlice("cookie-previous-secret", []string{}, "previous cookie secrets, only used to validate existing cookies after the cookie-secret is rotated (may be given multiple times)")
 	flagSet.StringSlice("cookie-domain", []string{}, "Optional cookie domains to force cookies to (ie: `.yourcompany.com`). The longest domain matching the request's host will be used (or the shortest cookie domain if there is no match).")
 	flagSet.String("cookie-path", "/", "an optional cookie path to force cookies to (ie: /poc/)*")
 	flagSet.Duration("cookie-expire", time.Duration(168)*time.Hour, "expire timeframe for cookie")
@@ -38,14 +40,23 @@ func cookieFlagSet() *pflag.FlagSet {
 // cookieDefaults creates a Cookie populating each field with its default value
 func cookieDefaults() Cookie {
 	return Cookie{
-		Name:     "_oauth2_proxy",
-		Secret:   "",
-		Domains:  nil,
-		Path:     "/",
-		Expire:   time.Duration(168) * time.Hour,
-		Refresh:  time.Duration(0),
-		Secure:   true,
-		HTTPOnly: true,
-		SameSite: "",
+		Name:            "_oauth2_proxy",
+		Secret:          "",
+		PreviousSecrets: nil,
+		Domains:         nil,
+		Path:            "/",
+		Expire:          time.Duration(168) * time.Hour,
+		Refresh:         time.Duration(0),
+		Secure:          true,
+		HTTPOnly:        true,
+		SameSite:        "",
 	}
 }
+
+// Secrets returns the ordered list of cookie secrets.
+// The first secret is used to sign and encrypt cookies, the previous secrets
+// are only used to validate and decrypt cookies created before the secret was
+// rotated.
+func (c *Cookie) Secrets() []string {
+	return append([]string{c.Secret}, c.PreviousSecrets...)
+}
@@ -0,0 +1,39 @@
+package options
+
+// Header represents an individual header that will be added to a request or
+// response header.
+type Header struct {
+	// Name is the header name to be used for this set of values.
+	// Names should be unique within a list of Headers.
+	Name string `json:"name"`
+
+	// Values contains the desired values for this header.
+	// Values from multiple sources and multi-valued claims are each added to
+	// the header separately unless Join is set.
+	Values []HeaderValue `json:"values"`
+
+	// Join, when set, combines all of the values into a single header value
+	// using Join as the separator.
+	// Eg. a Join of "," passes groups as "group-a,group-b".
+	Join string `json:"join,omitempty"`
+}
+
+// HeaderValue represents a single source for the value of a header.
+// Exactly one of Value, Claim or SessionField must be set.
+type HeaderValue struct {
+	// Value is a static value for the header.
+	Value string `json:"value,omitempty"`
+
+	// Claim is the name of an ID Token or userinfo claim stored in the
+	// session. Claims that are arrays produce one value per element.
+	Claim string `json:"claim,omitempty"`
+
+	// SessionField is the name of a field of the session.
+	// Must be one of: user, email, preferredUsername, groups, accessToken,
+	// idToken.
+	SessionField string `json:"sessionField,omitempty"`
+
+	// Prefix is added to the start of each value from this source.
+	// Eg. a Prefix of "Bearer " with the accessToken session field.
+	Prefix string `json:"prefix,omitempty"`
+}
@@ -0,0 +1,41 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Introspection contains configuration options for loading sessions from
+// opaque bearer tokens with the token introspection endpoint (RFC 7662) of
+// the provider
+type Introspection struct {
+	Enabled         bool          `flag:"introspect-bearer-tokens" cfg:"introspect_bearer_tokens"`
+	URL             string        `flag:"introspection-url" cfg:"introspection_url"`
+	RequiredScopes  []string      `flag:"introspection-required-scope" cfg:"introspection_required_scopes"`
+	Audiences       []string      `flag:"introspection-audience" cfg:"introspection_audiences"`
+	CacheTTL        time.Duration `flag:"introspection-cache-ttl" cfg:"introspection_cache_ttl"`
+	CacheMaxEntries int           `flag:"introspection-cache-max-entries" cfg:"introspection_cache_max_entries"`
+}
+
+func introspectionFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("introspection", pflag.ExitOnError)
+
+	flagSet.Bool("introspect-bearer-tokens", false, "load sessions from bearer tokens with the token introspection endpoint of the provider")
+	flagSet.String("introspection-url", "", "Token introspection endpoint; defaults to the OIDC `introspection_endpoint` when discovered")
+	flagSet.StringSlice("introspection-required-scope", []string{}, "scopes introspected tokens must have (may be given multiple times)")
+	flagSet.StringSlice("introspection-audience", []string{}, "audiences introspected tokens must have one of (may be given multiple times)")
+	flagSet.Duration("introspection-cache-ttl", time.Minute, "how long introspection results are cached; active tokens are never cached past their expiry")
+	flagSet.Int("introspection-cache-max-entries", 10000, "the most introspection results cached")
+
+	return flagSet
+}
+
+// introspectionDefaults creates an Introspection populating each field with
+// its default value
+func introspectionDefaults() Introspection {
+	return Introspection{
+		CacheTTL:        time.Minute,
+		CacheMaxEntries: 10000,
+	}
+}
@@ -0,0 +1,50 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// LDAP contains configuration options for validating basic auth and sign in
+// form credentials against an LDAP server
+type LDAP struct {
+	URL          string        `flag:"ldap-url" cfg:"ldap_url"`
+	StartTLS     bool          `flag:"ldap-start-tls" cfg:"ldap_start_tls"`
+	CAFile       string        `flag:"ldap-ca-file" cfg:"ldap_ca_file"`
+	BindDN       string        `flag:"ldap-bind-dn" cfg:"ldap_bind_dn"`
+	BindPassword string        `flag:"ldap-bind-password" cfg:"ldap_bind_password"`
+	UserBaseDN   string        `flag:"ldap-user-base-dn" cfg:"ldap_user_base_dn"`
+	UserFilter   string        `flag:"ldap-user-filter" cfg:"ldap_user_filter"`
+	GroupBaseDN  string        `flag:"ldap-group-base-dn" cfg:"ldap_group_base_dn"`
+	GroupFilter  string        `flag:"ldap-group-filter" cfg:"ldap_group_filter"`
+	Timeout      time.Duration `flag:"ldap-timeout" cfg:"ldap_timeout"`
+	CacheTTL     time.Duration `flag:"ldap-cache-ttl" cfg:"ldap_cache_ttl"`
+}
+
+func ldapFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("ldap", pflag.ExitOnError)
+
+	flagSet.String("ldap-url", "", "authenticate basic auth and sign in form credentials against the LDAP server at the URL (ie: ldaps://ldap.example.com)")
+	flagSet.Bool("ldap-start-tls", false, "upgrade ldap:// connections to TLS with StartTLS")
+	flagSet.String("ldap-ca-file", "", "path to the CA certificates verifying the LDAP server, instead of the system trust sources")
+	flagSet.String("ldap-bind-dn", "", "DN of the service account searching for users; searches are anonymous if empty")
+	flagSet.String("ldap-bind-password", "", "password of the service account")
+	flagSet.String("ldap-user-base-dn", "", "DN of the subtree searched for users")
+	flagSet.String("ldap-user-filter", "(uid={username})", "filter finding the user entry, {username} is replaced with the escaped username")
+	flagSet.String("ldap-group-base-dn", "", "DN of the subtree searched by the group filter (defaults to the user base DN)")
+	flagSet.String("ldap-group-filter", "", "filter users must match an entry with to sign in, {dn} and {username} are replaced with the escaped DN and name of the user (ie: (&(cn=admins)(member={dn})))")
+	flagSet.Duration("ldap-timeout", 10*time.Second, "timeout of the requests to the LDAP server")
+	flagSet.Duration("ldap-cache-ttl", time.Minute, "how long credentials validated against the LDAP server are accepted without binding again; 0 disables the cache")
+
+	return flagSet
+}
+
+// ldapDefaults creates a LDAP populating each field with its default value
+func ldapDefaults() LDAP {
+	return LDAP{
+		UserFilter: "(uid={username})",
+		Timeout:    10 * time.Second,
+		CacheTTL:   time.Minute,
+	}
+}
@@ -1,7 +1,9 @@
 package options
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
 
@@ -48,6 +50,25 @@ func Load(configFileName string, flagSet *pflag.FlagSet, into interface{}) error
 	return nil
 }
 
+// LoadJSON reads the JSON config file at the path given into the options
+// provided. Keys in the file that do not map to fields of the options are
+// reported as errors.
+func LoadJSON(configFileName string, into interface{}) error {
+	f, err := os.Open(configFileName)
+	if err != nil {
+		return fmt.Errorf("unable to load config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(into); err != nil {
+		return fmt.Errorf("error unmarshalling config: %w", err)
+	}
+
+	return nil
+}
+
 // registerFlags uses `cfg` and `flag` tags to associate flags in the flagSet
 // to the fields in the options interface provided.
 // Each exported field in the options must have a `cfg` tag otherwise an error will occur.
@@ -2,8 +2,10 @@ package options
 
 import (
 	"crypto"
+	"crypto/x509"
 	"net/url"
 	"regexp"
+	"time"
 
 	oidc "github.com/coreos/go-oidc"
 	ipapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/ip"
@@ -39,9 +41,12 @@ type Options struct {
 	AuthenticatedEmailsFile  string   `flag:"authenticated-emails-file" cfg:"authenticated_emails_file"`
 	KeycloakGroup            string   `flag:"keycloak-group" cfg:"keycloak_group"`
 	AzureTenant              string   `flag:"azure-tenant" cfg:"azure_tenant"`
+	AzureGroups              []string `flag:"azure-group" cfg:"azure_groups"`
+	AzureAppRoles            []string `flag:"azure-app-role" cfg:"azure_app_roles"`
 	BitbucketTeam            string   `flag:"bitbucket-team" cfg:"bitbucket_team"`
 	BitbucketRepository      string   `flag:"bitbucket-repository" cfg:"bitbucket_repository"`
 	EmailDomains             []string `flag:"email-domain" cfg:"email_domains"`
+	AllowedGroups            []string `flag:"allowed-group" cfg:"allowed_groups"`
 	WhitelistDomains         []string `flag:"whitelist-domain" cfg:"whitelist_domains"`
 	GitHubOrg                string   `flag:"github-org" cfg:"github_org"`
 	GitHubTeam               string   `flag:"github-team" cfg:"github_team"`
@@ -58,14 +63,22 @@ type Options struct {
 	Banner                   string   `flag:"banner" cfg:"banner"`
 	Footer                   string   `flag:"footer" cfg:"footer"`
 
-	Cookie  Cookie         `cfg:",squash"`
-	Session SessionOptions `cfg:",squash"`
-	Logging Logging        `cfg:",squash"`
+	Cookie        Cookie         `cfg:",squash"`
+	Session       SessionOptions `cfg:",squash"`
+	Logging       Logging        `cfg:",squash"`
+	LDAP          LDAP           `cfg:",squash"`
+	Introspection Introspection  `cfg:",squash"`
+	ClientTLS     ClientTLS      `cfg:",squash"`
 
 	// Not used in the legacy config, name not allowed to match an external key (upstreams)
 	// TODO(JoelSpeed): Rename when legacy config is removed
 	UpstreamServers Upstreams `cfg:",internal"`
 
+	// Not used in the legacy config, set from the alpha config
+	InjectRequestHeaders  []Header  `cfg:",internal"`
+	InjectResponseHeaders []Header  `cfg:",internal"`
+	Providers             Providers `cfg:",internal"`
+
 	SkipAuthRegex         []string `flag:"skip-auth-regex" cfg:"skip_auth_regex"`
 	SkipAuthStripHeaders  bool     `flag:"skip-auth-strip-headers" cfg:"skip_auth_strip_headers"`
 	SkipJwtBearerTokens   bool     `flag:"skip-jwt-bearer-tokens" cfg:"skip_jwt_bearer_tokens"`
@@ -93,8 +106,12 @@ type Options struct {
 	InsecureOIDCSkipIssuerVerification bool     `flag:"insecure-oidc-skip-issuer-verification" cfg:"insecure_oidc_skip_issuer_verification"`
 	SkipOIDCDiscovery                  bool     `flag:"skip-oidc-discovery" cfg:"skip_oidc_discovery"`
 	OIDCJwksURL                        string   `flag:"oidc-jwks-url" cfg:"oidc_jwks_url"`
+	OIDCGroupsClaim                    string   `flag:"oidc-groups-claim" cfg:"oidc_groups_claim"`
 	LoginURL                           string   `flag:"login-url" cfg:"login_url"`
 	RedeemURL                          string   `flag:"redeem-url" cfg:"redeem_url"`
+	LogoutURL                          string   `flag:"logout-url" cfg:"logout_url"`
+	ProviderLogout                     bool     `flag:"provider-logout" cfg:"provider_logout"`
+	CodeChallengeMethod                string   `flag:"code-challenge-method" cfg:"code_challenge_method"`
 	ProfileURL                         string   `flag:"profile-url" cfg:"profile_url"`
 	ProtectedResource                  string   `flag:"resource" cfg:"resource"`
 	ValidateURL                        string   `flag:"validate-url" cfg:"validate_url"`
@@ -102,6 +119,10 @@ type Options struct {
 	Prompt                             string   `flag:"prompt" cfg:"prompt"`
 	ApprovalPrompt                     string   `flag:"approval-prompt" cfg:"approval_prompt"` // Deprecated by OIDC 1.0
 	UserIDClaim                        string   `flag:"user-id-claim" cfg:"user_id_claim"`
+	SAMLIdPMetadataFile                string   `flag:"saml-idp-metadata-file" cfg:"saml_idp_metadata_file"`
+	SAMLEmailAttribute                 string   `flag:"saml-email-attribute" cfg:"saml_email_attribute"`
+	SAMLUserAttribute                  string   `flag:"saml-user-attribute" cfg:"saml_user_attribute"`
+	SAMLGroupsAttribute                string   `flag:"saml-groups-attribute" cfg:"saml_groups_attribute"`
 
 	SignatureKey    string `flag:"signature-key" cfg:"signature_key"`
 	AcrValues       string `flag:"acr-values" cfg:"acr_values"`
@@ -109,34 +130,48 @@ type Options struct {
 	JWTKeyFile      string `flag:"jwt-key-file" cfg:"jwt_key_file"`
 	PubJWKURL       string `flag:"pubjwk-url" cfg:"pubjwk_url"`
 	GCPHealthChecks bool   `flag:"gcp-healthchecks" cfg:"gcp_healthchecks"`
+	AdminToken      string `flag:"admin-token" cfg:"admin_token"`
 
 	// internal values that are set after config validation
 	redirectURL        *url.URL
 	compiledRegex      []*regexp.Regexp
 	provider           providers.Provider
+	providers          []providers.Provider
 	signatureData      *SignatureData
 	oidcVerifier       *oidc.IDTokenVerifier
+	providerVerifiers  map[string]*oidc.IDTokenVerifier
 	jwtBearerVerifiers []*oidc.IDTokenVerifier
 	realClientIPParser ipapi.RealClientIPParser
+	clientCAPool       *x509.CertPool
 }
 
 // Options for Getting internal values
-func (o *Options) GetRedirectURL() *url.URL                        { return o.redirectURL }
-func (o *Options) GetCompiledRegex() []*regexp.Regexp              { return o.compiledRegex }
-func (o *Options) GetProvider() providers.Provider                 { return o.provider }
-func (o *Options) GetSignatureData() *SignatureData                { return o.signatureData }
-func (o *Options) GetOIDCVerifier() *oidc.IDTokenVerifier          { return o.oidcVerifier }
+func (o *Options) GetRedirectURL() *url.URL               { return o.redirectURL }
+func (o *Options) GetCompiledRegex() []*regexp.Regexp     { return o.compiledRegex }
+func (o *Options) GetProvider() providers.Provider        { return o.provider }
+func (o *Options) GetProviders() []providers.Provider     { return o.providers }
+func (o *Options) GetSignatureData() *SignatureData       { return o.signatureData }
+func (o *Options) GetOIDCVerifier() *oidc.IDTokenVerifier { return o.oidcVerifier }
+func (o *Options) GetProviderOIDCVerifiers() map[string]*oidc.IDTokenVerifier {
+	return o.providerVerifiers
+}
 func (o *Options) GetJWTBearerVerifiers() []*oidc.IDTokenVerifier  { return o.jwtBearerVerifiers }
 func (o *Options) GetRealClientIPParser() ipapi.RealClientIPParser { return o.realClientIPParser }
+func (o *Options) GetClientCAPool() *x509.CertPool                 { return o.clientCAPool }
 
 // Options for Setting internal values
-func (o *Options) SetRedirectURL(s *url.URL)                        { o.redirectURL = s }
-func (o *Options) SetCompiledRegex(s []*regexp.Regexp)              { o.compiledRegex = s }
-func (o *Options) SetProvider(s providers.Provider)                 { o.provider = s }
-func (o *Options) SetSignatureData(s *SignatureData)                { o.signatureData = s }
-func (o *Options) SetOIDCVerifier(s *oidc.IDTokenVerifier)          { o.oidcVerifier = s }
+func (o *Options) SetRedirectURL(s *url.URL)               { o.redirectURL = s }
+func (o *Options) SetCompiledRegex(s []*regexp.Regexp)     { o.compiledRegex = s }
+func (o *Options) SetProvider(s providers.Provider)        { o.provider = s }
+func (o *Options) SetProviders(s []providers.Provider)     { o.providers = s }
+func (o *Options) SetSignatureData(s *SignatureData)       { o.signatureData = s }
+func (o *Options) SetOIDCVerifier(s *oidc.IDTokenVerifier) { o.oidcVerifier = s }
+func (o *Options) SetProviderOIDCVerifiers(s map[string]*oidc.IDTokenVerifier) {
+	o.providerVerifiers = s
+}
 func (o *Options) SetJWTBearerVerifiers(s []*oidc.IDTokenVerifier)  { o.jwtBearerVerifiers = s }
 func (o *Options) SetRealClientIPParser(s ipapi.RealClientIPParser) { o.realClientIPParser = s }
+func (o *Options) SetClientCAPool(s *x509.CertPool)                 { o.clientCAPool = s }
 
 // NewOptions constructs a new Options with defaulted values
 func NewOptions() *Options {
@@ -151,6 +186,9 @@ func NewOptions() *Options {
 		DisplayHtpasswdForm:              true,
 		Cookie:                           cookieDefaults(),
 		Session:                          sessionOptionsDefaults(),
+		LDAP:                             ldapDefaults(),
+		Introspection:                    introspectionDefaults(),
+		ClientTLS:                        clientTLSDefaults(),
 		AzureTenant:                      "common",
 		SetXAuthRequest:                  false,
 		SkipAuthPreflight:                false,
@@ -166,6 +204,9 @@ func NewOptions() *Options {
 		UserIDClaim:                      "email",
 		InsecureOIDCAllowUnverifiedEmail: false,
 		SkipOIDCDiscovery:                false,
+		OIDCGroupsClaim:                  "",
+		SAMLEmailAttribute:               "email",
+		SAMLGroupsAttribute:              "groups",
 		Logging:                          loggingDefaults(),
 	}
 }
@@ -201,9 +242,12 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("extra-jwt-issuers", []string{}, "if skip-jwt-bearer-tokens is set, a list of extra JWT issuer=audience pairs (where the issuer URL has a .well-known/openid-configuration or a .well-known/jwks.json)")
 
 	flagSet.StringSlice("email-domain", []string{}, "authenticate emails with the specified domain (may be given multiple times). Use * to authenticate any email")
+	flagSet.StringSlice("allowed-group", []string{}, "restrict logins to members of this group (may be given multiple times)")
 	flagSet.StringSlice("whitelist-domain", []string{}, "allowed domains for redirection after authentication. Prefix domain with a . to allow subdomains (eg .example.com)")
 	flagSet.String("keycloak-group", "", "restrict login to members of this group.")
 	flagSet.String("azure-tenant", "common", "go to a tenant-specific or common (tenant-independent) endpoint.")
+	flagSet.StringSlice("azure-group", []string{}, "restrict logins to members of this group, by object ID (may be given multiple times)")
+	flagSet.StringSlice("azure-app-role", []string{}, "restrict logins to users assigned this app role (may be given multiple times)")
 	flagSet.String("bitbucket-team", "", "restrict logins to members of this team")
 	flagSet.String("bitbucket-repository", "", "restrict logins to user with access to this repository")
 	flagSet.String("github-org", "", "restrict logins to members of this organisation")
@@ -229,6 +273,15 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.String("ping-user-agent", "", "special User-Agent that will be used for basic health checks")
 	flagSet.String("session-store-type", "cookie", "the session storage provider to use")
 	flagSet.Bool("session-cookie-minimal", false, "strip OAuth tokens from cookie session stores if they aren't needed (cookie session store only)")
+	flagSet.String("session-cookie-keyring-file", "", "path to a keyring file of key IDs and secrets wrapping the keys that encrypt sessions (cookie session store only)")
+	flagSet.Bool("session-cookie-keyring-migration", false, "decrypt sessions encrypted with the cookie secret before the keyring was configured (cookie session store only)")
+	flagSet.Duration("session-max-lifetime", time.Duration(0), "Maximum time since sign in before a session must sign in again, even if it is refreshed; 0 to disable")
+	flagSet.Duration("session-idle-timeout", time.Duration(0), "Time without any requests before a session must sign in again; 0 to disable")
+	flagSet.Duration("session-activity-update-interval", time.Minute, "How often the last activity of a session is saved when an idle timeout is set")
+	flagSet.Duration("session-ticket-rotation-interval", time.Duration(0), "How often server side sessions are given a new ticket, in addition to whenever they are refreshed; 0 to only rotate on refresh")
+	flagSet.Duration("session-ticket-rotation-grace-period", 30*time.Second, "How long the previous ticket of a server side session keeps working after it is rotated, for requests already in flight")
+	flagSet.String("session-binding-ip", "", "Bind sessions to the IP of the client that signed in: exact or prefix (/24 for IPv4, /64 for IPv6); disabled if empty")
+	flagSet.Bool("session-binding-user-agent", false, "Bind sessions to the User-Agent of the client that signed in")
 	flagSet.String("redis-connection-url", "", "URL of redis server for redis session storage (eg: redis://HOST[:PORT])")
 	flagSet.Bool("redis-use-sentinel", false, "Connect to redis via sentinels. Must set --redis-sentinel-master-name and --redis-sentinel-connection-urls to use this feature")
 	flagSet.String("redis-sentinel-master-name", "", "Redis sentinel master name. Used in conjunction with --redis-use-sentinel")
@@ -237,6 +290,10 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("redis-sentinel-connection-urls", []string{}, "List of Redis sentinel connection URLs (eg redis://HOST[:PORT]). Used in conjunction with --redis-use-sentinel")
 	flagSet.Bool("redis-use-cluster", false, "Connect to redis cluster. Must set --redis-cluster-connection-urls to use this feature")
 	flagSet.StringSlice("redis-cluster-connection-urls", []string{}, "List of Redis cluster connection URLs (eg redis://HOST[:PORT]). Used in conjunction with --redis-use-cluster")
+	flagSet.String("session-file-path", "", "Directory the file session store keeps sessions in")
+	flagSet.Duration("session-file-sweep-interval", time.Minute, "How often expired sessions are removed from the file session store")
+	flagSet.Int("session-memory-max-entries", 10000, "Maximum number of entries kept by the memory session store, the least recently used are evicted first")
+	flagSet.Duration("session-memory-stats-interval", time.Duration(0), "How often the memory session store logs its size and eviction stats; 0 to disable")
 
 	flagSet.String("provider", "google", "OAuth provider")
 	flagSet.String("provider-display-name", "", "Provider display name")
@@ -246,14 +303,22 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.Bool("insecure-oidc-skip-issuer-verification", false, "Do not verify if issuer matches OIDC discovery URL")
 	flagSet.Bool("skip-oidc-discovery", false, "Skip OIDC discovery and use manually supplied Endpoints")
 	flagSet.String("oidc-jwks-url", "", "OpenID Connect JWKS URL (ie: https://www.googleapis.com/oauth2/v3/certs)")
+	flagSet.String("oidc-groups-claim", "", "which OIDC claim contains the user groups, a dotted path for nested claims (ie: realm_access.roles), also read from the userinfo endpoint when set (default: the groups claim of the id_token)")
 	flagSet.String("login-url", "", "Authentication endpoint")
 	flagSet.String("redeem-url", "", "Token redemption endpoint")
+	flagSet.String("logout-url", "", "Provider logout endpoint (defaults to the OIDC end_session_endpoint when discovered)")
+	flagSet.String("code-challenge-method", "", "use PKCE with the given code challenge method in the authorization code flow (supported: S256)")
+	flagSet.Bool("provider-logout", false, "also sign out of the provider when signing out by redirecting to the provider logout endpoint")
 	flagSet.String("profile-url", "", "Profile access endpoint")
 	flagSet.String("resource", "", "The resource that is protected (Azure AD only)")
 	flagSet.String("validate-url", "", "Access token validation endpoint")
 	flagSet.String("scope", "", "OAuth scope specification")
 	flagSet.String("prompt", "", "OIDC prompt")
 	flagSet.String("approval-prompt", "force", "OAuth approval_prompt")
+	flagSet.String("saml-idp-metadata-file", "", "path to the SAML metadata of the IdP: required by saml")
+	flagSet.String("saml-email-attribute", "email", "which SAML attribute contains the user email, the NameID is used if it is missing and an email address")
+	flagSet.String("saml-user-attribute", "", "which SAML attribute contains the user name (defaults to the NameID)")
+	flagSet.String("saml-groups-attribute", "groups", "which SAML attribute contains the user groups")
 
 	flagSet.String("signature-key", "", "GAP-Signature request signature key (algorithm:secretkey)")
 	flagSet.String("acr-values", "", "acr values string:  optional")
@@ -261,11 +326,15 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.String("jwt-key-file", "", "path to the private key file in PEM format used to sign the JWT so that you can say something like -jwt-key-file=/etc/ssl/private/jwt_signing_key.pem: required by login.gov")
 	flagSet.String("pubjwk-url", "", "JWK pubkey access endpoint: required by login.gov")
 	flagSet.Bool("gcp-healthchecks", false, "Enable GCP/GKE healthcheck endpoints")
+	flagSet.String("admin-token", "", "Bearer token required by the session admin API under <proxy-prefix>/admin/sessions; the API is disabled when empty")
 
 	flagSet.String("user-id-claim", "email", "which claim contains the user ID")
 
 	flagSet.AddFlagSet(cookieFlagSet())
 	flagSet.AddFlagSet(loggingFlagSet())
+	flagSet.AddFlagSet(ldapFlagSet())
+	flagSet.AddFlagSet(introspectionFlagSet())
+	flagSet.AddFlagSet(clientTLSFlagSet())
 	flagSet.AddFlagSet(legacyUpstreamsFlagSet())
 
 	return flagSet
@@ -0,0 +1,70 @@
+package options
+
+// Providers is a collection of definitions for identity providers.
+type Providers []Provider
+
+// Provider represents the configuration for an identity provider users can
+// sign in with. When more than one provider is configured, the sign in page
+// lets users choose between them.
+type Provider struct {
+	// ID should be a unique identifier for the provider.
+	// It is carried in the OAuth state and recorded in sessions so that they
+	// are refreshed and validated by the provider they were created by.
+	// This value is required for all providers.
+	ID string `json:"id"`
+
+	// Type is the type of the provider, as accepted by the `--provider` flag,
+	// eg. google, azure, github or oidc.
+	Type string `json:"provider"`
+
+	// Name is shown on the sign in button of the provider.
+	// Defaults to the name of the provider type.
+	Name string `json:"name,omitempty"`
+
+	// ClientID is the OAuth Client ID of the proxy at the provider.
+	ClientID string `json:"clientID"`
+
+	// ClientSecret is the OAuth Client Secret of the proxy at the provider.
+	ClientSecret string `json:"clientSecret,omitempty"`
+
+	// ClientSecretFile is the name of a file containing the Client Secret,
+	// used instead of ClientSecret.
+	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+
+	// Scope is the OAuth scope requested, defaults to the provider default.
+	Scope string `json:"scope,omitempty"`
+
+	// OIDCIssuerURL is the OpenID Connect issuer of the provider, used to
+	// discover its endpoints and verify its ID Tokens.
+	OIDCIssuerURL string `json:"oidcIssuerURL,omitempty"`
+
+	// LoginURL, RedeemURL, ProfileURL, ValidateURL and IntrospectionURL
+	// override the endpoints of the provider.
+	LoginURL         string `json:"loginURL,omitempty"`
+	RedeemURL        string `json:"redeemURL,omitempty"`
+	ProfileURL       string `json:"profileURL,omitempty"`
+	ValidateURL      string `json:"validateURL,omitempty"`
+	IntrospectionURL string `json:"introspectionURL,omitempty"`
+
+	// AzureTenant is the Azure tenant of an azure provider.
+	// Defaults to the `--azure-tenant` flag.
+	AzureTenant string `json:"azureTenant,omitempty"`
+
+	// GitHubOrg and GitHubTeam restrict a github provider to members of the
+	// organisation or team. They default to the `--github-org` and
+	// `--github-team` flags.
+	GitHubOrg  string `json:"githubOrg,omitempty"`
+	GitHubTeam string `json:"githubTeam,omitempty"`
+
+	// SAMLIdPMetadataFile is the path to the metadata of the IdP of a saml
+	// provider.
+	SAMLIdPMetadataFile string `json:"samlIdPMetadataFile,omitempty"`
+
+	// SAMLEmailAttribute, SAMLUserAttribute and SAMLGroupsAttribute are the
+	// attributes of a saml provider the session is built from. They default
+	// to the `--saml-email-attribute`, `--saml-user-attribute` and
+	// `--saml-groups-attribute` flags.
+	SAMLEmailAttribute  string `json:"samlEmailAttribute,omitempty"`
+	SAMLUserAttribute   string `json:"samlUserAttribute,omitempty"`
+	SAMLGroupsAttribute string `json:"samlGroupsAttribute,omitempty"`
+}
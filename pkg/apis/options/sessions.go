@@ -1,10 +1,27 @@
 package options
 
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+)
+
 // SessionOptions contains configuration options for the SessionStore providers.
 type SessionOptions struct {
 	Type   string             `flag:"session-store-type" cfg:"session_store_type"`
 	Cookie CookieStoreOptions `cfg:",squash"`
 	Redis  RedisStoreOptions  `cfg:",squash"`
+	File   FileStoreOptions   `cfg:",squash"`
+	Memory MemoryStoreOptions `cfg:",squash"`
+
+	Binding SessionBindingOptions `cfg:",squash"`
+
+	MaxLifetime            time.Duration `flag:"session-max-lifetime" cfg:"session_max_lifetime"`
+	IdleTimeout            time.Duration `flag:"session-idle-timeout" cfg:"session_idle_timeout"`
+	ActivityUpdateInterval time.Duration `flag:"session-activity-update-interval" cfg:"session_activity_update_interval"`
+
+	TicketRotationInterval    time.Duration `flag:"session-ticket-rotation-interval" cfg:"session_ticket_rotation_interval"`
+	TicketRotationGracePeriod time.Duration `flag:"session-ticket-rotation-grace-period" cfg:"session_ticket_rotation_grace_period"`
 }
 
 // CookieSessionStoreType is used to indicate the CookieSessionStore should be
@@ -15,11 +32,30 @@ var CookieSessionStoreType = "cookie"
 // used for storing sessions.
 var RedisSessionStoreType = "redis"
 
+// FileSessionStoreType is used to indicate the FileSessionStore should be
+// used for storing sessions.
+var FileSessionStoreType = "file"
+
+// MemorySessionStoreType is used to indicate the MemorySessionStore should be
+// used for storing sessions.
+var MemorySessionStoreType = "memory"
+
 // CookieStoreOptions contains configuration options for the CookieSessionStore.
 type CookieStoreOptions struct {
-	Minimal bool `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
+	Minimal          bool   `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
+	KeyringFile      string `flag:"session-cookie-keyring-file" cfg:"session_cookie_keyring_file"`
+	KeyringMigration bool   `flag:"session-cookie-keyring-migration" cfg:"session_cookie_keyring_migration"`
+
+	// internal values that are set after config validation
+	keyring *encryption.Keyring
 }
 
+// GetKeyring returns the keyring loaded from the KeyringFile
+func (o *CookieStoreOptions) GetKeyring() *encryption.Keyring { return o.keyring }
+
+// SetKeyring sets the keyring loaded from the KeyringFile
+func (o *CookieStoreOptions) SetKeyring(k *encryption.Keyring) { o.keyring = k }
+
 // RedisStoreOptions contains configuration options for the RedisSessionStore.
 type RedisStoreOptions struct {
 	ConnectionURL          string   `flag:"redis-connection-url" cfg:"redis_connection_url"`
@@ -32,11 +68,57 @@ type RedisStoreOptions struct {
 	InsecureSkipTLSVerify  bool     `flag:"redis-insecure-skip-tls-verify" cfg:"redis_insecure_skip_tls_verify"`
 }
 
+// FileStoreOptions contains configuration options for the FileSessionStore.
+type FileStoreOptions struct {
+	Path          string        `flag:"session-file-path" cfg:"session_file_path"`
+	SweepInterval time.Duration `flag:"session-file-sweep-interval" cfg:"session_file_sweep_interval"`
+}
+
+// MemoryStoreOptions contains configuration options for the MemorySessionStore.
+type MemoryStoreOptions struct {
+	MaxEntries    int           `flag:"session-memory-max-entries" cfg:"session_memory_max_entries"`
+	StatsInterval time.Duration `flag:"session-memory-stats-interval" cfg:"session_memory_stats_interval"`
+}
+
+// SessionBindingOptions contains configuration options for binding sessions
+// to the client they were created by.
+type SessionBindingOptions struct {
+	IP        string `flag:"session-binding-ip" cfg:"session_binding_ip"`
+	UserAgent bool   `flag:"session-binding-user-agent" cfg:"session_binding_user_agent"`
+}
+
+// ExactIPSessionBinding is used to indicate sessions should be bound to the
+// exact IP of the client.
+var ExactIPSessionBinding = "exact"
+
+// PrefixIPSessionBinding is used to indicate sessions should be bound to the
+// /24 (IPv4) or /64 (IPv6) prefix of the IP of the client.
+var PrefixIPSessionBinding = "prefix"
+
 func sessionOptionsDefaults() SessionOptions {
 	return SessionOptions{
 		Type: CookieSessionStoreType,
 		Cookie: CookieStoreOptions{
-			Minimal: false,
+			Minimal:          false,
+			KeyringFile:      "",
+			KeyringMigration: false,
+		},
+		File: FileStoreOptions{
+			SweepInterval: time.Minute,
 		},
+		Memory: MemoryStoreOptions{
+			MaxEntries:    10000,
+			StatsInterval: time.Duration(0),
+		},
+		Binding: SessionBindingOptions{
+			IP:        "",
+			UserAgent: false,
+		},
+		MaxLifetime:            time.Duration(0),
+		IdleTimeout:            time.Duration(0),
+		ActivityUpdateInterval: time.Minute,
+
+		TicketRotationInterval:    time.Duration(0),
+		TicketRotationGracePeriod: 30 * time.Second,
 	}
 }
@@ -57,4 +57,18 @@ type Upstream struct {
 	// ProxyWebSockets enables proxying of websockets to upstream servers
 	// Defaults to true.
 	ProxyWebSockets *bool `json:"proxyWebSockets"`
+
+	// AllowedEmails restricts access to this upstream to sessions with one of
+	// the given email addresses.
+	// When used with AllowedEmailDomains, the session email must match either.
+	AllowedEmails []string `json:"allowedEmails,omitempty"`
+
+	// AllowedEmailDomains restricts access to this upstream to sessions with
+	// an email address in one of the given domains. Use * to allow any domain.
+	AllowedEmailDomains []string `json:"allowedEmailDomains,omitempty"`
+
+	// AllowedGroups restricts access to this upstream to sessions that are a
+	// member of at least one of the given groups.
+	// This is checked in addition to any email restrictions.
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
 }
@@ -1,7 +1,10 @@
 package sessions
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 )
 
 // SessionStore is an interface to storing user sessions in the proxy
@@ -10,3 +13,60 @@ type SessionStore interface {
 	Load(req *http.Request) (*SessionState, error)
 	Clear(rw http.ResponseWriter, req *http.Request) error
 }
+
+// OIDCSessionRevoker is implemented by server side session stores that can
+// clear every session of a provider linked to an OIDC session ID or subject
+type OIDCSessionRevoker interface {
+	ClearOIDCSessions(ctx context.Context, providerID, sid, sub string) error
+}
+
+// SessionLocker is implemented by session stores that can lock the session of
+// a request, so that only one request refreshes a session at a time
+type SessionLocker interface {
+	// LockSession waits until it obtains the lock on the session of the
+	// request, for at most the expiration. The returned function releases the
+	// lock, stores shared between instances may also expire it after the
+	// expiration.
+	LockSession(req *http.Request, expiration time.Duration) (unlock func() error, err error)
+}
+
+// SessionAdministrator is implemented by server side session stores that can
+// list and revoke the sessions of a user without their session cookies
+type SessionAdministrator interface {
+	// ListUsers returns every user with a stored session. Stores that can't
+	// list their keys return ErrListingNotSupported.
+	ListUsers(ctx context.Context) ([]string, error)
+	// ListSessions returns the active sessions of the user
+	ListSessions(ctx context.Context, user string) ([]SessionInfo, error)
+	// RevokeSession clears a session by its ID
+	RevokeSession(ctx context.Context, id string) error
+	// RevokeUserSessions clears every session of the user, returning how
+	// many sessions were cleared
+	RevokeUserSessions(ctx context.Context, user string) (int, error)
+}
+
+var (
+	// ErrListingNotSupported is returned when listing every user with a
+	// session is not supported by the session store
+	ErrListingNotSupported = errors.New("the session store does not support listing every session")
+
+	// ErrInvalidSessionID is returned when revoking a session with an ID that
+	// could not have been issued by the session store
+	ErrInvalidSessionID = errors.New("invalid session ID")
+)
+
+// SessionInfo describes a server side session without any of its tokens
+type SessionInfo struct {
+	// ID identifies the session so that it can be revoked
+	ID string `json:"id"`
+
+	// AuthenticatedAt is when the user signed in
+	AuthenticatedAt *time.Time `json:"authenticatedAt,omitempty"`
+
+	// SavedAt is when the session was last saved, eg. when it was refreshed
+	SavedAt time.Time `json:"savedAt"`
+
+	// ExpiresAt is when the session is removed from the store unless it is
+	// saved again
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"reflect"
 	"time"
 	"unicode/utf8"
 
@@ -25,6 +26,51 @@ type SessionState struct {
 	Email             string     `json:",omitempty" msgpack:"e,omitempty"`
 	User              string     `json:",omitempty" msgpack:"u,omitempty"`
 	PreferredUsername string     `json:",omitempty" msgpack:"pu,omitempty"`
+	Groups            []string   `json:",omitempty" msgpack:"g,omitempty"`
+
+	// OIDCSessionID is the `sid` claim identifying the session at the OIDC
+	// provider, used to revoke sessions on back-channel logout
+	OIDCSessionID string `json:",omitempty" msgpack:"sid,omitempty"`
+
+	// Claims are the ID Token or userinfo claims selected to be kept in the
+	// session, eg. to be passed to upstreams in headers
+	Claims map[string]interface{} `json:",omitempty" msgpack:"cl,omitempty"`
+
+	// AuthenticatedAt is when the user signed in. Unlike CreatedAt, it is not
+	// updated when the session is refreshed.
+	AuthenticatedAt *time.Time `json:",omitempty" msgpack:"aa,omitempty"`
+
+	// LastActivity is when the session was last used. It is saved at a
+	// bounded rate rather than on every request.
+	LastActivity *time.Time `json:",omitempty" msgpack:"la,omitempty"`
+
+	// EndsAt is when the provider ends the session, eg. the
+	// SessionNotOnOrAfter of a SAML assertion. Unlike ExpiresOn, it is not
+	// extended when the session is refreshed.
+	EndsAt *time.Time `json:",omitempty" msgpack:"ea,omitempty"`
+
+	// TicketIssuedAt is set by server side SessionStores to when the ticket
+	// identifying the session was issued, so it can be rotated at an interval
+	TicketIssuedAt *time.Time `json:",omitempty" msgpack:"ti,omitempty"`
+
+	// Binding is a hash of the client the session was created by, when
+	// sessions are bound to their client
+	Binding string `json:",omitempty" msgpack:"bd,omitempty"`
+
+	// ProviderID is the ID of the provider the session was created by, so it
+	// is refreshed and validated by the same provider. It is empty for the
+	// provider configured by the legacy options.
+	ProviderID string `json:",omitempty" msgpack:"pid,omitempty"`
+
+	// LoadedWithPreviousSecret is set by a SessionStore when the session was
+	// loaded using a previous cookie secret, so it should be saved again to
+	// protect it with the current secret. It is never stored.
+	LoadedWithPreviousSecret bool `json:"-" msgpack:"-"`
+
+	// RotateTicket requests server side SessionStores to identify the session
+	// with a new ticket when it is next saved, eg. because it was refreshed
+	// or its ticket is due to be rotated. It is never stored.
+	RotateTicket bool `json:"-" msgpack:"-"`
 }
 
 // IsExpired checks whether the session has expired
@@ -35,6 +81,11 @@ func (s *SessionState) IsExpired() bool {
 	return false
 }
 
+// HasEnded checks whether the session has passed the end set by the provider
+func (s *SessionState) HasEnded() bool {
+	return s.EndsAt != nil && !s.EndsAt.IsZero() && !s.EndsAt.After(time.Now())
+}
+
 // Age returns the age of a session
 func (s *SessionState) Age() time.Duration {
 	if s.CreatedAt != nil && !s.CreatedAt.IsZero() {
@@ -43,6 +94,24 @@ func (s *SessionState) Age() time.Duration {
 	return 0
 }
 
+// Lifetime returns how long ago the user signed in, falling back to the age
+// of sessions created before AuthenticatedAt was recorded
+func (s *SessionState) Lifetime() time.Duration {
+	if s.AuthenticatedAt != nil && !s.AuthenticatedAt.IsZero() {
+		return time.Now().Sub(*s.AuthenticatedAt)
+	}
+	return s.Age()
+}
+
+// IdleTime returns how long ago the session was last used, or 0 if no
+// activity has been recorded
+func (s *SessionState) IdleTime() time.Duration {
+	if s.LastActivity != nil && !s.LastActivity.IsZero() {
+		return time.Now().Sub(*s.LastActivity)
+	}
+	return 0
+}
+
 // String constructs a summary of the session state
 func (s *SessionState) String() string {
 	o := fmt.Sprintf("Session{email:%s user:%s PreferredUsername:%s", s.Email, s.User, s.PreferredUsername)
@@ -61,6 +130,9 @@ func (s *SessionState) String() string {
 	if s.RefreshToken != "" {
 		o += " refresh_token:true"
 	}
+	if len(s.Groups) > 0 {
+		o += fmt.Sprintf(" groups:%v", s.Groups)
+	}
 	return o + "}"
 }
 
@@ -226,14 +298,20 @@ func (s *SessionState) validate() error {
 		s.AccessToken,
 		s.IDToken,
 		s.RefreshToken,
+		s.OIDCSessionID,
 	} {
 		if !utf8.ValidString(field) {
 			return errors.New("invalid non-UTF8 field in session")
 		}
 	}
+	for _, group := range s.Groups {
+		if !utf8.ValidString(group) {
+			return errors.New("invalid non-UTF8 group in session")
+		}
+	}
 
 	empty := new(SessionState)
-	if *s == *empty {
+	if reflect.DeepEqual(*s, *empty) {
 		return errors.New("invalid empty session unmarshalled")
 	}
 
@@ -38,6 +38,32 @@ func TestAge(t *testing.T) {
 	assert.Equal(t, time.Hour, ss.Age().Round(time.Minute))
 }
 
+func TestLifetime(t *testing.T) {
+	ss := &SessionState{}
+
+	// Authenticated at and created at unset so should be 0
+	assert.Equal(t, time.Duration(0), ss.Lifetime())
+
+	// Falls back to the age of the session
+	ss.CreatedAt = timePtr(time.Now().Add(-1 * time.Hour))
+	assert.Equal(t, time.Hour, ss.Lifetime().Round(time.Minute))
+
+	// Set AuthenticatedAt to 3 hours ago
+	ss.AuthenticatedAt = timePtr(time.Now().Add(-3 * time.Hour))
+	assert.Equal(t, 3*time.Hour, ss.Lifetime().Round(time.Minute))
+}
+
+func TestIdleTime(t *testing.T) {
+	ss := &SessionState{CreatedAt: timePtr(time.Now().Add(-1 * time.Hour))}
+
+	// Last activity unset so should be 0
+	assert.Equal(t, time.Duration(0), ss.IdleTime())
+
+	// Set LastActivity to 10 minutes ago
+	ss.LastActivity = timePtr(time.Now().Add(-10 * time.Minute))
+	assert.Equal(t, 10*time.Minute, ss.IdleTime().Round(time.Minute))
+}
+
 // TestEncodeAndDecodeSessionState encodes & decodes various session states
 // and confirms the operation is 1:1
 func TestEncodeAndDecodeSessionState(t *testing.T) {
@@ -58,6 +84,7 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 			CreatedAt:         &created,
 			ExpiresOn:         &expires,
 			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Groups:            []string{"group-a", "group-b"},
 		},
 		"No ExpiresOn": {
 			Email:             "username@example.com",
@@ -83,6 +110,40 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 			CreatedAt:    &created,
 			RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
 		},
+		"With claims": {
+			Email:        "username@example.com",
+			User:         "username",
+			AccessToken:  "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:      "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:    &created,
+			ExpiresOn:    &expires,
+			RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Claims: map[string]interface{}{
+				"tenant":      "tenant-a",
+				"departments": []interface{}{"sales", "marketing"},
+			},
+		},
+		"With activity": {
+			Email:           "username@example.com",
+			User:            "username",
+			AccessToken:     "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:         "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:       &created,
+			ExpiresOn:       &expires,
+			RefreshToken:    "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			AuthenticatedAt: &created,
+			LastActivity:    &expires,
+			TicketIssuedAt:  &created,
+		},
+		"With a client binding": {
+			Email:        "username@example.com",
+			User:         "username",
+			AccessToken:  "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:    &created,
+			ExpiresOn:    &expires,
+			RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Binding:      "5c2f6f3b0ec1b5ee4d4ea1c2a9e40f8d6a7c0cbbd2f5ea0a3f4c3f0b2e1d6a9c",
+		},
 		"Bearer authorization header created session": {
 			Email:       "username",
 			User:        "username",
@@ -223,25 +284,33 @@ func Test_into(t *testing.T) {
 }
 
 func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionState) {
-	if expected.CreatedAt != nil {
-		assert.NotNil(t, actual.CreatedAt)
-		assert.Equal(t, true, expected.CreatedAt.Equal(*actual.CreatedAt))
-	} else {
-		assert.Nil(t, actual.CreatedAt)
-	}
-	if expected.ExpiresOn != nil {
-		assert.NotNil(t, actual.ExpiresOn)
-		assert.Equal(t, true, expected.ExpiresOn.Equal(*actual.ExpiresOn))
-	} else {
-		assert.Nil(t, actual.ExpiresOn)
-	}
+	compareTimes(t, expected.CreatedAt, actual.CreatedAt)
+	compareTimes(t, expected.ExpiresOn, actual.ExpiresOn)
+	compareTimes(t, expected.AuthenticatedAt, actual.AuthenticatedAt)
+	compareTimes(t, expected.LastActivity, actual.LastActivity)
+	compareTimes(t, expected.TicketIssuedAt, actual.TicketIssuedAt)
 
 	// Compare sessions without *time.Time fields
 	exp := *expected
 	exp.CreatedAt = nil
 	exp.ExpiresOn = nil
+	exp.AuthenticatedAt = nil
+	exp.LastActivity = nil
+	exp.TicketIssuedAt = nil
 	act := *actual
 	act.CreatedAt = nil
 	act.ExpiresOn = nil
+	act.AuthenticatedAt = nil
+	act.LastActivity = nil
+	act.TicketIssuedAt = nil
 	assert.Equal(t, exp, act)
 }
+
+func compareTimes(t *testing.T, expected *time.Time, actual *time.Time) {
+	if expected != nil {
+		assert.NotNil(t, actual)
+		assert.Equal(t, true, expected.Equal(*actual))
+	} else {
+		assert.Nil(t, actual)
+	}
+}
@@ -0,0 +1,246 @@
+package basic
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ldap"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/util"
+)
+
+// ldapConn is the part of an ldap.Conn used by the ldapValidator
+type ldapConn interface {
+	StartTLS(tlsConfig *tls.Config) error
+	Bind(dn, password string) error
+	Search(req *ldap.SearchRequest) ([]*ldap.Entry, error)
+	Close() error
+}
+
+// ldapValidator validates credentials by binding to an LDAP server as the
+// user. Users are found with a search, optionally made as a service account,
+// and may be required to match a group filter. Valid credentials are cached
+// so that basic auth requests don't bind every time.
+type ldapValidator struct {
+	opts      options.LDAP
+	tlsConfig *tls.Config
+	dial      func() (ldapConn, error)
+	cache     *credentialCache
+}
+
+// NewLDAPValidator constructs a validator binding to the LDAP server
+// configured in the options.
+func NewLDAPValidator(opts options.LDAP) (Validator, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if opts.CAFile != "" {
+		pool, err := util.GetCertPool([]string{opts.CAFile})
+		if err != nil {
+			return nil, fmt.Errorf("could not load LDAP CA file: %v", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	cache, err := newCredentialCache(opts.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ldapValidator{
+		opts:      opts,
+		tlsConfig: tlsConfig,
+		cache:     cache,
+	}
+	v.dial = func() (ldapConn, error) {
+		return ldap.Dial(opts.URL, tlsConfig, opts.Timeout)
+	}
+	return v, nil
+}
+
+// Validate checks the password by binding as the user found with the user
+// filter, then checks the group filter if any
+func (v *ldapValidator) Validate(user, password string) bool {
+	if user == "" || password == "" {
+		return false
+	}
+	if v.cache.contains(user, password) {
+		return true
+	}
+	err := v.validate(user, password)
+	if err != nil {
+		if !ldap.IsResultCode(err, ldap.ResultInvalidCredentials) {
+			logger.Printf("Error validating %q with LDAP: %v", user, err)
+		}
+		return false
+	}
+	v.cache.add(user, password)
+	return true
+}
+
+func (v *ldapValidator) validate(user, password string) error {
+	conn, err := v.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if v.opts.StartTLS {
+		if err := conn.StartTLS(v.tlsConfig); err != nil {
+			return err
+		}
+	}
+	if err := v.bindServiceAccount(conn); err != nil {
+		return err
+	}
+
+	userFilter := strings.ReplaceAll(v.opts.UserFilter, "{username}", ldap.EscapeFilter(user))
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN: v.opts.UserBaseDN,
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: userFilter,
+	})
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 {
+		return fmt.Errorf("expected 1 entry matching %s, found %d", userFilter, len(entries))
+	}
+	dn := entries[0].DN
+
+	if err := conn.Bind(dn, password); err != nil {
+		return err
+	}
+
+	if v.opts.GroupFilter == "" {
+		return nil
+	}
+	// Group entries may not be readable by the user
+	if err := v.bindServiceAccount(conn); err != nil {
+		return err
+	}
+	groupFilter := strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(dn),
+		"{username}", ldap.EscapeFilter(user),
+	).Replace(v.opts.GroupFilter)
+	groupBaseDN := v.opts.GroupBaseDN
+	if groupBaseDN == "" {
+		groupBaseDN = v.opts.UserBaseDN
+	}
+	groups, err := conn.Search(&ldap.SearchRequest{
+		BaseDN: groupBaseDN,
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: groupFilter,
+	})
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return errors.New("user does not match the group filter")
+	}
+	return nil
+}
+
+// bindServiceAccount binds as the service account, if configured. Searches
+// are anonymous otherwise.
+func (v *ldapValidator) bindServiceAccount(conn ldapConn) error {
+	if v.opts.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(v.opts.BindDN, v.opts.BindPassword)
+}
+
+// credentialCache holds the credentials validated in the last TTL. They are
+// keyed by an HMAC with a random key, so the cache holds neither the
+// passwords nor digests of them that could be checked offline.
+type credentialCache struct {
+	ttl     time.Duration
+	hashKey []byte
+
+	// now returns the current time, it allows tests to fast forward time
+	now func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]time.Time
+	// nextSweep is the number of entries at which expired entries are next
+	// removed
+	nextSweep int
+}
+
+// minCredentialCacheSweep is the fewest entries expired entries are removed
+// at
+const minCredentialCacheSweep = 1000
+
+func newCredentialCache(ttl time.Duration) (*credentialCache, error) {
+	hashKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(hashKey); err != nil {
+		return nil, fmt.Errorf("could not create the LDAP cache key: %v", err)
+	}
+	return &credentialCache{
+		ttl:       ttl,
+		hashKey:   hashKey,
+		now:       time.Now,
+		entries:   map[string]time.Time{},
+		nextSweep: minCredentialCacheSweep,
+	}, nil
+}
+
+// key is the HMAC of the credentials. The length of the user is included so
+// that no other user and password have the same input.
+func (c *credentialCache) key(user, password string) string {
+	mac := hmac.New(sha256.New, c.hashKey)
+	fmt.Fprintf(mac, "%d:%s:%s", len(user), user, password)
+	return string(mac.Sum(nil))
+}
+
+// contains reports whether the credentials were validated within the TTL
+func (c *credentialCache) contains(user, password string) bool {
+	if c.ttl <= 0 {
+		return false
+	}
+	key := c.key(user, password)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expires, ok := c.entries[key]
+	if ok && !c.now().Before(expires) {
+		delete(c.entries, key)
+		return false
+	}
+	return ok
+}
+
+// add caches the credentials for the TTL. Expired entries are removed
+// whenever the number of entries has doubled since they were last removed.
+func (c *credentialCache) add(user, password string) {
+	if c.ttl <= 0 {
+		return
+	}
+	key := c.key(user, password)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.nextSweep {
+		for k, expires := range c.entries {
+			if !now.Before(expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = 2 * len(c.entries)
+		if c.nextSweep < minCredentialCacheSweep {
+			c.nextSweep = minCredentialCacheSweep
+		}
+	}
+	c.entries[key] = now.Add(c.ttl)
+}
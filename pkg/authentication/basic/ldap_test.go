@@ -0,0 +1,247 @@
+package basic
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ldap"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	ldapBindDN       = "cn=proxy,dc=example,dc=com"
+	ldapBindPassword = "pr0xyP455"
+	ldapUserBaseDN   = "ou=people,dc=example,dc=com"
+	ldapGroupBaseDN  = "ou=groups,dc=example,dc=com"
+)
+
+// fakeDirectory is an ldapConn answering searches from fixed filters
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   map[string][]*ldap.Entry
+
+	startedTLS bool
+	boundDN    string
+	binds      []string
+	searches   []ldap.SearchRequest
+	closed     bool
+}
+
+func (d *fakeDirectory) StartTLS(_ *tls.Config) error {
+	d.startedTLS = true
+	return nil
+}
+
+func (d *fakeDirectory) Bind(dn, password string) error {
+	d.binds = append(d.binds, dn)
+	if d.passwords[dn] == "" || d.passwords[dn] != password {
+		d.boundDN = ""
+		return &ldap.Error{ResultCode: ldap.ResultInvalidCredentials}
+	}
+	d.boundDN = dn
+	return nil
+}
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) ([]*ldap.Entry, error) {
+	d.searches = append(d.searches, *req)
+	if d.boundDN == "" && d.passwords[ldapBindDN] != "" {
+		return nil, errors.New("anonymous searches are not allowed")
+	}
+	var entries []*ldap.Entry
+	for _, entry := range d.entries[req.Filter] {
+		if strings.HasSuffix(entry.DN, ","+req.BaseDN) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (d *fakeDirectory) Close() error {
+	d.closed = true
+	return nil
+}
+
+var _ = Describe("LDAP Suite", func() {
+	const (
+		user1DN = "uid=user1,ou=people,dc=example,dc=com"
+		user2DN = "uid=user2,ou=people,dc=example,dc=com"
+	)
+
+	var directory *fakeDirectory
+	var opts options.LDAP
+
+	newValidator := func() Validator {
+		validator, err := NewLDAPValidator(opts)
+		Expect(err).ToNot(HaveOccurred())
+		validator.(*ldapValidator).dial = func() (ldapConn, error) {
+			return directory, nil
+		}
+		return validator
+	}
+
+	BeforeEach(func() {
+		directory = &fakeDirectory{
+			passwords: map[string]string{
+				ldapBindDN: ldapBindPassword,
+				user1DN:    user1Password,
+				user2DN:    user2Password,
+			},
+			entries: map[string][]*ldap.Entry{
+				"(uid=user1)": {{DN: user1DN}},
+				"(uid=user2)": {{DN: user2DN}},
+				"(uid=\\2a)":  {},
+				"(&(cn=admins)(member=uid=user1,ou=people,dc=example,dc=com))": {
+					{DN: "cn=admins,ou=groups,dc=example,dc=com"},
+				},
+			},
+		}
+		opts = options.LDAP{
+			URL:          "ldap://ldap.example.com",
+			BindDN:       ldapBindDN,
+			BindPassword: ldapBindPassword,
+			UserBaseDN:   ldapUserBaseDN,
+			UserFilter:   "(uid={username})",
+		}
+	})
+
+	Context("with a service account", func() {
+		It("accepts the correct passwords", func() {
+			validator := newValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(directory.binds).To(Equal([]string{ldapBindDN, user1DN}))
+			Expect(directory.searches).To(Equal([]ldap.SearchRequest{
+				{BaseDN: ldapUserBaseDN, Scope: ldap.ScopeWholeSubtree, Filter: "(uid=user1)"},
+			}))
+			Expect(directory.closed).To(BeTrue())
+			Expect(directory.startedTLS).To(BeFalse())
+		})
+
+		It("rejects incorrect passwords", func() {
+			validator := newValidator()
+			Expect(validator.Validate(user1, user2Password)).To(BeFalse())
+			Expect(validator.Validate(user2, "")).To(BeFalse())
+		})
+
+		It("rejects unknown users", func() {
+			validator := newValidator()
+			Expect(validator.Validate("user3", user1Password)).To(BeFalse())
+		})
+
+		It("escapes the username in the filter", func() {
+			validator := newValidator()
+			Expect(validator.Validate("*", user1Password)).To(BeFalse())
+			Expect(directory.searches[0].Filter).To(Equal("(uid=\\2a)"))
+		})
+
+		It("rejects users when the service account can't bind", func() {
+			opts.BindPassword = "wrong"
+			validator := newValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeFalse())
+			Expect(directory.binds).To(Equal([]string{ldapBindDN}))
+		})
+
+		It("starts TLS when configured", func() {
+			opts.StartTLS = true
+			validator := newValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(directory.startedTLS).To(BeTrue())
+		})
+	})
+
+	Context("without a service account", func() {
+		BeforeEach(func() {
+			delete(directory.passwords, ldapBindDN)
+			opts.BindDN = ""
+			opts.BindPassword = ""
+		})
+
+		It("searches anonymously", func() {
+			validator := newValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(directory.binds).To(Equal([]string{user1DN}))
+		})
+	})
+
+	Context("with a group filter", func() {
+		BeforeEach(func() {
+			opts.GroupBaseDN = ldapGroupBaseDN
+			opts.GroupFilter = "(&(cn=admins)(member={dn}))"
+		})
+
+		It("accepts members of the group", func() {
+			validator := newValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(directory.binds).To(Equal([]string{ldapBindDN, user1DN, ldapBindDN}))
+			Expect(directory.searches[1]).To(Equal(ldap.SearchRequest{
+				BaseDN: ldapGroupBaseDN,
+				Scope:  ldap.ScopeWholeSubtree,
+				Filter: "(&(cn=admins)(member=uid=user1,ou=people,dc=example,dc=com))",
+			}))
+		})
+
+		It("rejects users outside the group", func() {
+			validator := newValidator()
+			Expect(validator.Validate(user2, user2Password)).To(BeFalse())
+		})
+
+		It("searches the user base DN without a group base DN", func() {
+			opts.GroupBaseDN = ""
+			validator := newValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeFalse())
+			Expect(directory.searches[1].BaseDN).To(Equal(ldapUserBaseDN))
+		})
+	})
+
+	Context("with a cache", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			opts.CacheTTL = time.Minute
+			now = time.Now()
+		})
+
+		newCachingValidator := func() Validator {
+			validator := newValidator()
+			validator.(*ldapValidator).cache.now = func() time.Time { return now }
+			return validator
+		}
+
+		It("doesn't bind again for credentials it accepted", func() {
+			validator := newCachingValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(directory.binds).To(Equal([]string{ldapBindDN, user1DN}))
+		})
+
+		It("binds again once the credentials expire", func() {
+			validator := newCachingValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			now = now.Add(time.Minute)
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(directory.binds).To(Equal([]string{ldapBindDN, user1DN, ldapBindDN, user1DN}))
+		})
+
+		It("doesn't cache rejected credentials", func() {
+			validator := newCachingValidator()
+			Expect(validator.Validate(user1, user2Password)).To(BeFalse())
+			Expect(validator.Validate(user1, user2Password)).To(BeFalse())
+			Expect(directory.binds).To(Equal([]string{ldapBindDN, user1DN, ldapBindDN, user1DN}))
+		})
+
+		It("binds for another password of the user", func() {
+			validator := newCachingValidator()
+			Expect(validator.Validate(user1, user1Password)).To(BeTrue())
+			Expect(validator.Validate(user1, user2Password)).To(BeFalse())
+		})
+	})
+
+	It("returns an error when the CA file can't be loaded", func() {
+		opts.CAFile = "/path/does/not/exist"
+		_, err := NewLDAPValidator(opts)
+		Expect(err).To(HaveOccurred())
+	})
+})
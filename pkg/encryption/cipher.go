@@ -5,10 +5,18 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// XChaCha20Poly1305Version is the version byte of values encrypted with
+// XChaCha20-Poly1305 by a versioned Cipher. Values encrypted before versioning
+// was introduced carry no version byte.
+const XChaCha20Poly1305Version byte = 0x01
+
 // Cipher provides methods to encrypt and decrypt
 type Cipher interface {
 	Encrypt(value []byte) ([]byte, error)
@@ -130,3 +138,82 @@ func (c *gcmCipher) Decrypt(ciphertext []byte) ([]byte, error) {
 	}
 	return plaintext, nil
 }
+
+type xChaCha20Poly1305Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewXChaCha20Poly1305Cipher returns a new XChaCha20-Poly1305 Cipher. The
+// secret must be 32 bytes, eg. a key from DeriveKey.
+func NewXChaCha20Poly1305Cipher(secret []byte) (Cipher, error) {
+	aead, err := chacha20poly1305.NewX(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &xChaCha20Poly1305Cipher{aead: aead}, nil
+}
+
+// Encrypt with XChaCha20-Poly1305 on raw bytes
+func (c *xChaCha20Poly1305Cipher) Encrypt(value []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(value)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce %s", err)
+	}
+	// The random nonce is large enough to never repeat, it is the first
+	// chunk of bytes in the ciphertext as with AES GCM
+	return c.aead.Seal(nonce, nonce, value, nil), nil
+}
+
+// Decrypt an XChaCha20-Poly1305 ciphertext
+func (c *xChaCha20Poly1305Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("encrypted value should be at least %d bytes, but is only %d bytes", nonceSize, len(ciphertext))
+	}
+
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return c.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+type versionedCipher struct {
+	version byte
+	current Cipher
+	legacy  Cipher
+}
+
+// NewVersionedCipher returns a Cipher that encrypts with the current Cipher
+// and prefixes the version byte. Values without the version byte were
+// encrypted before versioning and are decrypted with the legacy Cipher, if
+// there is one.
+func NewVersionedCipher(version byte, current Cipher, legacy Cipher) Cipher {
+	return &versionedCipher{
+		version: version,
+		current: current,
+		legacy:  legacy,
+	}
+}
+
+// Encrypt with the current Cipher & prefix the version byte
+func (c *versionedCipher) Encrypt(value []byte) ([]byte, error) {
+	encrypted, err := c.current.Encrypt(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.version}, encrypted...), nil
+}
+
+// Decrypt with the Cipher of the version of the ciphertext
+func (c *versionedCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) > 0 && ciphertext[0] == c.version {
+		plaintext, err := c.current.Decrypt(ciphertext[1:])
+		// Legacy ciphertexts may start with the version byte by chance
+		if err == nil || c.legacy == nil {
+			return plaintext, err
+		}
+	}
+
+	if c.legacy == nil {
+		return nil, errors.New("encrypted value has an unknown version")
+	}
+	return c.legacy.Decrypt(ciphertext)
+}
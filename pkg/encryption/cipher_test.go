@@ -118,6 +118,100 @@ func runEncryptAndDecrypt(t *testing.T, c Cipher, dataSize int) {
 	assert.NotEqual(t, encrypted, decrypted)
 }
 
+func TestEncryptAndDecryptXChaCha20Poly1305(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, secret)
+	assert.Equal(t, nil, err)
+
+	c, err := NewXChaCha20Poly1305Cipher(secret)
+	assert.Equal(t, nil, err)
+
+	ciphers := map[string]Cipher{
+		"Standard":  c,
+		"Base64":    NewBase64Cipher(c),
+		"Versioned": NewVersionedCipher(XChaCha20Poly1305Version, c, nil),
+	}
+	for cName, c := range ciphers {
+		t.Run(cName, func(t *testing.T) {
+			for _, dataSize := range []int{10, 100, 1000, 5000, 10000} {
+				t.Run(fmt.Sprintf("%d", dataSize), func(t *testing.T) {
+					runEncryptAndDecrypt(t, c, dataSize)
+				})
+			}
+		})
+	}
+}
+
+func TestXChaCha20Poly1305InvalidSecret(t *testing.T) {
+	_, err := NewXChaCha20Poly1305Cipher([]byte("0123456789abcdef"))
+	assert.Error(t, err)
+}
+
+func TestDecryptXChaCha20Poly1305WrongSecret(t *testing.T) {
+	c1, err := NewXChaCha20Poly1305Cipher([]byte("0123456789abcdefghijklmnopqrstuv"))
+	assert.Equal(t, nil, err)
+
+	c2, err := NewXChaCha20Poly1305Cipher([]byte("9876543210abcdefghijklmnopqrstuv"))
+	assert.Equal(t, nil, err)
+
+	data := []byte("f3928pufm982374dj02y485dsl34890u2t9nd4028s94dm58y2394087dhmsyt29h8df")
+
+	ciphertext, err := c1.Encrypt(data)
+	assert.Equal(t, nil, err)
+
+	_, err = c2.Decrypt(ciphertext)
+	assert.Error(t, err)
+
+	_, err = c1.Decrypt(ciphertext[:10])
+	assert.Error(t, err)
+}
+
+func TestVersionedCipher(t *testing.T) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	data := []byte("f3928pufm982374dj02y485dsl34890u2t9nd4028s94dm58y2394087dhmsyt29h8df")
+
+	legacy, err := NewCFBCipher(secret)
+	assert.Equal(t, nil, err)
+	current, err := NewXChaCha20Poly1305Cipher(DeriveKey(secret, SessionEncryptionKey))
+	assert.Equal(t, nil, err)
+	c := NewVersionedCipher(XChaCha20Poly1305Version, current, legacy)
+
+	t.Run("prefixes the version", func(t *testing.T) {
+		ciphertext, err := c.Encrypt(data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, XChaCha20Poly1305Version, ciphertext[0])
+
+		decrypted, err := current.Decrypt(ciphertext[1:])
+		assert.Equal(t, nil, err)
+		assert.Equal(t, data, decrypted)
+	})
+
+	t.Run("decrypts legacy values", func(t *testing.T) {
+		// Encrypt until both a legacy value starting with the version byte
+		// by chance and one starting with another byte have been decrypted
+		seen := map[bool]bool{}
+		for i := 0; i < 10000 && len(seen) < 2; i++ {
+			ciphertext, err := legacy.Encrypt(data)
+			assert.Equal(t, nil, err)
+
+			decrypted, err := c.Decrypt(ciphertext)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, data, decrypted)
+			seen[ciphertext[0] == XChaCha20Poly1305Version] = true
+		}
+		assert.Len(t, seen, 2)
+	})
+
+	t.Run("rejects unknown versions without a legacy cipher", func(t *testing.T) {
+		ciphertext, err := legacy.Encrypt(data)
+		assert.Equal(t, nil, err)
+		ciphertext[0] = XChaCha20Poly1305Version + 1
+
+		_, err = NewVersionedCipher(XChaCha20Poly1305Version, current, nil).Decrypt(ciphertext)
+		assert.Error(t, err)
+	})
+}
+
 func TestDecryptCFBWrongSecret(t *testing.T) {
 	secret1 := []byte("0123456789abcdefghijklmnopqrstuv")
 	secret2 := []byte("9876543210abcdefghijklmnopqrstuv")
@@ -0,0 +1,186 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// EnvelopeVersion is the version byte of values encrypted with the envelope
+// Cipher of a Keyring by a versioned Cipher
+const EnvelopeVersion byte = 0x02
+
+const (
+	// dataKeyLength is the length of the data key generated for every
+	// encrypted value, as required by XChaCha20-Poly1305
+	dataKeyLength = 32
+
+	// maxKeyIDLength is the longest key ID that can be recorded in an
+	// encrypted value
+	maxKeyIDLength = 255
+)
+
+// Keyring holds the master keys loaded from a keyring file. Every line of
+// the file is a key ID and a secret, separated by a comma. The first key
+// wraps the data keys of new values, the other keys are only used to unwrap
+// the data keys of values encrypted before.
+type Keyring struct {
+	path string
+	keys atomic.Value
+}
+
+// keyringKeys are the keys loaded from the keyring file at one time
+type keyringKeys struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring loads the keyring file at the path
+func NewKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	err := k.Load()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Load reloads the keyring file. The keys loaded before are kept if the file
+// is invalid.
+func (k *Keyring) Load() error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return fmt.Errorf("error opening keyring file: %v", err)
+	}
+	defer f.Close()
+
+	csvReader := csv.NewReader(f)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = 2
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("error reading keyring file: %v", err)
+	}
+	if len(records) == 0 {
+		return errors.New("keyring file does not contain any keys")
+	}
+
+	keys := &keyringKeys{aeads: make(map[string]cipher.AEAD, len(records))}
+	for _, record := range records {
+		id := strings.TrimSpace(record[0])
+		if id == "" || len(id) > maxKeyIDLength {
+			return fmt.Errorf("key ID %q must be between 1 and %d bytes", id, maxKeyIDLength)
+		}
+		if _, ok := keys.aeads[id]; ok {
+			return fmt.Errorf("key ID %q is used more than once", id)
+		}
+		block, err := aes.NewCipher(SecretBytes(strings.TrimSpace(record[1])))
+		if err != nil {
+			return fmt.Errorf("invalid secret for key %q: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("invalid secret for key %q: %v", id, err)
+		}
+		if keys.primary == "" {
+			keys.primary = id
+		}
+		keys.aeads[id] = aead
+	}
+
+	k.keys.Store(keys)
+	return nil
+}
+
+// PrimaryKeyID returns the ID of the key wrapping the data keys of new values
+func (k *Keyring) PrimaryKeyID() string {
+	return k.current().primary
+}
+
+func (k *Keyring) current() *keyringKeys {
+	return k.keys.Load().(*keyringKeys)
+}
+
+type envelopeCipher struct {
+	keyring *Keyring
+}
+
+// NewEnvelopeCipher returns a Cipher that encrypts every value with a new
+// data key using XChaCha20-Poly1305. The data key is wrapped with the primary
+// key of the keyring using AES GCM and stored with the ID of that key in the
+// encrypted value.
+func NewEnvelopeCipher(keyring *Keyring) Cipher {
+	return &envelopeCipher{keyring: keyring}
+}
+
+// Encrypt with a new data key wrapped by the primary key of the keyring
+func (c *envelopeCipher) Encrypt(value []byte) ([]byte, error) {
+	keys := c.keyring.current()
+	master := keys.aeads[keys.primary]
+
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to create data key %s", err)
+	}
+	dataCipher, err := NewXChaCha20Poly1305Cipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := dataCipher.Encrypt(value)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, master.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce %s", err)
+	}
+	// The key ID is authenticated with the data key so a wrapped key can't
+	// be moved to another key ID
+	wrappedKey := master.Seal(nonce, nonce, dataKey, []byte(keys.primary))
+
+	ciphertext := make([]byte, 0, 1+len(keys.primary)+len(wrappedKey)+len(encrypted))
+	ciphertext = append(ciphertext, byte(len(keys.primary)))
+	ciphertext = append(ciphertext, keys.primary...)
+	ciphertext = append(ciphertext, wrappedKey...)
+	return append(ciphertext, encrypted...), nil
+}
+
+// Decrypt with the data key unwrapped by the key recorded in the ciphertext
+func (c *envelopeCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 1 || len(ciphertext) < 1+int(ciphertext[0]) {
+		return nil, errors.New("encrypted value is missing the key ID")
+	}
+	id := string(ciphertext[1 : 1+int(ciphertext[0])])
+	ciphertext = ciphertext[1+len(id):]
+
+	master, ok := c.keyring.current().aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("encrypted value uses key %q which is not in the keyring", id)
+	}
+
+	wrappedKeyLength := master.NonceSize() + dataKeyLength + master.Overhead()
+	if len(ciphertext) < wrappedKeyLength {
+		return nil, fmt.Errorf("encrypted value should be at least %d bytes, but is only %d bytes", wrappedKeyLength, len(ciphertext))
+	}
+	nonce := ciphertext[:master.NonceSize()]
+	wrappedKey := ciphertext[master.NonceSize():wrappedKeyLength]
+	dataKey, err := master.Open(nil, nonce, wrappedKey, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q: %v", id, err)
+	}
+
+	dataCipher, err := NewXChaCha20Poly1305Cipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return dataCipher.Decrypt(ciphertext[wrappedKeyLength:])
+}
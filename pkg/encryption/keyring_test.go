@@ -0,0 +1,145 @@
+package encryption
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	keyringSecret1 = "0123456789abcdefghijklmnopqrstuv"
+	keyringSecret2 = "vutsrqponmlkjihgfedcba9876543210"
+)
+
+// writeKeyring writes the keyring file contents to the path
+func writeKeyring(t *testing.T, path string, contents string) {
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	assert.NoError(t, err)
+}
+
+func newTestKeyring(t *testing.T, contents string) (*Keyring, string) {
+	dir, err := ioutil.TempDir("", "oauth2-proxy-keyring")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "keyring")
+	writeKeyring(t, path, contents)
+	keyring, err := NewKeyring(path)
+	assert.NoError(t, err)
+	return keyring, path
+}
+
+func TestEnvelopeCipher(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "# Session keys\nkey-1,"+keyringSecret1+"\nkey-2,"+keyringSecret2+"\n")
+	assert.Equal(t, "key-1", keyring.PrimaryKeyID())
+
+	c := NewEnvelopeCipher(keyring)
+	value := []byte("my_session_value")
+
+	encrypted, err := c.Encrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(len("key-1")), encrypted[0])
+	assert.Equal(t, "key-1", string(encrypted[1:6]))
+	assert.NotContains(t, string(encrypted), string(value))
+
+	decrypted, err := c.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+
+	// Every value gets its own data key
+	encryptedAgain, err := c.Encrypt(value)
+	assert.NoError(t, err)
+	assert.NotEqual(t, encrypted[6:66], encryptedAgain[6:66])
+}
+
+func TestEnvelopeCipherTampered(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "key-1,"+keyringSecret1+"\nkey-2,"+keyringSecret2+"\n")
+	c := NewEnvelopeCipher(keyring)
+
+	encrypted, err := c.Encrypt([]byte("my_session_value"))
+	assert.NoError(t, err)
+
+	// The wrapped data key is bound to the ID of the key wrapping it
+	moved := append([]byte{}, encrypted...)
+	moved[5] = '2'
+	_, err = c.Decrypt(moved)
+	assert.Error(t, err)
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 0xff
+	_, err = c.Decrypt(tampered)
+	assert.Error(t, err)
+
+	_, err = c.Decrypt(encrypted[:20])
+	assert.Error(t, err)
+
+	_, err = c.Decrypt([]byte{})
+	assert.Error(t, err)
+}
+
+func TestKeyringLoad(t *testing.T) {
+	keyring, path := newTestKeyring(t, "key-1,"+keyringSecret1+"\n")
+	c := NewEnvelopeCipher(keyring)
+	value := []byte("my_session_value")
+
+	oldEncrypted, err := c.Encrypt(value)
+	assert.NoError(t, err)
+
+	// Adding a new primary key keeps the old key for decrypting
+	writeKeyring(t, path, "key-2,"+keyringSecret2+"\nkey-1,"+keyringSecret1+"\n")
+	assert.NoError(t, keyring.Load())
+	assert.Equal(t, "key-2", keyring.PrimaryKeyID())
+
+	newEncrypted, err := c.Encrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, "key-2", string(newEncrypted[1:6]))
+
+	decrypted, err := c.Decrypt(oldEncrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+
+	// Invalid keyring files keep the keys loaded before
+	writeKeyring(t, path, "key-3,tooshort\n")
+	assert.Error(t, keyring.Load())
+	assert.Equal(t, "key-2", keyring.PrimaryKeyID())
+
+	// Retiring the old key stops values it wrapped from decrypting
+	writeKeyring(t, path, "key-2,"+keyringSecret2+"\n")
+	assert.NoError(t, keyring.Load())
+
+	_, err = c.Decrypt(oldEncrypted)
+	assert.EqualError(t, err, `encrypted value uses key "key-1" which is not in the keyring`)
+	decrypted, err = c.Decrypt(newEncrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+}
+
+func TestNewKeyringInvalid(t *testing.T) {
+	testCases := map[string]string{
+		"Without keys":          "# No keys yet\n",
+		"With an invalid line":  "key-1\n",
+		"With an invalid key":   "key-1,tooshort\n",
+		"With an empty key ID":  "," + keyringSecret1 + "\n",
+		"With a repeated key":   "key-1," + keyringSecret1 + "\nkey-1," + keyringSecret2 + "\n",
+		"With too many columns": "key-1," + keyringSecret1 + ",extra\n",
+	}
+
+	for testName, contents := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "oauth2-proxy-keyring")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "keyring")
+			writeKeyring(t, path, contents)
+			_, err = NewKeyring(path)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := NewKeyring("/path/does/not/exist")
+	assert.Error(t, err)
+}
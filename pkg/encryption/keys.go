@@ -0,0 +1,38 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyPurpose is what a key derived from a secret is used for. Every purpose
+// gets its own key so a secret is never used directly for more than one
+// purpose.
+type KeyPurpose string
+
+const (
+	// CookieSigningKey signs cookies
+	CookieSigningKey KeyPurpose = "cookie-signing"
+
+	// SessionEncryptionKey encrypts sessions stored in cookies
+	SessionEncryptionKey KeyPurpose = "session-encryption"
+
+	// CSRFKey signs the CSRF cookie
+	CSRFKey KeyPurpose = "csrf"
+)
+
+// derivedKeyLength is the length of every derived key, as required by
+// XChaCha20-Poly1305
+const derivedKeyLength = 32
+
+// DeriveKey derives the key for the purpose from the secret with HKDF-SHA256
+func DeriveKey(secret []byte, purpose KeyPurpose) []byte {
+	key := make([]byte, derivedKeyLength)
+	kdf := hkdf.New(sha256.New, secret, nil, []byte("oauth2-proxy "+string(purpose)))
+	// HKDF only fails when reading more than 255 times the hash length, so
+	// reading a single key never fails
+	_, _ = io.ReadFull(kdf, key)
+	return key
+}
@@ -0,0 +1,21 @@
+package encryption
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveKey(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+
+	key := DeriveKey(secret, SessionEncryptionKey)
+	assert.Len(t, key, 32)
+	assert.Equal(t, key, DeriveKey(secret, SessionEncryptionKey))
+
+	// Every purpose and secret gets its own key
+	assert.NotEqual(t, key, DeriveKey(secret, CookieSigningKey))
+	assert.NotEqual(t, key, DeriveKey(secret, CSRFKey))
+	assert.NotEqual(t, key, DeriveKey([]byte("fedcba9876543210"), SessionEncryptionKey))
+	assert.NotEqual(t, secret, key[:len(secret)])
+}
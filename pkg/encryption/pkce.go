@@ -0,0 +1,27 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// CodeChallengeMethodS256 is the PKCE code challenge method that hashes the
+// code verifier with SHA-256 (RFC 7636)
+const CodeChallengeMethodS256 = "S256"
+
+// GenerateCodeVerifier generates a random 43 character PKCE code verifier
+func GenerateCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateCodeChallenge derives the S256 code challenge for a code verifier
+func GenerateCodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
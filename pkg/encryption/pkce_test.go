@@ -0,0 +1,24 @@
+package encryption
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCodeVerifier(t *testing.T) {
+	verifier, err := GenerateCodeVerifier()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`), verifier)
+
+	other, err := GenerateCodeVerifier()
+	assert.NoError(t, err)
+	assert.NotEqual(t, verifier, other)
+}
+
+func TestGenerateCodeChallenge(t *testing.T) {
+	// Example from RFC 7636 Appendix B
+	challenge := GenerateCodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", challenge)
+}
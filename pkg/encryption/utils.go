@@ -36,43 +36,90 @@ func SecretBytes(secret string) []byte {
 
 // Validate ensures a cookie is properly signed
 func Validate(cookie *http.Cookie, seed string, expiration time.Duration) (value []byte, t time.Time, ok bool) {
+	value, t, _, ok = ValidateWithSeeds(cookie, []string{seed}, expiration)
+	return
+}
+
+// ValidateWithSeeds ensures a cookie is properly signed by one of the seeds.
+// The seeds are tried in order and the index of the seed that signed the
+// cookie is returned, allowing callers to detect cookies signed with an old seed.
+func ValidateWithSeeds(cookie *http.Cookie, seeds []string, expiration time.Duration) (value []byte, t time.Time, seedIndex int, ok bool) {
+	return ValidateWithSeedsForPurpose(CookieSigningKey, cookie, seeds, expiration)
+}
+
+// legacySignatureCutoff is when the process started. Session cookies signed
+// with the seed itself can only have been created by versions that did not
+// derive keys, so they are only accepted when they were signed before the
+// cutoff. They stop being accepted once they expire, one cookie expiry after
+// upgrading at the latest.
+var legacySignatureCutoff = time.Now()
+
+// ValidateWithSeedsForPurpose ensures a cookie is properly signed by the key
+// derived for the purpose from one of the seeds. Session cookies signed with
+// the seed itself before keys were derived are accepted until they expire,
+// see legacySignatureCutoff.
+func ValidateWithSeedsForPurpose(purpose KeyPurpose, cookie *http.Cookie, seeds []string, expiration time.Duration) (value []byte, t time.Time, seedIndex int, ok bool) {
 	// value, timestamp, sig
 	parts := strings.Split(cookie.Value, "|")
 	if len(parts) != 3 {
 		return
 	}
-	if checkSignature(parts[2], seed, cookie.Name, parts[0], parts[1]) {
+	for i, seed := range seeds {
+		legacy := false
+		if !checkSignature(parts[2], signingKey(seed, purpose), cookie.Name, parts[0], parts[1]) {
+			if purpose != CookieSigningKey || !checkSignature(parts[2], seed, cookie.Name, parts[0], parts[1]) {
+				continue
+			}
+			legacy = true
+		}
 		ts, err := strconv.Atoi(parts[1])
 		if err != nil {
 			return
 		}
+		signedAt := time.Unix(int64(ts), 0)
+		if legacy && !signedAt.Before(legacySignatureCutoff) {
+			return
+		}
 		// The expiration timestamp set when the cookie was created
 		// isn't sent back by the browser. Hence, we check whether the
 		// creation timestamp stored in the cookie falls within the
 		// window defined by (Now()-expiration, Now()].
-		t = time.Unix(int64(ts), 0)
+		t = signedAt
 		if t.After(time.Now().Add(expiration*-1)) && t.Before(time.Now().Add(time.Minute*5)) {
 			// it's a valid cookie. now get the contents
 			rawValue, err := base64.URLEncoding.DecodeString(parts[0])
 			if err == nil {
 				value = rawValue
+				seedIndex = i
 				ok = true
-				return
 			}
 		}
+		return
 	}
 	return
 }
 
 // SignedValue returns a cookie that is signed and can later be checked with Validate
 func SignedValue(seed string, key string, value []byte, now time.Time) string {
+	return SignedValueForPurpose(CookieSigningKey, seed, key, value, now)
+}
+
+// SignedValueForPurpose returns a cookie that is signed with the key derived
+// for the purpose from the seed, it can later be checked with
+// ValidateWithSeedsForPurpose
+func SignedValueForPurpose(purpose KeyPurpose, seed string, key string, value []byte, now time.Time) string {
 	encodedValue := base64.URLEncoding.EncodeToString(value)
 	timeStr := fmt.Sprintf("%d", now.Unix())
-	sig := cookieSignature(sha256.New, seed, key, encodedValue, timeStr)
+	sig := cookieSignature(sha256.New, signingKey(seed, purpose), key, encodedValue, timeStr)
 	cookieVal := fmt.Sprintf("%s|%s|%s", encodedValue, timeStr, sig)
 	return cookieVal
 }
 
+// signingKey derives the key signing cookies for the purpose from the seed
+func signingKey(seed string, purpose KeyPurpose) string {
+	return string(DeriveKey(SecretBytes(seed), purpose))
+}
+
 func cookieSignature(signer func() hash.Hash, args ...string) string {
 	h := hmac.New(signer, []byte(args[0]))
 	for _, arg := range args[1:] {
@@ -7,7 +7,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -98,3 +100,91 @@ func TestSignAndValidate(t *testing.T) {
 	assert.False(t, checkSignature(sha256sig, seed, key, "tampered", epoch))
 	assert.False(t, checkSignature(sha1sig, seed, key, "tampered", epoch))
 }
+
+func TestValidateWithSeeds(t *testing.T) {
+	current := "0123456789abcdef"
+	previous := "fedcba9876543210"
+	key := "cookie-name"
+	now := time.Now()
+
+	cookie := &http.Cookie{
+		Name:  key,
+		Value: SignedValue(previous, key, []byte("value"), now),
+	}
+
+	value, ts, seedIndex, ok := ValidateWithSeeds(cookie, []string{current, previous}, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, now.Unix(), ts.Unix())
+	assert.Equal(t, 1, seedIndex)
+
+	_, _, seedIndex, ok = ValidateWithSeeds(cookie, []string{previous, current}, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, 0, seedIndex)
+
+	_, _, _, ok = ValidateWithSeeds(cookie, []string{current}, time.Hour)
+	assert.False(t, ok)
+
+	_, _, ok = Validate(cookie, previous, time.Hour)
+	assert.True(t, ok)
+}
+
+func TestValidateLegacySignedValue(t *testing.T) {
+	seed := "0123456789abcdef"
+	key := "cookie-name"
+
+	// Cookies signed before keys were derived are signed with the seed
+	legacySignedValue := func(now time.Time) string {
+		encodedValue := base64.URLEncoding.EncodeToString([]byte("value"))
+		timeStr := fmt.Sprintf("%d", now.Unix())
+		sig := cookieSignature(sha256.New, seed, key, encodedValue, timeStr)
+		return fmt.Sprintf("%s|%s|%s", encodedValue, timeStr, sig)
+	}
+
+	signedAt := legacySignatureCutoff.Add(-time.Minute)
+	cookie := &http.Cookie{
+		Name:  key,
+		Value: legacySignedValue(signedAt),
+	}
+
+	value, _, ok := Validate(cookie, seed, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+
+	// Only until they expire
+	_, _, ok = Validate(cookie, seed, 30*time.Second)
+	assert.False(t, ok)
+
+	// New cookies are signed with the derived key rather than the seed
+	assert.NotEqual(t, cookie.Value, SignedValue(seed, key, []byte("value"), signedAt))
+
+	// Cookies signed with the seed since the cutoff were not created by an
+	// earlier version
+	cookie.Value = legacySignedValue(legacySignatureCutoff.Add(time.Second))
+	_, _, ok = Validate(cookie, seed, time.Hour)
+	assert.False(t, ok)
+
+	// Cookies for other purposes are never accepted with the seed
+	cookie.Value = legacySignedValue(signedAt)
+	_, _, _, ok = ValidateWithSeedsForPurpose(CSRFKey, cookie, []string{seed}, time.Hour)
+	assert.False(t, ok)
+}
+
+func TestValidateWithSeedsForPurpose(t *testing.T) {
+	seed := "0123456789abcdef"
+	key := "cookie-name"
+	now := time.Now()
+
+	cookie := &http.Cookie{
+		Name:  key,
+		Value: SignedValueForPurpose(CSRFKey, seed, key, []byte("value"), now),
+	}
+
+	value, _, _, ok := ValidateWithSeedsForPurpose(CSRFKey, cookie, []string{seed}, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+
+	// A cookie signed for one purpose is not valid for another
+	_, _, _, ok = ValidateWithSeeds(cookie, []string{seed}, time.Hour)
+	assert.False(t, ok)
+}
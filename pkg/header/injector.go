@@ -0,0 +1,186 @@
+package header
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// Injector adds the configured headers, with values taken from a session, to
+// a request or response
+type Injector interface {
+	// Inject replaces any existing values of the configured headers with the
+	// values from the session
+	Inject(http.Header, *sessionsapi.SessionState)
+
+	// Strip removes the configured headers
+	Strip(http.Header)
+}
+
+// valueFunc returns the values of a header source for the session
+type valueFunc func(*sessionsapi.SessionState) []string
+
+// sessionFields maps the names allowed in HeaderValue.SessionField to the
+// session values they refer to
+var sessionFields = map[string]valueFunc{
+	"user": func(s *sessionsapi.SessionState) []string {
+		return nonEmpty(s.User)
+	},
+	"email": func(s *sessionsapi.SessionState) []string {
+		return nonEmpty(s.Email)
+	},
+	"preferredUsername": func(s *sessionsapi.SessionState) []string {
+		return nonEmpty(s.PreferredUsername)
+	},
+	"groups": func(s *sessionsapi.SessionState) []string {
+		return s.Groups
+	},
+	"accessToken": func(s *sessionsapi.SessionState) []string {
+		return nonEmpty(s.AccessToken)
+	},
+	"idToken": func(s *sessionsapi.SessionState) []string {
+		return nonEmpty(s.IDToken)
+	},
+}
+
+// IsSessionField checks whether the name is a session field that can be used
+// as a header value
+func IsSessionField(name string) bool {
+	_, ok := sessionFields[name]
+	return ok
+}
+
+type injectedHeader struct {
+	name   string
+	join   string
+	values []valueFunc
+}
+
+type injector struct {
+	headers []injectedHeader
+}
+
+// NewInjector creates an Injector for the header configuration given
+func NewInjector(headers []options.Header) (Injector, error) {
+	i := &injector{}
+	for _, header := range headers {
+		h := injectedHeader{
+			name: header.Name,
+			join: header.Join,
+		}
+		for _, value := range header.Values {
+			f, err := newValueFunc(value)
+			if err != nil {
+				return nil, fmt.Errorf("error building value for header %q: %v", header.Name, err)
+			}
+			h.values = append(h.values, f)
+		}
+		i.headers = append(i.headers, h)
+	}
+	return i, nil
+}
+
+// Inject replaces any existing values of the configured headers with the
+// values from the session. Headers without any values are removed.
+func (i *injector) Inject(header http.Header, session *sessionsapi.SessionState) {
+	for _, h := range i.headers {
+		header.Del(h.name)
+
+		var values []string
+		for _, f := range h.values {
+			values = append(values, f(session)...)
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		if h.join != "" {
+			header.Set(h.name, strings.Join(values, h.join))
+			continue
+		}
+		for _, value := range values {
+			header.Add(h.name, value)
+		}
+	}
+}
+
+// Strip removes the configured headers
+func (i *injector) Strip(header http.Header) {
+	for _, h := range i.headers {
+		header.Del(h.name)
+	}
+}
+
+func newValueFunc(value options.HeaderValue) (valueFunc, error) {
+	var f valueFunc
+	switch {
+	case value.Value != "" && value.Claim == "" && value.SessionField == "":
+		f = func(*sessionsapi.SessionState) []string {
+			return []string{value.Value}
+		}
+	case value.Claim != "" && value.Value == "" && value.SessionField == "":
+		f = func(s *sessionsapi.SessionState) []string {
+			return claimValues(s.Claims[value.Claim])
+		}
+	case value.SessionField != "" && value.Value == "" && value.Claim == "":
+		var ok bool
+		f, ok = sessionFields[value.SessionField]
+		if !ok {
+			return nil, fmt.Errorf("unknown session field %q", value.SessionField)
+		}
+	default:
+		return nil, fmt.Errorf("exactly one of value, claim or sessionField must be set")
+	}
+
+	if value.Prefix == "" {
+		return f, nil
+	}
+	return func(s *sessionsapi.SessionState) []string {
+		values := f(s)
+		prefixed := make([]string, 0, len(values))
+		for _, v := range values {
+			prefixed = append(prefixed, value.Prefix+v)
+		}
+		return prefixed
+	}, nil
+}
+
+// claimValues converts a claim to header values. Arrays produce a value per
+// element and objects are passed as JSON.
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case nil:
+		return nil
+	case string:
+		return nonEmpty(v)
+	case float64:
+		// Avoid exponent notation for large numeric claims such as IDs
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, elem := range v {
+			values = append(values, claimValues(elem)...)
+		}
+		return values
+	case map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return []string{string(data)}
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
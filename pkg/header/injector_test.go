@@ -0,0 +1,217 @@
+package header
+
+import (
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Injector", func() {
+	type injectorTableInput struct {
+		headers        []options.Header
+		initialHeader  http.Header
+		session        *sessionsapi.SessionState
+		expectedHeader http.Header
+		expectedErr    string
+	}
+
+	session := &sessionsapi.SessionState{
+		User:        "user",
+		Email:       "user@example.com",
+		Groups:      []string{"group-a", "group-b"},
+		AccessToken: "access_token",
+		Claims: map[string]interface{}{
+			"tenant":      "tenant-a",
+			"departments": []interface{}{"sales", "marketing"},
+			"employee_id": float64(123456789),
+			"address":     map[string]interface{}{"country": "NL"},
+		},
+	}
+
+	DescribeTable("Inject",
+		func(in injectorTableInput) {
+			injector, err := NewInjector(in.headers)
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+
+			header := in.initialHeader.Clone()
+			injector.Inject(header, in.session)
+			Expect(header).To(Equal(in.expectedHeader))
+		},
+		Entry("with no headers", injectorTableInput{
+			headers:        []options.Header{},
+			initialHeader:  http.Header{"Foo": []string{"bar"}},
+			session:        session,
+			expectedHeader: http.Header{"Foo": []string{"bar"}},
+		}),
+		Entry("with a static value", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Static",
+					Values: []options.HeaderValue{{Value: "static"}},
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Static": []string{"static"}},
+		}),
+		Entry("with a claim", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Tenant",
+					Values: []options.HeaderValue{{Claim: "tenant"}},
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Tenant": []string{"tenant-a"}},
+		}),
+		Entry("with a numeric claim", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Employee-Id",
+					Values: []options.HeaderValue{{Claim: "employee_id"}},
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Employee-Id": []string{"123456789"}},
+		}),
+		Entry("with an object claim", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Address",
+					Values: []options.HeaderValue{{Claim: "address"}},
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Address": []string{`{"country":"NL"}`}},
+		}),
+		Entry("with a multi-valued claim", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Departments",
+					Values: []options.HeaderValue{{Claim: "departments"}},
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Departments": []string{"sales", "marketing"}},
+		}),
+		Entry("with a joined multi-valued claim", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Departments",
+					Values: []options.HeaderValue{{Claim: "departments"}},
+					Join:   ",",
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Departments": []string{"sales,marketing"}},
+		}),
+		Entry("with a missing claim, removes the existing header", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Missing",
+					Values: []options.HeaderValue{{Claim: "missing"}},
+				},
+			},
+			initialHeader:  http.Header{"X-Missing": []string{"spoofed"}},
+			session:        session,
+			expectedHeader: http.Header{},
+		}),
+		Entry("with session fields", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-User",
+					Values: []options.HeaderValue{{SessionField: "user"}},
+				},
+				{
+					Name:   "X-Groups",
+					Values: []options.HeaderValue{{SessionField: "groups"}},
+					Join:   "|",
+				},
+				{
+					Name:   "X-Preferred-Username",
+					Values: []options.HeaderValue{{SessionField: "preferredUsername"}},
+				},
+			},
+			initialHeader: http.Header{"X-User": []string{"spoofed"}},
+			session:       session,
+			expectedHeader: http.Header{
+				"X-User":   []string{"user"},
+				"X-Groups": []string{"group-a|group-b"},
+			},
+		}),
+		Entry("with a prefix", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "Authorization",
+					Values: []options.HeaderValue{{SessionField: "accessToken", Prefix: "Bearer "}},
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"Authorization": []string{"Bearer access_token"}},
+		}),
+		Entry("with multiple sources", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name: "X-Roles",
+					Values: []options.HeaderValue{
+						{Value: "authenticated"},
+						{Claim: "departments", Prefix: "department:"},
+					},
+					Join: ",",
+				},
+			},
+			initialHeader:  http.Header{},
+			session:        session,
+			expectedHeader: http.Header{"X-Roles": []string{"authenticated,department:sales,department:marketing"}},
+		}),
+		Entry("with an unknown session field", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Unknown",
+					Values: []options.HeaderValue{{SessionField: "unknown"}},
+				},
+			},
+			expectedErr: "error building value for header \"X-Unknown\": unknown session field \"unknown\"",
+		}),
+		Entry("with multiple sources in a value", injectorTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Invalid",
+					Values: []options.HeaderValue{{Value: "static", Claim: "tenant"}},
+				},
+			},
+			expectedErr: "error building value for header \"X-Invalid\": exactly one of value, claim or sessionField must be set",
+		}),
+	)
+
+	It("Strip removes the configured headers", func() {
+		injector, err := NewInjector([]options.Header{
+			{
+				Name:   "X-Tenant",
+				Values: []options.HeaderValue{{Claim: "tenant"}},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		header := http.Header{
+			"Foo":      []string{"bar"},
+			"X-Tenant": []string{"spoofed"},
+		}
+		injector.Strip(header)
+		Expect(header).To(Equal(http.Header{"Foo": []string{"bar"}}))
+	})
+})
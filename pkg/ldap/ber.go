@@ -0,0 +1,231 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes of the tags used by LDAP
+const (
+	classUniversal   byte = 0x00
+	classApplication byte = 0x40
+	classContext     byte = 0x80
+
+	constructedBit byte = 0x20
+)
+
+// Universal tags of the types used by LDAP
+const (
+	tagBoolean     = 1
+	tagInteger     = 2
+	tagOctetString = 4
+	tagNull        = 5
+	tagEnumerated  = 10
+	tagSequence    = 16
+	tagSet         = 17
+)
+
+// maxPacketLength limits the size of the messages read from the server
+const maxPacketLength = 16 << 20
+
+// maxPacketDepth limits the nesting of the messages read from the server,
+// the deepest LDAP responses nest a few levels
+const maxPacketDepth = 32
+
+// packet is a BER encoded value. Constructed values hold their children,
+// primitive values their content.
+type packet struct {
+	class       byte
+	constructed bool
+	tag         int
+	value       []byte
+	children    []*packet
+}
+
+func newSequence(children ...*packet) *packet {
+	return newConstructed(classUniversal, tagSequence, children...)
+}
+
+func newConstructed(class byte, tag int, children ...*packet) *packet {
+	return &packet{class: class, constructed: true, tag: tag, children: children}
+}
+
+func newOctetString(class byte, tag int, value string) *packet {
+	return &packet{class: class, tag: tag, value: []byte(value)}
+}
+
+func newString(value string) *packet {
+	return newOctetString(classUniversal, tagOctetString, value)
+}
+
+func newInteger(tag int, value int64) *packet {
+	// Two's complement, big endian, in the fewest bytes
+	var b []byte
+	for {
+		b = append([]byte{byte(value)}, b...)
+		if (value < 0x80 && value >= -0x80) || len(b) == 8 {
+			break
+		}
+		value >>= 8
+	}
+	return &packet{class: classUniversal, tag: tag, value: b}
+}
+
+func newBoolean(value bool) *packet {
+	if value {
+		return &packet{class: classUniversal, tag: tagBoolean, value: []byte{0xff}}
+	}
+	return &packet{class: classUniversal, tag: tagBoolean, value: []byte{0x00}}
+}
+
+// is checks the class and tag of the packet
+func (p *packet) is(class byte, tag int) bool {
+	return p.class == class && p.tag == tag
+}
+
+// int decodes an INTEGER or ENUMERATED packet
+func (p *packet) int() (int64, error) {
+	if p.constructed || len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errors.New("invalid integer")
+	}
+	// Sign extend from the first byte
+	v := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+// str returns the content of a primitive packet as a string
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+// bytes encodes the packet
+func (p *packet) bytes() []byte {
+	content := p.value
+	identifier := p.class | byte(p.tag)
+	if p.constructed {
+		identifier |= constructedBit
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+
+	b := []byte{identifier}
+	b = append(b, encodeLength(len(content))...)
+	return append(b, content...)
+}
+
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var b []byte
+	for l := length; l > 0; l >>= 8 {
+		b = append([]byte{byte(l)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// readPacket reads and decodes the next packet
+func readPacket(r *bufio.Reader) (*packet, error) {
+	identifier, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r.ReadByte)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return decodePacket(identifier, content, 0)
+}
+
+// readLength reads a definite BER length
+func readLength(readByte func() (byte, error)) (int, error) {
+	first, err := readByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	// Indefinite lengths are not allowed in LDAP
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, fmt.Errorf("invalid BER length of %d bytes", n)
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err := readByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length < 0 || length > maxPacketLength {
+		return 0, fmt.Errorf("BER packet of %d bytes is too large", length)
+	}
+	return length, nil
+}
+
+// decodePacket decodes the content of a packet with the identifier,
+// decoding the children of constructed packets
+func decodePacket(identifier byte, content []byte, depth int) (*packet, error) {
+	if identifier&0x1f == 0x1f {
+		return nil, errors.New("high BER tag numbers are not supported")
+	}
+	if depth > maxPacketDepth {
+		return nil, errors.New("BER packet is nested too deeply")
+	}
+	p := &packet{
+		class:       identifier & 0xc0,
+		constructed: identifier&constructedBit != 0,
+		tag:         int(identifier & 0x1f),
+	}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+
+	for len(content) > 0 {
+		childIdentifier := content[0]
+		content = content[1:]
+		length, err := readLength(func() (byte, error) {
+			if len(content) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := content[0]
+			content = content[1:]
+			return b, nil
+		})
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if length > len(content) {
+			return nil, unexpectedEOF(io.ErrUnexpectedEOF)
+		}
+		child, err := decodePacket(childIdentifier, content[:length], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		content = content[length:]
+	}
+	return p, nil
+}
+
+// unexpectedEOF reports the end of the input within a packet
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("truncated BER packet")
+	}
+	return err
+}
@@ -0,0 +1,357 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Application tags of the LDAP operations
+const (
+	opBindRequest       = 0
+	opBindResponse      = 1
+	opUnbindRequest     = 2
+	opSearchRequest     = 3
+	opSearchResultEntry = 4
+	opSearchResultDone  = 5
+	opSearchResultRef   = 19
+	opExtendedRequest   = 23
+	opExtendedResponse  = 24
+)
+
+const (
+	protocolVersion = 3
+
+	// authenticationSimple is the context tag of the password of a bind
+	authenticationSimple = 0
+
+	// extendedRequestName is the context tag of the OID of an extended
+	// operation
+	extendedRequestName = 0
+	startTLSOID         = "1.3.6.1.4.1.1466.20037"
+
+	derefAliasesNever = 0
+
+	// noAttributes is the attribute list requesting entries without their
+	// attributes
+	noAttributes = "1.1"
+
+	// searchSizeLimit is the most entries a search returns
+	searchSizeLimit = 1000
+
+	defaultPort    = "389"
+	defaultTLSPort = "636"
+)
+
+// Result codes of the operations
+const (
+	ResultSuccess            = 0
+	ResultInvalidCredentials = 49
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// Error is an LDAP result other than success
+type Error struct {
+	ResultCode int64
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.ResultCode, e.Message)
+}
+
+// IsResultCode checks whether the error is an LDAP result with the code
+func IsResultCode(err error, code int64) bool {
+	var ldapErr *Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == code
+}
+
+// Entry is an entry returned by a search
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// SearchRequest searches the entries under the BaseDN matching the Filter
+type SearchRequest struct {
+	BaseDN string
+	Scope  int
+
+	// Filter is in the string representation of RFC 4515
+	Filter string
+
+	// Attributes are the attributes returned with the entries, no
+	// attributes are returned when empty
+	Attributes []string
+}
+
+// Conn is a connection to an LDAP server. Operations are sent one at a time,
+// it must not be used concurrently.
+type Conn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	messageID int64
+	timeout   time.Duration
+	host      string
+}
+
+// Dial connects to the server at the ldap:// or ldaps:// URL. Every
+// operation must complete within the timeout.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %v", err)
+	}
+	host := u.Hostname()
+	port := u.Port()
+	switch {
+	case u.Scheme == "ldap" && port == "":
+		port = defaultPort
+	case u.Scheme == "ldaps" && port == "":
+		port = defaultTLSPort
+	case u.Scheme != "ldap" && u.Scheme != "ldaps":
+		return nil, fmt.Errorf("invalid LDAP URL scheme %q, expected ldap or ldaps", u.Scheme)
+	}
+	if host == "" {
+		return nil, errors.New("invalid LDAP URL: missing host")
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if u.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), withServerName(tlsConfig, host))
+	} else {
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to LDAP server: %v", err)
+	}
+	return &Conn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		timeout: timeout,
+		host:    host,
+	}, nil
+}
+
+// withServerName returns a copy of the TLS config verifying the host name
+func withServerName(tlsConfig *tls.Config, host string) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// StartTLS upgrades the connection to TLS with the StartTLS extended
+// operation
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	request := newConstructed(classApplication, opExtendedRequest,
+		newOctetString(classContext, extendedRequestName, startTLSOID),
+	)
+	response, err := c.roundTrip(request, opExtendedResponse)
+	if err != nil {
+		return fmt.Errorf("error starting TLS: %v", err)
+	}
+	if err := resultError(response); err != nil {
+		return fmt.Errorf("error starting TLS: %v", err)
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("error starting TLS: %v", err)
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates the connection with the password of the DN.
+// Unauthenticated binds, with an empty password, are rejected as servers
+// accept them without checking the DN.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return errors.New("an empty password can't be used to bind")
+	}
+	request := newConstructed(classApplication, opBindRequest,
+		newInteger(tagInteger, protocolVersion),
+		newString(dn),
+		newOctetString(classContext, authenticationSimple, password),
+	)
+	response, err := c.roundTrip(request, opBindResponse)
+	if err != nil {
+		return fmt.Errorf("error binding as %q: %v", dn, err)
+	}
+	if err := resultError(response); err != nil {
+		return fmt.Errorf("error binding as %q: %w", dn, err)
+	}
+	return nil
+}
+
+// Search returns the entries matching the search request. Referrals are
+// not followed.
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	attributes := newSequence()
+	if len(req.Attributes) == 0 {
+		attributes.children = append(attributes.children, newString(noAttributes))
+	}
+	for _, attr := range req.Attributes {
+		attributes.children = append(attributes.children, newString(attr))
+	}
+	request := newConstructed(classApplication, opSearchRequest,
+		newString(req.BaseDN),
+		newInteger(tagEnumerated, int64(req.Scope)),
+		newInteger(tagEnumerated, derefAliasesNever),
+		newInteger(tagInteger, searchSizeLimit),
+		newInteger(tagInteger, int64(c.timeout/time.Second)),
+		newBoolean(false),
+		filter,
+		attributes,
+	)
+
+	id, err := c.send(request)
+	if err != nil {
+		return nil, fmt.Errorf("error searching %q: %v", req.BaseDN, err)
+	}
+	entries := []*Entry{}
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, fmt.Errorf("error searching %q: %v", req.BaseDN, err)
+		}
+		switch {
+		case op.is(classApplication, opSearchResultEntry):
+			entry, err := decodeEntry(op)
+			if err != nil {
+				return nil, fmt.Errorf("error searching %q: %v", req.BaseDN, err)
+			}
+			entries = append(entries, entry)
+		case op.is(classApplication, opSearchResultRef):
+			continue
+		case op.is(classApplication, opSearchResultDone):
+			if err := resultError(op); err != nil {
+				return nil, fmt.Errorf("error searching %q: %w", req.BaseDN, err)
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("error searching %q: unexpected response with tag %d", req.BaseDN, op.tag)
+		}
+	}
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.send(&packet{class: classApplication, tag: opUnbindRequest})
+	return c.conn.Close()
+}
+
+// roundTrip sends the request and receives the response to it, which must be
+// the operation with the tag
+func (c *Conn) roundTrip(request *packet, responseTag int) (*packet, error) {
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if !response.is(classApplication, responseTag) {
+		return nil, fmt.Errorf("unexpected response with tag %d", response.tag)
+	}
+	return response, nil
+}
+
+// send writes the operation in a new message and returns the message ID
+func (c *Conn) send(op *packet) (int64, error) {
+	c.messageID++
+	message := newSequence(newInteger(tagInteger, c.messageID), op)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(message.bytes())
+	return c.messageID, err
+}
+
+// receive reads the next message, which must be a response to the message
+// ID, and returns its operation
+func (c *Conn) receive(id int64) (*packet, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	message, err := readPacket(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if !message.is(classUniversal, tagSequence) || len(message.children) < 2 {
+		return nil, errors.New("invalid LDAP message")
+	}
+	responseID, err := message.children[0].int()
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP message ID: %v", err)
+	}
+	// Notices of disconnection are sent with the message ID 0
+	if responseID == 0 {
+		if err := resultError(message.children[1]); err != nil {
+			return nil, fmt.Errorf("disconnected by the server: %v", err)
+		}
+		return nil, errors.New("disconnected by the server")
+	}
+	if responseID != id {
+		return nil, fmt.Errorf("unexpected response to message %d", responseID)
+	}
+	return message.children[1], nil
+}
+
+// resultError decodes the LDAPResult at the start of the response
+func resultError(response *packet) error {
+	if !response.constructed || len(response.children) < 3 {
+		return errors.New("invalid LDAP result")
+	}
+	code, err := response.children[0].int()
+	if err != nil {
+		return fmt.Errorf("invalid LDAP result code: %v", err)
+	}
+	if code != ResultSuccess {
+		return &Error{ResultCode: code, Message: response.children[2].str()}
+	}
+	return nil
+}
+
+// decodeEntry decodes a SearchResultEntry
+func decodeEntry(op *packet) (*Entry, error) {
+	if len(op.children) != 2 {
+		return nil, errors.New("invalid search result entry")
+	}
+	entry := &Entry{
+		DN:         op.children[0].str(),
+		Attributes: map[string][]string{},
+	}
+	for _, attr := range op.children[1].children {
+		if len(attr.children) != 2 {
+			return nil, errors.New("invalid search result attribute")
+		}
+		name := attr.children[0].str()
+		for _, value := range attr.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.str())
+		}
+	}
+	return entry, nil
+}
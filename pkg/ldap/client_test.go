@@ -0,0 +1,196 @@
+package ldap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTimeout = 5 * time.Second
+
+// newTestTLSConfigs creates a self-signed certificate for 127.0.0.1 and
+// returns the server config using it with a client config trusting it
+func newTestTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return serverConfig, &tls.Config{RootCAs: pool}
+}
+
+func newTestDirectory(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *testServer {
+	s := newTestServer(t, tlsConfig, implicitTLS)
+	s.passwords["cn=proxy,dc=example,dc=com"] = "pr0xyP455"
+	s.entries["(&(objectClass=person)(uid=user1))"] = []*Entry{{
+		DN: "uid=user1,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"mail": {"user1@example.com"},
+			"cn":   {"User One", "user1"},
+		},
+	}}
+	s.entries["(uid=user*)"] = []*Entry{
+		{DN: "uid=user1,ou=people,dc=example,dc=com", Attributes: map[string][]string{}},
+		{DN: "uid=user2,ou=people,dc=example,dc=com", Attributes: map[string][]string{}},
+	}
+	return s
+}
+
+func TestBindAndSearch(t *testing.T) {
+	s := newTestDirectory(t, nil, false)
+	conn, err := Dial("ldap://"+s.addr(), nil, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.Bind("cn=proxy,dc=example,dc=com", "pr0xyP455"))
+
+	entries, err := conn.Search(&SearchRequest{
+		BaseDN:     "dc=example,dc=com",
+		Scope:      ScopeWholeSubtree,
+		Filter:     "(&(objectClass=person)(uid=user1))",
+		Attributes: []string{"mail", "cn"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Entry{{
+		DN: "uid=user1,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"mail": {"user1@example.com"},
+			"cn":   {"User One", "user1"},
+		},
+	}}, entries)
+
+	entries, err = conn.Search(&SearchRequest{
+		BaseDN: "dc=example,dc=com",
+		Scope:  ScopeWholeSubtree,
+		Filter: "(uid=user*)",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = conn.Search(&SearchRequest{
+		BaseDN: "dc=example,dc=com",
+		Scope:  ScopeWholeSubtree,
+		Filter: "(uid=user3)",
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Searches without attributes ask for none rather than all of them
+	requests := s.receivedRequests()
+	assert.Len(t, requests, 4)
+	assert.Equal(t, noAttributes, requests[3].children[7].children[0].str())
+}
+
+func TestBindInvalidCredentials(t *testing.T) {
+	s := newTestDirectory(t, nil, false)
+	conn, err := Dial("ldap://"+s.addr(), nil, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	err = conn.Bind("cn=proxy,dc=example,dc=com", "wrong")
+	assert.EqualError(t, err, `error binding as "cn=proxy,dc=example,dc=com": LDAP result code 49`)
+	assert.True(t, IsResultCode(err, ResultInvalidCredentials))
+
+	// Unauthenticated binds never reach the server
+	err = conn.Bind("cn=proxy,dc=example,dc=com", "")
+	assert.Error(t, err)
+	assert.False(t, IsResultCode(err, ResultSuccess))
+	assert.Len(t, s.receivedRequests(), 1)
+}
+
+func TestSearchInvalidFilter(t *testing.T) {
+	s := newTestDirectory(t, nil, false)
+	conn, err := Dial("ldap://"+s.addr(), nil, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Search(&SearchRequest{BaseDN: "dc=example,dc=com", Filter: "uid=user1"})
+	assert.EqualError(t, err, `invalid filter "uid=user1": filters must start with '('`)
+	assert.Empty(t, s.receivedRequests())
+}
+
+func TestStartTLS(t *testing.T) {
+	serverConfig, clientConfig := newTestTLSConfigs(t)
+	s := newTestDirectory(t, serverConfig, false)
+	conn, err := Dial("ldap://"+s.addr(), nil, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.StartTLS(clientConfig))
+	_, isTLS := conn.conn.(*tls.Conn)
+	assert.True(t, isTLS)
+	assert.NoError(t, conn.Bind("cn=proxy,dc=example,dc=com", "pr0xyP455"))
+}
+
+func TestStartTLSUntrusted(t *testing.T) {
+	serverConfig, _ := newTestTLSConfigs(t)
+	_, otherClientConfig := newTestTLSConfigs(t)
+	s := newTestDirectory(t, serverConfig, false)
+	conn, err := Dial("ldap://"+s.addr(), nil, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.Error(t, conn.StartTLS(otherClientConfig))
+}
+
+func TestStartTLSUnsupported(t *testing.T) {
+	s := newTestDirectory(t, nil, false)
+	conn, err := Dial("ldap://"+s.addr(), nil, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.EqualError(t, conn.StartTLS(nil), "error starting TLS: LDAP result code 2")
+}
+
+func TestDialLDAPS(t *testing.T) {
+	serverConfig, clientConfig := newTestTLSConfigs(t)
+	s := newTestDirectory(t, serverConfig, true)
+
+	conn, err := Dial("ldaps://"+s.addr(), clientConfig, testTimeout)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.Bind("cn=proxy,dc=example,dc=com", "pr0xyP455"))
+
+	_, err = Dial("ldaps://"+s.addr(), nil, testTimeout)
+	assert.Error(t, err)
+}
+
+func TestDialInvalidURL(t *testing.T) {
+	testCases := map[string]string{
+		"http://ldap.example.com": `invalid LDAP URL scheme "http", expected ldap or ldaps`,
+		"ldap://":                 "invalid LDAP URL: missing host",
+	}
+	for rawURL, expectedErr := range testCases {
+		t.Run(rawURL, func(t *testing.T) {
+			_, err := Dial(rawURL, nil, testTimeout)
+			assert.EqualError(t, err, expectedErr)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Context tags of the filter choices
+const (
+	filterAnd            = 0
+	filterOr             = 1
+	filterNot            = 2
+	filterEqualityMatch  = 3
+	filterSubstrings     = 4
+	filterGreaterOrEqual = 5
+	filterLessOrEqual    = 6
+	filterPresent        = 7
+	filterApproxMatch    = 8
+
+	substringInitial = 0
+	substringAny     = 1
+	substringFinal   = 2
+)
+
+// EscapeFilter escapes the characters with a special meaning in filters so
+// the value can be used as an assertion value
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ValidateFilter checks the filter can be used in a search request
+func ValidateFilter(filter string) error {
+	_, err := compileFilter(filter)
+	return err
+}
+
+// compileFilter encodes a filter in the string representation of RFC 4515.
+// Extensible matches are not supported.
+func compileFilter(filter string) (*packet, error) {
+	if filter == "" {
+		return nil, errors.New("empty filter")
+	}
+	p, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q after the filter", filter, rest)
+	}
+	return p, nil
+}
+
+// parseFilter parses the filter at the start of s, returning the remainder
+func parseFilter(s string) (*packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", errors.New("filters must start with '('")
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", errors.New("unexpected end of filter")
+	}
+
+	var p *packet
+	var err error
+	switch s[0] {
+	case '&':
+		p, s, err = parseFilterSet(filterAnd, s[1:])
+	case '|':
+		p, s, err = parseFilterSet(filterOr, s[1:])
+	case '!':
+		var child *packet
+		child, s, err = parseFilter(s[1:])
+		p = newConstructed(classContext, filterNot, child)
+	default:
+		p, s, err = parseFilterItem(s)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !strings.HasPrefix(s, ")") {
+		return nil, "", errors.New("filters must end with ')'")
+	}
+	return p, s[1:], nil
+}
+
+// parseFilterSet parses the filters of an and or an or filter
+func parseFilterSet(tag int, s string) (*packet, string, error) {
+	set := newConstructed(classContext, tag)
+	for strings.HasPrefix(s, "(") {
+		child, rest, err := parseFilter(s)
+		if err != nil {
+			return nil, "", err
+		}
+		set.children = append(set.children, child)
+		s = rest
+	}
+	if len(set.children) == 0 {
+		return nil, "", errors.New("and and or filters must contain a filter")
+	}
+	return set, s, nil
+}
+
+// parseFilterItem parses a simple, presence or substrings filter
+func parseFilterItem(s string) (*packet, string, error) {
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.New("filters must end with ')'")
+	}
+	item, rest := s[:end], s[end:]
+
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, "", fmt.Errorf("%q is not an attribute assertion", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := filterEqualityMatch
+	switch attr[len(attr)-1] {
+	case '>':
+		tag = filterGreaterOrEqual
+	case '<':
+		tag = filterLessOrEqual
+	case '~':
+		tag = filterApproxMatch
+	case ':':
+		return nil, "", errors.New("extensible match filters are not supported")
+	}
+	if tag != filterEqualityMatch {
+		attr = attr[:len(attr)-1]
+	}
+	if !validAttribute(attr) {
+		return nil, "", fmt.Errorf("invalid attribute %q", attr)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return newOctetString(classContext, filterPresent, attr), rest, nil
+	}
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		p, err := substringsFilter(attr, value)
+		return p, rest, err
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return newConstructed(classContext, tag, newString(attr), newString(unescaped)), rest, nil
+}
+
+// substringsFilter builds a substrings filter from a value with wildcards
+func substringsFilter(attr, value string) (*packet, error) {
+	parts := strings.Split(value, "*")
+	substrings := newSequence()
+	for i, part := range parts {
+		if part == "" {
+			if i != 0 && i != len(parts)-1 {
+				return nil, errors.New("substrings filters must not contain '**'")
+			}
+			continue
+		}
+		unescaped, err := unescapeFilterValue(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := substringAny
+		switch i {
+		case 0:
+			tag = substringInitial
+		case len(parts) - 1:
+			tag = substringFinal
+		}
+		substrings.children = append(substrings.children, newOctetString(classContext, tag, unescaped))
+	}
+	return newConstructed(classContext, filterSubstrings, newString(attr), substrings), nil
+}
+
+// unescapeFilterValue decodes the \XX escapes of an assertion value
+func unescapeFilterValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '\\':
+			if i+3 > len(value) {
+				return "", errors.New("escapes must be followed by two hex digits")
+			}
+			decoded, err := hex.DecodeString(value[i+1 : i+3])
+			if err != nil {
+				return "", errors.New("escapes must be followed by two hex digits")
+			}
+			b.Write(decoded)
+			i += 2
+		case '(', ')', '*':
+			return "", fmt.Errorf("unescaped %q in value", string(c))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// validAttribute checks an attribute description is a name or OID with
+// options
+func validAttribute(attr string) bool {
+	if attr == "" {
+		return false
+	}
+	for _, c := range attr {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.', c == ';':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeFilter(t *testing.T) {
+	assert.Equal(t, "user1", EscapeFilter("user1"))
+	assert.Equal(t, `\2a\29\28uid=\5c\00`, EscapeFilter("*)(uid=\\\x00"))
+}
+
+func TestCompileFilter(t *testing.T) {
+	testCases := map[string]*packet{
+		"(uid=user1)": newConstructed(classContext, filterEqualityMatch,
+			newString("uid"), newString("user1"),
+		),
+		"(cn=\\2a\\28x\\29)": newConstructed(classContext, filterEqualityMatch,
+			newString("cn"), newString("*(x)"),
+		),
+		"(mail=*)": newOctetString(classContext, filterPresent, "mail"),
+		"(uidNumber>=1000)": newConstructed(classContext, filterGreaterOrEqual,
+			newString("uidNumber"), newString("1000"),
+		),
+		"(uidNumber<=2000)": newConstructed(classContext, filterLessOrEqual,
+			newString("uidNumber"), newString("2000"),
+		),
+		"(cn~=user)": newConstructed(classContext, filterApproxMatch,
+			newString("cn"), newString("user"),
+		),
+		"(cn=ab*cd*ef)": newConstructed(classContext, filterSubstrings,
+			newString("cn"),
+			newSequence(
+				newOctetString(classContext, substringInitial, "ab"),
+				newOctetString(classContext, substringAny, "cd"),
+				newOctetString(classContext, substringFinal, "ef"),
+			),
+		),
+		"(cn=*cd*)": newConstructed(classContext, filterSubstrings,
+			newString("cn"),
+			newSequence(newOctetString(classContext, substringAny, "cd")),
+		),
+		"(&(objectClass=person)(|(uid=a)(!(uid=b))))": newConstructed(classContext, filterAnd,
+			newConstructed(classContext, filterEqualityMatch, newString("objectClass"), newString("person")),
+			newConstructed(classContext, filterOr,
+				newConstructed(classContext, filterEqualityMatch, newString("uid"), newString("a")),
+				newConstructed(classContext, filterNot,
+					newConstructed(classContext, filterEqualityMatch, newString("uid"), newString("b")),
+				),
+			),
+		),
+	}
+	for filter, expected := range testCases {
+		t.Run(filter, func(t *testing.T) {
+			compiled, err := compileFilter(filter)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.bytes(), compiled.bytes())
+		})
+	}
+}
+
+func TestCompileFilterInvalid(t *testing.T) {
+	testCases := []string{
+		"",
+		"uid=user1",
+		"(uid=user1",
+		"(uid=user1))",
+		"(uid)",
+		"(=user1)",
+		"(u id=user1)",
+		"(uid=a(b)",
+		"(uid=\\2)",
+		"(uid=\\zz)",
+		"(cn=a**b)",
+		"(&)",
+		"(!)",
+		"(cn:caseExactMatch:=user)",
+	}
+	for _, filter := range testCases {
+		t.Run(filter, func(t *testing.T) {
+			assert.Error(t, ValidateFilter(filter))
+		})
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	long := string(bytes.Repeat([]byte("a"), 300))
+	p := newSequence(
+		newInteger(tagInteger, 0),
+		newInteger(tagInteger, 127),
+		newInteger(tagInteger, 128),
+		newInteger(tagInteger, -129),
+		newInteger(tagInteger, 1<<40),
+		newBoolean(true),
+		newString(long),
+		newConstructed(classApplication, opSearchResultEntry, newString("dc=example,dc=com")),
+	)
+	decoded, err := readPacket(bufio.NewReader(bytes.NewReader(p.bytes())))
+	assert.NoError(t, err)
+	assert.Equal(t, p.bytes(), decoded.bytes())
+
+	for i, expected := range []int64{0, 127, 128, -129, 1 << 40} {
+		value, err := decoded.children[i].int()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, value)
+	}
+	assert.Equal(t, long, decoded.children[6].str())
+	assert.True(t, decoded.children[7].is(classApplication, opSearchResultEntry))
+}
+
+func TestReadPacketInvalid(t *testing.T) {
+	testCases := map[string][]byte{
+		"Truncated content":          {0x30, 0x05, 0x02, 0x01},
+		"Truncated child":            {0x30, 0x03, 0x02, 0x05, 0x01},
+		"Truncated length":           {0x30, 0x82, 0x01},
+		"Indefinite length":          {0x30, 0x80, 0x00, 0x00},
+		"Too large":                  {0x30, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"High tag number":            {0x1f, 0x01, 0x00},
+		"High tag number in a child": {0x30, 0x02, 0x1f, 0x00},
+		"Nested too deeply": func() []byte {
+			p := newSequence()
+			for i := 0; i <= maxPacketDepth; i++ {
+				p = newSequence(p)
+			}
+			return p.bytes()
+		}(),
+	}
+	for testName, b := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := readPacket(bufio.NewReader(bytes.NewReader(b)))
+			assert.Error(t, err)
+		})
+	}
+}
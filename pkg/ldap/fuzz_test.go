@@ -0,0 +1,82 @@
+//go:build go1.18
+// +build go1.18
+
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// FuzzReadPacket checks that any packet the decoder accepts from a server
+// encodes back to a packet it decodes identically
+func FuzzReadPacket(f *testing.F) {
+	f.Add(newSequence(
+		newInteger(tagInteger, 1),
+		newConstructed(classApplication, opSearchResultEntry,
+			newString("uid=user1,dc=example,dc=com"),
+			newSequence(newSequence(newString("mail"), newConstructed(classUniversal, tagSet, newString("user1@example.com")))),
+		),
+	).bytes())
+	f.Add(resultPacket(opBindResponse, 49).bytes())
+	f.Add([]byte{0x30, 0x84, 0x00, 0x00, 0x00, 0x03, 0x02, 0x01, 0x01})
+	f.Add([]byte{0x30, 0x03, 0x02, 0x05, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+		encoded := p.bytes()
+		decoded, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("re-encoded packet does not decode: %v", err)
+		}
+		if !bytes.Equal(encoded, decoded.bytes()) {
+			t.Fatalf("re-encoded packet decodes differently: %x != %x", encoded, decoded.bytes())
+		}
+		_, _ = p.int()
+	})
+}
+
+// FuzzParseFilter checks that the filters that compile encode to a packet
+// the decoder accepts, and that any value escaped with EscapeFilter is
+// matched literally
+func FuzzParseFilter(f *testing.F) {
+	for _, filter := range []string{
+		"(uid=user1)",
+		"(&(objectClass=person)(|(uid=user1)(mail=user1@example.com)))",
+		"(!(cn=\\2a\\28x\\29))",
+		"(mail=*)",
+		"(cn=a*b*c)",
+		"(uidNumber>=1000)",
+		"(cn~=jon)",
+		"(cn:dn:=x)",
+	} {
+		f.Add(filter)
+	}
+
+	f.Fuzz(func(t *testing.T, filter string) {
+		escaped, err := compileFilter("(uid=" + EscapeFilter(filter) + ")")
+		if err != nil {
+			t.Fatalf("escaped value %q does not compile: %v", filter, err)
+		}
+		if value := escaped.children[1].str(); value != filter {
+			t.Fatalf("escaped value %q compiles to %q", filter, value)
+		}
+
+		p, err := compileFilter(filter)
+		if err != nil {
+			return
+		}
+		encoded := p.bytes()
+		decoded, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("filter %q encodes to an invalid packet: %v", filter, err)
+		}
+		if !bytes.Equal(encoded, decoded.bytes()) {
+			t.Fatalf("filter %q decodes differently", filter)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer is an in-process stand-in for an LDAP server. Binds are checked
+// against the passwords and searches are answered with the entries listed
+// for their filter, compared in their encoded form.
+type testServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	passwords map[string]string
+	entries   map[string][]*Entry
+
+	mu       sync.Mutex
+	requests []*packet
+	wg       sync.WaitGroup
+}
+
+func newTestServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *testServer {
+	var listener net.Listener
+	var err error
+	if implicitTLS {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	assert.NoError(t, err)
+
+	s := &testServer{
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		passwords: map[string]string{},
+		entries:   map[string][]*Entry{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *testServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// receivedRequests returns the operations of the messages received so far
+func (s *testServer) receivedRequests() []*packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*packet{}, s.requests...)
+}
+
+func (s *testServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		message, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id := message.children[0]
+		op := message.children[1]
+		s.mu.Lock()
+		s.requests = append(s.requests, op)
+		s.mu.Unlock()
+
+		reply := func(response *packet) {
+			conn.Write(newSequence(id, response).bytes())
+		}
+		switch {
+		case op.is(classApplication, opBindRequest):
+			dn, password := op.children[1].str(), op.children[2].str()
+			code := int64(ResultSuccess)
+			if expected, ok := s.passwords[dn]; !ok || expected != password {
+				code = ResultInvalidCredentials
+			}
+			reply(resultPacket(opBindResponse, code))
+		case op.is(classApplication, opSearchRequest):
+			filter := string(op.children[6].bytes())
+			for f, entries := range s.entries {
+				compiled, err := compileFilter(f)
+				if err != nil || string(compiled.bytes()) != filter {
+					continue
+				}
+				for _, entry := range entries {
+					reply(entryPacket(entry))
+				}
+			}
+			reply(resultPacket(opSearchResultDone, ResultSuccess))
+		case op.is(classApplication, opExtendedRequest):
+			if s.tlsConfig == nil {
+				reply(resultPacket(opExtendedResponse, 2))
+				continue
+			}
+			reply(resultPacket(opExtendedResponse, ResultSuccess))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(tlsConn)
+		case op.is(classApplication, opUnbindRequest):
+			return
+		}
+	}
+}
+
+func resultPacket(tag int, code int64) *packet {
+	return newConstructed(classApplication, tag,
+		newInteger(tagEnumerated, code),
+		newString(""),
+		newString(""),
+	)
+}
+
+func entryPacket(entry *Entry) *packet {
+	attributes := newSequence()
+	for name, values := range entry.Attributes {
+		set := newConstructed(classUniversal, tagSet)
+		for _, value := range values {
+			set.children = append(set.children, newString(value))
+		}
+		attributes.children = append(attributes.children, newSequence(newString(name), set))
+	}
+	return newConstructed(classApplication, opSearchResultEntry, newString(entry.DN), attributes)
+}
@@ -59,11 +59,11 @@ func getBasicSession(validator basic.Validator, req *http.Request) (*sessionsapi
 	}
 
 	if validator.Validate(user, password) {
-		logger.PrintAuthf(user, req, logger.AuthSuccess, "Authenticated via basic auth and HTpasswd File")
+		logger.PrintAuthf(user, req, logger.AuthSuccess, "Authenticated via basic auth")
 		return &sessionsapi.SessionState{User: user}, nil
 	}
 
-	logger.PrintAuthf(user, req, logger.AuthFailure, "Invalid authentication via basic auth: not in Htpasswd File")
+	logger.PrintAuthf(user, req, logger.AuthFailure, "Invalid authentication via basic auth")
 	return nil, nil
 }
 
@@ -0,0 +1,294 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// NewClientCertSessionLoader creates a new clientCertSessionLoader which
+// loads sessions from the verified client certificate of the request.
+// Certificates of TLS connections are verified in the handshake, forwarded
+// certificates are verified with the roots and only read from the
+// connections of the trusted proxies.
+// If a session was loaded by a previous handler, it will not be replaced.
+func NewClientCertSessionLoader(opts options.ClientTLS, roots *x509.CertPool) alice.Constructor {
+	trustedProxies := ip.NewNetSet()
+	for _, ipStr := range opts.TrustedProxies {
+		if ipNet := ip.ParseIPNet(ipStr); ipNet != nil {
+			trustedProxies.AddIPNet(*ipNet)
+		}
+	}
+
+	cl := &clientCertSessionLoader{
+		roots:          roots,
+		userField:      opts.UserField,
+		header:         opts.Header,
+		headerFormat:   opts.HeaderFormat,
+		trustedProxies: trustedProxies,
+	}
+	return cl.loadSession
+}
+
+// clientCertSessionLoader is responsible for loading sessions from X.509
+// client certificates.
+type clientCertSessionLoader struct {
+	roots          *x509.CertPool
+	userField      string
+	header         string
+	headerFormat   string
+	trustedProxies *ip.NetSet
+}
+
+// loadSession attempts to load a session from the client certificate of the
+// request.
+// If no certificate was presented, or it is invalid, no session will be
+// loaded and the request will be passed to the next handler.
+// If a session was loaded by a previous handler, it will not be replaced.
+// The certificate header is removed so that upstreams never receive a
+// certificate that was not verified.
+func (c *clientCertSessionLoader) loadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := GetRequestScope(req)
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		if scope.Session != nil {
+			// The session was already loaded, pass to the next handler
+			c.stripHeader(req)
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		session, err := c.getClientCertSession(req)
+		if err != nil {
+			logger.Printf("Error retrieving session from client certificate: %v", err)
+		}
+		c.stripHeader(req)
+
+		// Add the session to the scope if it was found
+		scope.Session = session
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// getClientCertSession creates a session for the verified client certificate
+// of the request, if any
+func (c *clientCertSessionLoader) getClientCertSession(req *http.Request) (*sessionsapi.SessionState, error) {
+	cert, err := c.findClientCert(req)
+	if err != nil || cert == nil {
+		return nil, err
+	}
+
+	user, err := c.certUser(cert)
+	if err != nil {
+		return nil, err
+	}
+	email := user
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	}
+
+	logger.PrintAuthf(user, req, logger.AuthSuccess, "Authenticated via client certificate")
+	expires := cert.NotAfter
+	return &sessionsapi.SessionState{
+		User:      user,
+		Email:     email,
+		ExpiresOn: &expires,
+	}, nil
+}
+
+// findClientCert returns the verified client certificate of the TLS
+// connection, or of the forwarded header if configured
+func (c *clientCertSessionLoader) findClientCert(req *http.Request) (*x509.Certificate, error) {
+	if c.header == "" {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			return nil, nil
+		}
+		return req.TLS.VerifiedChains[0][0], nil
+	}
+
+	value := req.Header.Get(c.header)
+	if value == "" {
+		return nil, nil
+	}
+	if !c.fromTrustedProxy(req) {
+		return nil, fmt.Errorf("ignoring the %s header from %s, which is not a trusted proxy", c.header, req.RemoteAddr)
+	}
+	var certs []*x509.Certificate
+	var err error
+	switch c.headerFormat {
+	case options.XFCCClientCertHeader:
+		certs, err = parseXFCC(value)
+	default:
+		certs, err = parseEscapedPEM(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %v", c.header, err)
+	}
+	// CA certificates without extended key usages are valid for client auth,
+	// but are not client certificates
+	if certs[0].IsCA {
+		return nil, fmt.Errorf("invalid client certificate in %s header: %q is a CA certificate", c.header, certs[0].Subject)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate in %s header: %v", c.header, err)
+	}
+	return certs[0], nil
+}
+
+// fromTrustedProxy checks the request was sent by a trusted proxy. The address
+// of the connection is used, as headers naming the client can be forged.
+func (c *clientCertSessionLoader) fromTrustedProxy(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	remoteIP := net.ParseIP(host)
+	return remoteIP != nil && c.trustedProxies.Has(remoteIP)
+}
+
+// stripHeader removes the certificate header from the request, if certificates
+// are read from a header
+func (c *clientCertSessionLoader) stripHeader(req *http.Request) {
+	if c.header != "" {
+		req.Header.Del(c.header)
+	}
+}
+
+// certUser returns the configured field of the certificate
+func (c *clientCertSessionLoader) certUser(cert *x509.Certificate) (string, error) {
+	switch c.userField {
+	case options.ClientCertUserEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0], nil
+		}
+	case options.ClientCertUserURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String(), nil
+		}
+	default:
+		if cert.Subject.CommonName != "" {
+			return cert.Subject.CommonName, nil
+		}
+	}
+	return "", fmt.Errorf("client certificate %q has no %s", cert.Subject, c.userField)
+}
+
+// parseEscapedPEM decodes the certificates of a URL encoded PEM value
+func parseEscapedPEM(value string) ([]*x509.Certificate, error) {
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	rest := []byte(unescaped)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// parseXFCC decodes the certificates of the last element of an
+// X-Forwarded-Client-Cert header, which the closest proxy added for its
+// client
+func parseXFCC(value string) ([]*x509.Certificate, error) {
+	elements := splitXFCC(value, ',')
+	pairs := splitXFCC(elements[len(elements)-1], ';')
+
+	var cert, chain string
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid key value pair %q", pair)
+		}
+		value := kv[1]
+		if strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) && len(value) > 1 {
+			value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "cert":
+			cert = value
+		case "chain":
+			chain = value
+		}
+	}
+	if cert == "" && chain == "" {
+		return nil, errors.New("no Cert or Chain in the last element")
+	}
+
+	// The Chain starts with the client certificate
+	var certs []*x509.Certificate
+	if chain != "" {
+		chainCerts, err := parseEscapedPEM(chain)
+		if err != nil {
+			return nil, err
+		}
+		certs = chainCerts
+	}
+	if cert != "" {
+		leaf, err := parseEscapedPEM(cert)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(leaf[:1], certs...)
+	}
+	return certs, nil
+}
+
+// splitXFCC splits the value on the separator outside of quotes
+func splitXFCC(value string, sep rune) []string {
+	var parts []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
@@ -0,0 +1,306 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// testCertificate is a certificate with its PEM encoding and private key
+type testCertificate struct {
+	cert *x509.Certificate
+	pem  string
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate creates a certificate from the template, signed by the
+// parent or self-signed if the parent is nil
+func newTestCertificate(template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour).Truncate(time.Second)
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &testCertificate{
+		cert: cert,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		key:  key,
+	}
+}
+
+func newTestCA(name string, parent *testCertificate) *testCertificate {
+	return newTestCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, parent)
+}
+
+var _ = Describe("Client Certificate Session Suite", func() {
+	var rootCA, intermediateCA, otherCA *testCertificate
+	var clientCert, intermediateClientCert, untrustedClientCert *testCertificate
+	var roots *x509.CertPool
+	var opts options.ClientTLS
+
+	spiffeID, _ := url.Parse("spiffe://example.com/ns/default/sa/client")
+
+	BeforeEach(func() {
+		rootCA = newTestCA("Root CA", nil)
+		intermediateCA = newTestCA("Intermediate CA", rootCA)
+		otherCA = newTestCA("Other CA", nil)
+
+		clientTemplate := func() *x509.Certificate {
+			return &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "client.example.com"},
+				EmailAddresses: []string{"client@example.com"},
+				URIs:           []*url.URL{spiffeID},
+				KeyUsage:       x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}
+		}
+		clientCert = newTestCertificate(clientTemplate(), rootCA)
+		intermediateClientCert = newTestCertificate(clientTemplate(), intermediateCA)
+		untrustedClientCert = newTestCertificate(clientTemplate(), otherCA)
+
+		roots = x509.NewCertPool()
+		roots.AddCert(rootCA.cert)
+
+		// httptest requests are sent from 192.0.2.1
+		opts = options.ClientTLS{
+			UserField:      options.ClientCertUserCommonName,
+			HeaderFormat:   options.PEMClientCertHeader,
+			TrustedProxies: []string{"192.0.2.0/24"},
+		}
+	})
+
+	loadSession := func(req *http.Request, existingSession *sessionsapi.SessionState) *sessionsapi.SessionState {
+		scope := &middlewareapi.RequestScope{
+			Session: existingSession,
+		}
+		req = req.WithContext(context.WithValue(req.Context(), requestScopeKey, scope))
+
+		handler := NewClientCertSessionLoader(opts, roots)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return scope.Session
+	}
+
+	expectedSession := func(user, email string, cert *testCertificate) *sessionsapi.SessionState {
+		expires := cert.cert.NotAfter
+		return &sessionsapi.SessionState{
+			User:      user,
+			Email:     email,
+			ExpiresOn: &expires,
+		}
+	}
+
+	Context("with the certificate of the TLS connection", func() {
+		newTLSRequest := func(cert *testCertificate) *http.Request {
+			req := httptest.NewRequest("", "https://proxy.example.com/", nil)
+			req.TLS = &tls.ConnectionState{}
+			if cert != nil {
+				req.TLS.PeerCertificates = []*x509.Certificate{cert.cert}
+				req.TLS.VerifiedChains = [][]*x509.Certificate{{cert.cert, rootCA.cert}}
+			}
+			return req
+		}
+
+		It("loads a session with the common name", func() {
+			session := loadSession(newTLSRequest(clientCert), nil)
+			Expect(session).To(Equal(expectedSession("client.example.com", "client@example.com", clientCert)))
+		})
+
+		It("loads a session with the email address", func() {
+			opts.UserField = options.ClientCertUserEmail
+			session := loadSession(newTLSRequest(clientCert), nil)
+			Expect(session).To(Equal(expectedSession("client@example.com", "client@example.com", clientCert)))
+		})
+
+		It("loads a session with the URI", func() {
+			opts.UserField = options.ClientCertUserURI
+			session := loadSession(newTLSRequest(clientCert), nil)
+			Expect(session).To(Equal(expectedSession(spiffeID.String(), "client@example.com", clientCert)))
+		})
+
+		It("uses the user as the email without an email address", func() {
+			cert := newTestCertificate(&x509.Certificate{
+				Subject:     pkix.Name{CommonName: "service"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, rootCA)
+			session := loadSession(newTLSRequest(cert), nil)
+			Expect(session).To(Equal(expectedSession("service", "service", cert)))
+		})
+
+		It("does not load a session without the user field", func() {
+			cert := newTestCertificate(&x509.Certificate{
+				Subject:     pkix.Name{CommonName: "service"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, rootCA)
+			opts.UserField = options.ClientCertUserURI
+			Expect(loadSession(newTLSRequest(cert), nil)).To(BeNil())
+		})
+
+		It("does not load a session without a verified certificate", func() {
+			Expect(loadSession(newTLSRequest(nil), nil)).To(BeNil())
+			Expect(loadSession(httptest.NewRequest("", "/", nil), nil)).To(BeNil())
+		})
+
+		It("does not replace an existing session", func() {
+			existing := &sessionsapi.SessionState{User: "user"}
+			Expect(loadSession(newTLSRequest(clientCert), existing)).To(Equal(existing))
+		})
+
+		It("ignores the certificate header", func() {
+			req := httptest.NewRequest("", "/", nil)
+			req.Header.Set("X-Client-Cert", url.PathEscape(clientCert.pem))
+			Expect(loadSession(req, nil)).To(BeNil())
+		})
+	})
+
+	Context("with a PEM certificate header", func() {
+		BeforeEach(func() {
+			opts.Header = "X-Client-Cert"
+		})
+
+		newHeaderRequest := func(value string) *http.Request {
+			req := httptest.NewRequest("", "/", nil)
+			req.Header.Set("X-Client-Cert", value)
+			return req
+		}
+
+		It("loads a session from an escaped certificate", func() {
+			session := loadSession(newHeaderRequest(url.PathEscape(clientCert.pem)), nil)
+			Expect(session).To(Equal(expectedSession("client.example.com", "client@example.com", clientCert)))
+		})
+
+		It("loads a session from a certificate followed by its intermediate", func() {
+			value := url.PathEscape(intermediateClientCert.pem + intermediateCA.pem)
+			session := loadSession(newHeaderRequest(value), nil)
+			Expect(session).To(Equal(expectedSession("client.example.com", "client@example.com", intermediateClientCert)))
+		})
+
+		It("does not load a session without the intermediate", func() {
+			value := url.PathEscape(intermediateClientCert.pem)
+			Expect(loadSession(newHeaderRequest(value), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an untrusted certificate", func() {
+			value := url.PathEscape(untrustedClientCert.pem)
+			Expect(loadSession(newHeaderRequest(value), nil)).To(BeNil())
+		})
+
+		It("does not load a session from a CA certificate", func() {
+			value := url.PathEscape(rootCA.pem)
+			Expect(loadSession(newHeaderRequest(value), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an invalid header", func() {
+			Expect(loadSession(newHeaderRequest("not a certificate"), nil)).To(BeNil())
+			Expect(loadSession(newHeaderRequest("%zz"), nil)).To(BeNil())
+		})
+
+		It("ignores the certificate of the TLS connection", func() {
+			req := httptest.NewRequest("", "https://proxy.example.com/", nil)
+			req.TLS = &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{clientCert.cert, rootCA.cert}},
+			}
+			Expect(loadSession(req, nil)).To(BeNil())
+		})
+
+		It("ignores the header from a peer that is not a trusted proxy", func() {
+			req := newHeaderRequest(url.PathEscape(clientCert.pem))
+			req.RemoteAddr = "198.51.100.7:1234"
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			Expect(loadSession(req, nil)).To(BeNil())
+		})
+
+		It("removes the header before the request is proxied", func() {
+			req := newHeaderRequest(url.PathEscape(clientCert.pem))
+			Expect(loadSession(req, nil)).ToNot(BeNil())
+			Expect(req.Header.Get("X-Client-Cert")).To(BeEmpty())
+
+			req = newHeaderRequest(url.PathEscape(clientCert.pem))
+			req.RemoteAddr = "198.51.100.7:1234"
+			Expect(loadSession(req, nil)).To(BeNil())
+			Expect(req.Header.Get("X-Client-Cert")).To(BeEmpty())
+
+			req = newHeaderRequest(url.PathEscape(clientCert.pem))
+			existing := &sessionsapi.SessionState{User: "existing"}
+			Expect(loadSession(req, existing)).To(Equal(existing))
+			Expect(req.Header.Get("X-Client-Cert")).To(BeEmpty())
+		})
+	})
+
+	Context("with an XFCC header", func() {
+		BeforeEach(func() {
+			opts.Header = "X-Forwarded-Client-Cert"
+			opts.HeaderFormat = options.XFCCClientCertHeader
+		})
+
+		newXFCCRequest := func(value string) *http.Request {
+			req := httptest.NewRequest("", "/", nil)
+			req.Header.Set("X-Forwarded-Client-Cert", value)
+			return req
+		}
+
+		It("loads a session from the Cert", func() {
+			value := `By=spiffe://example.com/proxy;Hash=abcdef;Cert="` + url.PathEscape(clientCert.pem) +
+				`";Subject="CN=client.example.com,O=\"Example, Inc\"";URI=` + spiffeID.String()
+			session := loadSession(newXFCCRequest(value), nil)
+			Expect(session).To(Equal(expectedSession("client.example.com", "client@example.com", clientCert)))
+		})
+
+		It("loads a session from the Chain", func() {
+			value := `Hash=abcdef;Chain="` + url.PathEscape(intermediateClientCert.pem+intermediateCA.pem) + `"`
+			session := loadSession(newXFCCRequest(value), nil)
+			Expect(session).To(Equal(expectedSession("client.example.com", "client@example.com", intermediateClientCert)))
+		})
+
+		It("uses the element added by the closest proxy", func() {
+			value := `Cert="` + url.PathEscape(untrustedClientCert.pem) + `",Cert="` + url.PathEscape(clientCert.pem) + `"`
+			session := loadSession(newXFCCRequest(value), nil)
+			Expect(session).To(Equal(expectedSession("client.example.com", "client@example.com", clientCert)))
+
+			value = `Cert="` + url.PathEscape(clientCert.pem) + `",Cert="` + url.PathEscape(untrustedClientCert.pem) + `"`
+			Expect(loadSession(newXFCCRequest(value), nil)).To(BeNil())
+		})
+
+		It("does not load a session without a Cert or Chain", func() {
+			value := `By=spiffe://example.com/proxy;Hash=abcdef;Subject="CN=client.example.com";URI=` + spiffeID.String()
+			Expect(loadSession(newXFCCRequest(value), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an untrusted certificate", func() {
+			value := `Cert="` + url.PathEscape(untrustedClientCert.pem) + `"`
+			Expect(loadSession(newXFCCRequest(value), nil)).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,364 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
+)
+
+// IntrospectionSessionLoaderOptions contains all of the requirements to
+// construct an introspection session loader.
+type IntrospectionSessionLoaderOptions struct {
+	// IntrospectionURL is the token introspection endpoint of the provider
+	IntrospectionURL string
+
+	// ClientID and GetClientSecret are the client credentials the proxy
+	// authenticates with to the introspection endpoint
+	ClientID        string
+	GetClientSecret func() (string, error)
+
+	// RequiredScopes are the scopes tokens must all have
+	RequiredScopes []string
+
+	// Audiences are the audiences tokens must have one of. Any audience is
+	// accepted when empty.
+	Audiences []string
+
+	// CacheTTL is how long introspection results are cached, active tokens
+	// are never cached past their expiry. 0 disables the cache.
+	CacheTTL time.Duration
+
+	// CacheMaxEntries is the most results cached, the least recently used
+	// results are evicted first
+	CacheMaxEntries int
+}
+
+// NewIntrospectionSessionLoader creates a new introspectionSessionLoader which
+// loads sessions from bearer tokens in Authorization headers, asking the
+// provider whether they are active with token introspection (RFC 7662).
+// If a session was loaded by a previous handler, it will not be replaced.
+func NewIntrospectionSessionLoader(opts *IntrospectionSessionLoaderOptions) alice.Constructor {
+	il := &introspectionSessionLoader{
+		introspectionURL: opts.IntrospectionURL,
+		clientID:         opts.ClientID,
+		getClientSecret:  opts.GetClientSecret,
+		requiredScopes:   opts.RequiredScopes,
+		audiences:        opts.Audiences,
+		cache:            newIntrospectionCache(opts.CacheTTL, opts.CacheMaxEntries),
+	}
+	return il.loadSession
+}
+
+// introspectionSessionLoader is responsible for loading sessions from opaque
+// bearer tokens in Authorization headers.
+type introspectionSessionLoader struct {
+	introspectionURL string
+	clientID         string
+	getClientSecret  func() (string, error)
+	requiredScopes   []string
+	audiences        []string
+	cache            *introspectionCache
+}
+
+// introspectionResponse is the part of an introspection response used to
+// check the token and build its session
+type introspectionResponse struct {
+	Active   bool                  `json:"active"`
+	Scope    string                `json:"scope"`
+	Audience introspectionAudience `json:"aud"`
+	Subject  string                `json:"sub"`
+	Username string                `json:"username"`
+	Email    string                `json:"email"`
+	Expiry   int64                 `json:"exp"`
+}
+
+// introspectionAudience is a single audience or a list of audiences
+type introspectionAudience []string
+
+func (a *introspectionAudience) UnmarshalJSON(b []byte) error {
+	var audience string
+	if err := json.Unmarshal(b, &audience); err == nil {
+		*a = introspectionAudience{audience}
+		return nil
+	}
+	var audiences []string
+	if err := json.Unmarshal(b, &audiences); err != nil {
+		return fmt.Errorf("invalid aud: %v", err)
+	}
+	*a = audiences
+	return nil
+}
+
+// loadSession attempts to load a session from a bearer token stored in an
+// Authorization header within the request.
+// If no bearer token is found, or the token is not active, no session will be
+// loaded and the request will be passed to the next handler.
+// If a session was loaded by a previous handler, it will not be replaced.
+func (i *introspectionSessionLoader) loadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := GetRequestScope(req)
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		if scope.Session != nil {
+			// The session was already loaded, pass to the next handler
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		session, err := i.getIntrospectedSession(req)
+		if err != nil {
+			logger.Printf("Error introspecting token in Authorization header: %v", err)
+		}
+
+		// Add the session to the scope if it was found
+		scope.Session = session
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// getIntrospectedSession loads a session for the bearer token in the
+// Authorization header, if any, from the cache or the introspection endpoint
+func (i *introspectionSessionLoader) getIntrospectedSession(req *http.Request) (*sessionsapi.SessionState, error) {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		// No auth header provided, so don't attempt to load a session
+		return nil, nil
+	}
+	tokenType, token, err := splitAuthHeader(auth)
+	if err != nil || tokenType != "Bearer" {
+		// Other loaders handle other types of credentials
+		return nil, nil
+	}
+
+	key := introspectionCacheKey(token)
+	if session, ok := i.cache.get(key); ok {
+		if session == nil {
+			return nil, nil
+		}
+		// Each request gets its own copy of the session
+		copied := *session
+		return &copied, nil
+	}
+
+	response, err := i.introspect(req.Context(), token)
+	if err != nil {
+		// Failures to introspect are not cached, the token may be active
+		return nil, err
+	}
+	session, err := i.sessionFromResponse(token, response)
+	if err != nil {
+		i.cache.set(key, nil, time.Time{})
+		return nil, err
+	}
+	var expiry time.Time
+	if session.ExpiresOn != nil {
+		expiry = *session.ExpiresOn
+	}
+	i.cache.set(key, session, expiry)
+
+	copied := *session
+	return &copied, nil
+}
+
+// introspect asks the introspection endpoint about the token, authenticating
+// with the client credentials
+func (i *introspectionSessionLoader) introspect(ctx context.Context, token string) (*introspectionResponse, error) {
+	clientSecret, err := i.getClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the client secret: %v", err)
+	}
+
+	params := url.Values{}
+	params.Add("token", token)
+	params.Add("token_type_hint", "access_token")
+
+	// The client credentials are form encoded before being used for basic
+	// auth (RFC 6749, Section 2.3.1)
+	credentials := url.QueryEscape(i.clientID) + ":" + url.QueryEscape(clientSecret)
+
+	var response introspectionResponse
+	err = requests.New(i.introspectionURL).
+		WithContext(ctx).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SetHeader("Accept", "application/json").
+		SetHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials))).
+		Do().
+		UnmarshalInto(&response)
+	if err != nil {
+		return nil, fmt.Errorf("error calling the introspection endpoint: %v", err)
+	}
+	return &response, nil
+}
+
+// sessionFromResponse checks the token is active with the required scopes and
+// audience and creates a session from the introspection response
+func (i *introspectionSessionLoader) sessionFromResponse(token string, response *introspectionResponse) (*sessionsapi.SessionState, error) {
+	if !response.Active {
+		return nil, errors.New("token is not active")
+	}
+	if response.Expiry != 0 && !time.Now().Before(time.Unix(response.Expiry, 0)) {
+		return nil, errors.New("token has expired")
+	}
+
+	scopes := strings.Fields(response.Scope)
+	for _, required := range i.requiredScopes {
+		if !contains(scopes, required) {
+			return nil, fmt.Errorf("token does not have the required scope %q", required)
+		}
+	}
+	if len(i.audiences) > 0 && !containsAny(response.Audience, i.audiences) {
+		return nil, fmt.Errorf("token audience %q is not allowed", []string(response.Audience))
+	}
+
+	user := response.Subject
+	if user == "" {
+		user = response.Username
+	}
+	if user == "" {
+		return nil, errors.New("introspection response has no sub or username")
+	}
+	email := response.Email
+	if email == "" {
+		email = user
+	}
+
+	session := &sessionsapi.SessionState{
+		Email:             email,
+		User:              user,
+		PreferredUsername: response.Username,
+		AccessToken:       token,
+	}
+	if response.Expiry != 0 {
+		expires := time.Unix(response.Expiry, 0)
+		session.ExpiresOn = &expires
+	}
+	return session, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if contains(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// introspectionCacheKey is a digest of the token, so that the cache does not
+// hold the tokens themselves
+func introspectionCacheKey(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return string(digest[:])
+}
+
+// introspectionCache caches introspection results by token. Active tokens are
+// cached with their session, inactive tokens without.
+type introspectionCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	// now returns the current time, it allows tests to fast forward time
+	now func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// introspectionCacheEntry is a cached result with its expiry time
+type introspectionCacheEntry struct {
+	key     string
+	session *sessionsapi.SessionState
+	expires time.Time
+}
+
+func newIntrospectionCache(ttl time.Duration, maxEntries int) *introspectionCache {
+	return &introspectionCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// get returns the cached session for the key, which is nil for inactive
+// tokens. The second value reports whether the key was cached.
+func (c *introspectionCache) get(key string) (*sessionsapi.SessionState, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*introspectionCacheEntry)
+	if !c.now().Before(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e.session, true
+}
+
+// set caches the session for the key for the TTL, but not past the expiry of
+// the token if it is set
+func (c *introspectionCache) set(key string, session *sessionsapi.SessionState, tokenExpiry time.Time) {
+	if c.ttl <= 0 || c.maxEntries < 1 {
+		return
+	}
+	expires := c.now().Add(c.ttl)
+	if !tokenExpiry.IsZero() && tokenExpiry.Before(expires) {
+		expires = tokenExpiry
+	}
+	e := &introspectionCacheEntry{
+		key:     key,
+		session: session,
+		expires: expires,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove deletes an element from the cache. The lock must be held.
+func (c *introspectionCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*introspectionCacheEntry).key)
+}
@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Introspection Session Suite", func() {
+	const (
+		clientID     = "client-id"
+		clientSecret = "client/secret"
+		activeToken  = "active-token"
+	)
+
+	var expiry = time.Now().Add(time.Hour).Truncate(time.Second)
+
+	var server *httptest.Server
+	var responses map[string]map[string]interface{}
+	var introspections int
+	var opts *IntrospectionSessionLoaderOptions
+
+	BeforeEach(func() {
+		introspections = 0
+		responses = map[string]map[string]interface{}{
+			activeToken: {
+				"active":   true,
+				"scope":    "openid api:read api:write",
+				"aud":      []string{"https://api.example.com", "other"},
+				"sub":      "1234567890",
+				"username": "john",
+				"email":    "john@example.com",
+				"exp":      expiry.Unix(),
+			},
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			introspections++
+			user, password, ok := req.BasicAuth()
+			if !ok || user != clientID || password != "client%2Fsecret" || req.Method != "POST" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if err := req.ParseForm(); err != nil || req.PostForm.Get("token_type_hint") != "access_token" {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			response, ok := responses[req.PostForm.Get("token")]
+			if !ok {
+				response = map[string]interface{}{"active": false}
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(rw).Encode(response)
+		}))
+
+		opts = &IntrospectionSessionLoaderOptions{
+			IntrospectionURL: server.URL,
+			ClientID:         clientID,
+			GetClientSecret:  func() (string, error) { return clientSecret, nil },
+			CacheTTL:         time.Minute,
+			CacheMaxEntries:  10,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	loadSession := func(handler http.Handler, authorizationHeader string, existingSession *sessionsapi.SessionState) *sessionsapi.SessionState {
+		scope := &middlewareapi.RequestScope{
+			Session: existingSession,
+		}
+		req := httptest.NewRequest("", "/", nil)
+		req.Header.Set("Authorization", authorizationHeader)
+		req = req.WithContext(context.WithValue(req.Context(), requestScopeKey, scope))
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return scope.Session
+	}
+
+	newHandler := func() http.Handler {
+		return NewIntrospectionSessionLoader(opts)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	}
+
+	Context("with an active token", func() {
+		It("loads a session from the introspection response", func() {
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).To(Equal(&sessionsapi.SessionState{
+				Email:             "john@example.com",
+				User:              "1234567890",
+				PreferredUsername: "john",
+				AccessToken:       activeToken,
+				ExpiresOn:         &expiry,
+			}))
+		})
+
+		It("falls back to the username without a subject or email", func() {
+			delete(responses[activeToken], "sub")
+			delete(responses[activeToken], "email")
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session.User).To(Equal("john"))
+			Expect(session.Email).To(Equal("john"))
+		})
+
+		It("does not replace an existing session", func() {
+			existing := &sessionsapi.SessionState{User: "user"}
+			session := loadSession(newHandler(), "Bearer "+activeToken, existing)
+			Expect(session).To(Equal(existing))
+			Expect(introspections).To(Equal(0))
+		})
+
+		It("accepts tokens with the required scopes and audience", func() {
+			opts.RequiredScopes = []string{"api:read", "api:write"}
+			opts.Audiences = []string{"https://api.example.com"}
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).ToNot(BeNil())
+		})
+
+		It("accepts a single audience", func() {
+			responses[activeToken]["aud"] = "https://api.example.com"
+			opts.Audiences = []string{"https://api.example.com"}
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).ToNot(BeNil())
+		})
+
+		It("rejects tokens without a required scope", func() {
+			opts.RequiredScopes = []string{"api:read", "api:admin"}
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).To(BeNil())
+		})
+
+		It("rejects tokens for another audience", func() {
+			opts.Audiences = []string{"https://admin.example.com"}
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).To(BeNil())
+		})
+
+		It("rejects expired tokens", func() {
+			responses[activeToken]["exp"] = time.Now().Add(-time.Minute).Unix()
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).To(BeNil())
+		})
+	})
+
+	Context("without an active token", func() {
+		It("does not load a session for inactive tokens", func() {
+			session := loadSession(newHandler(), "Bearer inactive-token", nil)
+			Expect(session).To(BeNil())
+			Expect(introspections).To(Equal(1))
+		})
+
+		It("does not load a session when the client credentials are rejected", func() {
+			opts.GetClientSecret = func() (string, error) { return "wrong", nil }
+			session := loadSession(newHandler(), "Bearer "+activeToken, nil)
+			Expect(session).To(BeNil())
+		})
+
+		It("ignores other authorization headers", func() {
+			handler := newHandler()
+			Expect(loadSession(handler, "", nil)).To(BeNil())
+			Expect(loadSession(handler, "Basic dXNlcjpwYXNzd29yZA==", nil)).To(BeNil())
+			Expect(loadSession(handler, "Bearer", nil)).To(BeNil())
+			Expect(introspections).To(Equal(0))
+		})
+	})
+
+	Context("with the cache", func() {
+		It("caches active tokens", func() {
+			handler := newHandler()
+			first := loadSession(handler, "Bearer "+activeToken, nil)
+			second := loadSession(handler, "Bearer "+activeToken, nil)
+			Expect(second).To(Equal(first))
+			Expect(introspections).To(Equal(1))
+
+			// Sessions loaded from the cache are copies
+			second.User = "changed"
+			third := loadSession(handler, "Bearer "+activeToken, nil)
+			Expect(third.User).To(Equal("1234567890"))
+		})
+
+		It("caches inactive tokens", func() {
+			handler := newHandler()
+			Expect(loadSession(handler, "Bearer inactive-token", nil)).To(BeNil())
+			Expect(loadSession(handler, "Bearer inactive-token", nil)).To(BeNil())
+			Expect(introspections).To(Equal(1))
+		})
+
+		It("does not cache failed introspections", func() {
+			secret := "wrong"
+			opts.GetClientSecret = func() (string, error) { return secret, nil }
+			handler := newHandler()
+			Expect(loadSession(handler, "Bearer "+activeToken, nil)).To(BeNil())
+
+			secret = clientSecret
+			Expect(loadSession(handler, "Bearer "+activeToken, nil)).ToNot(BeNil())
+			Expect(introspections).To(Equal(2))
+		})
+
+		It("does not cache when the TTL is 0", func() {
+			opts.CacheTTL = 0
+			handler := newHandler()
+			loadSession(handler, "Bearer "+activeToken, nil)
+			loadSession(handler, "Bearer "+activeToken, nil)
+			Expect(introspections).To(Equal(2))
+		})
+	})
+
+	Context("introspectionCache", func() {
+		var cache *introspectionCache
+		var now time.Time
+		session := &sessionsapi.SessionState{User: "user"}
+
+		BeforeEach(func() {
+			now = time.Now()
+			cache = newIntrospectionCache(time.Minute, 2)
+			cache.now = func() time.Time { return now }
+		})
+
+		It("expires entries after the TTL", func() {
+			cache.set("key", session, time.Time{})
+			now = now.Add(59 * time.Second)
+			cached, ok := cache.get("key")
+			Expect(ok).To(BeTrue())
+			Expect(cached).To(Equal(session))
+
+			now = now.Add(time.Second)
+			_, ok = cache.get("key")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("expires entries with the token", func() {
+			cache.set("key", session, now.Add(10*time.Second))
+			now = now.Add(10 * time.Second)
+			_, ok := cache.get("key")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("evicts the least recently used entries", func() {
+			cache.set("key1", session, time.Time{})
+			cache.set("key2", nil, time.Time{})
+			_, ok := cache.get("key1")
+			Expect(ok).To(BeTrue())
+
+			cache.set("key3", session, time.Time{})
+			_, ok = cache.get("key2")
+			Expect(ok).To(BeFalse())
+			_, ok = cache.get("key1")
+			Expect(ok).To(BeTrue())
+			cached, ok := cache.get("key3")
+			Expect(ok).To(BeTrue())
+			Expect(cached).To(Equal(session))
+		})
+	})
+})
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// refreshResult is the outcome of refreshing a session
+type refreshResult struct {
+	session   *sessionsapi.SessionState
+	refreshed bool
+	err       error
+}
+
+// refreshResultGracePeriod is how long the result of a successful refresh is
+// shared with requests that still carry the session it refreshed, such as
+// requests the browser sent before it received the refreshed session
+const refreshResultGracePeriod = 10 * time.Second
+
+// refreshCall is a session refresh in progress, or one that finished within
+// the grace period
+type refreshCall struct {
+	done   chan struct{}
+	result refreshResult
+}
+
+// refreshGroup ensures a session is only refreshed once within the process,
+// until the grace period of a successful refresh is over. The zero value is
+// ready to use.
+type refreshGroup struct {
+	mutex sync.Mutex
+	calls map[string]*refreshCall
+}
+
+// do runs the refresh for the first caller with the key. Callers with the same
+// key while the refresh is running wait for it and share its result, as do
+// callers within the grace period after it succeeded. Failed refreshes are
+// forgotten straight away so that the next caller retries.
+func (g *refreshGroup) do(key string, refresh func() refreshResult) (result refreshResult, shared bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*refreshCall{}
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		<-call.done
+		return call.result, true
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			g.forget(key, call)
+		} else {
+			time.AfterFunc(refreshResultGracePeriod, func() { g.forget(key, call) })
+		}
+		close(call.done)
+	}()
+
+	call.result = refresh()
+	succeeded = call.result.err == nil
+	return call.result, false
+}
+
+// forget removes the call for the key, unless it was replaced by a later call
+func (g *refreshGroup) forget(key string, call *refreshCall) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// sessionKey identifies a session by its user and tokens, so that concurrent
+// requests carrying the same session have the same key
+func sessionKey(session *sessionsapi.SessionState) string {
+	h := sha256.New()
+	for _, value := range []string{session.Email, session.User, session.AccessToken, session.IDToken, session.RefreshToken} {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	if session.CreatedAt != nil {
+		h.Write([]byte(session.CreatedAt.String()))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sessionUpdated checks whether the stored session has different tokens to
+// the session loaded at the start of the request, meaning it was refreshed
+func sessionUpdated(loaded, stored *sessionsapi.SessionState) bool {
+	return stored.AccessToken != loaded.AccessToken ||
+		stored.IDToken != loaded.IDToken ||
+		stored.RefreshToken != loaded.RefreshToken
+}
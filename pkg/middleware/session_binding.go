@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
+)
+
+// SessionBinder binds sessions to a fingerprint of the client they were
+// created by, so that a stolen session cookie can't be used by another
+// client.
+type SessionBinder struct {
+	ipBinding          string
+	userAgent          bool
+	realClientIPParser ipapi.RealClientIPParser
+}
+
+// NewSessionBinder creates a SessionBinder from the binding options. The
+// real client IP parser may be nil, in which case the remote address of the
+// request is used. It returns nil when sessions are not bound.
+func NewSessionBinder(opts options.SessionBindingOptions, realClientIPParser ipapi.RealClientIPParser) *SessionBinder {
+	if opts.IP == "" && !opts.UserAgent {
+		return nil
+	}
+	return &SessionBinder{
+		ipBinding:          opts.IP,
+		userAgent:          opts.UserAgent,
+		realClientIPParser: realClientIPParser,
+	}
+}
+
+// Bind records the fingerprint of the client of the request in the session
+func (b *SessionBinder) Bind(req *http.Request, s *sessionsapi.SessionState) error {
+	fingerprint, err := b.fingerprint(req)
+	if err != nil {
+		return err
+	}
+	s.Binding = fingerprint
+	return nil
+}
+
+// Verify checks that the session was bound to the client of the request.
+// Sessions that were never bound are rejected, loaders bind them instead when
+// they are first loaded.
+func (b *SessionBinder) Verify(req *http.Request, s *sessionsapi.SessionState) error {
+	if s.Binding == "" {
+		return errors.New("session is not bound to a client")
+	}
+	fingerprint, err := b.fingerprint(req)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(fingerprint), []byte(s.Binding)) != 1 {
+		return errors.New("session is bound to another client")
+	}
+	return nil
+}
+
+// fingerprint hashes the parts of the client of the request that sessions
+// are bound to
+func (b *SessionBinder) fingerprint(req *http.Request) (string, error) {
+	hash := sha256.New()
+	if b.ipBinding != "" {
+		clientIP, err := ip.GetClientIP(b.realClientIPParser, req)
+		if err != nil {
+			return "", fmt.Errorf("unable to get the client IP: %v", err)
+		}
+		if clientIP == nil {
+			return "", errors.New("unable to get the client IP")
+		}
+		if b.ipBinding == options.PrefixIPSessionBinding {
+			clientIP = ipPrefix(clientIP)
+		}
+		fmt.Fprintf(hash, "ip:%s\n", clientIP)
+	}
+	if b.userAgent {
+		fmt.Fprintf(hash, "ua:%s\n", req.UserAgent())
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ipPrefix masks the IP to its /24 prefix for IPv4 or /64 prefix for IPv6
+func ipPrefix(clientIP net.IP) net.IP {
+	if ipv4 := clientIP.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32))
+	}
+	return clientIP.Mask(net.CIDRMask(64, 128))
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session Binding Suite", func() {
+	newRequest := func(remoteAddr, userAgent string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", userAgent)
+		return req
+	}
+
+	It("does not create a SessionBinder when sessions are not bound", func() {
+		Expect(NewSessionBinder(options.SessionBindingOptions{}, nil)).To(BeNil())
+	})
+
+	type sessionBindingTableInput struct {
+		opts        options.SessionBindingOptions
+		boundReq    *http.Request
+		req         *http.Request
+		expectedErr string
+	}
+
+	DescribeTable("when verifying a bound session",
+		func(in sessionBindingTableInput) {
+			binder := NewSessionBinder(in.opts, nil)
+			Expect(binder).ToNot(BeNil())
+
+			session := &sessionsapi.SessionState{Email: "john.doe@example.com"}
+			Expect(binder.Bind(in.boundReq, session)).To(Succeed())
+			Expect(session.Binding).ToNot(BeEmpty())
+
+			err := binder.Verify(in.req, session)
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+			} else {
+				Expect(err).ToNot(HaveOccurred())
+			}
+		},
+		Entry("with the exact IP from the same IP", sessionBindingTableInput{
+			opts:     options.SessionBindingOptions{IP: options.ExactIPSessionBinding},
+			boundReq: newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:      newRequest("192.0.2.10:5678", "Browser/2.0"),
+		}),
+		Entry("with the exact IP from another IP in the prefix", sessionBindingTableInput{
+			opts:        options.SessionBindingOptions{IP: options.ExactIPSessionBinding},
+			boundReq:    newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:         newRequest("192.0.2.11:1234", "Browser/1.0"),
+			expectedErr: "session is bound to another client",
+		}),
+		Entry("with the IPv4 prefix from another IP in the /24", sessionBindingTableInput{
+			opts:     options.SessionBindingOptions{IP: options.PrefixIPSessionBinding},
+			boundReq: newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:      newRequest("192.0.2.200:1234", "Browser/1.0"),
+		}),
+		Entry("with the IPv4 prefix from another /24", sessionBindingTableInput{
+			opts:        options.SessionBindingOptions{IP: options.PrefixIPSessionBinding},
+			boundReq:    newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:         newRequest("192.0.3.10:1234", "Browser/1.0"),
+			expectedErr: "session is bound to another client",
+		}),
+		Entry("with the IPv6 prefix from another IP in the /64", sessionBindingTableInput{
+			opts:     options.SessionBindingOptions{IP: options.PrefixIPSessionBinding},
+			boundReq: newRequest("[2001:db8:1:2::10]:1234", "Browser/1.0"),
+			req:      newRequest("[2001:db8:1:2:ffff::1]:1234", "Browser/1.0"),
+		}),
+		Entry("with the IPv6 prefix from another /64", sessionBindingTableInput{
+			opts:        options.SessionBindingOptions{IP: options.PrefixIPSessionBinding},
+			boundReq:    newRequest("[2001:db8:1:2::10]:1234", "Browser/1.0"),
+			req:         newRequest("[2001:db8:1:3::10]:1234", "Browser/1.0"),
+			expectedErr: "session is bound to another client",
+		}),
+		Entry("with the User-Agent only from another IP", sessionBindingTableInput{
+			opts:     options.SessionBindingOptions{UserAgent: true},
+			boundReq: newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:      newRequest("198.51.100.1:1234", "Browser/1.0"),
+		}),
+		Entry("with the User-Agent only from another User-Agent", sessionBindingTableInput{
+			opts:        options.SessionBindingOptions{UserAgent: true},
+			boundReq:    newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:         newRequest("192.0.2.10:1234", "Browser/2.0"),
+			expectedErr: "session is bound to another client",
+		}),
+		Entry("with the IP and User-Agent from another User-Agent", sessionBindingTableInput{
+			opts:        options.SessionBindingOptions{IP: options.ExactIPSessionBinding, UserAgent: true},
+			boundReq:    newRequest("192.0.2.10:1234", "Browser/1.0"),
+			req:         newRequest("192.0.2.10:1234", "Browser/2.0"),
+			expectedErr: "session is bound to another client",
+		}),
+	)
+
+	It("rejects sessions that were never bound", func() {
+		binder := NewSessionBinder(options.SessionBindingOptions{UserAgent: true}, nil)
+		err := binder.Verify(newRequest("192.0.2.10:1234", "Browser/1.0"), &sessionsapi.SessionState{})
+		Expect(err).To(MatchError("session is not bound to a client"))
+	})
+
+	It("binds to the real client IP when a real client IP parser is set", func() {
+		parser, err := ip.GetRealClientIPParser("X-Forwarded-For")
+		Expect(err).ToNot(HaveOccurred())
+		binder := NewSessionBinder(options.SessionBindingOptions{IP: options.ExactIPSessionBinding}, parser)
+
+		boundReq := newRequest("10.0.0.1:1234", "Browser/1.0")
+		boundReq.Header.Set("X-Forwarded-For", "192.0.2.10")
+		session := &sessionsapi.SessionState{}
+		Expect(binder.Bind(boundReq, session)).To(Succeed())
+
+		req := newRequest("10.0.0.2:1234", "Browser/1.0")
+		req.Header.Set("X-Forwarded-For", "192.0.2.10")
+		Expect(binder.Verify(req, session)).To(Succeed())
+
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		Expect(binder.Verify(req, session)).To(MatchError("session is bound to another client"))
+	})
+
+	Context("StoredSessionLoader", func() {
+		It("rejects and clears sessions bound to another client", func() {
+			binder := NewSessionBinder(options.SessionBindingOptions{UserAgent: true}, nil)
+			bound := &sessionsapi.SessionState{Email: "john.doe@example.com"}
+			Expect(binder.Bind(newRequest("192.0.2.10:1234", "Browser/1.0"), bound)).To(Succeed())
+
+			cleared := false
+			store := &fakeSessionStore{
+				LoadFunc: func(_ *http.Request) (*sessionsapi.SessionState, error) {
+					return bound, nil
+				},
+				ClearFunc: func(_ http.ResponseWriter, _ *http.Request) error {
+					cleared = true
+					return nil
+				},
+			}
+
+			handler := NewStoredSessionLoader(&StoredSessionLoaderOptions{
+				SessionStore:  store,
+				SessionBinder: binder,
+			})
+			serve := func(req *http.Request) *sessionsapi.SessionState {
+				scope := &middlewareapi.RequestScope{}
+				req = req.WithContext(context.WithValue(req.Context(), requestScopeKey, scope))
+				var gotSession *sessionsapi.SessionState
+				handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					gotSession = GetRequestScope(r).Session
+				})).ServeHTTP(httptest.NewRecorder(), req)
+				return gotSession
+			}
+
+			Expect(serve(newRequest("192.0.2.10:1234", "Browser/1.0"))).To(Equal(bound))
+			Expect(cleared).To(BeFalse())
+
+			Expect(serve(newRequest("192.0.2.10:1234", "Stolen/1.0"))).To(BeNil())
+			Expect(cleared).To(BeTrue())
+		})
+
+		It("binds and saves sessions that were never bound", func() {
+			stored := &sessionsapi.SessionState{Email: "john.doe@example.com"}
+			saved := false
+			store := &fakeSessionStore{
+				LoadFunc: func(_ *http.Request) (*sessionsapi.SessionState, error) {
+					loaded := *stored
+					return &loaded, nil
+				},
+				SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+					saved = true
+					*stored = *ss
+					return nil
+				},
+			}
+
+			handler := NewStoredSessionLoader(&StoredSessionLoaderOptions{
+				SessionStore:  store,
+				SessionBinder: NewSessionBinder(options.SessionBindingOptions{UserAgent: true}, nil),
+			})
+			serve := func(req *http.Request) *sessionsapi.SessionState {
+				scope := &middlewareapi.RequestScope{}
+				req = req.WithContext(context.WithValue(req.Context(), requestScopeKey, scope))
+				handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+				return scope.Session
+			}
+
+			Expect(serve(newRequest("192.0.2.10:1234", "Browser/1.0"))).ToNot(BeNil())
+			Expect(saved).To(BeTrue())
+			Expect(stored.Binding).ToNot(BeEmpty())
+
+			Expect(serve(newRequest("192.0.2.10:1234", "Browser/1.0"))).ToNot(BeNil())
+			Expect(serve(newRequest("192.0.2.10:1234", "Stolen/1.0"))).To(BeNil())
+		})
+	})
+})
@@ -22,6 +22,22 @@ type StoredSessionLoaderOptions struct {
 	// How often should sessions be refreshed
 	RefreshPeriod time.Duration
 
+	// How long after signing in sessions are no longer valid, even if they
+	// are refreshed. 0 disables the limit.
+	MaxLifetime time.Duration
+
+	// How long sessions can go without any requests before they are no
+	// longer valid. 0 disables the limit.
+	IdleTimeout time.Duration
+
+	// How often the last activity of a session is saved when IdleTimeout is
+	// set
+	ActivityUpdateInterval time.Duration
+
+	// Binds sessions to the client they were created by. Sessions are not
+	// bound when nil.
+	SessionBinder *SessionBinder
+
 	// Provider based sesssion refreshing
 	RefreshSessionIfNeeded func(context.Context, *sessionsapi.SessionState) (bool, error)
 
@@ -39,6 +55,10 @@ func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor
 	ss := &storedSessionLoader{
 		store:                              opts.SessionStore,
 		refreshPeriod:                      opts.RefreshPeriod,
+		maxLifetime:                        opts.MaxLifetime,
+		idleTimeout:                        opts.IdleTimeout,
+		activityUpdateInterval:             opts.ActivityUpdateInterval,
+		sessionBinder:                      opts.SessionBinder,
 		refreshSessionWithProviderIfNeeded: opts.RefreshSessionIfNeeded,
 		validateSessionState:               opts.ValidateSessionState,
 	}
@@ -50,8 +70,16 @@ func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor
 type storedSessionLoader struct {
 	store                              sessionsapi.SessionStore
 	refreshPeriod                      time.Duration
+	maxLifetime                        time.Duration
+	idleTimeout                        time.Duration
+	activityUpdateInterval             time.Duration
+	sessionBinder                      *SessionBinder
 	refreshSessionWithProviderIfNeeded func(context.Context, *sessionsapi.SessionState) (bool, error)
 	validateSessionState               func(context.Context, *sessionsapi.SessionState) bool
+
+	// refreshes tracks the session refreshes in progress for stores that
+	// can't lock sessions
+	refreshes refreshGroup
 }
 
 // loadSession attempts to load a session as identified by the request cookies.
@@ -94,14 +122,130 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 		return nil, nil
 	}
 
+	bound, err := s.verifySessionBinding(req, session)
+	if err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Rejected session: %v", err)
+		return nil, err
+	}
+
+	stamped, err := s.stampAuthenticatedAtIfNeeded(session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validateSessionLifetime(session)
+	if err != nil {
+		return nil, err
+	}
+
 	err = s.refreshSessionIfNeeded(rw, req, session)
 	if err != nil {
 		return nil, fmt.Errorf("error refreshing access token for session (%s): %v", session, err)
 	}
 
+	s.resaveSessionIfNeeded(rw, req, session, bound || stamped)
 	return session, nil
 }
 
+// verifySessionBinding checks the session is bound to the client of the
+// request, when sessions are bound.
+// Sessions created before binding was enabled are bound to the client of the
+// first request that loads them instead, so that enabling binding doesn't
+// sign every user out. It returns true when the session was bound and must
+// be saved.
+func (s *storedSessionLoader) verifySessionBinding(req *http.Request, session *sessionsapi.SessionState) (bool, error) {
+	if s.sessionBinder == nil {
+		return false, nil
+	}
+	if session.Binding == "" {
+		err := s.sessionBinder.Bind(req, session)
+		if err != nil {
+			return false, fmt.Errorf("unable to bind session: %v", err)
+		}
+		logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Bound session created before session binding was enabled")
+		return true, nil
+	}
+	return false, s.sessionBinder.Verify(req, session)
+}
+
+// stampAuthenticatedAtIfNeeded records when the user signed in for sessions
+// created before it was recorded, when there is a maximum lifetime.
+// Their CreatedAt is the best estimate until they are first refreshed, after
+// which it would restart their lifetime, so they must be saved with it.
+// Sessions without either time can't be held to the maximum lifetime and
+// are rejected.
+func (s *storedSessionLoader) stampAuthenticatedAtIfNeeded(session *sessionsapi.SessionState) (bool, error) {
+	if s.maxLifetime <= time.Duration(0) ||
+		(session.AuthenticatedAt != nil && !session.AuthenticatedAt.IsZero()) {
+		return false, nil
+	}
+	if session.CreatedAt == nil || session.CreatedAt.IsZero() {
+		return false, errors.New("session has no authentication or creation time to enforce the maximum lifetime")
+	}
+	authenticatedAt := *session.CreatedAt
+	session.AuthenticatedAt = &authenticatedAt
+	return true, nil
+}
+
+// resaveSessionIfNeeded saves a session that was loaded using a previous
+// cookie secret so that it is protected by the current secret from now on.
+// It also saves the last activity of the session once it is older than the
+// activity update interval, so that sessions are not saved on every request,
+// and saves sessions whose ticket is due to be rotated or that were updated
+// when loaded, eg. bound to their client or stamped with their sign in time.
+// Failing to save is not fatal as the session is still valid.
+func (s *storedSessionLoader) resaveSessionIfNeeded(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState, updated bool) {
+	updateActivity := s.activityUpdateDue(session)
+	if !session.LoadedWithPreviousSecret && !updateActivity && !session.RotateTicket && !updated {
+		return
+	}
+
+	if updateActivity {
+		now := time.Now()
+		session.LastActivity = &now
+	}
+	err := s.store.Save(rw, req, session)
+	if err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+		return
+	}
+	session.LoadedWithPreviousSecret = false
+	session.RotateTicket = false
+}
+
+// activityUpdateDue checks whether the last activity of the session should be
+// saved. Activity is only tracked when an idle timeout is set.
+func (s *storedSessionLoader) activityUpdateDue(session *sessionsapi.SessionState) bool {
+	if s.idleTimeout <= time.Duration(0) {
+		return false
+	}
+	if session.LastActivity == nil || session.LastActivity.IsZero() {
+		return true
+	}
+	return session.IdleTime() >= s.activityUpdateInterval
+}
+
+// validateSessionLifetime checks the session has not passed the end set by
+// the provider, exceeded the maximum lifetime or been idle for longer than the
+// idle timeout.
+// An error implies the session is no longer valid.
+func (s *storedSessionLoader) validateSessionLifetime(session *sessionsapi.SessionState) error {
+	if session.HasEnded() {
+		return fmt.Errorf("session ended at %s as set by the provider", session.EndsAt)
+	}
+	if s.maxLifetime > time.Duration(0) && session.Lifetime() > s.maxLifetime {
+		return fmt.Errorf("session exceeded the maximum lifetime of %s", s.maxLifetime)
+	}
+	if s.idleTimeout > time.Duration(0) && session.IdleTime() > s.idleTimeout {
+		return fmt.Errorf("session was idle for longer than %s", s.idleTimeout)
+	}
+	return nil
+}
+
+// refreshLockExpiration is the longest a request waits for, and holds, the
+// lock on a session while it is refreshed
+const refreshLockExpiration = 10 * time.Second
+
 // refreshSessionIfNeeded will attempt to refresh a session if the session
 // is older than the refresh period.
 // It is assumed that if the provider refreshes the session, the session is now
@@ -109,23 +253,87 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 // If the session requires refreshing but the provider does not refresh it,
 // we must validate the session to ensure that the returned session is still
 // valid.
+// Concurrent requests with the same session only refresh it once, as
+// providers that rotate refresh tokens revoke them when they are reused.
 func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
 	if s.refreshPeriod <= time.Duration(0) || session.Age() < s.refreshPeriod {
 		// Refresh is disabled or the session is not old enough, do nothing
 		return nil
 	}
 
+	if locker, ok := s.store.(sessionsapi.SessionLocker); ok {
+		return s.refreshLockedSession(rw, req, session, locker)
+	}
+	return s.refreshSharedSession(rw, req, session)
+}
+
+// refreshLockedSession refreshes the session while holding the session lock
+// from the store.
+// Requests that waited for the lock reload the session and use it when
+// another request refreshed it in the meantime.
+func (s *storedSessionLoader) refreshLockedSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState, locker sessionsapi.SessionLocker) error {
+	unlock, err := locker.LockSession(req, refreshLockExpiration)
+	if err != nil {
+		return fmt.Errorf("error locking session: %v", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logger.Printf("Error unlocking session: %v", err)
+		}
+	}()
+
+	latest, err := s.store.Load(req)
+	if err != nil {
+		return fmt.Errorf("error reloading session: %v", err)
+	}
+	if latest != nil && sessionUpdated(session, latest) {
+		logger.Printf("Session for %s was refreshed by another request", session)
+		*session = *latest
+		return nil
+	}
+
+	_, err = s.refreshSession(rw, req, session)
+	return err
+}
+
+// refreshSharedSession refreshes the session once for all concurrent requests
+// with the same session in this process.
+// Requests that waited share the result and save the refreshed session in
+// their response too.
+func (s *storedSessionLoader) refreshSharedSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
+	result, shared := s.refreshes.do(sessionKey(session), func() refreshResult {
+		refreshed, err := s.refreshSession(rw, req, session)
+		copied := *session
+		return refreshResult{session: &copied, refreshed: refreshed, err: err}
+	})
+	if !shared || result.err != nil {
+		return result.err
+	}
+
+	*session = *result.session
+	if result.refreshed {
+		err := s.store.Save(rw, req, session)
+		if err != nil {
+			logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+		}
+	}
+	return nil
+}
+
+// refreshSession refreshes the session with the provider, or validates it
+// when the provider does not refresh it
+func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) (bool, error) {
 	logger.Printf("Refreshing %s old session cookie for %s (refresh after %s)", session.Age(), session, s.refreshPeriod)
 	refreshed, err := s.refreshSessionWithProvider(rw, req, session)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if !refreshed {
 		// Session wasn't refreshed, so make sure it's still valid
-		return s.validateSession(req.Context(), session)
+		return false, s.validateSession(req.Context(), session)
 	}
-	return nil
+	return true, nil
 }
 
 // refreshSessionWithProvider attempts to refresh the sessinon with the provider
@@ -140,12 +348,17 @@ func (s *storedSessionLoader) refreshSessionWithProvider(rw http.ResponseWriter,
 		return false, nil
 	}
 
-	// Because the session was refreshed, make sure to save it
+	// Because the session was refreshed, make sure to save it with a new
+	// ticket so that a leaked ticket does not outlive the refresh
+	session.RotateTicket = true
 	err = s.store.Save(rw, req, session)
 	if err != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
 		return false, fmt.Errorf("error saving session: %v", err)
 	}
+	// Saving protects the session with the current cookie secret
+	session.LoadedWithPreviousSecret = false
+	session.RotateTicket = false
 	return true, nil
 }
 
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/middleware"
@@ -365,6 +367,155 @@ var _ = Describe("Stored Session Suite", func() {
 		)
 	})
 
+	Context("refreshSessionIfNeeded with concurrent requests", func() {
+		const concurrentRequests = 10
+
+		var refreshes int32
+		var saves int32
+		var release chan struct{}
+		var refreshStarted chan struct{}
+
+		createdPast := time.Now().Add(-5 * time.Minute)
+
+		newSession := func() *sessionsapi.SessionState {
+			return &sessionsapi.SessionState{
+				AccessToken:  "AccessToken",
+				RefreshToken: refresh,
+				CreatedAt:    &createdPast,
+			}
+		}
+
+		// refreshConcurrently refreshes a copy of the session in every request,
+		// letting the provider refresh finish once every request has started
+		refreshConcurrently := func(s *storedSessionLoader) []*sessionsapi.SessionState {
+			sessions := make([]*sessionsapi.SessionState, concurrentRequests)
+			var wg sync.WaitGroup
+			for i := range sessions {
+				sessions[i] = newSession()
+				wg.Add(1)
+				go func(session *sessionsapi.SessionState) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					req := httptest.NewRequest("", "/", nil)
+					Expect(s.refreshSessionIfNeeded(httptest.NewRecorder(), req, session)).To(Succeed())
+				}(sessions[i])
+			}
+
+			<-refreshStarted
+			// Give the other requests time to wait on the refresh
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			return sessions
+		}
+
+		BeforeEach(func() {
+			refreshes = 0
+			saves = 0
+			release = make(chan struct{})
+			refreshStarted = make(chan struct{}, concurrentRequests)
+		})
+
+		refreshFunc := func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+			atomic.AddInt32(&refreshes, 1)
+			refreshStarted <- struct{}{}
+			<-release
+			ss.AccessToken = "RefreshedAccessToken"
+			ss.RefreshToken = "Refreshed"
+			return true, nil
+		}
+
+		Context("with a store that can't lock sessions", func() {
+			It("refreshes the session once and shares it with every request", func() {
+				s := &storedSessionLoader{
+					refreshPeriod: 1 * time.Minute,
+					store: &fakeSessionStore{
+						SaveFunc: func(_ http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+							atomic.AddInt32(&saves, 1)
+							return nil
+						},
+					},
+					refreshSessionWithProviderIfNeeded: refreshFunc,
+				}
+
+				for _, session := range refreshConcurrently(s) {
+					Expect(session.AccessToken).To(Equal("RefreshedAccessToken"))
+					Expect(session.RefreshToken).To(Equal("Refreshed"))
+				}
+				Expect(refreshes).To(Equal(int32(1)))
+				Expect(saves).To(Equal(int32(concurrentRequests)))
+			})
+
+			It("shares the refreshed session with requests that arrive after the refresh", func() {
+				s := &storedSessionLoader{
+					refreshPeriod: 1 * time.Minute,
+					store: &fakeSessionStore{
+						SaveFunc: func(_ http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+							atomic.AddInt32(&saves, 1)
+							return nil
+						},
+					},
+					refreshSessionWithProviderIfNeeded: refreshFunc,
+				}
+				close(release)
+
+				for i := 0; i < 2; i++ {
+					session := newSession()
+					req := httptest.NewRequest("", "/", nil)
+					Expect(s.refreshSessionIfNeeded(httptest.NewRecorder(), req, session)).To(Succeed())
+					Expect(session.AccessToken).To(Equal("RefreshedAccessToken"))
+					Expect(session.RefreshToken).To(Equal("Refreshed"))
+				}
+				Expect(refreshes).To(Equal(int32(1)))
+				Expect(saves).To(Equal(int32(2)))
+			})
+
+			It("retries a refresh that failed", func() {
+				s := &storedSessionLoader{
+					refreshPeriod: 1 * time.Minute,
+					store:         &fakeSessionStore{},
+					refreshSessionWithProviderIfNeeded: func(_ context.Context, _ *sessionsapi.SessionState) (bool, error) {
+						atomic.AddInt32(&refreshes, 1)
+						return false, errors.New("error refreshing session")
+					},
+				}
+
+				for i := 0; i < 2; i++ {
+					req := httptest.NewRequest("", "/", nil)
+					Expect(s.refreshSessionIfNeeded(httptest.NewRecorder(), req, newSession())).ToNot(Succeed())
+				}
+				Expect(refreshes).To(Equal(int32(2)))
+			})
+		})
+
+		Context("with a store that locks sessions", func() {
+			It("refreshes the session once and reloads it in the other requests", func() {
+				store := &fakeLockingSessionStore{stored: newSession()}
+				store.SaveFunc = func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+					atomic.AddInt32(&saves, 1)
+					store.setStored(ss)
+					return nil
+				}
+				store.LoadFunc = func(_ *http.Request) (*sessionsapi.SessionState, error) {
+					return store.getStored(), nil
+				}
+
+				s := &storedSessionLoader{
+					refreshPeriod:                      1 * time.Minute,
+					store:                              store,
+					refreshSessionWithProviderIfNeeded: refreshFunc,
+				}
+
+				for _, session := range refreshConcurrently(s) {
+					Expect(session.AccessToken).To(Equal("RefreshedAccessToken"))
+					Expect(session.RefreshToken).To(Equal("Refreshed"))
+				}
+				Expect(refreshes).To(Equal(int32(1)))
+				Expect(saves).To(Equal(int32(1)))
+			})
+		})
+	})
+
 	Context("refreshSessionWithProvider", func() {
 		type refreshSessionWithProviderTableInput struct {
 			session         *sessionsapi.SessionState
@@ -383,6 +534,8 @@ var _ = Describe("Stored Session Suite", func() {
 					store: &fakeSessionStore{
 						SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
 							saved = true
+							// Refreshed sessions are saved with a new ticket
+							Expect(ss.RotateTicket).To(BeTrue())
 							if ss.AccessToken == "NoSave" {
 								return errors.New("unable to save session")
 							}
@@ -410,6 +563,9 @@ var _ = Describe("Stored Session Suite", func() {
 				}
 				Expect(refreshed).To(Equal(in.expectRefreshed))
 				Expect(saved).To(Equal(in.expectSaved))
+				if refreshed {
+					Expect(in.session.RotateTicket).To(BeFalse())
+				}
 			},
 			Entry("when the provider does not refresh the session", refreshSessionWithProviderTableInput{
 				session: &sessionsapi.SessionState{
@@ -449,6 +605,270 @@ var _ = Describe("Stored Session Suite", func() {
 		)
 	})
 
+	Context("resaveSessionIfNeeded", func() {
+		lastActivity := time.Now().Add(-5 * time.Minute)
+
+		type resaveSessionIfNeededTableInput struct {
+			session                        *sessionsapi.SessionState
+			idleTimeout                    time.Duration
+			updated                        bool
+			expectSaved                    bool
+			expectLoadedWithPreviousSecret bool
+			expectActivityUpdated          bool
+		}
+
+		DescribeTable("with a session",
+			func(in resaveSessionIfNeededTableInput) {
+				saved := false
+				previousActivity := in.session.LastActivity
+
+				s := &storedSessionLoader{
+					idleTimeout:            in.idleTimeout,
+					activityUpdateInterval: time.Minute,
+					store: &fakeSessionStore{
+						SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+							saved = true
+							if ss.AccessToken == "NoSave" {
+								return errors.New("unable to save session")
+							}
+							return nil
+						},
+					},
+				}
+
+				req := httptest.NewRequest("", "/", nil)
+				s.resaveSessionIfNeeded(nil, req, in.session, in.updated)
+				Expect(saved).To(Equal(in.expectSaved))
+				Expect(in.session.LoadedWithPreviousSecret).To(Equal(in.expectLoadedWithPreviousSecret))
+				Expect(in.session.RotateTicket).To(BeFalse())
+				if in.expectActivityUpdated {
+					Expect(in.session.LastActivity).ToNot(BeNil())
+					Expect(*in.session.LastActivity).To(BeTemporally("~", time.Now(), time.Second))
+				} else {
+					Expect(in.session.LastActivity).To(Equal(previousActivity))
+				}
+			},
+			Entry("loaded with the current cookie secret", resaveSessionIfNeededTableInput{
+				session:                        &sessionsapi.SessionState{},
+				expectSaved:                    false,
+				expectLoadedWithPreviousSecret: false,
+			}),
+			Entry("loaded with a previous cookie secret", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					LoadedWithPreviousSecret: true,
+				},
+				expectSaved:                    true,
+				expectLoadedWithPreviousSecret: false,
+			}),
+			Entry("loaded with a previous cookie secret when saving returns an error", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					AccessToken:              "NoSave",
+					LoadedWithPreviousSecret: true,
+				},
+				expectSaved:                    true,
+				expectLoadedWithPreviousSecret: true,
+			}),
+			Entry("with recent activity and no idle timeout", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					LastActivity: &lastActivity,
+				},
+				expectSaved:                    false,
+				expectLoadedWithPreviousSecret: false,
+				expectActivityUpdated:          false,
+			}),
+			Entry("with no activity and an idle timeout", resaveSessionIfNeededTableInput{
+				session:                        &sessionsapi.SessionState{},
+				idleTimeout:                    time.Hour,
+				expectSaved:                    true,
+				expectLoadedWithPreviousSecret: false,
+				expectActivityUpdated:          true,
+			}),
+			Entry("with activity older than the update interval", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					LastActivity: &lastActivity,
+				},
+				idleTimeout:                    time.Hour,
+				expectSaved:                    true,
+				expectLoadedWithPreviousSecret: false,
+				expectActivityUpdated:          true,
+			}),
+			Entry("with activity newer than the update interval", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					LastActivity: timePtr(time.Now().Add(-10 * time.Second)),
+				},
+				idleTimeout:                    time.Hour,
+				expectSaved:                    false,
+				expectLoadedWithPreviousSecret: false,
+				expectActivityUpdated:          false,
+			}),
+			Entry("with a ticket due to be rotated", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					RotateTicket: true,
+				},
+				expectSaved:                    true,
+				expectLoadedWithPreviousSecret: false,
+				expectActivityUpdated:          false,
+			}),
+			Entry("when updated while loading", resaveSessionIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					AuthenticatedAt: timePtr(time.Now().Add(-time.Hour)),
+				},
+				updated:                        true,
+				expectSaved:                    true,
+				expectLoadedWithPreviousSecret: false,
+				expectActivityUpdated:          false,
+			}),
+		)
+	})
+
+	Context("stampAuthenticatedAtIfNeeded", func() {
+		createdAt := time.Now().Add(-2 * time.Hour)
+		authenticatedAt := time.Now().Add(-3 * time.Hour)
+
+		type stampAuthenticatedAtIfNeededTableInput struct {
+			session                 *sessionsapi.SessionState
+			maxLifetime             time.Duration
+			expectStamped           bool
+			expectedAuthenticatedAt *time.Time
+			expectedError           error
+		}
+
+		DescribeTable("with a session",
+			func(in stampAuthenticatedAtIfNeededTableInput) {
+				s := &storedSessionLoader{
+					maxLifetime: in.maxLifetime,
+				}
+
+				stamped, err := s.stampAuthenticatedAtIfNeeded(in.session)
+				if in.expectedError != nil {
+					Expect(err).To(MatchError(in.expectedError))
+				} else {
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(stamped).To(Equal(in.expectStamped))
+				Expect(in.session.AuthenticatedAt).To(Equal(in.expectedAuthenticatedAt))
+			},
+			Entry("without a maximum lifetime", stampAuthenticatedAtIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt: &createdAt,
+				},
+				expectStamped:           false,
+				expectedAuthenticatedAt: nil,
+			}),
+			Entry("with a sign in time", stampAuthenticatedAtIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt:       &createdAt,
+					AuthenticatedAt: &authenticatedAt,
+				},
+				maxLifetime:             12 * time.Hour,
+				expectStamped:           false,
+				expectedAuthenticatedAt: &authenticatedAt,
+			}),
+			Entry("without a sign in time", stampAuthenticatedAtIfNeededTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt: &createdAt,
+				},
+				maxLifetime:             12 * time.Hour,
+				expectStamped:           true,
+				expectedAuthenticatedAt: &createdAt,
+			}),
+			Entry("without a sign in or creation time", stampAuthenticatedAtIfNeededTableInput{
+				session:                 &sessionsapi.SessionState{},
+				maxLifetime:             12 * time.Hour,
+				expectStamped:           false,
+				expectedAuthenticatedAt: nil,
+				expectedError:           errors.New("session has no authentication or creation time to enforce the maximum lifetime"),
+			}),
+		)
+	})
+
+	Context("validateSessionLifetime", func() {
+		type validateSessionLifetimeTableInput struct {
+			session       *sessionsapi.SessionState
+			maxLifetime   time.Duration
+			idleTimeout   time.Duration
+			expectedError error
+		}
+
+		DescribeTable("with a session",
+			func(in validateSessionLifetimeTableInput) {
+				s := &storedSessionLoader{
+					maxLifetime: in.maxLifetime,
+					idleTimeout: in.idleTimeout,
+				}
+
+				err := s.validateSessionLifetime(in.session)
+				if in.expectedError != nil {
+					Expect(err).To(MatchError(in.expectedError))
+				} else {
+					Expect(err).ToNot(HaveOccurred())
+				}
+			},
+			Entry("when the limits are disabled", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					AuthenticatedAt: timePtr(time.Now().Add(-48 * time.Hour)),
+					LastActivity:    timePtr(time.Now().Add(-24 * time.Hour)),
+				},
+				expectedError: nil,
+			}),
+			Entry("when the session is within the limits", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					AuthenticatedAt: timePtr(time.Now().Add(-2 * time.Hour)),
+					LastActivity:    timePtr(time.Now().Add(-5 * time.Minute)),
+				},
+				maxLifetime:   12 * time.Hour,
+				idleTimeout:   30 * time.Minute,
+				expectedError: nil,
+			}),
+			Entry("when the session exceeded the maximum lifetime", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					AuthenticatedAt: timePtr(time.Now().Add(-13 * time.Hour)),
+					LastActivity:    timePtr(time.Now().Add(-5 * time.Minute)),
+				},
+				maxLifetime:   12 * time.Hour,
+				idleTimeout:   30 * time.Minute,
+				expectedError: errors.New("session exceeded the maximum lifetime of 12h0m0s"),
+			}),
+			Entry("when a refreshed session without a sign in time exceeded the maximum lifetime", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt: timePtr(time.Now().Add(-13 * time.Hour)),
+				},
+				maxLifetime:   12 * time.Hour,
+				expectedError: errors.New("session exceeded the maximum lifetime of 12h0m0s"),
+			}),
+			Entry("when the session was idle for longer than the idle timeout", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					AuthenticatedAt: timePtr(time.Now().Add(-2 * time.Hour)),
+					LastActivity:    timePtr(time.Now().Add(-45 * time.Minute)),
+				},
+				maxLifetime:   12 * time.Hour,
+				idleTimeout:   30 * time.Minute,
+				expectedError: errors.New("session was idle for longer than 30m0s"),
+			}),
+			Entry("when the session has no activity recorded", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt: timePtr(time.Now().Add(-2 * time.Hour)),
+				},
+				idleTimeout:   30 * time.Minute,
+				expectedError: nil,
+			}),
+			Entry("when the session passed the end set by the provider", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt: timePtr(time.Now().Add(-5 * time.Minute)),
+					EndsAt:    timePtr(time.Date(2020, 10, 1, 8, 0, 0, 0, time.UTC)),
+				},
+				expectedError: errors.New("session ended at 2020-10-01 08:00:00 +0000 UTC as set by the provider"),
+			}),
+			Entry("when the session is before the end set by the provider", validateSessionLifetimeTableInput{
+				session: &sessionsapi.SessionState{
+					CreatedAt: timePtr(time.Now().Add(-5 * time.Minute)),
+					EndsAt:    timePtr(time.Now().Add(time.Hour)),
+				},
+				expectedError: nil,
+			}),
+		)
+	})
+
 	Context("validateSession", func() {
 		var s *storedSessionLoader
 
@@ -522,3 +942,38 @@ func (f *fakeSessionStore) Clear(rw http.ResponseWriter, req *http.Request) erro
 	}
 	return nil
 }
+
+// fakeLockingSessionStore is a fakeSessionStore that can lock sessions
+type fakeLockingSessionStore struct {
+	fakeSessionStore
+
+	lock        sync.Mutex
+	storedMutex sync.Mutex
+	stored      *sessionsapi.SessionState
+}
+
+func (f *fakeLockingSessionStore) LockSession(_ *http.Request, _ time.Duration) (func() error, error) {
+	f.lock.Lock()
+	return func() error {
+		f.lock.Unlock()
+		return nil
+	}, nil
+}
+
+func (f *fakeLockingSessionStore) getStored() *sessionsapi.SessionState {
+	f.storedMutex.Lock()
+	defer f.storedMutex.Unlock()
+	stored := *f.stored
+	return &stored
+}
+
+func (f *fakeLockingSessionStore) setStored(s *sessionsapi.SessionState) {
+	f.storedMutex.Lock()
+	defer f.storedMutex.Unlock()
+	stored := *s
+	f.stored = &stored
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,154 @@
+package saml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// canonicalize serializes the element and its descendants with Exclusive XML
+// Canonicalization 1.0 without comments. The exclude element, usually the
+// enveloped signature, is left out. Namespaces with the inclusive prefixes are
+// rendered wherever they are in scope, as required by the InclusiveNamespaces
+// PrefixList, with "#default" meaning the default namespace.
+func canonicalize(e *element, exclude *element, inclusivePrefixes []string) ([]byte, error) {
+	c := &canonicalizer{
+		exclude:   exclude,
+		inclusive: map[string]bool{},
+	}
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		c.inclusive[prefix] = true
+	}
+
+	err := c.writeElement(e, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(c.b.String()), nil
+}
+
+type canonicalizer struct {
+	b         strings.Builder
+	exclude   *element
+	inclusive map[string]bool
+}
+
+// writeElement writes the element with the namespace declarations not yet
+// rendered by its output ancestors
+func (c *canonicalizer) writeElement(e *element, rendered map[string]string) error {
+	prefixes := map[string]bool{e.prefix: true}
+	for _, a := range e.attrs {
+		if a.prefix != "" && a.prefix != "xml" {
+			prefixes[a.prefix] = true
+		}
+	}
+	for prefix := range c.inclusive {
+		if _, ok := e.lookupNamespace(prefix); ok {
+			prefixes[prefix] = true
+		}
+	}
+
+	declarations := []string{}
+	childRendered := copyNamespaces(rendered)
+	for prefix := range prefixes {
+		ns, ok := e.lookupNamespace(prefix)
+		if !ok && prefix != "" {
+			return fmt.Errorf("namespace prefix %q is not bound", prefix)
+		}
+		previous, wasRendered := rendered[prefix]
+		if wasRendered && previous == ns {
+			continue
+		}
+		if !wasRendered && prefix == "" && ns == "" {
+			continue
+		}
+		childRendered[prefix] = ns
+		declarations = append(declarations, prefix)
+	}
+	sort.Strings(declarations)
+
+	attrs := make([]attribute, len(e.attrs))
+	copy(attrs, e.attrs)
+	attrNamespaces := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		if a.prefix == "" {
+			continue
+		}
+		ns, ok := e.lookupNamespace(a.prefix)
+		if !ok {
+			return fmt.Errorf("namespace prefix %q is not bound", a.prefix)
+		}
+		attrNamespaces[a.prefix] = ns
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		nsi, nsj := attrNamespaces[attrs[i].prefix], attrNamespaces[attrs[j].prefix]
+		if nsi != nsj {
+			return nsi < nsj
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	c.b.WriteString("<" + qualifiedName(e.prefix, e.local))
+	for _, prefix := range declarations {
+		if prefix == "" {
+			c.b.WriteString(` xmlns="`)
+		} else {
+			c.b.WriteString(` xmlns:` + prefix + `="`)
+		}
+		c.b.WriteString(escapeAttr(childRendered[prefix]) + `"`)
+	}
+	for _, a := range attrs {
+		c.b.WriteString(" " + qualifiedName(a.prefix, a.local) + `="` + escapeAttr(a.value) + `"`)
+	}
+	c.b.WriteString(">")
+
+	for _, child := range e.children {
+		switch ch := child.(type) {
+		case string:
+			c.b.WriteString(escapeText(ch))
+		case *element:
+			if ch == c.exclude {
+				continue
+			}
+			if err := c.writeElement(ch, childRendered); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.b.WriteString("</" + qualifiedName(e.prefix, e.local) + ">")
+	return nil
+}
+
+// copyNamespaces copies the rendered namespaces so an element can add to them
+// without changing those of its siblings
+func copyNamespaces(namespaces map[string]string) map[string]string {
+	c := make(map[string]string, len(namespaces)+1)
+	for prefix, ns := range namespaces {
+		c[prefix] = ns
+	}
+	return c
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
@@ -0,0 +1,170 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlNamespace is bound to the xml prefix in every document
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is an element of a parsed XML document. Unlike encoding/xml, the
+// prefixes and namespace declarations are kept as written so the element can
+// be canonicalized.
+type element struct {
+	parent *element
+	prefix string
+	local  string
+
+	// namespaces are the namespaces declared on the element by prefix, the
+	// default namespace has an empty prefix
+	namespaces map[string]string
+	attrs      []attribute
+
+	// children are *element or string character data
+	children []interface{}
+}
+
+// attribute is an attribute of an element that is not a namespace
+// declaration
+type attribute struct {
+	prefix string
+	local  string
+	value  string
+}
+
+// parseDocument parses the XML document into a tree of elements and returns
+// the root element. Documents with a DTD are rejected.
+func parseDocument(data []byte) (*element, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *element
+	for {
+		token, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing XML: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, errors.New("error parsing XML: more than one root element")
+			}
+			e := &element{
+				parent:     current,
+				prefix:     t.Name.Space,
+				local:      t.Name.Local,
+				namespaces: map[string]string{},
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					e.namespaces[""] = attr.Value
+				case attr.Name.Space == "xmlns":
+					e.namespaces[attr.Name.Local] = attr.Value
+				default:
+					e.attrs = append(e.attrs, attribute{prefix: attr.Name.Space, local: attr.Name.Local, value: attr.Value})
+				}
+			}
+			if current == nil {
+				root = e
+			} else {
+				current.children = append(current.children, e)
+			}
+			current = e
+		case xml.EndElement:
+			if current == nil || current.prefix != t.Name.Space || current.local != t.Name.Local {
+				return nil, fmt.Errorf("error parsing XML: unexpected end element %s", t.Name.Local)
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, string(t))
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, errors.New("error parsing XML: text outside of the root element")
+			}
+		case xml.Directive:
+			return nil, errors.New("error parsing XML: DTDs are not allowed")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, errors.New("error parsing XML: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNamespace returns the namespace bound to the prefix in the scope of
+// the element
+func (e *element) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for s := e; s != nil; s = s.parent {
+		if ns, ok := s.namespaces[prefix]; ok {
+			return ns, true
+		}
+	}
+	return "", false
+}
+
+// namespace returns the namespace of the element
+func (e *element) namespace() string {
+	ns, _ := e.lookupNamespace(e.prefix)
+	return ns
+}
+
+// is checks the namespace and local name of the element
+func (e *element) is(namespace, local string) bool {
+	return e.local == local && e.namespace() == namespace
+}
+
+// childElements returns the child elements with the namespace and local name
+func (e *element) childElements(namespace, local string) []*element {
+	elements := []*element{}
+	for _, child := range e.children {
+		if c, ok := child.(*element); ok && c.is(namespace, local) {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
+// childElement returns the only child element with the namespace and local
+// name
+func (e *element) childElement(namespace, local string) (*element, error) {
+	elements := e.childElements(namespace, local)
+	if len(elements) != 1 {
+		return nil, fmt.Errorf("expected exactly one %s element in %s, found %d", local, e.local, len(elements))
+	}
+	return elements[0], nil
+}
+
+// attr returns the value of the attribute without a namespace with the local
+// name
+func (e *element) attr(local string) string {
+	for _, a := range e.attrs {
+		if a.prefix == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// text returns the character data of the element without surrounding
+// whitespace
+func (e *element) text() string {
+	var b strings.Builder
+	for _, child := range e.children {
+		if s, ok := child.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
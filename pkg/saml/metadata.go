@@ -0,0 +1,151 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+const (
+	metadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	protocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	redirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	postBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+// IdPMetadata is the metadata of an identity provider needed to sign users
+// in with it
+type IdPMetadata struct {
+	// EntityID is the issuer of the assertions of the IdP
+	EntityID string
+
+	// SSOURL is the single sign on endpoint of the IdP for the HTTP-Redirect
+	// binding
+	SSOURL *url.URL
+
+	// Certificates are the certificates the IdP signs assertions with
+	Certificates []*x509.Certificate
+}
+
+type entityDescriptor struct {
+	XMLName           xml.Name           `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID          string             `xml:"entityID,attr"`
+	IDPSSODescriptors []idpSSODescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+type idpSSODescriptor struct {
+	KeyDescriptors       []keyDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+	SingleSignOnServices []endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+}
+
+type keyDescriptor struct {
+	Use     string `xml:"use,attr"`
+	KeyInfo struct {
+		X509Data []struct {
+			X509Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# X509Certificate"`
+		} `xml:"http://www.w3.org/2000/09/xmldsig# X509Data"`
+	} `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+}
+
+type endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// LoadIdPMetadata loads the metadata of the IdP from the file
+func LoadIdPMetadata(path string) (*IdPMetadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading IdP metadata: %v", err)
+	}
+	return ParseIdPMetadata(data)
+}
+
+// ParseIdPMetadata parses the EntityDescriptor of an IdP
+func ParseIdPMetadata(data []byte) (*IdPMetadata, error) {
+	descriptor := &entityDescriptor{}
+	err := xml.Unmarshal(data, descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing IdP metadata: %v", err)
+	}
+	if descriptor.EntityID == "" {
+		return nil, errors.New("IdP metadata does not have an entityID")
+	}
+	if len(descriptor.IDPSSODescriptors) != 1 {
+		return nil, fmt.Errorf("IdP metadata should have one IDPSSODescriptor, found %d", len(descriptor.IDPSSODescriptors))
+	}
+	idp := descriptor.IDPSSODescriptors[0]
+
+	metadata := &IdPMetadata{EntityID: descriptor.EntityID}
+	for _, service := range idp.SingleSignOnServices {
+		if service.Binding == redirectBinding {
+			metadata.SSOURL, err = url.Parse(service.Location)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IdP SingleSignOnService location: %v", err)
+			}
+			break
+		}
+	}
+	if metadata.SSOURL == nil {
+		return nil, errors.New("IdP metadata does not have a SingleSignOnService with the HTTP-Redirect binding")
+	}
+
+	for _, key := range idp.KeyDescriptors {
+		if key.Use != "" && key.Use != "signing" {
+			continue
+		}
+		for _, data := range key.KeyInfo.X509Data {
+			for _, encoded := range data.X509Certificates {
+				der, err := decodeBase64(encoded)
+				if err != nil {
+					return nil, fmt.Errorf("invalid IdP certificate: %v", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("invalid IdP certificate: %v", err)
+				}
+				metadata.Certificates = append(metadata.Certificates, cert)
+			}
+		}
+	}
+	if len(metadata.Certificates) == 0 {
+		return nil, errors.New("IdP metadata does not have a signing certificate")
+	}
+	return metadata, nil
+}
+
+type spEntityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata AssertionConsumerService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SPSSODescriptor"`
+}
+
+// Metadata returns the metadata of the service provider, with the ACS URL
+// assertions are posted to
+func (sp *ServiceProvider) Metadata(acsURL string) ([]byte, error) {
+	descriptor := &spEntityDescriptor{EntityID: sp.EntityID}
+	descriptor.SPSSODescriptor.WantAssertionsSigned = true
+	descriptor.SPSSODescriptor.ProtocolSupportEnumeration = protocolNamespace
+	descriptor.SPSSODescriptor.AssertionConsumerService.Binding = postBinding
+	descriptor.SPSSODescriptor.AssertionConsumerService.Location = acsURL
+
+	data, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
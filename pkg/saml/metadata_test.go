@@ -0,0 +1,72 @@
+package saml
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadIdPMetadata(t *testing.T) {
+	idp, err := LoadIdPMetadata("testdata/idp_metadata.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://idp.example.com/metadata", idp.EntityID)
+	assert.Equal(t, "https://idp.example.com/sso/redirect?tenant=example", idp.SSOURL.String())
+	assert.Len(t, idp.Certificates, 1)
+	assert.Equal(t, "idp.example.com", idp.Certificates[0].Subject.CommonName)
+
+	_, err = LoadIdPMetadata("testdata/does_not_exist.xml")
+	assert.Error(t, err)
+}
+
+func TestParseIdPMetadataInvalid(t *testing.T) {
+	testCases := map[string]struct {
+		metadata      string
+		expectedError string
+	}{
+		"Without an entity ID": {
+			metadata:      `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata"></EntityDescriptor>`,
+			expectedError: "IdP metadata does not have an entityID",
+		},
+		"Without an IDPSSODescriptor": {
+			metadata:      `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="idp"></EntityDescriptor>`,
+			expectedError: "IdP metadata should have one IDPSSODescriptor, found 0",
+		},
+		"Without the HTTP-Redirect binding": {
+			metadata: `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="idp"><IDPSSODescriptor>
+				<SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso"/>
+			</IDPSSODescriptor></EntityDescriptor>`,
+			expectedError: "IdP metadata does not have a SingleSignOnService with the HTTP-Redirect binding",
+		},
+		"Without a signing certificate": {
+			metadata: `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="idp"><IDPSSODescriptor>
+				<SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/>
+				<KeyDescriptor use="encryption"><KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><X509Data><X509Certificate>MIIB</X509Certificate></X509Data></KeyInfo></KeyDescriptor>
+			</IDPSSODescriptor></EntityDescriptor>`,
+			expectedError: "IdP metadata does not have a signing certificate",
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := ParseIdPMetadata([]byte(tc.metadata))
+			assert.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestServiceProviderMetadata(t *testing.T) {
+	sp := NewServiceProvider("oauth2-proxy", nil)
+
+	data, err := sp.Metadata("https://proxy.example.com/oauth2/callback")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), xml.Header))
+
+	descriptor := &spEntityDescriptor{}
+	assert.NoError(t, xml.Unmarshal(data, descriptor))
+	assert.Equal(t, "oauth2-proxy", descriptor.EntityID)
+	assert.True(t, descriptor.SPSSODescriptor.WantAssertionsSigned)
+	assert.Equal(t, postBinding, descriptor.SPSSODescriptor.AssertionConsumerService.Binding)
+	assert.Equal(t, "https://proxy.example.com/oauth2/callback", descriptor.SPSSODescriptor.AssertionConsumerService.Location)
+}
@@ -0,0 +1,327 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bearerMethod  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// maxClockSkew is the difference allowed between the clocks of the IdP
+	// and the proxy when checking the validity of assertions
+	maxClockSkew = 90 * time.Second
+)
+
+// ServiceProvider signs users in with a SAML IdP. AuthnRequests are sent with
+// the HTTP-Redirect binding and responses are received with the HTTP-POST
+// binding.
+type ServiceProvider struct {
+	// EntityID identifies the service provider to the IdP, it must be the
+	// audience of assertions
+	EntityID string
+
+	IdP *IdPMetadata
+
+	// now returns the current time, it allows tests to fast forward time
+	now func() time.Time
+}
+
+// NewServiceProvider creates a ServiceProvider with the entity ID for the IdP
+func NewServiceProvider(entityID string, idp *IdPMetadata) *ServiceProvider {
+	return &ServiceProvider{
+		EntityID: entityID,
+		IdP:      idp,
+		now:      time.Now,
+	}
+}
+
+// Assertion is the content of a verified assertion
+type Assertion struct {
+	NameID       string
+	NameIDFormat string
+
+	// SessionNotOnOrAfter is when the IdP wants the session to end, if set
+	SessionNotOnOrAfter *time.Time
+
+	// Attributes are the values of the attributes by Name and, if set,
+	// by FriendlyName
+	Attributes map[string][]string
+}
+
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                struct {
+		AllowCreate bool `xml:"AllowCreate,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// AuthnRequestURL returns the URL of the IdP that signs the user in with an
+// AuthnRequest with the ID. The response is posted to the ACS URL along with
+// the relay state.
+func (sp *ServiceProvider) AuthnRequestURL(acsURL, requestID, relayState string) (string, error) {
+	request := &authnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                sp.now().UTC().Format(time.RFC3339),
+		Destination:                 sp.IdP.SSOURL.String(),
+		AssertionConsumerServiceURL: acsURL,
+		ProtocolBinding:             postBinding,
+		Issuer:                      sp.EntityID,
+	}
+	request.NameIDPolicy.AllowCreate = true
+	data, err := xml.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	u := *sp.IdP.SSOURL
+	params := u.Query()
+	params.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		params.Set("RelayState", relayState)
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+type response struct {
+	XMLName    xml.Name    `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	Assertions []assertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+}
+
+type assertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	Issuer  string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject struct {
+		NameID struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+		SubjectConfirmations []struct {
+			Method                  string `xml:"Method,attr"`
+			SubjectConfirmationData struct {
+				Recipient    string    `xml:"Recipient,attr"`
+				InResponseTo string    `xml:"InResponseTo,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+			} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions struct {
+		NotBefore            time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	AuthnStatements []struct {
+		SessionNotOnOrAfter time.Time `xml:"SessionNotOnOrAfter,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnStatement"`
+	AttributeStatements []struct {
+		Attributes []struct {
+			Name         string   `xml:"Name,attr"`
+			FriendlyName string   `xml:"FriendlyName,attr"`
+			Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Attribute"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement"`
+}
+
+// ParseResponse verifies the base64 encoded SAMLResponse posted to the ACS
+// URL in response to the AuthnRequest with the ID and returns its assertion.
+// Either the response or the assertion must be signed by the IdP. Only the
+// signed content is used.
+func (sp *ServiceProvider) ParseResponse(samlResponse, acsURL, requestID string) (*Assertion, error) {
+	data, err := decodeBase64(samlResponse)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse encoding: %v", err)
+	}
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !root.is(protocolNamespace, "Response") {
+		return nil, fmt.Errorf("expected a SAML Response, got %s", root.local)
+	}
+
+	// The response may not be signed, these checks do not replace the
+	// checks of the signed assertion
+	if destination := root.attr("Destination"); destination != "" && destination != acsURL {
+		return nil, fmt.Errorf("response destination %q is not the ACS URL", destination)
+	}
+	if root.attr("InResponseTo") != requestID {
+		return nil, errors.New("response is not in response to the AuthnRequest")
+	}
+	if err := checkStatus(root); err != nil {
+		return nil, err
+	}
+	if len(root.childElements(assertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertionElement, err := root.childElement(assertionNamespace, "Assertion")
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := sp.verifiedAssertion(root, assertionElement)
+	if err != nil {
+		return nil, err
+	}
+	return sp.checkAssertion(a, acsURL, requestID)
+}
+
+// checkStatus checks the status code of the response is success
+func checkStatus(root *element) error {
+	status, err := root.childElement(protocolNamespace, "Status")
+	if err != nil {
+		return err
+	}
+	code, err := status.childElement(protocolNamespace, "StatusCode")
+	if err != nil {
+		return err
+	}
+	if code.attr("Value") != statusSuccess {
+		return fmt.Errorf("IdP returned status %q", code.attr("Value"))
+	}
+	return nil
+}
+
+// verifiedAssertion verifies the signature of the response or, if the
+// response is not signed, of the assertion and decodes the assertion from the
+// signed content
+func (sp *ServiceProvider) verifiedAssertion(root *element, assertionElement *element) (*assertion, error) {
+	signature, err := signatureOf(root)
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		signed, err := verifySignature(root, signature, sp.IdP.Certificates)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response signature: %v", err)
+		}
+		r := &response{}
+		if err := xml.Unmarshal(signed, r); err != nil {
+			return nil, fmt.Errorf("error decoding response: %v", err)
+		}
+		if len(r.Assertions) != 1 {
+			return nil, fmt.Errorf("expected exactly one assertion, found %d", len(r.Assertions))
+		}
+		return &r.Assertions[0], nil
+	}
+
+	signature, err = signatureOf(assertionElement)
+	if err != nil {
+		return nil, err
+	}
+	if signature == nil {
+		return nil, errors.New("neither the response nor the assertion is signed")
+	}
+	signed, err := verifySignature(assertionElement, signature, sp.IdP.Certificates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion signature: %v", err)
+	}
+	a := &assertion{}
+	if err := xml.Unmarshal(signed, a); err != nil {
+		return nil, fmt.Errorf("error decoding assertion: %v", err)
+	}
+	return a, nil
+}
+
+// checkAssertion checks the issuer, subject confirmation, conditions and
+// audience of the assertion
+func (sp *ServiceProvider) checkAssertion(a *assertion, acsURL, requestID string) (*Assertion, error) {
+	now := sp.now()
+
+	if a.Issuer != sp.IdP.EntityID {
+		return nil, fmt.Errorf("assertion issuer %q is not the IdP", a.Issuer)
+	}
+
+	confirmed := false
+	for _, confirmation := range a.Subject.SubjectConfirmations {
+		data := confirmation.SubjectConfirmationData
+		if confirmation.Method == bearerMethod &&
+			data.Recipient == acsURL &&
+			data.InResponseTo == requestID &&
+			now.Add(-maxClockSkew).Before(data.NotOnOrAfter) {
+			confirmed = true
+			break
+		}
+	}
+	if !confirmed {
+		return nil, errors.New("assertion does not have a valid bearer subject confirmation")
+	}
+
+	if !a.Conditions.NotBefore.IsZero() && now.Add(maxClockSkew).Before(a.Conditions.NotBefore) {
+		return nil, errors.New("assertion is not valid yet")
+	}
+	if !a.Conditions.NotOnOrAfter.IsZero() && !now.Add(-maxClockSkew).Before(a.Conditions.NotOnOrAfter) {
+		return nil, errors.New("assertion has expired")
+	}
+	if len(a.Conditions.AudienceRestrictions) == 0 {
+		return nil, errors.New("assertion does not have an audience restriction")
+	}
+	for _, restriction := range a.Conditions.AudienceRestrictions {
+		if !contains(restriction.Audiences, sp.EntityID) {
+			return nil, fmt.Errorf("assertion audience is not %q", sp.EntityID)
+		}
+	}
+
+	result := &Assertion{
+		NameID:       a.Subject.NameID.Value,
+		NameIDFormat: a.Subject.NameID.Format,
+		Attributes:   map[string][]string{},
+	}
+	for _, statement := range a.AuthnStatements {
+		if !statement.SessionNotOnOrAfter.IsZero() {
+			sessionNotOnOrAfter := statement.SessionNotOnOrAfter
+			result.SessionNotOnOrAfter = &sessionNotOnOrAfter
+		}
+	}
+	for _, statement := range a.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			values := make([]string, 0, len(attr.Values))
+			for _, value := range attr.Values {
+				values = append(values, strings.TrimSpace(value))
+			}
+			result.Attributes[attr.Name] = append(result.Attributes[attr.Name], values...)
+			if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+				result.Attributes[attr.FriendlyName] = append(result.Attributes[attr.FriendlyName], values...)
+			}
+		}
+	}
+	return result, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
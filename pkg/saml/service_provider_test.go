@@ -0,0 +1,318 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testEntityID = "oauth2-proxy"
+	testACSURL   = "https://proxy.example.com/oauth2/callback"
+)
+
+func newTestServiceProvider(t *testing.T) *ServiceProvider {
+	idp, err := LoadIdPMetadata("testdata/idp_metadata.xml")
+	assert.NoError(t, err)
+	return NewServiceProvider(testEntityID, idp)
+}
+
+// readResponse reads a response fixture, applying the replacements to it
+// before encoding it as it would be posted by the IdP
+func readResponse(t *testing.T, name string, replacements ...string) string {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	assert.NoError(t, err)
+	response := strings.NewReplacer(replacements...).Replace(string(data))
+	return base64.StdEncoding.EncodeToString([]byte(response))
+}
+
+// newUntrustedCertificate creates a self signed certificate for a key the
+// fixtures are not signed with
+func newUntrustedCertificate(t *testing.T) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestAuthnRequestURL(t *testing.T) {
+	sp := newTestServiceProvider(t)
+	sp.now = func() time.Time { return time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC) }
+
+	loginURL, err := sp.AuthnRequestURL(testACSURL, "id-request1", "nonce:/app")
+	assert.NoError(t, err)
+
+	u, err := url.Parse(loginURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "idp.example.com", u.Host)
+	assert.Equal(t, "/sso/redirect", u.Path)
+	params := u.Query()
+	assert.Equal(t, "example", params.Get("tenant"))
+	assert.Equal(t, "nonce:/app", params.Get("RelayState"))
+
+	deflated, err := base64.StdEncoding.DecodeString(params.Get("SAMLRequest"))
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	assert.NoError(t, err)
+
+	request := &authnRequest{}
+	assert.NoError(t, xml.Unmarshal(data, request))
+	assert.Equal(t, "id-request1", request.ID)
+	assert.Equal(t, "2.0", request.Version)
+	assert.Equal(t, "2020-10-01T12:00:00Z", request.IssueInstant)
+	assert.Equal(t, "https://idp.example.com/sso/redirect?tenant=example", request.Destination)
+	assert.Equal(t, testACSURL, request.AssertionConsumerServiceURL)
+	assert.Equal(t, postBinding, request.ProtocolBinding)
+	assert.Equal(t, testEntityID, request.Issuer)
+}
+
+func TestParseResponseSignedAssertion(t *testing.T) {
+	sp := newTestServiceProvider(t)
+
+	a, err := sp.ParseResponse(readResponse(t, "response_signed_assertion.xml"), testACSURL, "id-request1")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", a.NameID)
+	assert.Equal(t, "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress", a.NameIDFormat)
+	assert.Equal(t, time.Date(2120, 10, 1, 8, 0, 0, 0, time.UTC), *a.SessionNotOnOrAfter)
+	assert.Equal(t, map[string][]string{
+		"urn:oid:0.9.2342.19200300.100.1.3": {"jane.doe@example.com"},
+		"mail":                              {"jane.doe@example.com"},
+		"uid":                               {"jdoe"},
+		"groups":                            {"admins", "developers & testers"},
+	}, a.Attributes)
+}
+
+func TestParseResponseSignedResponse(t *testing.T) {
+	sp := newTestServiceProvider(t)
+
+	a, err := sp.ParseResponse(readResponse(t, "response_signed.xml"), testACSURL, "id-request2")
+	assert.NoError(t, err)
+	assert.Equal(t, "a1b2c3", a.NameID)
+	assert.Nil(t, a.SessionNotOnOrAfter)
+	assert.Equal(t, map[string][]string{"email": {"john.doe@example.com"}}, a.Attributes)
+}
+
+func TestParseResponseInvalid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		fixture       string
+		replacements  []string
+		requestID     string
+		now           time.Time
+		untrusted     bool
+		expectedError string
+	}{
+		{
+			name:          "With a tampered signed assertion",
+			fixture:       "response_signed_assertion.xml",
+			replacements:  []string{">jane.doe@example.com</saml:NameID>", ">admin@example.com</saml:NameID>"},
+			requestID:     "id-request1",
+			expectedError: "invalid assertion signature: digest of Assertion does not match the signature",
+		},
+		{
+			name:          "With a tampered signed response",
+			fixture:       "response_signed.xml",
+			replacements:  []string{"john.doe@example.com", "admin@example.com"},
+			requestID:     "id-request2",
+			expectedError: "invalid response signature: digest of Response does not match the signature",
+		},
+		{
+			name:          "With an untrusted signing key",
+			fixture:       "response_signed_assertion.xml",
+			requestID:     "id-request1",
+			untrusted:     true,
+			expectedError: "invalid assertion signature: signature was not made by any of the IdP certificates",
+		},
+		{
+			name:          "With a different request ID",
+			fixture:       "response_signed_assertion.xml",
+			requestID:     "id-request2",
+			expectedError: "response is not in response to the AuthnRequest",
+		},
+		{
+			name:          "With a different request ID in the unsigned response",
+			fixture:       "response_signed_assertion.xml",
+			replacements:  []string{`Destination="https://proxy.example.com/oauth2/callback" InResponseTo="id-request1"`, `Destination="https://proxy.example.com/oauth2/callback" InResponseTo="id-request2"`},
+			requestID:     "id-request2",
+			expectedError: "assertion does not have a valid bearer subject confirmation",
+		},
+		{
+			name:          "With a different destination",
+			fixture:       "response_signed_assertion.xml",
+			replacements:  []string{`Destination="https://proxy.example.com/oauth2/callback"`, `Destination="https://other.example.com/oauth2/callback"`},
+			requestID:     "id-request1",
+			expectedError: `response destination "https://other.example.com/oauth2/callback" is not the ACS URL`,
+		},
+		{
+			name:          "With an expired assertion",
+			fixture:       "response_signed_assertion.xml",
+			requestID:     "id-request1",
+			now:           time.Date(2121, 1, 1, 0, 0, 0, 0, time.UTC),
+			expectedError: "assertion does not have a valid bearer subject confirmation",
+		},
+		{
+			name:          "With an assertion that is not valid yet",
+			fixture:       "response_signed_assertion.xml",
+			requestID:     "id-request1",
+			now:           time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC),
+			expectedError: "assertion is not valid yet",
+		},
+		{
+			name:          "With a failed status",
+			fixture:       "response_signed_assertion.xml",
+			replacements:  []string{"status:Success", "status:Requester"},
+			requestID:     "id-request1",
+			expectedError: `IdP returned status "urn:oasis:names:tc:SAML:2.0:status:Requester"`,
+		},
+		{
+			name:          "Without a signature",
+			fixture:       "response_signed_assertion.xml",
+			replacements:  []string{"<ds:Signature ", "<ds:NotASignature ", "</ds:Signature>", "</ds:NotASignature>"},
+			requestID:     "id-request1",
+			expectedError: "neither the response nor the assertion is signed",
+		},
+		{
+			name:          "With an unsigned assertion added to the signed response",
+			fixture:       "response_signed.xml",
+			replacements:  []string{"</samlp:Response>", `<Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion"><Issuer>https://idp.example.com/metadata</Issuer></Assertion></samlp:Response>`},
+			requestID:     "id-request2",
+			expectedError: "expected exactly one Assertion element in Response, found 2",
+		},
+		{
+			name:          "With a document type declaration",
+			fixture:       "response_signed.xml",
+			replacements:  []string{`<?xml version="1.0" encoding="UTF-8"?>`, `<!DOCTYPE Response [<!ENTITY name "admin@example.com">]>`},
+			requestID:     "id-request2",
+			expectedError: "error parsing XML: DTDs are not allowed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sp := newTestServiceProvider(t)
+			if !tc.now.IsZero() {
+				sp.now = func() time.Time { return tc.now }
+			}
+			if tc.untrusted {
+				sp.IdP.Certificates = []*x509.Certificate{newUntrustedCertificate(t)}
+			}
+
+			_, err := sp.ParseResponse(readResponse(t, tc.fixture, tc.replacements...), testACSURL, tc.requestID)
+			assert.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestParseResponseAudience(t *testing.T) {
+	sp := newTestServiceProvider(t)
+	sp.EntityID = "other-sp"
+
+	_, err := sp.ParseResponse(readResponse(t, "response_signed_assertion.xml"), testACSURL, "id-request1")
+	assert.EqualError(t, err, `assertion audience is not "other-sp"`)
+}
+
+// TestParseResponseRealIdPs verifies responses signed by real IdPs, their
+// canonicalization differs from the fixtures signed for these tests
+func TestParseResponseRealIdPs(t *testing.T) {
+	testCases := []struct {
+		name           string
+		metadata       string
+		fixture        string
+		entityID       string
+		acsURL         string
+		requestID      string
+		now            time.Time
+		expectedNameID string
+		expectedAttrs  map[string][]string
+	}{
+		{
+			name:           "Google Workspace with a signed assertion",
+			metadata:       "google_idp_metadata.xml",
+			fixture:        "google_response.xml",
+			entityID:       "https://29ee6d2e.ngrok.io/saml/metadata",
+			acsURL:         "https://29ee6d2e.ngrok.io/saml/acs",
+			requestID:      "id-fd419a5ab0472645427f8e07d87a3a5dd0b2e9a6",
+			now:            time.Date(2016, 1, 5, 16, 55, 39, 0, time.UTC),
+			expectedNameID: "ross@octolabs.io",
+			expectedAttrs: map[string][]string{
+				"firstName": {"Ross"},
+				"lastName":  {"Kinder"},
+				"phone":     nil,
+				"address":   nil,
+				"jobTitle":  nil,
+			},
+		},
+		{
+			name:           "Okta with a signed response and assertion",
+			metadata:       "okta_idp_metadata.xml",
+			fixture:        "okta_response.xml",
+			entityID:       "https://dev.sudo.wtf:8443/v1/teams/asa",
+			acsURL:         "https://dev.sudo.wtf:8443/v1/_saml_callback",
+			requestID:      "_ffea96b1-44a2-4a86-9683-45807984ab5b",
+			now:            time.Date(2020, 9, 1, 17, 51, 12, 0, time.UTC),
+			expectedNameID: "phoebe.yu@okta.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp, err := LoadIdPMetadata("testdata/" + tc.metadata)
+			assert.NoError(t, err)
+			sp := NewServiceProvider(tc.entityID, idp)
+			sp.now = func() time.Time { return tc.now }
+
+			a, err := sp.ParseResponse(readResponse(t, tc.fixture), tc.acsURL, tc.requestID)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedNameID, a.NameID)
+			if tc.expectedAttrs != nil {
+				assert.Equal(t, tc.expectedAttrs, a.Attributes)
+			}
+
+			// Comments are not part of the canonical form so they do not
+			// break the signature, but they must not truncate the NameID
+			// either
+			user := strings.SplitN(tc.expectedNameID, "@", 2)
+			commented := readResponse(t, tc.fixture, ">"+tc.expectedNameID+"<", ">"+user[0]+"@<!-- comment -->"+user[1]+"<")
+			a, err = sp.ParseResponse(commented, tc.acsURL, tc.requestID)
+			if err == nil {
+				assert.Equal(t, tc.expectedNameID, a.NameID)
+			}
+
+			tampered := readResponse(t, tc.fixture, ">"+tc.expectedNameID+"<", ">admin@"+user[1]+"<")
+			_, err = sp.ParseResponse(tampered, tc.acsURL, tc.requestID)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseResponseSHA1(t *testing.T) {
+	idp, err := LoadIdPMetadata("testdata/onelogin_idp_metadata.xml")
+	assert.NoError(t, err)
+	sp := NewServiceProvider("https://29ee6d2e.ngrok.io/saml/metadata", idp)
+	sp.now = func() time.Time { return time.Date(2016, 1, 5, 17, 53, 11, 0, time.UTC) }
+
+	_, err = sp.ParseResponse(readResponse(t, "onelogin_response.xml"), "https://29ee6d2e.ngrok.io/saml/acs", "id-d40c15c104b52691eccf0a2a5c8a15595be75423")
+	assert.EqualError(t, err, `invalid response signature: unsupported signature method "http://www.w3.org/2000/09/xmldsig#rsa-sha1"`)
+}
@@ -0,0 +1,178 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	// Register the hash functions of the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	dsigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+	excC14NAlgorithm            = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	envelopedSignatureAlgorithm = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+)
+
+// signatureMethods are the supported signature algorithms. SHA-1 is not
+// supported.
+var signatureMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512": crypto.SHA512,
+}
+
+// digestMethods are the supported digest algorithms
+var digestMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+// signatureOf returns the enveloped signature of the element, or nil if the
+// element is not signed
+func signatureOf(e *element) (*element, error) {
+	signatures := e.childElements(dsigNamespace, "Signature")
+	switch len(signatures) {
+	case 0:
+		return nil, nil
+	case 1:
+		return signatures[0], nil
+	default:
+		return nil, fmt.Errorf("%s has more than one signature", e.local)
+	}
+}
+
+// verifySignature verifies the enveloped signature of the element with one
+// of the certificates. The signature must reference the element by its ID.
+// It returns the canonical form of the element without the signature, the
+// only form of the element that should be trusted.
+func verifySignature(e *element, signature *element, certificates []*x509.Certificate) ([]byte, error) {
+	signedInfo, err := signature.childElement(dsigNamespace, "SignedInfo")
+	if err != nil {
+		return nil, err
+	}
+
+	c14nMethod, err := signedInfo.childElement(dsigNamespace, "CanonicalizationMethod")
+	if err != nil {
+		return nil, err
+	}
+	if c14nMethod.attr("Algorithm") != excC14NAlgorithm {
+		return nil, fmt.Errorf("unsupported canonicalization method %q", c14nMethod.attr("Algorithm"))
+	}
+	signatureMethod, err := signedInfo.childElement(dsigNamespace, "SignatureMethod")
+	if err != nil {
+		return nil, err
+	}
+	signatureHash, ok := signatureMethods[signatureMethod.attr("Algorithm")]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature method %q", signatureMethod.attr("Algorithm"))
+	}
+
+	canonical, err := verifyReference(e, signature, signedInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureValue, err := signature.childElement(dsigNamespace, "SignatureValue")
+	if err != nil {
+		return nil, err
+	}
+	signatureBytes, err := decodeBase64(signatureValue.text())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature value: %v", err)
+	}
+
+	canonicalSignedInfo, err := canonicalize(signedInfo, nil, inclusivePrefixes(c14nMethod))
+	if err != nil {
+		return nil, err
+	}
+	h := signatureHash.New()
+	h.Write(canonicalSignedInfo)
+	hashed := h.Sum(nil)
+
+	for _, cert := range certificates {
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(key, signatureHash, hashed, signatureBytes) == nil {
+			return canonical, nil
+		}
+	}
+	return nil, errors.New("signature was not made by any of the IdP certificates")
+}
+
+// verifyReference checks the signed info has a single reference to the
+// element and that the digest of the element matches it
+func verifyReference(e *element, signature *element, signedInfo *element) ([]byte, error) {
+	reference, err := signedInfo.childElement(dsigNamespace, "Reference")
+	if err != nil {
+		return nil, err
+	}
+	id := e.attr("ID")
+	if id == "" || reference.attr("URI") != "#"+id {
+		return nil, fmt.Errorf("signature reference %q does not match the %s ID %q", reference.attr("URI"), e.local, id)
+	}
+
+	transforms, err := reference.childElement(dsigNamespace, "Transforms")
+	if err != nil {
+		return nil, err
+	}
+	transformList := transforms.childElements(dsigNamespace, "Transform")
+	if len(transformList) != 2 ||
+		transformList[0].attr("Algorithm") != envelopedSignatureAlgorithm ||
+		transformList[1].attr("Algorithm") != excC14NAlgorithm {
+		return nil, errors.New("signature transforms must be the enveloped signature and exclusive canonicalization")
+	}
+
+	digestMethod, err := reference.childElement(dsigNamespace, "DigestMethod")
+	if err != nil {
+		return nil, err
+	}
+	digestHash, ok := digestMethods[digestMethod.attr("Algorithm")]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest method %q", digestMethod.attr("Algorithm"))
+	}
+	digestValue, err := reference.childElement(dsigNamespace, "DigestValue")
+	if err != nil {
+		return nil, err
+	}
+	expected, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest value: %v", err)
+	}
+
+	canonical, err := canonicalize(e, signature, inclusivePrefixes(transformList[1]))
+	if err != nil {
+		return nil, err
+	}
+	h := digestHash.New()
+	h.Write(canonical)
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return nil, fmt.Errorf("digest of %s does not match the signature", e.local)
+	}
+	return canonical, nil
+}
+
+// inclusivePrefixes returns the InclusiveNamespaces PrefixList of an
+// exclusive canonicalization method or transform
+func inclusivePrefixes(method *element) []string {
+	for _, child := range method.children {
+		if c, ok := child.(*element); ok && c.is(excC14NAlgorithm, "InclusiveNamespaces") {
+			return strings.Fields(c.attr("PrefixList"))
+		}
+	}
+	return nil
+}
+
+// decodeBase64 decodes base64 that may be split over lines
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
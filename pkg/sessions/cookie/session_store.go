@@ -31,6 +31,10 @@ type SessionStore struct {
 	Cookie       *options.Cookie
 	CookieCipher encryption.Cipher
 	Minimal      bool
+
+	// PreviousCookieCiphers are made from the previous cookie secrets, in the
+	// same order, and are only used to decrypt sessions
+	PreviousCookieCiphers []encryption.Cipher
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -56,15 +60,21 @@ func (s *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
 		// always http.ErrNoCookie
 		return nil, fmt.Errorf("cookie %q not present", s.Cookie.Name)
 	}
-	val, _, ok := encryption.Validate(c, s.Cookie.Secret, s.Cookie.Expire)
+	val, _, secretIndex, ok := encryption.ValidateWithSeeds(c, s.Cookie.Secrets(), s.Cookie.Expire)
 	if !ok {
 		return nil, errors.New("cookie signature not valid")
 	}
 
-	session, err := sessionFromCookie(val, s.CookieCipher)
+	// The session was encrypted with the cipher of the secret that signed it
+	cipher := s.CookieCipher
+	if secretIndex > 0 {
+		cipher = s.PreviousCookieCiphers[secretIndex-1]
+	}
+	session, err := sessionFromCookie(val, cipher)
 	if err != nil {
 		return nil, err
 	}
+	session.LoadedWithPreviousSecret = secretIndex > 0
 	return session, nil
 }
 
@@ -148,18 +158,58 @@ func (s *SessionStore) makeCookie(req *http.Request, name string, value string,
 // NewCookieSessionStore initialises a new instance of the SessionStore from
 // the configuration given
 func NewCookieSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
-	cipher, err := encryption.NewCFBCipher(encryption.SecretBytes(cookieOpts.Secret))
+	keyring := opts.Cookie.GetKeyring()
+	cipher, err := newCookieCipher(cookieOpts.Secret, keyring, opts.Cookie.KeyringMigration)
 	if err != nil {
 		return nil, fmt.Errorf("error initialising cipher: %v", err)
 	}
 
+	previousCiphers := make([]encryption.Cipher, 0, len(cookieOpts.PreviousSecrets))
+	for _, secret := range cookieOpts.PreviousSecrets {
+		previousCipher, err := newCookieCipher(secret, keyring, opts.Cookie.KeyringMigration)
+		if err != nil {
+			return nil, fmt.Errorf("error initialising cipher for previous cookie secret: %v", err)
+		}
+		previousCiphers = append(previousCiphers, previousCipher)
+	}
+
 	return &SessionStore{
-		CookieCipher: cipher,
-		Cookie:       cookieOpts,
-		Minimal:      opts.Cookie.Minimal,
+		CookieCipher:          cipher,
+		Cookie:                cookieOpts,
+		Minimal:               opts.Cookie.Minimal,
+		PreviousCookieCiphers: previousCiphers,
 	}, nil
 }
 
+// newCookieCipher creates the cipher for sessions encrypted with the cookie
+// secret. Sessions are encrypted with XChaCha20-Poly1305 using a key derived
+// from the secret, sessions encrypted with AES-CFB using the secret itself
+// can still be decrypted.
+// When there is a keyring, sessions are encrypted with data keys wrapped by
+// the keyring instead. Sessions encrypted with the secret can then only be
+// decrypted while migrating to the keyring.
+func newCookieCipher(secret string, keyring *encryption.Keyring, migration bool) (encryption.Cipher, error) {
+	if keyring != nil && !migration {
+		return encryption.NewVersionedCipher(encryption.EnvelopeVersion, encryption.NewEnvelopeCipher(keyring), nil), nil
+	}
+
+	secretBytes := encryption.SecretBytes(secret)
+	legacyCipher, err := encryption.NewCFBCipher(secretBytes)
+	if err != nil {
+		return nil, err
+	}
+	xChaChaCipher, err := encryption.NewXChaCha20Poly1305Cipher(
+		encryption.DeriveKey(secretBytes, encryption.SessionEncryptionKey))
+	if err != nil {
+		return nil, err
+	}
+	secretCipher := encryption.NewVersionedCipher(encryption.XChaCha20Poly1305Version, xChaChaCipher, legacyCipher)
+	if keyring == nil {
+		return secretCipher, nil
+	}
+	return encryption.NewVersionedCipher(encryption.EnvelopeVersion, encryption.NewEnvelopeCipher(keyring), secretCipher), nil
+}
+
 // splitCookie reads the full cookie generated to store the session and splits
 // it into a slice of cookies which fit within the 4kb cookie limit indexing
 // the cookies from 0
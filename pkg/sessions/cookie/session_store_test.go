@@ -2,14 +2,18 @@ package cookie
 
 import (
 	"fmt"
+	"io/ioutil"
 	mathrand "math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
@@ -160,3 +164,79 @@ func Test_splitCookie_joinCookies(t *testing.T) {
 		})
 	}
 }
+
+func Test_newCookieCipher(t *testing.T) {
+	const secret = "0123456789abcdefghijklmnopqrstuv"
+	value := []byte("my_session_value")
+
+	c, err := newCookieCipher(secret, nil, false)
+	assert.NoError(t, err)
+
+	encrypted, err := c.Encrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, encryption.XChaCha20Poly1305Version, encrypted[0])
+
+	decrypted, err := c.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+
+	legacyCipher, err := encryption.NewCFBCipher(encryption.SecretBytes(secret))
+	assert.NoError(t, err)
+	legacyEncrypted, err := legacyCipher.Encrypt(value)
+	assert.NoError(t, err)
+
+	decrypted, err = c.Decrypt(legacyEncrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+}
+
+func Test_newCookieCipherWithKeyring(t *testing.T) {
+	const secret = "0123456789abcdefghijklmnopqrstuv"
+	value := []byte("my_session_value")
+
+	dir, err := ioutil.TempDir("", "oauth2-proxy-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyringFile := filepath.Join(dir, "keyring")
+	err = ioutil.WriteFile(keyringFile, []byte("key-1,vutsrqponmlkjihgfedcba9876543210\n"), 0600)
+	assert.NoError(t, err)
+	keyring, err := encryption.NewKeyring(keyringFile)
+	assert.NoError(t, err)
+
+	secretCipher, err := newCookieCipher(secret, nil, false)
+	assert.NoError(t, err)
+	secretEncrypted, err := secretCipher.Encrypt(value)
+	assert.NoError(t, err)
+	legacyCipher, err := encryption.NewCFBCipher(encryption.SecretBytes(secret))
+	assert.NoError(t, err)
+	legacyEncrypted, err := legacyCipher.Encrypt(value)
+	assert.NoError(t, err)
+
+	for _, migration := range []bool{false, true} {
+		t.Run(fmt.Sprintf("migration %t", migration), func(t *testing.T) {
+			c, err := newCookieCipher(secret, keyring, migration)
+			assert.NoError(t, err)
+
+			encrypted, err := c.Encrypt(value)
+			assert.NoError(t, err)
+			assert.Equal(t, encryption.EnvelopeVersion, encrypted[0])
+			assert.Equal(t, "key-1", string(encrypted[2:7]))
+
+			decrypted, err := c.Decrypt(encrypted)
+			assert.NoError(t, err)
+			assert.Equal(t, value, decrypted)
+
+			// Sessions encrypted with the cookie secret can only be decrypted
+			// while migrating to the keyring
+			for _, ciphertext := range [][]byte{secretEncrypted, legacyEncrypted} {
+				decrypted, err = c.Decrypt(ciphertext)
+				if migration {
+					assert.NoError(t, err)
+					assert.Equal(t, value, decrypted)
+				} else {
+					assert.Error(t, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+)
+
+const (
+	// entrySuffix is added to the name of every file holding an entry so
+	// that temporary files and unrelated files are ignored by the sweeper
+	entrySuffix = ".session"
+
+	// expiryHeaderLength is the length of the expiry time written at the
+	// start of every entry, as big endian Unix nanoseconds
+	expiryHeaderLength = 8
+)
+
+// SessionStore is an implementation of the persistence.Store
+// interface that stores sessions in files on the local disk.
+// Every key is stored in its own file, prefixed with its expiry time.
+type SessionStore struct {
+	Path string
+
+	// now returns the current time, it allows tests to fast forward time
+	now func() time.Time
+
+	lock sync.RWMutex
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewFileSessionStore initialises a new instance of the SessionStore and wraps
+// it in a persistence.Manager
+func NewFileSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	fs, err := newSessionStore(opts.File)
+	if err != nil {
+		return nil, err
+	}
+	go fs.sweep(opts.File.SweepInterval)
+
+	return persistence.NewManager(fs, opts, cookieOpts), nil
+}
+
+// newSessionStore creates the session directory, if needed, and a
+// SessionStore using it
+func newSessionStore(opts options.FileStoreOptions) (*SessionStore, error) {
+	if opts.Path == "" {
+		return nil, errors.New("a path is required for the file session store")
+	}
+	err := os.MkdirAll(opts.Path, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the session directory: %v", err)
+	}
+
+	return &SessionStore{
+		Path: opts.Path,
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// Save writes the value to the file for the key along with the expiry time.
+// The file is replaced atomically so concurrent readers never see partial
+// entries.
+func (store *SessionStore) Save(_ context.Context, key string, value []byte, exp time.Duration) error {
+	entry := make([]byte, expiryHeaderLength, expiryHeaderLength+len(value))
+	binary.BigEndian.PutUint64(entry, uint64(store.now().Add(exp).UnixNano()))
+	entry = append(entry, value...)
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	tmp, err := ioutil.TempFile(store.Path, "tmp-")
+	if err != nil {
+		return fmt.Errorf("error saving file session: %v", err)
+	}
+	_, err = tmp.Write(entry)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), store.entryPath(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving file session: %v", err)
+	}
+	return nil
+}
+
+// Load reads the value stored for the key, treating expired entries as
+// missing
+func (store *SessionStore) Load(_ context.Context, key string) ([]byte, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	entry, err := ioutil.ReadFile(store.entryPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("error loading file session: %v", err)
+	}
+	if len(entry) < expiryHeaderLength {
+		return nil, errors.New("error loading file session: entry is corrupt")
+	}
+	if store.expired(entry) {
+		return nil, fmt.Errorf("error loading file session: key not found: %s", key)
+	}
+	return entry[expiryHeaderLength:], nil
+}
+
+// Clear removes the file for the key
+func (store *SessionStore) Clear(_ context.Context, key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	err := os.Remove(store.entryPath(key))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error clearing the session from disk: %v", err)
+	}
+	return nil
+}
+
+// Close stops the background expiry sweeper
+func (store *SessionStore) Close() {
+	store.once.Do(func() {
+		close(store.stop)
+	})
+}
+
+// sweep removes expired entries from the disk every interval until the
+// store is closed
+func (store *SessionStore) sweep(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			err := store.removeExpired()
+			if err != nil {
+				logger.Printf("Error removing expired file sessions: %v", err)
+			}
+		}
+	}
+}
+
+// removeExpired removes every entry that has passed its expiry time.
+// Entries are checked without the lock, as they are replaced atomically,
+// so that the sweep does not block requests. The lock is only held to
+// remove each expired entry.
+func (store *SessionStore) removeExpired() error {
+	names, err := filepath.Glob(filepath.Join(store.Path, "*"+entrySuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		expired, err := store.entryExpired(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if !expired {
+			continue
+		}
+		err = store.removeIfExpired(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIfExpired removes an entry under the lock after checking it was not
+// saved again since it was found to be expired
+func (store *SessionStore) removeIfExpired(name string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	expired, err := store.entryExpired(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !expired {
+		return nil
+	}
+	err = os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// entryExpired reads the expiry time of an entry without loading its value.
+// Corrupt entries are treated as expired.
+func (store *SessionStore) entryExpired(name string) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, expiryHeaderLength)
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return true, nil
+	}
+	return store.expired(header), nil
+}
+
+// expired checks the expiry time at the start of an entry
+func (store *SessionStore) expired(entry []byte) bool {
+	expiry := time.Unix(0, int64(binary.BigEndian.Uint64(entry[:expiryHeaderLength])))
+	return !store.now().Before(expiry)
+}
+
+// entryPath builds the file path for a key. Keys are hashed so that any
+// key results in a safe file name of a fixed length.
+func (store *SessionStore) entryPath(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(store.Path, hex.EncodeToString(hash[:])+entrySuffix)
+}
@@ -0,0 +1,122 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSessionStore(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "File SessionStore")
+}
+
+var _ = Describe("File SessionStore Tests", func() {
+	var dir string
+	var elapsed time.Duration
+	var stores []*SessionStore
+
+	now := func() time.Time {
+		return time.Now().Add(elapsed)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oauth2-proxy-sessions")
+		Expect(err).ToNot(HaveOccurred())
+		elapsed = 0
+		stores = nil
+	})
+
+	AfterEach(func() {
+		for _, store := range stores {
+			store.Close()
+		}
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	tests.RunSessionStoreTests(
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			opts.Type = options.FileSessionStoreType
+			opts.File.Path = dir
+			opts.File.SweepInterval = time.Minute
+
+			ss, err := NewFileSessionStore(opts, cookieOpts)
+			if err != nil {
+				return nil, err
+			}
+			// Allow the tests to fast forward the expiry of sessions
+			store := ss.(*persistence.Manager).Store.(*SessionStore)
+			store.now = now
+			stores = append(stores, store)
+			return ss, nil
+		},
+		func(d time.Duration) error {
+			elapsed += d
+			return nil
+		},
+	)
+
+	Context("without a path", func() {
+		It("returns an error", func() {
+			_, err := NewFileSessionStore(&options.SessionOptions{}, &options.Cookie{})
+			Expect(err).To(MatchError("a path is required for the file session store"))
+		})
+	})
+
+	Context("removeExpired", func() {
+		var store *SessionStore
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			var err error
+			store, err = newSessionStore(options.FileStoreOptions{Path: dir})
+			Expect(err).ToNot(HaveOccurred())
+			store.now = now
+
+			Expect(store.Save(ctx, "short", []byte("short lived"), time.Minute)).To(Succeed())
+			Expect(store.Save(ctx, "long", []byte("long lived"), time.Hour)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "corrupt"+entrySuffix), []byte("c"), 0600)).To(Succeed())
+
+			elapsed = 2 * time.Minute
+			Expect(store.removeExpired()).To(Succeed())
+		})
+
+		It("removes expired entries", func() {
+			_, err := os.Stat(store.entryPath("short"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("removes corrupt entries", func() {
+			_, err := os.Stat(filepath.Join(dir, "corrupt"+entrySuffix))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("keeps entries that have not expired", func() {
+			value, err := store.Load(ctx, "long")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal([]byte("long lived")))
+		})
+
+		It("keeps entries saved again after they were found to be expired", func() {
+			Expect(store.Save(ctx, "short", []byte("saved again"), time.Minute)).To(Succeed())
+			Expect(store.removeIfExpired(store.entryPath("short"))).To(Succeed())
+
+			value, err := store.Load(ctx, "short")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal([]byte("saved again")))
+		})
+	})
+})
@@ -0,0 +1,289 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+)
+
+// Stats reports the size of the SessionStore and how many entries have been
+// removed from it since it was created
+type Stats struct {
+	// Entries is the number of entries currently held, including indexes and
+	// entries that have expired but have not been removed yet
+	Entries int
+
+	// MaxEntries is the number of entries held before evicting
+	MaxEntries int
+
+	// Indexes is the number of the entries held that are indexes
+	Indexes int
+
+	// Evictions counts the entries removed to make room for new entries
+	Evictions uint64
+
+	// Expirations counts the expired entries removed
+	Expirations uint64
+}
+
+// String constructs a summary of the stats for logging
+func (s Stats) String() string {
+	return fmt.Sprintf("entries:%d/%d indexes:%d evictions:%d expirations:%d", s.Entries, s.MaxEntries, s.Indexes, s.Evictions, s.Expirations)
+}
+
+// entry is a SessionStore value with its expiry time
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// Ensure SessionStore can list every user with a session
+var _ persistence.Lister = (*SessionStore)(nil)
+
+// SessionStore is an implementation of the persistence.Store
+// interface that stores sessions in memory.
+// It holds a bounded number of entries, indexes included, evicting the least
+// recently used entry when full. Using a session also uses the indexes that
+// list it, so an index is never evicted while it lists a session that is
+// still held, as the session could no longer be found. An index is removed
+// once none of the sessions it lists are held.
+type SessionStore struct {
+	maxEntries int
+
+	// now returns the current time, it allows tests to fast forward time
+	now func() time.Time
+
+	// indexedKeys reports whether a key holds an index rather than a session
+	// and returns the keys of the sessions listed by the index value
+	indexedKeys func(key string, value []byte) ([]string, bool)
+
+	lock        sync.Mutex
+	entries     map[string]*list.Element
+	lru         *list.List
+	evictions   uint64
+	expirations uint64
+
+	// indexed holds the keys of the sessions held that each index lists, and
+	// indexedBy the keys of the indexes that list each session
+	indexed   map[string]map[string]struct{}
+	indexedBy map[string]map[string]struct{}
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewMemorySessionStore initialises a new instance of the SessionStore and
+// wraps it in a persistence.Manager
+func NewMemorySessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	ms, err := newSessionStore(opts.Memory.MaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Memory.StatsInterval > 0 {
+		go ms.logStats(opts.Memory.StatsInterval)
+	}
+
+	manager := persistence.NewManager(ms, opts, cookieOpts)
+	ms.indexedKeys = manager.IndexedKeys
+	return manager, nil
+}
+
+// newSessionStore creates an empty SessionStore holding up to maxEntries
+func newSessionStore(maxEntries int) (*SessionStore, error) {
+	if maxEntries < 1 {
+		return nil, errors.New("the memory session store must allow at least 1 entry")
+	}
+
+	return &SessionStore{
+		maxEntries:  maxEntries,
+		now:         time.Now,
+		indexedKeys: func(string, []byte) ([]string, bool) { return nil, false },
+		entries:     map[string]*list.Element{},
+		lru:         list.New(),
+		indexed:     map[string]map[string]struct{}{},
+		indexedBy:   map[string]map[string]struct{}{},
+		stop:        make(chan struct{}),
+	}, nil
+}
+
+// Save stores a copy of the value for the key until it expires, evicting the
+// least recently used entries if the store is full
+func (store *SessionStore) Save(_ context.Context, key string, value []byte, exp time.Duration) error {
+	e := &entry{
+		key:     key,
+		value:   append([]byte(nil), value...),
+		expires: store.now().Add(exp),
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if elem, ok := store.entries[key]; ok {
+		elem.Value = e
+		store.touch(elem)
+	} else {
+		store.entries[key] = store.lru.PushFront(e)
+	}
+	if sessionKeys, ok := store.indexedKeys(key, e.value); ok {
+		store.linkIndex(key, sessionKeys)
+	}
+
+	for store.lru.Len() > store.maxEntries {
+		store.evictions += uint64(store.remove(store.lru.Back().Value.(*entry).key))
+	}
+	return nil
+}
+
+// Load returns a copy of the value stored for the key, treating expired
+// entries as missing
+func (store *SessionStore) Load(_ context.Context, key string) ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	elem, ok := store.entries[key]
+	if !ok {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	e := elem.Value.(*entry)
+	if !store.now().Before(e.expires) {
+		store.expirations += uint64(store.remove(key))
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	store.touch(elem)
+	return append([]byte(nil), e.value...), nil
+}
+
+// Clear removes the entry for the key
+func (store *SessionStore) Clear(_ context.Context, key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.remove(key)
+	return nil
+}
+
+// ListKeys returns every unexpired key starting with the prefix
+func (store *SessionStore) ListKeys(_ context.Context, prefix string) ([]string, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	now := store.now()
+	keys := []string{}
+	for key, elem := range store.entries {
+		if strings.HasPrefix(key, prefix) && now.Before(elem.Value.(*entry).expires) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Stats returns the current size and eviction stats of the store
+func (store *SessionStore) Stats() Stats {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return Stats{
+		Entries:     store.lru.Len(),
+		MaxEntries:  store.maxEntries,
+		Indexes:     len(store.indexed),
+		Evictions:   store.evictions,
+		Expirations: store.expirations,
+	}
+}
+
+// Close stops logging the stats of the store
+func (store *SessionStore) Close() {
+	store.once.Do(func() {
+		close(store.stop)
+	})
+}
+
+// touch marks the entry as the most recently used, followed by the indexes
+// that list it. The lock must be held.
+func (store *SessionStore) touch(elem *list.Element) {
+	store.lru.MoveToFront(elem)
+	for indexKey := range store.indexedBy[elem.Value.(*entry).key] {
+		store.lru.MoveToFront(store.entries[indexKey])
+	}
+}
+
+// linkIndex records the sessions held that the index lists, replacing those
+// it listed before. The lock must be held.
+func (store *SessionStore) linkIndex(indexKey string, sessionKeys []string) {
+	store.unlinkIndex(indexKey)
+
+	held := map[string]struct{}{}
+	for _, sessionKey := range sessionKeys {
+		if _, ok := store.entries[sessionKey]; !ok {
+			continue
+		}
+		held[sessionKey] = struct{}{}
+		if store.indexedBy[sessionKey] == nil {
+			store.indexedBy[sessionKey] = map[string]struct{}{}
+		}
+		store.indexedBy[sessionKey][indexKey] = struct{}{}
+	}
+	store.indexed[indexKey] = held
+}
+
+// unlinkIndex forgets the sessions listed by the index. The lock must be
+// held.
+func (store *SessionStore) unlinkIndex(indexKey string) {
+	for sessionKey := range store.indexed[indexKey] {
+		delete(store.indexedBy[sessionKey], indexKey)
+		if len(store.indexedBy[sessionKey]) == 0 {
+			delete(store.indexedBy, sessionKey)
+		}
+	}
+	delete(store.indexed, indexKey)
+}
+
+// remove deletes the entry for the key and returns the number of entries
+// removed. A session is dropped from the indexes that list it, and indexes
+// left without any sessions are removed with it. The lock must be held.
+func (store *SessionStore) remove(key string) int {
+	elem, ok := store.entries[key]
+	if !ok {
+		return 0
+	}
+	store.lru.Remove(elem)
+	delete(store.entries, key)
+	removed := 1
+
+	store.unlinkIndex(key)
+	for indexKey := range store.indexedBy[key] {
+		delete(store.indexed[indexKey], key)
+		if len(store.indexed[indexKey]) == 0 {
+			removed += store.remove(indexKey)
+		}
+	}
+	delete(store.indexedBy, key)
+	return removed
+}
+
+// logStats logs the stats of the store every interval until the store is
+// closed
+func (store *SessionStore) logStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			logger.Printf("Memory session store stats: %s", store.Stats())
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSessionStore(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memory SessionStore")
+}
+
+var _ = Describe("Memory SessionStore Tests", func() {
+	var elapsed time.Duration
+	var sharedStore *SessionStore
+
+	now := func() time.Time {
+		return time.Now().Add(elapsed)
+	}
+
+	BeforeEach(func() {
+		elapsed = 0
+
+		// Session stores created within a test share their entries, like
+		// the other stores share a server
+		var err error
+		sharedStore, err = newSessionStore(100)
+		Expect(err).ToNot(HaveOccurred())
+		// Allow the tests to fast forward the expiry of sessions
+		sharedStore.now = now
+	})
+
+	tests.RunSessionStoreTests(
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			opts.Type = options.MemorySessionStoreType
+			opts.Memory.MaxEntries = 100
+
+			manager := persistence.NewManager(sharedStore, opts, cookieOpts)
+			sharedStore.indexedKeys = manager.IndexedKeys
+			return manager, nil
+		},
+		func(d time.Duration) error {
+			elapsed += d
+			return nil
+		},
+	)
+
+	Context("without any entries allowed", func() {
+		It("returns an error", func() {
+			_, err := NewMemorySessionStore(&options.SessionOptions{}, &options.Cookie{})
+			Expect(err).To(MatchError("the memory session store must allow at least 1 entry"))
+		})
+	})
+
+	Context("when full", func() {
+		var store *SessionStore
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			var err error
+			store, err = newSessionStore(2)
+			Expect(err).ToNot(HaveOccurred())
+			store.now = now
+
+			Expect(store.Save(ctx, "first", []byte("first"), time.Hour)).To(Succeed())
+			Expect(store.Save(ctx, "second", []byte("second"), time.Hour)).To(Succeed())
+
+			// Loading the first entry makes the second the least recently used
+			_, err = store.Load(ctx, "first")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(store.Save(ctx, "third", []byte("third"), time.Hour)).To(Succeed())
+		})
+
+		It("evicts the least recently used entry", func() {
+			_, err := store.Load(ctx, "second")
+			Expect(err).To(MatchError("key not found: second"))
+		})
+
+		It("keeps the most recently used entries", func() {
+			Expect(store.Load(ctx, "first")).To(Equal([]byte("first")))
+			Expect(store.Load(ctx, "third")).To(Equal([]byte("third")))
+		})
+
+		It("reports the eviction", func() {
+			Expect(store.Stats()).To(Equal(Stats{
+				Entries:     2,
+				MaxEntries:  2,
+				Evictions:   1,
+				Expirations: 0,
+			}))
+		})
+
+		It("does not evict when an entry is replaced", func() {
+			Expect(store.Save(ctx, "first", []byte("replaced"), time.Hour)).To(Succeed())
+			Expect(store.Load(ctx, "first")).To(Equal([]byte("replaced")))
+			Expect(store.Stats().Evictions).To(Equal(uint64(1)))
+		})
+	})
+
+	Context("with index entries", func() {
+		var store *SessionStore
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			var err error
+			store, err = newSessionStore(4)
+			Expect(err).ToNot(HaveOccurred())
+			store.now = now
+			// Indexes list the keys of their sessions separated by commas
+			store.indexedKeys = func(key string, value []byte) ([]string, bool) {
+				if !strings.HasPrefix(key, "index-") {
+					return nil, false
+				}
+				return strings.Split(string(value), ","), true
+			}
+
+			Expect(store.Save(ctx, "first", []byte("first"), time.Hour)).To(Succeed())
+			Expect(store.Save(ctx, "index-first", []byte("first"), time.Hour)).To(Succeed())
+			Expect(store.Save(ctx, "second", []byte("second"), time.Hour)).To(Succeed())
+			Expect(store.Save(ctx, "index-second", []byte("first,second"), time.Hour)).To(Succeed())
+		})
+
+		It("counts them as entries", func() {
+			Expect(store.Stats()).To(Equal(Stats{
+				Entries:     4,
+				MaxEntries:  4,
+				Indexes:     2,
+				Evictions:   0,
+				Expirations: 0,
+			}))
+		})
+
+		It("lists and clears them", func() {
+			Expect(store.ListKeys(ctx, "index-")).To(ConsistOf("index-first", "index-second"))
+			Expect(store.Clear(ctx, "index-first")).To(Succeed())
+			_, err := store.Load(ctx, "index-first")
+			Expect(err).To(MatchError("key not found: index-first"))
+			Expect(store.Load(ctx, "first")).To(Equal([]byte("first")))
+		})
+
+		It("evicts sessions before the indexes that list them", func() {
+			Expect(store.Save(ctx, "third", []byte("third"), time.Hour)).To(Succeed())
+
+			// The first session was the least recently used, so it is evicted
+			// along with the index that only listed it
+			_, err := store.Load(ctx, "first")
+			Expect(err).To(MatchError("key not found: first"))
+			_, err = store.Load(ctx, "index-first")
+			Expect(err).To(MatchError("key not found: index-first"))
+			Expect(store.Load(ctx, "index-second")).To(Equal([]byte("first,second")))
+			Expect(store.Stats()).To(Equal(Stats{
+				Entries:     3,
+				MaxEntries:  4,
+				Indexes:     1,
+				Evictions:   2,
+				Expirations: 0,
+			}))
+		})
+
+		It("keeps the indexes of sessions that are used", func() {
+			_, err := store.Load(ctx, "first")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.Save(ctx, "third", []byte("third"), time.Hour)).To(Succeed())
+
+			// Loading the first session also used both of its indexes, leaving
+			// the second session as the least recently used
+			_, err = store.Load(ctx, "second")
+			Expect(err).To(MatchError("key not found: second"))
+			Expect(store.ListKeys(ctx, "")).To(ConsistOf("first", "index-first", "index-second", "third"))
+		})
+
+		It("removes an index once none of its sessions are held", func() {
+			Expect(store.Clear(ctx, "first")).To(Succeed())
+			_, err := store.Load(ctx, "index-first")
+			Expect(err).To(MatchError("key not found: index-first"))
+			Expect(store.Load(ctx, "index-second")).To(Equal([]byte("first,second")))
+
+			Expect(store.Clear(ctx, "second")).To(Succeed())
+			_, err = store.Load(ctx, "index-second")
+			Expect(err).To(MatchError("key not found: index-second"))
+			Expect(store.Stats().Entries).To(Equal(0))
+		})
+	})
+
+	Context("with an expired entry", func() {
+		var store *SessionStore
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			var err error
+			store, err = newSessionStore(10)
+			Expect(err).ToNot(HaveOccurred())
+			store.now = now
+
+			Expect(store.Save(ctx, "expiring", []byte("expiring"), time.Minute)).To(Succeed())
+			elapsed = 2 * time.Minute
+		})
+
+		It("removes the entry when loaded", func() {
+			_, err := store.Load(ctx, "expiring")
+			Expect(err).To(MatchError("key not found: expiring"))
+			Expect(store.Stats()).To(Equal(Stats{
+				Entries:     0,
+				MaxEntries:  10,
+				Evictions:   0,
+				Expirations: 1,
+			}))
+		})
+	})
+})
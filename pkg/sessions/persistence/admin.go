@@ -0,0 +1,199 @@
+package persistence
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// userIndex indexes sessions by the email of the user, or the user name when
+// the session has no email
+const userIndex = "user"
+
+var _ sessions.SessionAdministrator = (*Manager)(nil)
+
+// userSessions is the value stored in a user index. Unlike the OIDC indexes,
+// it describes every session as sessions can't be decrypted without the
+// secret from their ticket.
+type userSessions struct {
+	User     string                 `json:"user"`
+	Sessions []sessions.SessionInfo `json:"sessions"`
+}
+
+// ListUsers returns every user with an active session. It requires a Store
+// that implements Lister.
+func (m *Manager) ListUsers(ctx context.Context) ([]string, error) {
+	lister, ok := m.Store.(Lister)
+	if !ok {
+		return nil, sessions.ErrListingNotSupported
+	}
+
+	keys, err := lister.ListKeys(ctx, m.indexKeyPrefix(userIndex))
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %v", err)
+	}
+
+	users := []string{}
+	for _, key := range keys {
+		index := m.loadUserIndex(ctx, key)
+		if len(m.activeSessions(ctx, index.Sessions)) > 0 {
+			users = append(users, index.User)
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// ListSessions returns the active sessions of the user
+func (m *Manager) ListSessions(ctx context.Context, user string) ([]sessions.SessionInfo, error) {
+	if user == "" {
+		return nil, errors.New("a user is required to list sessions")
+	}
+
+	index := m.loadUserIndex(ctx, m.indexKey(userIndex, user))
+	return m.activeSessions(ctx, index.Sessions), nil
+}
+
+// RevokeSession clears the session with the ID from the Store. The session
+// is no longer listed as it can't be loaded, and is dropped from the index
+// of its user once it would have expired.
+func (m *Manager) RevokeSession(ctx context.Context, id string) error {
+	if !m.isTicketID(id) {
+		return sessions.ErrInvalidSessionID
+	}
+	return m.Store.Clear(ctx, id)
+}
+
+// RevokeUserSessions clears every session of the user along with the index
+// of their sessions
+func (m *Manager) RevokeUserSessions(ctx context.Context, user string) (int, error) {
+	if user == "" {
+		return 0, errors.New("a user is required to revoke sessions")
+	}
+
+	key := m.indexKey(userIndex, user)
+	unlock, err := m.lockKey(ctx, key, indexLockExpiration)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	active := m.activeSessions(ctx, m.loadUserIndex(ctx, key).Sessions)
+	for _, info := range active {
+		err := m.Store.Clear(ctx, info.ID)
+		if err != nil {
+			return 0, fmt.Errorf("error clearing session %s: %v", info.ID, err)
+		}
+	}
+	return len(active), m.Store.Clear(ctx, key)
+}
+
+// addToUserIndex records the session in the index of the user, replacing
+// the retired ticket ID and dropping expired sessions. Like the OIDC indexes,
+// the index is locked while it is updated and sessions cleared before they
+// expire are only dropped when the index is read, so saving a session
+// doesn't load the other sessions of the user.
+func (m *Manager) addToUserIndex(ctx context.Context, user string, info sessions.SessionInfo, retiredID string) error {
+	key := m.indexKey(userIndex, user)
+	unlock, err := m.lockKey(ctx, key, indexLockExpiration)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := time.Now()
+	infos := []sessions.SessionInfo{info}
+	for _, existing := range m.loadUserIndex(ctx, key).Sessions {
+		if existing.ID == info.ID || existing.ID == retiredID || !existing.ExpiresAt.After(now) {
+			continue
+		}
+		infos = append(infos, existing)
+	}
+
+	val, err := json.Marshal(userSessions{User: user, Sessions: infos})
+	if err != nil {
+		return fmt.Errorf("error encoding user index: %v", err)
+	}
+	return m.Store.Save(ctx, key, val, m.Options.Expire)
+}
+
+// loadUserIndex loads the sessions stored in a user index. A missing or
+// corrupt index is treated as empty.
+func (m *Manager) loadUserIndex(ctx context.Context, key string) userSessions {
+	val, err := m.Store.Load(ctx, key)
+	if err != nil {
+		return userSessions{}
+	}
+	return parseUserIndex(val)
+}
+
+// parseUserIndex parses the value of a user index. An empty or corrupt value
+// is treated as empty.
+func parseUserIndex(val []byte) userSessions {
+	var index userSessions
+	if len(val) == 0 {
+		return index
+	}
+	if err := json.Unmarshal(val, &index); err != nil {
+		return userSessions{}
+	}
+	return index
+}
+
+// activeSessions filters out the sessions that have expired or have been
+// cleared from the Store
+func (m *Manager) activeSessions(ctx context.Context, infos []sessions.SessionInfo) []sessions.SessionInfo {
+	now := time.Now()
+	active := []sessions.SessionInfo{}
+	for _, info := range infos {
+		if !info.ExpiresAt.After(now) {
+			continue
+		}
+		if _, err := m.Store.Load(ctx, info.ID); err != nil {
+			continue
+		}
+		active = append(active, info)
+	}
+	return active
+}
+
+// sessionInfo describes the session saved with the ticket ID
+func (m *Manager) sessionInfo(s *sessions.SessionState, ticketID string) sessions.SessionInfo {
+	now := time.Now()
+	authenticatedAt := s.AuthenticatedAt
+	if authenticatedAt == nil {
+		authenticatedAt = s.CreatedAt
+	}
+	return sessions.SessionInfo{
+		ID:              ticketID,
+		AuthenticatedAt: authenticatedAt,
+		SavedAt:         now,
+		ExpiresAt:       now.Add(m.Options.Expire),
+	}
+}
+
+// isTicketID checks the ID has the format of a ticket ID, so that revoking a
+// session can't clear other keys such as indexes
+func (m *Manager) isTicketID(id string) bool {
+	prefix := fmt.Sprintf("%s-", m.Options.Name)
+	if !strings.HasPrefix(id, prefix) {
+		return false
+	}
+	rawID, err := hex.DecodeString(strings.TrimPrefix(id, prefix))
+	return err == nil && len(rawID) == 16
+}
+
+// indexedUser is the user a session is indexed under
+func indexedUser(s *sessions.SessionState) string {
+	if s.Email != "" {
+		return s.Email
+	}
+	return s.User
+}
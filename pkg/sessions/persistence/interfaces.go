@@ -13,3 +13,23 @@ type Store interface {
 	Load(context.Context, string) ([]byte, error)
 	Clear(context.Context, string) error
 }
+
+// Locker is implemented by Stores that can hold a lock on a key for every
+// instance sharing the Store. The persistence.Manager falls back to locking
+// within the process for Stores that don't implement it.
+type Locker interface {
+	// ObtainLock attempts to set the lock on the key to the token until the
+	// expiration. It returns false if the lock is already held.
+	ObtainLock(ctx context.Context, key string, token string, expiration time.Duration) (bool, error)
+	// ReleaseLock releases the lock on the key if it is still held with
+	// the token.
+	ReleaseLock(ctx context.Context, key string, token string) error
+}
+
+// Lister is implemented by Stores that can list the keys they hold. It allows
+// the persistence.Manager to list every user with a session, rather than only
+// the sessions of a given user. Keys are still deleted with Store.Clear.
+type Lister interface {
+	// ListKeys returns every unexpired key starting with the prefix
+	ListKeys(ctx context.Context, prefix string) ([]string, error)
+}
@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+)
+
+// lockRetryInterval is how often a Locker is polled while waiting for a lock
+// held by another request
+var lockRetryInterval = 50 * time.Millisecond
+
+// indexLockExpiration is how long the lock on an index is held at most while
+// it is updated
+var indexLockExpiration = 5 * time.Second
+
+// LockSession waits until it obtains the lock on the session ticket of the
+// request. The lock is held in the Store when it implements Locker, so it
+// applies to every instance sharing the Store, otherwise it is only held
+// within this process.
+func (m *Manager) LockSession(req *http.Request, expiration time.Duration) (func() error, error) {
+	tckt, err := decodeTicketFromRequest(req, m.Options)
+	if err != nil {
+		return nil, err
+	}
+	return m.lockKey(req.Context(), tckt.id, expiration)
+}
+
+// lockKey waits until it obtains the lock on a Store key, for at most the
+// expiration. Like session locks, it is held in the Store when it implements
+// Locker and within this process otherwise.
+func (m *Manager) lockKey(ctx context.Context, key string, expiration time.Duration) (func() error, error) {
+	ctx, cancel := context.WithTimeout(ctx, expiration)
+	defer cancel()
+
+	locker, ok := m.Store.(Locker)
+	if !ok {
+		return m.locks.lock(ctx, key)
+	}
+	return obtainLock(ctx, locker, fmt.Sprintf("%s-lock", key), expiration)
+}
+
+// obtainLock polls the Locker until the lock on the key is obtained or the
+// context is done
+func obtainLock(ctx context.Context, locker Locker, key string, expiration time.Duration) (func() error, error) {
+	token, err := encryption.Nonce()
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock token: %v", err)
+	}
+
+	for {
+		ok, err := locker.ObtainLock(ctx, key, token, expiration)
+		if err != nil {
+			return nil, fmt.Errorf("error obtaining session lock: %v", err)
+		}
+		if ok {
+			return func() error {
+				return locker.ReleaseLock(context.Background(), key, token)
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for session lock: %v", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// keyLocks holds a lock per key within the process. Locks are removed once
+// no request holds or waits for them.
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is a lock that can be waited on with a context
+type keyLock struct {
+	held chan struct{}
+	refs int
+}
+
+// lock waits until it obtains the lock on the key or the context is done
+func (l *keyLocks) lock(ctx context.Context, key string) (func() error, error) {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*keyLock{}
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{held: make(chan struct{}, 1)}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mutex.Unlock()
+
+	select {
+	case kl.held <- struct{}{}:
+		var once sync.Once
+		return func() error {
+			once.Do(func() {
+				<-kl.held
+				l.release(key, kl)
+			})
+			return nil
+		}, nil
+	case <-ctx.Done():
+		l.release(key, kl)
+		return nil, fmt.Errorf("timed out waiting for session lock: %v", ctx.Err())
+	}
+}
+
+// release drops a reference to the lock, removing it when it is unused
+func (l *keyLocks) release(key string, kl *keyLock) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	kl.refs--
+	if kl.refs == 0 {
+		delete(l.locks, key)
+	}
+}
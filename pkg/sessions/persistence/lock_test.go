@@ -0,0 +1,141 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeLocker is a Store that holds locks in memory
+type fakeLocker struct {
+	*tests.MockStore
+
+	mutex    sync.Mutex
+	locks    map[string]string
+	attempts int
+}
+
+func (f *fakeLocker) ObtainLock(_ context.Context, key string, token string, _ time.Duration) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.attempts++
+	if _, ok := f.locks[key]; ok {
+		return false, nil
+	}
+	f.locks[key] = token
+	return true, nil
+}
+
+func (f *fakeLocker) ReleaseLock(_ context.Context, key string, token string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.locks[key] != token {
+		return errors.New("lock is not held with the token")
+	}
+	delete(f.locks, key)
+	return nil
+}
+
+var _ = Describe("Session Lock Tests", func() {
+	var cookieOpts *options.Cookie
+	var session *sessionsapi.SessionState
+
+	BeforeEach(func() {
+		cookieOpts = &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdef",
+			Expire: time.Hour,
+		}
+		session = &sessionsapi.SessionState{Email: "john.doe@example.com"}
+	})
+
+	// saveSession saves the session with the Manager and returns a request
+	// carrying the ticket cookie
+	saveSession := func(m *Manager) *http.Request {
+		rw := httptest.NewRecorder()
+		Expect(m.Save(rw, httptest.NewRequest("GET", "/", nil), session)).To(Succeed())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, c := range rw.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		return req
+	}
+
+	Context("with a Store that is not a Locker", func() {
+		var m *Manager
+
+		BeforeEach(func() {
+			m = NewManager(tests.NewMockStore(), &options.SessionOptions{}, cookieOpts)
+		})
+
+		It("locks the session within the process", func() {
+			req := saveSession(m)
+
+			unlock, err := m.LockSession(req, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			By("timing out while the lock is held")
+			_, err = m.LockSession(req, 50*time.Millisecond)
+			Expect(err).To(HaveOccurred())
+
+			By("obtaining the lock once it is released")
+			Expect(unlock()).To(Succeed())
+			unlock, err = m.LockSession(req, 50*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(unlock()).To(Succeed())
+			Expect(m.locks.locks).To(BeEmpty())
+		})
+
+		It("returns an error without a ticket", func() {
+			_, err := m.LockSession(httptest.NewRequest("GET", "/", nil), time.Second)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("with a Store that is a Locker", func() {
+		var m *Manager
+		var locker *fakeLocker
+		var retryInterval time.Duration
+
+		BeforeEach(func() {
+			retryInterval = lockRetryInterval
+			locker = &fakeLocker{
+				MockStore: tests.NewMockStore(),
+				locks:     map[string]string{},
+			}
+			m = NewManager(locker, &options.SessionOptions{}, cookieOpts)
+			lockRetryInterval = 10 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			lockRetryInterval = retryInterval
+		})
+
+		It("locks the session in the Store", func() {
+			req := saveSession(m)
+
+			unlock, err := m.LockSession(req, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locker.locks).To(HaveLen(1))
+
+			By("retrying until timing out while the lock is held")
+			_, err = m.LockSession(req, 50*time.Millisecond)
+			Expect(err).To(HaveOccurred())
+			Expect(locker.attempts).To(BeNumerically(">", 2))
+
+			By("releasing the lock in the Store")
+			Expect(unlock()).To(Succeed())
+			Expect(locker.locks).To(BeEmpty())
+		})
+	})
+})
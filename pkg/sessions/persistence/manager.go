@@ -1,45 +1,76 @@
 package persistence
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 )
 
+const (
+	// oidcSessionIndex indexes ticket IDs by the OIDC session ID
+	oidcSessionIndex = "sid"
+	// oidcSubjectIndex indexes ticket IDs by the OIDC subject
+	oidcSubjectIndex = "sub"
+)
+
+var _ sessions.OIDCSessionRevoker = (*Manager)(nil)
+var _ sessions.SessionLocker = (*Manager)(nil)
+
 // Manager wraps a Store and handles the implementation details of the
 // sessions.SessionStore with its use of session tickets
 type Manager struct {
 	Store   Store
 	Options *options.Cookie
+
+	// rotationInterval is how often the ticket of a session is rotated, in
+	// addition to whenever the session is refreshed
+	rotationInterval time.Duration
+	// rotationGracePeriod is how long a rotated ticket keeps working
+	rotationGracePeriod time.Duration
+
+	// locks are the session locks held within this process, used when the
+	// Store is not a Locker
+	locks keyLocks
 }
 
 // NewManager creates a Manager that can wrap a Store and manage the
 // sessions.SessionStore implementation details
-func NewManager(store Store, cookieOpts *options.Cookie) *Manager {
+func NewManager(store Store, opts *options.SessionOptions, cookieOpts *options.Cookie) *Manager {
 	return &Manager{
-		Store:   store,
-		Options: cookieOpts,
+		Store:               store,
+		Options:             cookieOpts,
+		rotationInterval:    opts.TicketRotationInterval,
+		rotationGracePeriod: opts.TicketRotationGracePeriod,
 	}
 }
 
 // Save saves a session in a persistent Store. Save will generate (or reuse an
 // existing) ticket which manages unique per session encryption & retrieval
 // from the persistent data store.
+// A new ticket is generated for new sessions, so a ticket set before signing
+// in is never reused, and for sessions flagged with RotateTicket. The
+// previous ticket is then cleared from the Store.
 func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState) error {
 	if s.CreatedAt == nil || s.CreatedAt.IsZero() {
 		now := time.Now()
 		s.CreatedAt = &now
 	}
 
-	tckt, err := decodeTicketFromRequest(req, m.Options)
+	// Only rotated tickets get a grace period, a ticket replaced by a new
+	// session must stop working straight away
+	graceful := s.TicketIssuedAt != nil
+	tckt, retired, err := m.ticketForSave(req, s)
 	if err != nil {
-		tckt, err = newTicket(m.Options)
-		if err != nil {
-			return fmt.Errorf("error creating a session ticket: %v", err)
-		}
+		return fmt.Errorf("error creating a session ticket: %v", err)
 	}
 
 	err = tckt.saveSession(s, func(key string, val []byte, exp time.Duration) error {
@@ -48,6 +79,20 @@ func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.Se
 	if err != nil {
 		return err
 	}
+
+	var retiredID string
+	if retired != nil {
+		err = m.retireTicket(req.Context(), retired, tckt, graceful)
+		if err != nil {
+			return fmt.Errorf("error retiring session ticket: %v", err)
+		}
+		retiredID = retired.id
+	}
+
+	err = m.indexSession(req.Context(), s, tckt.id, retiredID)
+	if err != nil {
+		return fmt.Errorf("error indexing session: %v", err)
+	}
 	tckt.setCookie(rw, req, s)
 
 	return nil
@@ -61,9 +106,33 @@ func (m *Manager) Load(req *http.Request) (*sessions.SessionState, error) {
 		return nil, err
 	}
 
-	return tckt.loadSession(func(key string) ([]byte, error) {
+	loader := func(key string) ([]byte, error) {
 		return m.Store.Load(req.Context(), key)
-	})
+	}
+	ss, err := tckt.loadSession(loader)
+	if err != nil {
+		// The ticket may have been rotated by a parallel request
+		current := m.currentTicket(req.Context(), tckt)
+		if current == tckt {
+			return nil, err
+		}
+		ss, err = current.loadSession(loader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ss.TicketIssuedAt == nil {
+		// Sessions saved before tickets were rotated are treated as issued
+		// when they were created, so they are not mistaken for new sessions
+		issuedAt := time.Now()
+		if ss.CreatedAt != nil {
+			issuedAt = *ss.CreatedAt
+		}
+		ss.TicketIssuedAt = &issuedAt
+	}
+	ss.RotateTicket = m.rotationDue(ss)
+	return ss, nil
 }
 
 // Clear clears any saved session information for a given ticket cookie.
@@ -85,7 +154,182 @@ func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
 	}
 
 	tckt.clearCookie(rw, req)
+	if current := m.currentTicket(req.Context(), tckt); current != tckt {
+		err = m.Store.Clear(req.Context(), rotatedKey(tckt.id))
+		if err != nil {
+			return err
+		}
+		tckt = current
+	}
 	return tckt.clearSession(func(key string) error {
 		return m.Store.Clear(req.Context(), key)
 	})
 }
+
+// ClearOIDCSessions clears every session of the provider linked to the OIDC
+// session ID. When no session ID is given, every session of the subject is
+// cleared.
+func (m *Manager) ClearOIDCSessions(ctx context.Context, providerID, sid, sub string) error {
+	index, value := oidcSessionIndex, sid
+	if sid == "" {
+		index, value = oidcSubjectIndex, sub
+	}
+	if value == "" {
+		return errors.New("an OIDC session ID or subject is required")
+	}
+
+	key := m.indexKey(index, providerIndexValue(providerID, value))
+	// Sessions indexed while they are cleared would outlive the index
+	unlock, err := m.lockKey(ctx, key, indexLockExpiration)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, entry := range m.loadIndex(ctx, key) {
+		err := m.Store.Clear(ctx, entry.ticketID)
+		if err != nil {
+			return fmt.Errorf("error clearing session %s: %v", entry.ticketID, err)
+		}
+	}
+	return m.Store.Clear(ctx, key)
+}
+
+// indexSession records the ticket ID under the user, OIDC session ID and
+// subject of the session so it can be found without the ticket cookie. The
+// retired ticket ID, if any, is replaced by the ticket ID.
+func (m *Manager) indexSession(ctx context.Context, s *sessions.SessionState, ticketID, retiredID string) error {
+	if user := indexedUser(s); user != "" {
+		err := m.addToUserIndex(ctx, user, m.sessionInfo(s, ticketID), retiredID)
+		if err != nil {
+			return err
+		}
+	}
+	if s.OIDCSessionID != "" {
+		err := m.addToIndex(ctx, oidcSessionIndex, providerIndexValue(s.ProviderID, s.OIDCSessionID), ticketID, retiredID)
+		if err != nil {
+			return err
+		}
+	}
+	// Only OIDC sessions have an ID token, the User is the subject claim
+	if s.IDToken != "" && s.User != "" {
+		return m.addToIndex(ctx, oidcSubjectIndex, providerIndexValue(s.ProviderID, s.User), ticketID, retiredID)
+	}
+	return nil
+}
+
+// providerIndexValue namespaces an OIDC session ID or subject with the ID of
+// the provider of the session, as the issuers of different providers may use
+// the same values. Provider IDs can't contain a colon. Sessions of the legacy
+// provider, which has no ID, are indexed by the bare value.
+func providerIndexValue(providerID, value string) string {
+	if providerID == "" {
+		return value
+	}
+	return providerID + ":" + value
+}
+
+// indexEntry is a ticket ID in an index with the time its session expires
+type indexEntry struct {
+	ticketID string
+	expires  time.Time
+}
+
+// addToIndex adds the ticket ID to the index for the value, replacing the
+// retired ticket ID and dropping expired sessions. The index is locked while
+// it is updated so that sessions saved at the same time are all kept.
+// Sessions cleared before they expire are only dropped when the index is
+// cleared.
+func (m *Manager) addToIndex(ctx context.Context, index, value, ticketID, retiredID string) error {
+	key := m.indexKey(index, value)
+	unlock, err := m.lockKey(ctx, key, indexLockExpiration)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := time.Now()
+	lines := []string{formatIndexEntry(indexEntry{ticketID: ticketID, expires: now.Add(m.Options.Expire)})}
+	for _, entry := range m.loadIndex(ctx, key) {
+		if entry.ticketID == ticketID || entry.ticketID == retiredID || !entry.expires.After(now) {
+			continue
+		}
+		lines = append(lines, formatIndexEntry(entry))
+	}
+
+	return m.Store.Save(ctx, key, []byte(strings.Join(lines, "\n")), m.Options.Expire)
+}
+
+// loadIndex loads the entries stored in an index. A missing index is treated
+// as empty.
+func (m *Manager) loadIndex(ctx context.Context, key string) []indexEntry {
+	val, err := m.Store.Load(ctx, key)
+	if err != nil {
+		return nil
+	}
+	return m.parseIndex(val)
+}
+
+// parseIndex parses the entries of an index value
+func (m *Manager) parseIndex(val []byte) []indexEntry {
+	if len(val) == 0 {
+		return nil
+	}
+
+	entries := []indexEntry{}
+	for _, line := range strings.Split(string(val), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Entries indexed without an expiry are kept for another expire
+		// period
+		entry := indexEntry{ticketID: fields[0], expires: time.Now().Add(m.Options.Expire)}
+		if len(fields) > 1 {
+			if expires, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				entry.expires = time.Unix(expires, 0)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// formatIndexEntry formats an entry as a line of an index
+func formatIndexEntry(entry indexEntry) string {
+	return fmt.Sprintf("%s %d", entry.ticketID, entry.expires.Unix())
+}
+
+// indexKey builds the Store key for an index. The value is hashed to keep
+// keys a fixed length and distinct from ticket IDs.
+func (m *Manager) indexKey(index, value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return m.indexKeyPrefix(index) + hex.EncodeToString(hash[:])
+}
+
+// IndexedKeys reports whether the Store key holds an index rather than a
+// session, and returns the keys of the sessions listed by the index value.
+// Stores that evict entries must keep indexes for as long as the sessions
+// they list.
+func (m *Manager) IndexedKeys(key string, val []byte) ([]string, bool) {
+	keys := []string{}
+	switch {
+	case strings.HasPrefix(key, m.indexKeyPrefix(userIndex)):
+		for _, info := range parseUserIndex(val).Sessions {
+			keys = append(keys, info.ID)
+		}
+	case strings.HasPrefix(key, m.indexKeyPrefix(oidcSessionIndex)),
+		strings.HasPrefix(key, m.indexKeyPrefix(oidcSubjectIndex)):
+		for _, entry := range m.parseIndex(val) {
+			keys = append(keys, entry.ticketID)
+		}
+	default:
+		return nil, false
+	}
+	return keys, true
+}
+
+// indexKeyPrefix is the start of the Store key of every entry in an index
+func (m *Manager) indexKeyPrefix(index string) string {
+	return fmt.Sprintf("%s-%s-", m.Options.Name, index)
+}
@@ -1,6 +1,10 @@
 package persistence
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,11 +28,178 @@ var _ = Describe("Persistence Manager SessionStore Tests", func() {
 		ms = tests.NewMockStore()
 	})
 	tests.RunSessionStoreTests(
-		func(_ *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
-			return NewManager(ms, cookieOpts), nil
+		func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+			return NewManager(ms, opts, cookieOpts), nil
 		},
 		func(d time.Duration) error {
 			ms.FastForward(d)
 			return nil
 		})
 })
+
+// concurrentStore is a Store that can be used by parallel requests. Loads
+// return slowly so that parallel updates of the same key overlap.
+type concurrentStore struct {
+	mutex sync.Mutex
+	store *tests.MockStore
+}
+
+func (c *concurrentStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.store.Save(ctx, key, value, exp)
+}
+
+func (c *concurrentStore) Load(ctx context.Context, key string) ([]byte, error) {
+	c.mutex.Lock()
+	val, err := c.store.Load(ctx, key)
+	c.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	return val, err
+}
+
+func (c *concurrentStore) Clear(ctx context.Context, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.store.Clear(ctx, key)
+}
+
+var _ = Describe("Session Index Tests", func() {
+	var store *concurrentStore
+	var m *Manager
+
+	BeforeEach(func() {
+		store = &concurrentStore{store: tests.NewMockStore()}
+		m = NewManager(store, &options.SessionOptions{}, &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdef",
+			Expire: time.Hour,
+		})
+	})
+
+	newSession := func() *sessionsapi.SessionState {
+		return &sessionsapi.SessionState{
+			Email:         "john.doe@example.com",
+			User:          "subject",
+			IDToken:       "id-token",
+			OIDCSessionID: "oidc-session-id",
+		}
+	}
+
+	// save saves the session with the cookies of the request and returns a
+	// request carrying the resulting ticket cookie
+	save := func(req *http.Request, session *sessionsapi.SessionState) *http.Request {
+		rw := httptest.NewRecorder()
+		Expect(m.Save(rw, req, session)).To(Succeed())
+
+		next := httptest.NewRequest("GET", "/", nil)
+		for _, c := range rw.Result().Cookies() {
+			next.AddCookie(c)
+		}
+		return next
+	}
+
+	ticketID := func(req *http.Request) string {
+		tckt, err := decodeTicketFromRequest(req, m.Options)
+		Expect(err).ToNot(HaveOccurred())
+		return tckt.id
+	}
+
+	indexedTicketIDs := func(index, value string) []string {
+		ticketIDs := []string{}
+		for _, entry := range m.loadIndex(context.Background(), m.indexKey(index, value)) {
+			ticketIDs = append(ticketIDs, entry.ticketID)
+		}
+		return ticketIDs
+	}
+
+	It("indexes every session saved at the same time", func() {
+		const count = 10
+		reqs := make([]*http.Request, count)
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				reqs[i] = save(httptest.NewRequest("GET", "/", nil), newSession())
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(indexedTicketIDs(oidcSessionIndex, "oidc-session-id")).To(HaveLen(count))
+		Expect(indexedTicketIDs(oidcSubjectIndex, "subject")).To(HaveLen(count))
+		Expect(m.ListSessions(context.Background(), "john.doe@example.com")).To(HaveLen(count))
+
+		Expect(m.ClearOIDCSessions(context.Background(), "", "", "subject")).To(Succeed())
+		for _, req := range reqs {
+			_, err := m.Load(req)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	It("replaces the ticket ID of a rotated session", func() {
+		req := save(httptest.NewRequest("GET", "/", nil), newSession())
+		loaded, err := m.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+
+		loaded.RotateTicket = true
+		next := save(req, loaded)
+		Expect(ticketID(next)).ToNot(Equal(ticketID(req)))
+		Expect(indexedTicketIDs(oidcSessionIndex, "oidc-session-id")).To(ConsistOf(ticketID(next)))
+		index := m.loadUserIndex(context.Background(), m.indexKey(userIndex, "john.doe@example.com"))
+		Expect(index.Sessions).To(HaveLen(1))
+		Expect(index.Sessions[0].ID).To(Equal(ticketID(next)))
+	})
+
+	It("drops cleared sessions from the user index when it is read", func() {
+		cleared := save(httptest.NewRequest("GET", "/", nil), newSession())
+		Expect(store.Clear(context.Background(), ticketID(cleared))).To(Succeed())
+		req := save(httptest.NewRequest("GET", "/", nil), newSession())
+
+		// Saving a session does not load the other sessions of the user
+		index := m.loadUserIndex(context.Background(), m.indexKey(userIndex, "john.doe@example.com"))
+		Expect(index.Sessions).To(HaveLen(2))
+
+		infos, err := m.ListSessions(context.Background(), "john.doe@example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+		Expect(infos[0].ID).To(Equal(ticketID(req)))
+	})
+
+	It("indexes the sessions of a provider under its ID", func() {
+		session := newSession()
+		session.ProviderID = "provider"
+		req := save(httptest.NewRequest("GET", "/", nil), session)
+		Expect(indexedTicketIDs(oidcSessionIndex, "provider:oidc-session-id")).To(ConsistOf(ticketID(req)))
+		Expect(indexedTicketIDs(oidcSubjectIndex, "provider:subject")).To(ConsistOf(ticketID(req)))
+		Expect(indexedTicketIDs(oidcSessionIndex, "oidc-session-id")).To(BeEmpty())
+
+		Expect(m.ClearOIDCSessions(context.Background(), "", "oidc-session-id", "")).To(Succeed())
+		_, err := m.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.ClearOIDCSessions(context.Background(), "provider", "oidc-session-id", "")).To(Succeed())
+		_, err = m.Load(req)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("drops expired sessions from the index", func() {
+		key := m.indexKey(oidcSessionIndex, "oidc-session-id")
+		expired := formatIndexEntry(indexEntry{ticketID: "_oauth2_proxy-expired", expires: time.Now().Add(-time.Minute)})
+		Expect(store.Save(context.Background(), key, []byte(expired), time.Hour)).To(Succeed())
+
+		req := save(httptest.NewRequest("GET", "/", nil), newSession())
+		Expect(indexedTicketIDs(oidcSessionIndex, "oidc-session-id")).To(ConsistOf(ticketID(req)))
+	})
+
+	It("clears sessions indexed without an expiry", func() {
+		req := save(httptest.NewRequest("GET", "/", nil), newSession())
+		key := m.indexKey(oidcSessionIndex, "oidc-session-id")
+		Expect(store.Save(context.Background(), key, []byte(ticketID(req)), time.Hour)).To(Succeed())
+
+		Expect(m.ClearOIDCSessions(context.Background(), "", "oidc-session-id", "")).To(Succeed())
+		_, err := m.Load(req)
+		Expect(err).To(HaveOccurred())
+	})
+})
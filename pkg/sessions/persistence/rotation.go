@@ -0,0 +1,110 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// maxRotations limits how many rotations of a ticket are followed, in case
+// a ticket is rotated again within the grace period
+const maxRotations = 3
+
+// ticketForSave returns the ticket to save the session with. When the ticket
+// from the request is replaced with a new ticket it is returned as retired.
+func (m *Manager) ticketForSave(req *http.Request, s *sessions.SessionState) (*ticket, *ticket, error) {
+	var retired *ticket
+	tckt, err := decodeTicketFromRequest(req, m.Options)
+	if err == nil {
+		tckt = m.currentTicket(req.Context(), tckt)
+		if s.TicketIssuedAt != nil && !s.RotateTicket {
+			return tckt, nil, nil
+		}
+		retired = tckt
+	}
+
+	tckt, err = newTicket(m.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	s.TicketIssuedAt = &now
+	s.RotateTicket = false
+	return tckt, retired, nil
+}
+
+// retireTicket clears the session of a ticket replaced by the current
+// ticket. With a grace period the retired ticket keeps pointing to the
+// current ticket for a while, so that requests already in flight with the
+// retired ticket cookie keep working.
+func (m *Manager) retireTicket(ctx context.Context, retired, current *ticket, graceful bool) error {
+	if graceful && m.rotationGracePeriod > 0 {
+		c, err := retired.makeCipher()
+		if err != nil {
+			return err
+		}
+		// The current ticket is encrypted with the secret of the retired
+		// ticket, so only its cookie can follow the rotation
+		val, err := c.Encrypt([]byte(current.encodeTicket()))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the rotated ticket: %v", err)
+		}
+		err = m.Store.Save(ctx, rotatedKey(retired.id), val, m.rotationGracePeriod)
+		if err != nil {
+			return err
+		}
+	}
+	return m.Store.Clear(ctx, retired.id)
+}
+
+// currentTicket follows the rotations of a ticket within their grace period
+// to the ticket that replaced it. The ticket is returned unchanged when it
+// has not been rotated.
+func (m *Manager) currentTicket(ctx context.Context, tckt *ticket) *ticket {
+	for i := 0; i < maxRotations; i++ {
+		next, ok := m.rotatedTicket(ctx, tckt)
+		if !ok {
+			break
+		}
+		tckt = next
+	}
+	return tckt
+}
+
+// rotatedTicket loads the ticket that replaced a ticket, if it was rotated
+// within the grace period
+func (m *Manager) rotatedTicket(ctx context.Context, tckt *ticket) (*ticket, bool) {
+	val, err := m.Store.Load(ctx, rotatedKey(tckt.id))
+	if err != nil {
+		return nil, false
+	}
+	c, err := tckt.makeCipher()
+	if err != nil {
+		return nil, false
+	}
+	encTicket, err := c.Decrypt(val)
+	if err != nil {
+		return nil, false
+	}
+	next, err := decodeTicket(string(encTicket), m.Options)
+	if err != nil {
+		return nil, false
+	}
+	next.signedWithPreviousSecret = tckt.signedWithPreviousSecret
+	return next, true
+}
+
+// rotationDue checks whether the ticket of a session was issued longer than
+// the rotation interval ago
+func (m *Manager) rotationDue(s *sessions.SessionState) bool {
+	return m.rotationInterval > 0 && s.TicketIssuedAt != nil &&
+		time.Since(*s.TicketIssuedAt) >= m.rotationInterval
+}
+
+// rotatedKey is the Store key pointing a rotated ticket to its replacement
+func rotatedKey(ticketID string) string {
+	return fmt.Sprintf("%s-rotated", ticketID)
+}
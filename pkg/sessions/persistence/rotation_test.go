@@ -0,0 +1,159 @@
+package persistence
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session Ticket Rotation Tests", func() {
+	var cookieOpts *options.Cookie
+	var ms *tests.MockStore
+	var m *Manager
+
+	BeforeEach(func() {
+		cookieOpts = &options.Cookie{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdef",
+			Expire: time.Hour,
+		}
+		ms = tests.NewMockStore()
+		m = NewManager(ms, &options.SessionOptions{
+			TicketRotationInterval:    10 * time.Minute,
+			TicketRotationGracePeriod: 30 * time.Second,
+		}, cookieOpts)
+	})
+
+	// save saves the session with the cookies of the request and returns a
+	// request carrying the resulting ticket cookie
+	save := func(req *http.Request, session *sessionsapi.SessionState) *http.Request {
+		rw := httptest.NewRecorder()
+		Expect(m.Save(rw, req, session)).To(Succeed())
+
+		next := httptest.NewRequest("GET", "/", nil)
+		for _, c := range rw.Result().Cookies() {
+			next.AddCookie(c)
+		}
+		return next
+	}
+
+	ticketID := func(req *http.Request) string {
+		tckt, err := decodeTicketFromRequest(req, cookieOpts)
+		Expect(err).ToNot(HaveOccurred())
+		return tckt.id
+	}
+
+	It("reuses the ticket of a session that is not rotated", func() {
+		req := save(httptest.NewRequest("GET", "/", nil), &sessionsapi.SessionState{Email: "john.doe@example.com"})
+		loaded, err := m.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.TicketIssuedAt).ToNot(BeNil())
+		Expect(loaded.RotateTicket).To(BeFalse())
+
+		next := save(req, loaded)
+		Expect(ticketID(next)).To(Equal(ticketID(req)))
+	})
+
+	It("issues a new ticket to a new session saved with an existing ticket", func() {
+		req := save(httptest.NewRequest("GET", "/", nil), &sessionsapi.SessionState{Email: "john.doe@example.com"})
+		next := save(req, &sessionsapi.SessionState{Email: "jane.doe@example.com"})
+		Expect(ticketID(next)).ToNot(Equal(ticketID(req)))
+
+		By("clearing the previous ticket without a grace period")
+		_, err := m.Load(req)
+		Expect(err).To(HaveOccurred())
+
+		loaded, err := m.Load(next)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.Email).To(Equal("jane.doe@example.com"))
+	})
+
+	Context("when the ticket is rotated", func() {
+		var req, next *http.Request
+
+		BeforeEach(func() {
+			req = save(httptest.NewRequest("GET", "/", nil), &sessionsapi.SessionState{Email: "john.doe@example.com"})
+			loaded, err := m.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			loaded.RotateTicket = true
+			loaded.AccessToken = "refreshed"
+			next = save(req, loaded)
+		})
+
+		It("issues a new ticket and clears the previous session", func() {
+			Expect(ticketID(next)).ToNot(Equal(ticketID(req)))
+			_, err := ms.Load(context.Background(), ticketID(req))
+			Expect(err).To(HaveOccurred())
+
+			loaded, err := m.Load(next)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.AccessToken).To(Equal("refreshed"))
+		})
+
+		It("loads the session with the previous ticket within the grace period", func() {
+			loaded, err := m.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.AccessToken).To(Equal("refreshed"))
+		})
+
+		It("saves the session with the new ticket when the previous ticket is used", func() {
+			loaded, err := m.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ticketID(save(req, loaded))).To(Equal(ticketID(next)))
+			_, err = ms.Load(context.Background(), ticketID(req))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("no longer loads the session with the previous ticket after the grace period", func() {
+			ms.FastForward(time.Minute)
+			_, err := m.Load(req)
+			Expect(err).To(HaveOccurred())
+
+			_, err = m.Load(next)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("clears the session when signing out with the previous ticket", func() {
+			Expect(m.Clear(httptest.NewRecorder(), req)).To(Succeed())
+			_, err := m.Load(next)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("rotates the ticket once the rotation interval has passed", func() {
+		req := save(httptest.NewRequest("GET", "/", nil), &sessionsapi.SessionState{Email: "john.doe@example.com"})
+		loaded, err := m.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+		issuedAt := time.Now().Add(-time.Hour)
+		loaded.TicketIssuedAt = &issuedAt
+		Expect(ticketID(save(req, loaded))).To(Equal(ticketID(req)))
+
+		loaded, err = m.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.RotateTicket).To(BeTrue())
+
+		next := save(req, loaded)
+		Expect(ticketID(next)).ToNot(Equal(ticketID(req)))
+		Expect(loaded.RotateTicket).To(BeFalse())
+		Expect(*loaded.TicketIssuedAt).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	It("clears the previous ticket straight away without a grace period", func() {
+		m.rotationGracePeriod = 0
+		req := save(httptest.NewRequest("GET", "/", nil), &sessionsapi.SessionState{Email: "john.doe@example.com"})
+		loaded, err := m.Load(req)
+		Expect(err).ToNot(HaveOccurred())
+		loaded.RotateTicket = true
+		save(req, loaded)
+
+		_, err = m.Load(req)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -38,6 +38,10 @@ type ticket struct {
 	id      string
 	secret  []byte
 	options *options.Cookie
+
+	// signedWithPreviousSecret is set when the ticket cookie was signed
+	// with a previous cookie secret
+	signedWithPreviousSecret bool
 }
 
 // newTicket creates a new ticket. The ID & secret will be randomly created
@@ -97,13 +101,18 @@ func decodeTicketFromRequest(req *http.Request, cookieOpts *options.Cookie) (*ti
 	}
 
 	// An existing cookie exists, try to retrieve the ticket
-	val, _, ok := encryption.Validate(requestCookie, cookieOpts.Secret, cookieOpts.Expire)
+	val, _, secretIndex, ok := encryption.ValidateWithSeeds(requestCookie, cookieOpts.Secrets(), cookieOpts.Expire)
 	if !ok {
 		return nil, fmt.Errorf("session ticket cookie failed validation: %v", err)
 	}
 
 	// Valid cookie, decode the ticket
-	return decodeTicket(string(val), cookieOpts)
+	tckt, err := decodeTicket(string(val), cookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	tckt.signedWithPreviousSecret = secretIndex > 0
+	return tckt, nil
 }
 
 // saveSession encodes the SessionState with the ticket's secret and persists
@@ -136,8 +145,12 @@ func (t *ticket) loadSession(loader loadFunc) (*sessions.SessionState, error) {
 	}
 	ss, err := sessions.DecodeSessionState(ciphertext, c, false)
 	if err != nil {
-		return t.legacyV5LoadSession(ciphertext)
+		ss, err = t.legacyV5LoadSession(ciphertext)
+		if err != nil {
+			return nil, err
+		}
 	}
+	ss.LoadedWithPreviousSecret = t.signedWithPreviousSecret
 	return ss, nil
 }
 
@@ -207,15 +220,20 @@ func (t *ticket) legacyV5LoadSession(resultBytes []byte) (*sessions.SessionState
 	stream := cipher.NewCFBDecrypter(block, t.secret)
 	stream.XORKeyStream(resultBytes, resultBytes)
 
-	cfbCipher, err := encryption.NewCFBCipher(encryption.SecretBytes(t.options.Secret))
-	if err != nil {
-		return nil, err
-	}
-	legacyCipher := encryption.NewBase64Cipher(cfbCipher)
-
-	session, err := sessions.LegacyV5DecodeSessionState(string(resultBytes), legacyCipher)
-	if err != nil {
-		return nil, err
-	}
-	return session, nil
+	// The session may have been encrypted with a cookie secret since rotated
+	for _, secret := range t.options.Secrets() {
+		var cfbCipher encryption.Cipher
+		cfbCipher, err = encryption.NewCFBCipher(encryption.SecretBytes(secret))
+		if err != nil {
+			return nil, err
+		}
+		legacyCipher := encryption.NewBase64Cipher(cfbCipher)
+
+		var session *sessions.SessionState
+		session, err = sessions.LegacyV5DecodeSessionState(string(resultBytes), legacyCipher)
+		if err == nil {
+			return session, nil
+		}
+	}
+	return nil, err
 }
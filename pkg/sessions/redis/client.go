@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -11,9 +12,21 @@ import (
 type Client interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error)
 	Del(ctx context.Context, key string) error
+	DelIfEqual(ctx context.Context, key string, value []byte) error
+	Scan(ctx context.Context, match string) ([]string, error)
 }
 
+// delIfEqualScript deletes a key only when it holds the expected value,
+// atomically
+var delIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 var _ Client = (*client)(nil)
 
 type client struct {
@@ -32,10 +45,22 @@ func (c *client) Set(ctx context.Context, key string, value []byte, expiration t
 	return c.WithContext(ctx).Set(key, value, expiration).Err()
 }
 
+func (c *client) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	return c.WithContext(ctx).SetNX(key, value, expiration).Result()
+}
+
 func (c *client) Del(ctx context.Context, key string) error {
 	return c.WithContext(ctx).Del(key).Err()
 }
 
+func (c *client) DelIfEqual(ctx context.Context, key string, value []byte) error {
+	return delIfEqualScript.Run(c.WithContext(ctx), []string{key}, value).Err()
+}
+
+func (c *client) Scan(ctx context.Context, match string) ([]string, error) {
+	return scanKeys(c.WithContext(ctx), match)
+}
+
 var _ Client = (*clusterClient)(nil)
 
 type clusterClient struct {
@@ -54,6 +79,43 @@ func (c *clusterClient) Set(ctx context.Context, key string, value []byte, expir
 	return c.WithContext(ctx).Set(key, value, expiration).Err()
 }
 
+func (c *clusterClient) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	return c.WithContext(ctx).SetNX(key, value, expiration).Result()
+}
+
 func (c *clusterClient) Del(ctx context.Context, key string) error {
 	return c.WithContext(ctx).Del(key).Err()
 }
+
+func (c *clusterClient) DelIfEqual(ctx context.Context, key string, value []byte) error {
+	return delIfEqualScript.Run(c.WithContext(ctx), []string{key}, value).Err()
+}
+
+// Scan scans every master of the cluster, as each only holds the keys in
+// its own slots
+func (c *clusterClient) Scan(ctx context.Context, match string) ([]string, error) {
+	var lock sync.Mutex
+	keys := []string{}
+	err := c.WithContext(ctx).ForEachMaster(func(master *redis.Client) error {
+		masterKeys, err := scanKeys(master.WithContext(ctx), match)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		keys = append(keys, masterKeys...)
+		return nil
+	})
+	return keys, err
+}
+
+// scanKeys iterates over every key matching the pattern. Unlike KEYS, SCAN
+// doesn't block the server while iterating.
+func scanKeys(c *redis.Client, match string) ([]string, error) {
+	keys := []string{}
+	iter := c.Scan(0, match, 0).Iterator()
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
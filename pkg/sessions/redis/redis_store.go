@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -14,6 +15,12 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
 )
 
+// Ensure SessionStore can hold session locks for every instance using redis
+var _ persistence.Locker = (*SessionStore)(nil)
+
+// Ensure SessionStore can list every user with a session
+var _ persistence.Lister = (*SessionStore)(nil)
+
 // SessionStore is an implementation of the persistence.Store
 // interface that stores sessions in redis
 type SessionStore struct {
@@ -31,7 +38,7 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 	rs := &SessionStore{
 		Client: client,
 	}
-	return persistence.NewManager(rs, cookieOpts), nil
+	return persistence.NewManager(rs, opts, cookieOpts), nil
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -64,6 +71,39 @@ func (store *SessionStore) Clear(ctx context.Context, key string) error {
 	return nil
 }
 
+// ListKeys returns every key in redis starting with the prefix
+func (store *SessionStore) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := store.Client.Scan(ctx, globEscaper.Replace(prefix)+"*")
+	if err != nil {
+		return nil, fmt.Errorf("error listing redis keys: %v", err)
+	}
+	return keys, nil
+}
+
+// globEscaper escapes the characters that have a special meaning in redis
+// glob patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// ObtainLock sets the lock key to the token, unless it is already set, until
+// the expiration
+func (store *SessionStore) ObtainLock(ctx context.Context, key string, token string, expiration time.Duration) (bool, error) {
+	ok, err := store.Client.SetNX(ctx, key, []byte(token), expiration)
+	if err != nil {
+		return false, fmt.Errorf("error obtaining redis lock: %v", err)
+	}
+	return ok, nil
+}
+
+// ReleaseLock deletes the lock key if it is still set to the token, so a lock
+// that expired and was obtained by another request is not released
+func (store *SessionStore) ReleaseLock(ctx context.Context, key string, token string) error {
+	err := store.Client.DelIfEqual(ctx, key, []byte(token))
+	if err != nil {
+		return fmt.Errorf("error releasing redis lock: %v", err)
+	}
+	return nil
+}
+
 // newRedisClient makes a redis.Client (either standalone, sentinel aware, or
 // redis cluster)
 func newRedisClient(opts options.RedisStoreOptions) (Client, error) {
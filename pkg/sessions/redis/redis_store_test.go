@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"context"
 	"log"
 	"os"
 	"testing"
@@ -127,4 +128,57 @@ var _ = Describe("Redis SessionStore Tests", func() {
 			},
 		)
 	})
+
+	Context("with locks", func() {
+		var store *SessionStore
+
+		BeforeEach(func() {
+			var err error
+			ss, err = NewRedisSessionStore(&options.SessionOptions{
+				Type: options.RedisSessionStoreType,
+				Redis: options.RedisStoreOptions{
+					ConnectionURL: "redis://" + mr.Addr(),
+				},
+			}, &options.Cookie{})
+			Expect(err).ToNot(HaveOccurred())
+			store = ss.(*persistence.Manager).Store.(*SessionStore)
+		})
+
+		It("obtains the lock only once until it is released", func() {
+			ok, err := store.ObtainLock(context.Background(), "lock", "token-a", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+
+			ok, err = store.ObtainLock(context.Background(), "lock", "token-b", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			Expect(store.ReleaseLock(context.Background(), "lock", "token-a")).To(Succeed())
+			ok, err = store.ObtainLock(context.Background(), "lock", "token-b", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+		})
+
+		It("does not release a lock held with another token", func() {
+			ok, err := store.ObtainLock(context.Background(), "lock", "token-a", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+
+			Expect(store.ReleaseLock(context.Background(), "lock", "token-b")).To(Succeed())
+			value, err := mr.Get("lock")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("token-a"))
+		})
+
+		It("obtains the lock once it expires", func() {
+			ok, err := store.ObtainLock(context.Background(), "lock", "token-a", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+
+			mr.FastForward(2 * time.Minute)
+			ok, err = store.ObtainLock(context.Background(), "lock", "token-b", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+		})
+	})
 })
@@ -2,7 +2,9 @@ package sessions_test
 
 import (
 	"encoding/base64"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 
@@ -10,6 +12,8 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions"
 	sessionscookie "github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/cookie"
+	sessionsfile "github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/file"
+	sessionsmemory "github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/memory"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/redis"
 	. "github.com/onsi/ginkgo"
@@ -75,6 +79,44 @@ var _ = Describe("NewSessionStore", func() {
 		})
 	})
 
+	Context("with type 'file'", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "oauth2-proxy-sessions")
+			Expect(err).ToNot(HaveOccurred())
+
+			opts.Type = options.FileSessionStoreType
+			opts.File.Path = dir
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("creates a persistence.Manager that wraps a file.SessionStore", func() {
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss).To(BeAssignableToTypeOf(&persistence.Manager{}))
+			Expect(ss.(*persistence.Manager).Store).To(BeAssignableToTypeOf(&sessionsfile.SessionStore{}))
+		})
+	})
+
+	Context("with type 'memory'", func() {
+		BeforeEach(func() {
+			opts.Type = options.MemorySessionStoreType
+			opts.Memory.MaxEntries = 100
+		})
+
+		It("creates a persistence.Manager that wraps a memory.SessionStore", func() {
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss).To(BeAssignableToTypeOf(&persistence.Manager{}))
+			Expect(ss.(*persistence.Manager).Store).To(BeAssignableToTypeOf(&sessionsmemory.SessionStore{}))
+		})
+	})
+
 	Context("with an invalid type", func() {
 		BeforeEach(func() {
 			opts.Type = "invalid-type"
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"crypto/rand"
 	"net/http"
 	"net/http/httptest"
@@ -81,6 +82,7 @@ func RunSessionStoreTests(newSS NewSessionStoreFunc, persistentFastForward Persi
 				RefreshToken: "RefreshToken",
 				Email:        "john.doe@example.com",
 				User:         "john.doe",
+				Groups:       []string{"admins", "developers"},
 			}
 
 			request := httptest.NewRequest("GET", "http://example.com/", nil)
@@ -133,6 +135,92 @@ func RunSessionStoreTests(newSS NewSessionStoreFunc, persistentFastForward Persi
 				PersistentSessionStoreInterfaceTests(&input)
 			}
 		})
+
+		Context("with a rotated cookie secret", func() {
+			var previousSS sessionsapi.SessionStore
+
+			BeforeEach(func() {
+				previousSecret := make([]byte, 32)
+				_, err := rand.Read(previousSecret)
+				Expect(err).ToNot(HaveOccurred())
+
+				previousCookieOpts := *input.cookieOpts
+				previousCookieOpts.Secret = string(previousSecret)
+				previousSS, err = newSS(opts, &previousCookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+
+				input.cookieOpts.PreviousSecrets = []string{string(previousSecret)}
+				ss, err = newSS(opts, input.cookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			SecretRotationTests(&input, func() sessionsapi.SessionStore {
+				return previousSS
+			})
+		})
+	})
+}
+
+// SecretRotationTests checks that sessions saved by a session store using a
+// previous cookie secret can be loaded and are saved with the current secret
+func SecretRotationTests(in *testInput, previousSS sessionStoreFunc) {
+	Context("when Load is called with a session saved with the previous secret", func() {
+		var loadedSession *sessionsapi.SessionState
+
+		BeforeEach(func() {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			resp := httptest.NewRecorder()
+			err := previousSS().Save(resp, req, in.session)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, cookie := range resp.Result().Cookies() {
+				in.request.AddCookie(cookie)
+			}
+
+			loadedSession, err = in.ss().Load(in.request)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("loads a session equal to the original session", func() {
+			Expect(loadedSession.Email).To(Equal(in.session.Email))
+			Expect(loadedSession.User).To(Equal(in.session.User))
+			Expect(loadedSession.Groups).To(Equal(in.session.Groups))
+		})
+
+		It("marks the session as loaded with a previous secret", func() {
+			Expect(loadedSession.LoadedWithPreviousSecret).To(BeTrue())
+		})
+
+		Context("and the session is saved again", func() {
+			var resultCookies []*http.Cookie
+
+			BeforeEach(func() {
+				err := in.ss().Save(in.response, in.request, loadedSession)
+				Expect(err).ToNot(HaveOccurred())
+				resultCookies = in.response.Result().Cookies()
+			})
+
+			It("signs the cookies with the current secret", func() {
+				Expect(resultCookies).ToNot(BeEmpty())
+				for _, cookie := range resultCookies {
+					_, _, seedIndex, ok := encryption.ValidateWithSeeds(cookie, in.cookieOpts.Secrets(), in.cookieOpts.Expire)
+					Expect(ok).To(BeTrue())
+					Expect(seedIndex).To(Equal(0))
+				}
+			})
+
+			It("loads the session without a previous secret", func() {
+				loadReq := httptest.NewRequest("GET", "http://example.com/", nil)
+				for _, cookie := range resultCookies {
+					loadReq.AddCookie(cookie)
+				}
+
+				reloaded, err := in.ss().Load(loadReq)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reloaded.Email).To(Equal(in.session.Email))
+				Expect(reloaded.LoadedWithPreviousSecret).To(BeFalse())
+			})
+		})
 	})
 }
 
@@ -244,6 +332,161 @@ func PersistentSessionStoreInterfaceTests(in *testInput) {
 		CheckCookieOptions(in)
 	})
 
+	// Check that sessions can be cleared by their OIDC session ID or subject
+	Context("when ClearOIDCSessions is called on a persistent store", func() {
+		var resultCookies []*http.Cookie
+
+		BeforeEach(func() {
+			in.session.OIDCSessionID = "oidc-session-id"
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			saveResp := httptest.NewRecorder()
+			err := in.ss().Save(saveResp, req, in.session)
+			Expect(err).ToNot(HaveOccurred())
+			resultCookies = saveResp.Result().Cookies()
+		})
+
+		loadSession := func() (*sessionsapi.SessionState, error) {
+			loadReq := httptest.NewRequest("GET", "http://example.com/", nil)
+			for _, c := range resultCookies {
+				loadReq.AddCookie(c)
+			}
+			return in.ss().Load(loadReq)
+		}
+
+		It("implements OIDCSessionRevoker", func() {
+			_, ok := in.ss().(sessionsapi.OIDCSessionRevoker)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("clears sessions with the OIDC session ID", func() {
+			revoker := in.ss().(sessionsapi.OIDCSessionRevoker)
+			Expect(revoker.ClearOIDCSessions(context.Background(), "", "oidc-session-id", "")).To(Succeed())
+
+			loaded, err := loadSession()
+			Expect(err).To(HaveOccurred())
+			Expect(loaded).To(BeNil())
+		})
+
+		It("clears sessions with the subject", func() {
+			revoker := in.ss().(sessionsapi.OIDCSessionRevoker)
+			Expect(revoker.ClearOIDCSessions(context.Background(), "", "", in.session.User)).To(Succeed())
+
+			loaded, err := loadSession()
+			Expect(err).To(HaveOccurred())
+			Expect(loaded).To(BeNil())
+		})
+
+		It("keeps sessions with another OIDC session ID", func() {
+			revoker := in.ss().(sessionsapi.OIDCSessionRevoker)
+			Expect(revoker.ClearOIDCSessions(context.Background(), "", "other-session-id", "")).To(Succeed())
+
+			loaded, err := loadSession()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.OIDCSessionID).To(Equal("oidc-session-id"))
+		})
+
+		It("keeps sessions of another provider with the OIDC session ID", func() {
+			revoker := in.ss().(sessionsapi.OIDCSessionRevoker)
+			Expect(revoker.ClearOIDCSessions(context.Background(), "other-provider", "oidc-session-id", "")).To(Succeed())
+			Expect(revoker.ClearOIDCSessions(context.Background(), "other-provider", "", in.session.User)).To(Succeed())
+
+			loaded, err := loadSession()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.OIDCSessionID).To(Equal("oidc-session-id"))
+		})
+	})
+
+	// Check that the sessions of a user can be listed and revoked
+	Context("when the sessions of a user are administered on a persistent store", func() {
+		var sessionCookies [][]*http.Cookie
+
+		BeforeEach(func() {
+			sessionCookies = nil
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				saveResp := httptest.NewRecorder()
+				err := in.ss().Save(saveResp, req, in.session)
+				Expect(err).ToNot(HaveOccurred())
+				sessionCookies = append(sessionCookies, saveResp.Result().Cookies())
+			}
+		})
+
+		loadSession := func(cookies []*http.Cookie) (*sessionsapi.SessionState, error) {
+			loadReq := httptest.NewRequest("GET", "http://example.com/", nil)
+			for _, c := range cookies {
+				loadReq.AddCookie(c)
+			}
+			return in.ss().Load(loadReq)
+		}
+
+		It("implements SessionAdministrator", func() {
+			_, ok := in.ss().(sessionsapi.SessionAdministrator)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("lists the sessions of the user", func() {
+			admin := in.ss().(sessionsapi.SessionAdministrator)
+			infos, err := admin.ListSessions(context.Background(), in.session.Email)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(HaveLen(2))
+			Expect(infos[0].ID).ToNot(Equal(infos[1].ID))
+			Expect(infos[0].ExpiresAt).To(BeTemporally("~", time.Now().Add(in.cookieOpts.Expire), time.Minute))
+		})
+
+		It("lists no sessions for another user", func() {
+			admin := in.ss().(sessionsapi.SessionAdministrator)
+			infos, err := admin.ListSessions(context.Background(), "jane.doe@example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(BeEmpty())
+		})
+
+		It("lists the user when the store supports listing", func() {
+			admin := in.ss().(sessionsapi.SessionAdministrator)
+			users, err := admin.ListUsers(context.Background())
+			if err == sessionsapi.ErrListingNotSupported {
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(users).To(ConsistOf(in.session.Email))
+		})
+
+		It("revokes a single session", func() {
+			admin := in.ss().(sessionsapi.SessionAdministrator)
+			infos, err := admin.ListSessions(context.Background(), in.session.Email)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(HaveLen(2))
+
+			Expect(admin.RevokeSession(context.Background(), infos[0].ID)).To(Succeed())
+
+			remaining, err := admin.ListSessions(context.Background(), in.session.Email)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(remaining).To(ConsistOf(infos[1]))
+		})
+
+		It("does not revoke keys that are not sessions", func() {
+			admin := in.ss().(sessionsapi.SessionAdministrator)
+			Expect(admin.RevokeSession(context.Background(), "_oauth2_proxy-user-abc")).ToNot(Succeed())
+		})
+
+		It("revokes every session of the user", func() {
+			admin := in.ss().(sessionsapi.SessionAdministrator)
+			revoked, err := admin.RevokeUserSessions(context.Background(), in.session.Email)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(Equal(2))
+
+			for _, cookies := range sessionCookies {
+				loaded, err := loadSession(cookies)
+				Expect(err).To(HaveOccurred())
+				Expect(loaded).To(BeNil())
+			}
+
+			infos, err := admin.ListSessions(context.Background(), in.session.Email)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(BeEmpty())
+		})
+	})
+
 	// Test TTLs and cleanup of persistent session storage
 	// For non-persistent we rely on the browser cookie lifecycle
 	Context("when Load is called on a persistent store", func() {
@@ -410,14 +653,19 @@ func LoadSessionTests(in *testInput) {
 		l := *loadedSession
 		l.CreatedAt = nil
 		l.ExpiresOn = nil
+		l.TicketIssuedAt = nil
 		s := *in.session
 		s.CreatedAt = nil
 		s.ExpiresOn = nil
+		s.TicketIssuedAt = nil
 		Expect(l).To(Equal(s))
 
 		// Compare time.Time separately
 		Expect(loadedSession.CreatedAt.Equal(*in.session.CreatedAt)).To(BeTrue())
 		Expect(loadedSession.ExpiresOn.Equal(*in.session.ExpiresOn)).To(BeTrue())
+		if in.session.TicketIssuedAt != nil {
+			Expect(loadedSession.TicketIssuedAt.Equal(*in.session.TicketIssuedAt)).To(BeTrue())
+		}
 
 	})
 }
@@ -0,0 +1,57 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/util"
+)
+
+// validateClientTLS checks the client certificate settings and loads the CAs
+// client certificates are verified with
+func validateClientTLS(o *options.Options) []string {
+	if len(o.ClientTLS.CAFiles) == 0 {
+		if o.ClientTLS.Header != "" {
+			return []string{"missing setting: tls-client-ca-file is required with tls-client-cert-header"}
+		}
+		return []string{}
+	}
+
+	msgs := []string{}
+	pool, err := util.GetCertPool(o.ClientTLS.CAFiles)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to load client CA file(s): %v", err))
+	}
+	o.SetClientCAPool(pool)
+
+	switch o.ClientTLS.UserField {
+	case options.ClientCertUserCommonName, options.ClientCertUserEmail, options.ClientCertUserURI:
+	default:
+		msgs = append(msgs, fmt.Sprintf("tls_client_cert_user (%s) must be one of: cn, email, uri", o.ClientTLS.UserField))
+	}
+
+	if o.ClientTLS.Header == "" {
+		if o.TLSCertFile == "" && o.TLSKeyFile == "" {
+			msgs = append(msgs, "tls_client_ca_files requires an HTTPS listener: set tls-cert-file and tls-key-file, or tls-client-cert-header behind a front proxy")
+		}
+		return msgs
+	}
+
+	// The header can be set by any client that can reach the proxy directly,
+	// it is only read from the connections of the front proxies
+	if len(o.ClientTLS.TrustedProxies) == 0 {
+		msgs = append(msgs, "missing setting: tls-client-cert-trusted-proxy is required with tls-client-cert-header, only the front proxies may set the header")
+	}
+	for i, ipStr := range o.ClientTLS.TrustedProxies {
+		if ip.ParseIPNet(ipStr) == nil {
+			msgs = append(msgs, fmt.Sprintf("tls_client_cert_trusted_proxies[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+	switch o.ClientTLS.HeaderFormat {
+	case options.PEMClientCertHeader, options.XFCCClientCertHeader:
+	default:
+		msgs = append(msgs, fmt.Sprintf("tls_client_cert_header_format (%s) must be one of: pem, xfcc", o.ClientTLS.HeaderFormat))
+	}
+	return msgs
+}
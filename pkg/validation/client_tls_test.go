@@ -0,0 +1,158 @@
+package validation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	. "github.com/onsi/gomega"
+)
+
+// Test certificate created with an OpenSSL command in the following form:
+// openssl req -x509 -newkey rsa:4096 -keyout key-unused.pem -out cert.pem -nodes -subj "/CN=oauth-proxy test ca"
+const clientTLSTestCA = `-----BEGIN CERTIFICATE-----
+MIICuTCCAaGgAwIBAgIFAKuKEWowDQYJKoZIhvcNAQELBQAwHjEcMBoGA1UEAxMT
+b2F1dGgtcHJveHkgdGVzdCBjYTAeFw0xNzEwMjQyMDExMzJaFw0xOTEwMjQyMDEx
+MzJaMB4xHDAaBgNVBAMTE29hdXRoLXByb3h5IHRlc3QgY2EwggEiMA0GCSqGSIb3
+DQEBAQUAA4IBDwAwggEKAoIBAQC5/kmgKNiECuxlj27yTWBWOMVvIB0AaRhQrMA7
+3iSCk/SHhaTabUuXUGRwmCAewT/y9oX3rTdfnSPCn7praU/27lRFBgOGFrTzAZH6
+voisF54I3ZxWZgHDJ/ig/KFwd0Y8OATj9/k9uAJSCe6aT7BouJPZVWNGF2dF5BOJ
+EwFsJiN2s8HpF14DhxFOMMtlckdMHGxi3wj3E/hBCfGvGGU4Wezz48vEWWC1ajWM
+qVq2vVWi1bcNft8FjWa5wTGpdlDQJM7yvKYJPwRkEjgIXtF1ra3JM3WTTFZO9Yhd
+QXwO7IWRTdTaypKTNbTDKuWQZsm7xQM9sNcFkukGb3o+uBpLAgMBAAEwDQYJKoZI
+hvcNAQELBQADggEBAHJNrUfHhN7VOUF60pG8sOEkx0ztjbtbYMj2N9Kb0oSya+re
+Kmb2Z4JgyV7XHCZ03Jch6L7UBI3Y6/Lp1zdwU03LFayVUchLkvFonoXpRRP5UFYN
++36xP3ZL1qBYFphARsCk6/tl36czH4oF5gTlhWCRy3upNzn+INk467hnCKt5xuse
+zhm+xQv/VN1poI0S/oCg9HLA9iKpoqGJByN32yoFr3QViLPqkmJ1v8EiH0Ns+1m3
+pP5YlVqdRCVrxgT80PIMsvQhfcuIrbbeiRDEUdEX7FqebuGCEa2757MTdW7UYQiB
+7kgECMnwAOlJME8aDKnmTBajaMy6xCSC87V7wps=
+-----END CERTIFICATE-----
+`
+
+func Test_validateClientTLS(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "client-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	caFile := filepath.Join(tempDir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte(clientTLSTestCA), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missingFile := filepath.Join(tempDir, "missing.pem")
+
+	const (
+		headerWithoutCAMsg = "missing setting: tls-client-ca-file is required with tls-client-cert-header"
+		userFieldMsg       = "tls_client_cert_user (dn) must be one of: cn, email, uri"
+		listenerMsg        = "tls_client_ca_files requires an HTTPS listener: set tls-cert-file and tls-key-file, or tls-client-cert-header behind a front proxy"
+		trustedProxyMsg    = "missing setting: tls-client-cert-trusted-proxy is required with tls-client-cert-header, only the front proxies may set the header"
+		invalidProxyMsg    = "tls_client_cert_trusted_proxies[1] (proxy.example.com) could not be recognized"
+		headerFormatMsg    = "tls_client_cert_header_format (der) must be one of: pem, xfcc"
+	)
+	loadMsg := "unable to load client CA file(s): certificate authority file (" + missingFile +
+		") could not be read - open " + missingFile + ": no such file or directory"
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+		loadsPool  bool
+	}{
+		"No client CA files": {
+			opts:       &options.Options{},
+			errStrings: []string{},
+		},
+		"Client CA files with an HTTPS listener": {
+			opts: &options.Options{
+				TLSCertFile: "/etc/tls/tls.crt",
+				TLSKeyFile:  "/etc/tls/tls.key",
+				ClientTLS: options.ClientTLS{
+					CAFiles:   []string{caFile},
+					UserField: options.ClientCertUserCommonName,
+				},
+			},
+			errStrings: []string{},
+			loadsPool:  true,
+		},
+		"Client CA files with a header": {
+			opts: &options.Options{
+				ClientTLS: options.ClientTLS{
+					CAFiles:        []string{caFile},
+					UserField:      options.ClientCertUserURI,
+					Header:         "X-Forwarded-Client-Cert",
+					HeaderFormat:   options.XFCCClientCertHeader,
+					TrustedProxies: []string{"10.0.0.0/8", "fd00::1"},
+				},
+			},
+			errStrings: []string{},
+			loadsPool:  true,
+		},
+		"Header without client CA files": {
+			opts: &options.Options{
+				ClientTLS: options.ClientTLS{
+					Header:         "X-Client-Cert",
+					TrustedProxies: []string{"10.0.0.1"},
+				},
+			},
+			errStrings: []string{headerWithoutCAMsg},
+		},
+		"Missing client CA file": {
+			opts: &options.Options{
+				TLSCertFile: "/etc/tls/tls.crt",
+				TLSKeyFile:  "/etc/tls/tls.key",
+				ClientTLS: options.ClientTLS{
+					CAFiles:   []string{missingFile},
+					UserField: options.ClientCertUserEmail,
+				},
+			},
+			errStrings: []string{loadMsg},
+		},
+		"Without an HTTPS listener or a header": {
+			opts: &options.Options{
+				ClientTLS: options.ClientTLS{
+					CAFiles:   []string{caFile},
+					UserField: "dn",
+				},
+			},
+			errStrings: []string{userFieldMsg, listenerMsg},
+			loadsPool:  true,
+		},
+		"Header without trusted proxies": {
+			opts: &options.Options{
+				ReverseProxy: true,
+				ClientTLS: options.ClientTLS{
+					CAFiles:      []string{caFile},
+					UserField:    options.ClientCertUserCommonName,
+					Header:       "X-Client-Cert",
+					HeaderFormat: "der",
+				},
+			},
+			errStrings: []string{trustedProxyMsg, headerFormatMsg},
+			loadsPool:  true,
+		},
+		"Header with an invalid trusted proxy": {
+			opts: &options.Options{
+				ClientTLS: options.ClientTLS{
+					CAFiles:        []string{caFile},
+					UserField:      options.ClientCertUserCommonName,
+					Header:         "X-Client-Cert",
+					HeaderFormat:   options.PEMClientCertHeader,
+					TrustedProxies: []string{"10.0.0.1", "proxy.example.com"},
+				},
+			},
+			errStrings: []string{invalidProxyMsg},
+			loadsPool:  true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateClientTLS(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+			g.Expect(tc.opts.GetClientCAPool() != nil).To(Equal(tc.loadsPool))
+		})
+	}
+}
@@ -11,6 +11,9 @@ import (
 
 func validateCookie(o options.Cookie) []string {
 	msgs := validateCookieSecret(o.Secret)
+	for _, secret := range o.PreviousSecrets {
+		msgs = append(msgs, validatePreviousCookieSecret(secret)...)
+	}
 
 	if o.Refresh >= o.Expire {
 		msgs = append(msgs, fmt.Sprintf(
@@ -52,7 +55,17 @@ func validateCookieSecret(secret string) []string {
 	if secret == "" {
 		return []string{"missing setting: cookie-secret"}
 	}
+	return validateCookieSecretLength("cookie_secret", secret)
+}
+
+func validatePreviousCookieSecret(secret string) []string {
+	if secret == "" {
+		return []string{"cookie_previous_secrets must not contain empty secrets"}
+	}
+	return validateCookieSecretLength("cookie_previous_secrets", secret)
+}
 
+func validateCookieSecretLength(name, secret string) []string {
 	secretBytes := encryption.SecretBytes(secret)
 	// Check if the secret is a valid length
 	switch len(secretBytes) {
@@ -62,7 +75,7 @@ func validateCookieSecret(secret string) []string {
 	}
 	// Invalid secret size found, return a message
 	return []string{fmt.Sprintf(
-		"cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is %d bytes",
-		len(secretBytes)),
+		"%s must be 16, 24, or 32 bytes to create an AES cipher, but is %d bytes",
+		name, len(secretBytes)),
 	}
 }
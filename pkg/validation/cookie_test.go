@@ -34,6 +34,8 @@ func TestValidateCookie(t *testing.T) {
 	missingSecretMsg := "missing setting: cookie-secret"
 	invalidSecretMsg := "cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is 6 bytes"
 	invalidBase64SecretMsg := "cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is 10 bytes"
+	invalidPreviousSecretMsg := "cookie_previous_secrets must be 16, 24, or 32 bytes to create an AES cipher, but is 6 bytes"
+	emptyPreviousSecretMsg := "cookie_previous_secrets must not contain empty secrets"
 	refreshLongerThanExpireMsg := "cookie_refresh (\"1h0m0s\") must be less than cookie_expire (\"15m0s\")"
 	invalidSameSiteMsg := "cookie_samesite (\"invalid\") must be one of ['', 'lax', 'strict', 'none']"
 
@@ -106,6 +108,41 @@ func TestValidateCookie(t *testing.T) {
 			},
 			errStrings: []string{},
 		},
+		{
+			name: "with valid previous secrets",
+			cookie: options.Cookie{
+				Name:            validName,
+				Secret:          validSecret,
+				PreviousSecrets: []string{validBase64Secret, validSecret},
+				Domains:         emptyDomains,
+				Path:            "",
+				Expire:          time.Hour,
+				Refresh:         15 * time.Minute,
+				Secure:          true,
+				HTTPOnly:        false,
+				SameSite:        "",
+			},
+			errStrings: []string{},
+		},
+		{
+			name: "with invalid previous secrets",
+			cookie: options.Cookie{
+				Name:            validName,
+				Secret:          validSecret,
+				PreviousSecrets: []string{invalidSecret, ""},
+				Domains:         emptyDomains,
+				Path:            "",
+				Expire:          time.Hour,
+				Refresh:         15 * time.Minute,
+				Secure:          true,
+				HTTPOnly:        false,
+				SameSite:        "",
+			},
+			errStrings: []string{
+				invalidPreviousSecretMsg,
+				emptyPreviousSecretMsg,
+			},
+		},
 		{
 			name: "with an invalid Base64 secret",
 			cookie: options.Cookie{
@@ -0,0 +1,87 @@
+package validation
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/header"
+)
+
+func validateHeaders(headers []options.Header) []string {
+	msgs := []string{}
+	names := make(map[string]struct{})
+
+	for _, h := range headers {
+		msgs = append(msgs, validateHeader(h, names)...)
+	}
+
+	return msgs
+}
+
+// validateHeader validates that the header has valid values and that the
+// header names are unique across all headers
+func validateHeader(h options.Header, names map[string]struct{}) []string {
+	msgs := []string{}
+
+	if h.Name == "" {
+		msgs = append(msgs, "header has empty name: names are required for all headers")
+	}
+
+	// Ensure header names are unique
+	name := http.CanonicalHeaderKey(h.Name)
+	if _, ok := names[name]; ok {
+		msgs = append(msgs, fmt.Sprintf("multiple headers found with name %q: header names must be unique", h.Name))
+	}
+	names[name] = struct{}{}
+
+	if len(h.Values) == 0 {
+		msgs = append(msgs, fmt.Sprintf("header %q has no values: at least one value is required", h.Name))
+	}
+	for _, value := range h.Values {
+		msgs = append(msgs, validateHeaderValue(h.Name, value)...)
+	}
+
+	return msgs
+}
+
+// validateHeaderValue checks that exactly one source is set for the value
+// and that session fields are known
+func validateHeaderValue(name string, value options.HeaderValue) []string {
+	sources := 0
+	for _, source := range []string{value.Value, value.Claim, value.SessionField} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return []string{fmt.Sprintf("header %q has a value with %d sources: exactly one of value, claim or sessionField must be set", name, sources)}
+	}
+
+	if value.SessionField != "" && !header.IsSessionField(value.SessionField) {
+		return []string{fmt.Sprintf("header %q has unknown sessionField %q", name, value.SessionField)}
+	}
+	return []string{}
+}
+
+// headerClaims returns the names of the claims used by the headers, so the
+// provider can keep them in the session
+func headerClaims(headerLists ...[]options.Header) []string {
+	var claims []string
+	seen := make(map[string]struct{})
+	for _, headers := range headerLists {
+		for _, h := range headers {
+			for _, value := range h.Values {
+				if value.Claim == "" {
+					continue
+				}
+				if _, ok := seen[value.Claim]; ok {
+					continue
+				}
+				seen[value.Claim] = struct{}{}
+				claims = append(claims, value.Claim)
+			}
+		}
+	}
+	return claims
+}
@@ -0,0 +1,130 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Headers", func() {
+	type validateHeaderTableInput struct {
+		headers    []options.Header
+		errStrings []string
+	}
+
+	validClaimHeader := options.Header{
+		Name: "X-Tenant",
+		Values: []options.HeaderValue{
+			{Claim: "tenant"},
+		},
+	}
+	validSessionFieldHeader := options.Header{
+		Name: "X-Groups",
+		Values: []options.HeaderValue{
+			{SessionField: "groups"},
+		},
+		Join: ",",
+	}
+	validStaticHeader := options.Header{
+		Name: "X-Static",
+		Values: []options.HeaderValue{
+			{Value: "static", Prefix: "prefix-"},
+		},
+	}
+
+	emptyNameMsg := "header has empty name: names are required for all headers"
+	noValuesMsg := "header \"X-Foo\" has no values: at least one value is required"
+	multipleNamesMsg := "multiple headers found with name \"x-tenant\": header names must be unique"
+	noSourcesMsg := "header \"X-Foo\" has a value with 0 sources: exactly one of value, claim or sessionField must be set"
+	multipleSourcesMsg := "header \"X-Foo\" has a value with 2 sources: exactly one of value, claim or sessionField must be set"
+	unknownSessionFieldMsg := "header \"X-Foo\" has unknown sessionField \"unknown\""
+
+	DescribeTable("validateHeaders",
+		func(o *validateHeaderTableInput) {
+			Expect(validateHeaders(o.headers)).To(ConsistOf(o.errStrings))
+		},
+		Entry("with no headers", &validateHeaderTableInput{
+			headers:    []options.Header{},
+			errStrings: []string{},
+		}),
+		Entry("with valid headers", &validateHeaderTableInput{
+			headers: []options.Header{
+				validClaimHeader,
+				validSessionFieldHeader,
+				validStaticHeader,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an empty name", &validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Values: []options.HeaderValue{{Value: "foo"}},
+				},
+			},
+			errStrings: []string{emptyNameMsg},
+		}),
+		Entry("with no values", &validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "X-Foo",
+				},
+			},
+			errStrings: []string{noValuesMsg},
+		}),
+		Entry("with duplicate names", &validateHeaderTableInput{
+			headers: []options.Header{
+				validClaimHeader,
+				{
+					Name:   "x-tenant",
+					Values: []options.HeaderValue{{Value: "foo"}},
+				},
+			},
+			errStrings: []string{multipleNamesMsg},
+		}),
+		Entry("with a value without a source", &validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Foo",
+					Values: []options.HeaderValue{{Prefix: "foo"}},
+				},
+			},
+			errStrings: []string{noSourcesMsg},
+		}),
+		Entry("with a value with multiple sources", &validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Foo",
+					Values: []options.HeaderValue{{Value: "foo", Claim: "bar"}},
+				},
+			},
+			errStrings: []string{multipleSourcesMsg},
+		}),
+		Entry("with an unknown session field", &validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name:   "X-Foo",
+					Values: []options.HeaderValue{{SessionField: "unknown"}},
+				},
+			},
+			errStrings: []string{unknownSessionFieldMsg},
+		}),
+	)
+
+	It("headerClaims returns the unique claims used by the headers", func() {
+		requestHeaders := []options.Header{
+			validClaimHeader,
+			validSessionFieldHeader,
+			{
+				Name: "X-Departments",
+				Values: []options.HeaderValue{
+					{Claim: "departments"},
+					{Claim: "tenant"},
+				},
+			},
+		}
+		responseHeaders := []options.Header{validClaimHeader}
+
+		Expect(headerClaims(requestHeaders, responseHeaders)).To(Equal([]string{"tenant", "departments"}))
+	})
+})
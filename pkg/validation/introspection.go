@@ -0,0 +1,30 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+)
+
+// validateIntrospection checks the settings of bearer token introspection.
+// The provider must have been configured, its endpoint may be discovered.
+func validateIntrospection(o *options.Options) []string {
+	if !o.Introspection.Enabled {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Introspection.CacheTTL < time.Duration(0) {
+		msgs = append(msgs, "introspection_cache_ttl must not be negative")
+	}
+	if o.Introspection.CacheMaxEntries < 1 {
+		msgs = append(msgs, "introspection_cache_max_entries must be greater than 0")
+	}
+	if provider := o.GetProvider(); provider != nil {
+		introspectionURL := provider.Data().IntrospectionURL
+		if introspectionURL == nil || introspectionURL.String() == "" {
+			msgs = append(msgs, "missing setting: introspection-url")
+		}
+	}
+	return msgs
+}
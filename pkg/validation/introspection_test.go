@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/providers"
+	. "github.com/onsi/gomega"
+)
+
+func Test_validateIntrospection(t *testing.T) {
+	const (
+		cacheTTLMsg         = "introspection_cache_ttl must not be negative"
+		cacheMaxEntriesMsg  = "introspection_cache_max_entries must be greater than 0"
+		introspectionURLMsg = "missing setting: introspection-url"
+	)
+
+	introspectionURL, _ := url.Parse("https://issuer.example.com/introspect")
+
+	testCases := map[string]struct {
+		introspection    options.Introspection
+		introspectionURL *url.URL
+		errStrings       []string
+	}{
+		"Introspection disabled": {
+			introspection: options.Introspection{
+				CacheMaxEntries: 0,
+			},
+			errStrings: []string{},
+		},
+		"Introspection with an introspection URL": {
+			introspection: options.Introspection{
+				Enabled:         true,
+				CacheTTL:        time.Minute,
+				CacheMaxEntries: 100,
+			},
+			introspectionURL: introspectionURL,
+			errStrings:       []string{},
+		},
+		"Introspection without a cache": {
+			introspection: options.Introspection{
+				Enabled:         true,
+				CacheMaxEntries: 100,
+			},
+			introspectionURL: introspectionURL,
+			errStrings:       []string{},
+		},
+		"Introspection without an introspection URL": {
+			introspection: options.Introspection{
+				Enabled:         true,
+				CacheTTL:        time.Minute,
+				CacheMaxEntries: 100,
+			},
+			introspectionURL: &url.URL{},
+			errStrings:       []string{introspectionURLMsg},
+		},
+		"Introspection with invalid cache settings": {
+			introspection: options.Introspection{
+				Enabled:         true,
+				CacheTTL:        -time.Minute,
+				CacheMaxEntries: 0,
+			},
+			introspectionURL: introspectionURL,
+			errStrings:       []string{cacheTTLMsg, cacheMaxEntriesMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			o := &options.Options{
+				Introspection: tc.introspection,
+			}
+			o.SetProvider(providers.New("oidc", &providers.ProviderData{
+				IntrospectionURL: tc.introspectionURL,
+			}))
+
+			errStrings := validateIntrospection(o)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
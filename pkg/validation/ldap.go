@@ -0,0 +1,60 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/ldap"
+)
+
+func validateLDAP(o *options.Options) []string {
+	if o.LDAP.URL == "" {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.HtpasswdFile != "" {
+		msgs = append(msgs, "htpasswd_file and ldap_url cannot both be set")
+	}
+
+	u, err := url.Parse(o.LDAP.URL)
+	switch {
+	case err != nil:
+		msgs = append(msgs, fmt.Sprintf("invalid ldap_url: %v", err))
+	case u.Scheme != "ldap" && u.Scheme != "ldaps":
+		msgs = append(msgs, fmt.Sprintf("invalid ldap_url %q: the scheme must be ldap or ldaps", o.LDAP.URL))
+	case u.Hostname() == "":
+		msgs = append(msgs, fmt.Sprintf("invalid ldap_url %q: missing host", o.LDAP.URL))
+	case u.Scheme == "ldaps" && o.LDAP.StartTLS:
+		msgs = append(msgs, "ldap_start_tls cannot be used with an ldaps:// ldap_url")
+	}
+
+	if o.LDAP.BindDN != "" && o.LDAP.BindPassword == "" {
+		msgs = append(msgs, "missing setting: ldap_bind_password is required with ldap_bind_dn")
+	}
+	if o.LDAP.UserBaseDN == "" {
+		msgs = append(msgs, "missing setting: ldap_user_base_dn")
+	}
+	if o.LDAP.Timeout <= time.Duration(0) {
+		msgs = append(msgs, "ldap_timeout must be greater than 0")
+	}
+	if o.LDAP.CacheTTL < time.Duration(0) {
+		msgs = append(msgs, "ldap_cache_ttl must not be negative")
+	}
+
+	if !strings.Contains(o.LDAP.UserFilter, "{username}") {
+		msgs = append(msgs, "ldap_user_filter must contain {username}")
+	} else if err := ldap.ValidateFilter(strings.ReplaceAll(o.LDAP.UserFilter, "{username}", "x")); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid ldap_user_filter: %v", err))
+	}
+	if o.LDAP.GroupFilter != "" {
+		groupFilter := strings.NewReplacer("{dn}", "x", "{username}", "x").Replace(o.LDAP.GroupFilter)
+		if err := ldap.ValidateFilter(groupFilter); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid ldap_group_filter: %v", err))
+		}
+	}
+	return msgs
+}
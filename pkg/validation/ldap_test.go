@@ -0,0 +1,117 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	. "github.com/onsi/gomega"
+)
+
+func Test_validateLDAP(t *testing.T) {
+	const (
+		htpasswdMsg     = "htpasswd_file and ldap_url cannot both be set"
+		schemeMsg       = "invalid ldap_url \"https://ldap.example.com\": the scheme must be ldap or ldaps"
+		startTLSMsg     = "ldap_start_tls cannot be used with an ldaps:// ldap_url"
+		bindPasswordMsg = "missing setting: ldap_bind_password is required with ldap_bind_dn"
+		userBaseDNMsg   = "missing setting: ldap_user_base_dn"
+		timeoutMsg      = "ldap_timeout must be greater than 0"
+		cacheTTLMsg     = "ldap_cache_ttl must not be negative"
+		usernameMsg     = "ldap_user_filter must contain {username}"
+		groupFilterMsg  = "invalid ldap_group_filter: invalid filter \"(&(cn=admins)(member=x)\": filters must end with ')'"
+	)
+
+	validLDAP := func() options.LDAP {
+		return options.LDAP{
+			URL:          "ldap://ldap.example.com",
+			StartTLS:     true,
+			BindDN:       "cn=proxy,dc=example,dc=com",
+			BindPassword: "secret",
+			UserBaseDN:   "ou=people,dc=example,dc=com",
+			UserFilter:   "(uid={username})",
+			GroupFilter:  "(&(cn=admins)(member={dn}))",
+			Timeout:      10 * time.Second,
+		}
+	}
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"No LDAP URL": {
+			opts:       &options.Options{},
+			errStrings: []string{},
+		},
+		"Valid LDAP options": {
+			opts: &options.Options{
+				LDAP: validLDAP(),
+			},
+			errStrings: []string{},
+		},
+		"LDAP with an htpasswd file": {
+			opts: &options.Options{
+				HtpasswdFile: "/etc/htpasswd",
+				LDAP:         validLDAP(),
+			},
+			errStrings: []string{htpasswdMsg},
+		},
+		"Invalid URL scheme": {
+			opts: &options.Options{
+				LDAP: func() options.LDAP {
+					l := validLDAP()
+					l.URL = "https://ldap.example.com"
+					return l
+				}(),
+			},
+			errStrings: []string{schemeMsg},
+		},
+		"StartTLS with LDAPS": {
+			opts: &options.Options{
+				LDAP: func() options.LDAP {
+					l := validLDAP()
+					l.URL = "ldaps://ldap.example.com"
+					return l
+				}(),
+			},
+			errStrings: []string{startTLSMsg},
+		},
+		"Missing settings": {
+			opts: &options.Options{
+				LDAP: options.LDAP{
+					URL:        "ldaps://ldap.example.com",
+					BindDN:     "cn=proxy,dc=example,dc=com",
+					UserFilter: "(uid=admin)",
+				},
+			},
+			errStrings: []string{bindPasswordMsg, userBaseDNMsg, timeoutMsg, usernameMsg},
+		},
+		"Negative cache TTL": {
+			opts: &options.Options{
+				LDAP: func() options.LDAP {
+					l := validLDAP()
+					l.CacheTTL = -time.Minute
+					return l
+				}(),
+			},
+			errStrings: []string{cacheTTLMsg},
+		},
+		"Invalid group filter": {
+			opts: &options.Options{
+				LDAP: func() options.LDAP {
+					l := validLDAP()
+					l.GroupFilter = "(&(cn=admins)(member={dn})"
+					return l
+				}(),
+			},
+			errStrings: []string{groupFilterMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateLDAP(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
@@ -16,9 +16,11 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/saml"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/util"
 	"github.com/oauth2-proxy/oauth2-proxy/providers"
 )
@@ -28,6 +30,15 @@ import (
 func Validate(o *options.Options) error {
 	msgs := validateCookie(o.Cookie)
 	msgs = append(msgs, validateSessionCookieMinimal(o)...)
+	msgs = append(msgs, validateSessionCookieKeyring(o)...)
+	msgs = append(msgs, validateSessionFile(o)...)
+	msgs = append(msgs, validateSessionMemory(o)...)
+	msgs = append(msgs, validateSessionLifetime(o)...)
+	msgs = append(msgs, validateSessionTicketRotation(o)...)
+	msgs = append(msgs, validateSessionBinding(o)...)
+	msgs = append(msgs, validateSessionAdmin(o)...)
+	msgs = append(msgs, validateLDAP(o)...)
+	msgs = append(msgs, validateClientTLS(o)...)
 
 	if o.SSLInsecureSkipVerify {
 		insecureTransport := &http.Transport{
@@ -49,107 +60,29 @@ func Validate(o *options.Options) error {
 		}
 	}
 
-	if o.ClientID == "" {
-		msgs = append(msgs, "missing setting: client-id")
-	}
-	// login.gov uses a signed JWT to authenticate, not a client-secret
-	if o.ProviderType != "login.gov" {
-		if o.ClientSecret == "" && o.ClientSecretFile == "" {
-			msgs = append(msgs, "missing setting: client-secret or client-secret-file")
-		}
-		if o.ClientSecret == "" && o.ClientSecretFile != "" {
-			_, err := ioutil.ReadFile(o.ClientSecretFile)
-			if err != nil {
-				msgs = append(msgs, "could not read client secret file: "+o.ClientSecretFile)
-			}
-		}
+	if len(o.Providers) == 0 {
+		msgs = append(msgs, validateClient(o)...)
 	}
-	if o.AuthenticatedEmailsFile == "" && len(o.EmailDomains) == 0 && o.HtpasswdFile == "" {
+	if o.AuthenticatedEmailsFile == "" && len(o.EmailDomains) == 0 && o.HtpasswdFile == "" && o.LDAP.URL == "" {
 		msgs = append(msgs, "missing setting for email validation: email-domain or authenticated-emails-file required."+
 			"\n      use email-domain=* to authorize all email addresses")
 	}
 
+	if o.CodeChallengeMethod != "" && o.CodeChallengeMethod != encryption.CodeChallengeMethodS256 {
+		msgs = append(msgs, fmt.Sprintf("unsupported code-challenge-method %q: only %q is supported", o.CodeChallengeMethod, encryption.CodeChallengeMethodS256))
+	}
+
 	if o.SetBasicAuth && o.SetAuthorization {
 		msgs = append(msgs, "mutually exclusive: set-basic-auth and set-authorization-header can not both be true")
 	}
 
-	if o.OIDCIssuerURL != "" {
-
-		ctx := context.Background()
-
-		if o.InsecureOIDCSkipIssuerVerification && !o.SkipOIDCDiscovery {
-			// go-oidc doesn't let us pass bypass the issuer check this in the oidc.NewProvider call
-			// (which uses discovery to get the URLs), so we'll do a quick check ourselves and if
-			// we get the URLs, we'll just use the non-discovery path.
-
-			logger.Printf("Performing OIDC Discovery...")
-
-			requestURL := strings.TrimSuffix(o.OIDCIssuerURL, "/") + "/.well-known/openid-configuration"
-			body, err := requests.New(requestURL).
-				WithContext(ctx).
-				Do().
-				UnmarshalJSON()
-			if err != nil {
-				logger.Printf("error: failed to discover OIDC configuration: %v", err)
-			} else {
-				// Prefer manually configured URLs. It's a bit unclear
-				// why you'd be doing discovery and also providing the URLs
-				// explicitly though...
-				if o.LoginURL == "" {
-					o.LoginURL = body.Get("authorization_endpoint").MustString()
-				}
-
-				if o.RedeemURL == "" {
-					o.RedeemURL = body.Get("token_endpoint").MustString()
-				}
-
-				if o.OIDCJwksURL == "" {
-					o.OIDCJwksURL = body.Get("jwks_uri").MustString()
-				}
-
-				if o.ProfileURL == "" {
-					o.ProfileURL = body.Get("userinfo_endpoint").MustString()
-				}
-
-				o.SkipOIDCDiscovery = true
-			}
-		}
-
-		// Construct a manual IDTokenVerifier from issuer URL & JWKS URI
-		// instead of metadata discovery if we enable -skip-oidc-discovery.
-		// In this case we need to make sure the required endpoints for
-		// the provider are configured.
-		if o.SkipOIDCDiscovery {
-			if o.LoginURL == "" {
-				msgs = append(msgs, "missing setting: login-url")
-			}
-			if o.RedeemURL == "" {
-				msgs = append(msgs, "missing setting: redeem-url")
-			}
-			if o.OIDCJwksURL == "" {
-				msgs = append(msgs, "missing setting: oidc-jwks-url")
-			}
-			keySet := oidc.NewRemoteKeySet(ctx, o.OIDCJwksURL)
-			o.SetOIDCVerifier(oidc.NewVerifier(o.OIDCIssuerURL, keySet, &oidc.Config{
-				ClientID:        o.ClientID,
-				SkipIssuerCheck: o.InsecureOIDCSkipIssuerVerification,
-			}))
-		} else {
-			// Configure discoverable provider data.
-			provider, err := oidc.NewProvider(ctx, o.OIDCIssuerURL)
-			if err != nil {
-				return err
-			}
-			o.SetOIDCVerifier(provider.Verifier(&oidc.Config{
-				ClientID:        o.ClientID,
-				SkipIssuerCheck: o.InsecureOIDCSkipIssuerVerification,
-			}))
-
-			o.LoginURL = provider.Endpoint().AuthURL
-			o.RedeemURL = provider.Endpoint().TokenURL
-		}
-		if o.Scope == "" {
-			o.Scope = "openid email profile"
+	if len(o.Providers) > 0 {
+		msgs = parseProviders(o, msgs)
+	} else {
+		var err error
+		msgs, err = configureOIDC(o, msgs)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -177,6 +110,8 @@ func Validate(o *options.Options) error {
 	o.SetRedirectURL(redirectURL)
 
 	msgs = append(msgs, validateUpstreams(o.UpstreamServers)...)
+	msgs = append(msgs, validateHeaders(o.InjectRequestHeaders)...)
+	msgs = append(msgs, validateHeaders(o.InjectResponseHeaders)...)
 
 	for _, u := range o.SkipAuthRegex {
 		compiledRegex, err := regexp.Compile(u)
@@ -186,7 +121,11 @@ func Validate(o *options.Options) error {
 		}
 		o.SetCompiledRegex(append(o.GetCompiledRegex(), compiledRegex))
 	}
-	msgs = parseProviderInfo(o, msgs)
+	if len(o.Providers) == 0 {
+		msgs = parseProviderInfo(o, msgs)
+		o.SetProviders([]providers.Provider{o.GetProvider()})
+	}
+	msgs = append(msgs, validateIntrospection(o)...)
 
 	if len(o.GoogleGroups) > 0 || o.GoogleAdminEmail != "" || o.GoogleServiceAccountJSON != "" {
 		if len(o.GoogleGroups) < 1 {
@@ -233,6 +172,137 @@ func Validate(o *options.Options) error {
 	return nil
 }
 
+// validateClient checks the OAuth client credentials of the provider
+func validateClient(o *options.Options) []string {
+	msgs := []string{}
+	if o.ClientID == "" {
+		msgs = append(msgs, "missing setting: client-id")
+	}
+	// login.gov uses a signed JWT to authenticate, not a client-secret, and
+	// the client-id of saml is the entity ID of the proxy
+	if o.ProviderType != "login.gov" && o.ProviderType != "saml" {
+		// public clients prove the code exchange with PKCE instead of a secret
+		if o.ClientSecret == "" && o.ClientSecretFile == "" && o.CodeChallengeMethod == "" {
+			msgs = append(msgs, "missing setting: client-secret or client-secret-file")
+		}
+		if o.ClientSecret == "" && o.ClientSecretFile != "" {
+			_, err := ioutil.ReadFile(o.ClientSecretFile)
+			if err != nil {
+				msgs = append(msgs, "could not read client secret file: "+o.ClientSecretFile)
+			}
+		}
+	}
+	return msgs
+}
+
+// configureOIDC discovers the endpoints of the OIDC issuer, if one is
+// configured, and builds the verifier for its ID Tokens
+func configureOIDC(o *options.Options, msgs []string) ([]string, error) {
+	if o.OIDCIssuerURL == "" {
+		return msgs, nil
+	}
+
+	ctx := context.Background()
+
+	if o.InsecureOIDCSkipIssuerVerification && !o.SkipOIDCDiscovery {
+		// go-oidc doesn't let us pass bypass the issuer check this in the oidc.NewProvider call
+		// (which uses discovery to get the URLs), so we'll do a quick check ourselves and if
+		// we get the URLs, we'll just use the non-discovery path.
+
+		logger.Printf("Performing OIDC Discovery...")
+
+		requestURL := strings.TrimSuffix(o.OIDCIssuerURL, "/") + "/.well-known/openid-configuration"
+		body, err := requests.New(requestURL).
+			WithContext(ctx).
+			Do().
+			UnmarshalJSON()
+		if err != nil {
+			logger.Printf("error: failed to discover OIDC configuration: %v", err)
+		} else {
+			// Prefer manually configured URLs. It's a bit unclear
+			// why you'd be doing discovery and also providing the URLs
+			// explicitly though...
+			if o.LoginURL == "" {
+				o.LoginURL = body.Get("authorization_endpoint").MustString()
+			}
+
+			if o.RedeemURL == "" {
+				o.RedeemURL = body.Get("token_endpoint").MustString()
+			}
+
+			if o.LogoutURL == "" {
+				o.LogoutURL = body.Get("end_session_endpoint").MustString()
+			}
+
+			if o.OIDCJwksURL == "" {
+				o.OIDCJwksURL = body.Get("jwks_uri").MustString()
+			}
+
+			if o.ProfileURL == "" {
+				o.ProfileURL = body.Get("userinfo_endpoint").MustString()
+			}
+
+			if o.Introspection.URL == "" {
+				o.Introspection.URL = body.Get("introspection_endpoint").MustString()
+			}
+
+			o.SkipOIDCDiscovery = true
+		}
+	}
+
+	// Construct a manual IDTokenVerifier from issuer URL & JWKS URI
+	// instead of metadata discovery if we enable -skip-oidc-discovery.
+	// In this case we need to make sure the required endpoints for
+	// the provider are configured.
+	if o.SkipOIDCDiscovery {
+		if o.LoginURL == "" {
+			msgs = append(msgs, "missing setting: login-url")
+		}
+		if o.RedeemURL == "" {
+			msgs = append(msgs, "missing setting: redeem-url")
+		}
+		if o.OIDCJwksURL == "" {
+			msgs = append(msgs, "missing setting: oidc-jwks-url")
+		}
+		keySet := oidc.NewRemoteKeySet(ctx, o.OIDCJwksURL)
+		o.SetOIDCVerifier(oidc.NewVerifier(o.OIDCIssuerURL, keySet, &oidc.Config{
+			ClientID:        o.ClientID,
+			SkipIssuerCheck: o.InsecureOIDCSkipIssuerVerification,
+		}))
+	} else {
+		// Configure discoverable provider data.
+		provider, err := oidc.NewProvider(ctx, o.OIDCIssuerURL)
+		if err != nil {
+			return msgs, err
+		}
+		o.SetOIDCVerifier(provider.Verifier(&oidc.Config{
+			ClientID:        o.ClientID,
+			SkipIssuerCheck: o.InsecureOIDCSkipIssuerVerification,
+		}))
+
+		o.LoginURL = provider.Endpoint().AuthURL
+		o.RedeemURL = provider.Endpoint().TokenURL
+
+		var claims struct {
+			EndSessionURL    string `json:"end_session_endpoint"`
+			IntrospectionURL string `json:"introspection_endpoint"`
+		}
+		if err := provider.Claims(&claims); err != nil {
+			msgs = append(msgs, fmt.Sprintf("failed to parse OIDC discovery claims: %v", err))
+		}
+		if o.LogoutURL == "" {
+			o.LogoutURL = claims.EndSessionURL
+		}
+		if o.Introspection.URL == "" {
+			o.Introspection.URL = claims.IntrospectionURL
+		}
+	}
+	if o.Scope == "" {
+		o.Scope = "openid email profile"
+	}
+	return msgs, nil
+}
+
 func parseProviderInfo(o *options.Options, msgs []string) []string {
 	p := &providers.ProviderData{
 		Scope:            o.Scope,
@@ -242,23 +312,33 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 		Prompt:           o.Prompt,
 		ApprovalPrompt:   o.ApprovalPrompt,
 		AcrValues:        o.AcrValues,
+		AdditionalClaims: headerClaims(o.InjectRequestHeaders, o.InjectResponseHeaders),
 	}
 	p.LoginURL, msgs = parseURL(o.LoginURL, "login", msgs)
 	p.RedeemURL, msgs = parseURL(o.RedeemURL, "redeem", msgs)
+	p.LogoutURL, msgs = parseURL(o.LogoutURL, "logout", msgs)
 	p.ProfileURL, msgs = parseURL(o.ProfileURL, "profile", msgs)
 	p.ValidateURL, msgs = parseURL(o.ValidateURL, "validate", msgs)
+	p.IntrospectionURL, msgs = parseURL(o.Introspection.URL, "introspection", msgs)
 	p.ProtectedResource, msgs = parseURL(o.ProtectedResource, "resource", msgs)
 
 	o.SetProvider(providers.New(o.ProviderType, p))
 	switch p := o.GetProvider().(type) {
 	case *providers.AzureProvider:
 		p.Configure(o.AzureTenant)
+		p.SetGroupRestriction(o.AzureGroups)
+		p.SetAppRoleRestriction(o.AzureAppRoles)
 	case *providers.GitHubProvider:
 		p.SetOrgTeam(o.GitHubOrg, o.GitHubTeam)
 		p.SetRepo(o.GitHubRepo, o.GitHubToken)
 		p.SetUsers(o.GitHubUsers)
 	case *providers.KeycloakProvider:
 		p.SetGroup(o.KeycloakGroup)
+		p.Verifier = o.GetOIDCVerifier()
+		// the nonce sent with every sign in is verified against the ID token
+		if !hasScope(p.Scope, "openid") {
+			msgs = append(msgs, "keycloak provider requires the openid scope to receive an ID token: add openid to scope")
+		}
 	case *providers.GoogleProvider:
 		if o.GoogleServiceAccountJSON != "" {
 			file, err := os.Open(o.GoogleServiceAccountJSON)
@@ -274,6 +354,7 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 	case *providers.OIDCProvider:
 		p.AllowUnverifiedEmail = o.InsecureOIDCAllowUnverifiedEmail
 		p.UserIDClaim = o.UserIDClaim
+		p.GroupsClaim = o.OIDCGroupsClaim
 		if o.GetOIDCVerifier() == nil {
 			msgs = append(msgs, "oidc provider requires an oidc issuer URL")
 		} else {
@@ -332,6 +413,20 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 				p.JWTKey = signKey
 			}
 		}
+	case *providers.SAMLProvider:
+		p.EmailAttribute = o.SAMLEmailAttribute
+		p.UserAttribute = o.SAMLUserAttribute
+		p.GroupsAttribute = o.SAMLGroupsAttribute
+		if o.SAMLIdPMetadataFile == "" {
+			msgs = append(msgs, "saml provider requires an IdP metadata file")
+		} else {
+			idp, err := saml.LoadIdPMetadata(o.SAMLIdPMetadataFile)
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("invalid saml-idp-metadata-file: %v", err))
+			} else {
+				p.Configure(idp)
+			}
+		}
 	}
 	return msgs
 }
@@ -411,3 +506,13 @@ func parseURL(toParse string, urltype string, msgs []string) (*url.URL, []string
 	}
 	return parsed, msgs
 }
+
+// hasScope checks whether the space separated scopes contain the scope
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
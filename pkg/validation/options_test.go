@@ -54,6 +54,20 @@ func TestNewOptions(t *testing.T) {
 	assert.Equal(t, expected, err.Error())
 }
 
+func TestPublicClientOption(t *testing.T) {
+	o := options.NewOptions()
+	o.Cookie.Secret = cookieSecret
+	o.ClientID = clientID
+	o.CodeChallengeMethod = "S256"
+	o.EmailDomains = []string{"*"}
+	err := Validate(o)
+	assert.Equal(t, nil, err)
+
+	s, err := o.GetProvider().Data().GetClientSecret()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", s)
+}
+
 func TestClientSecretFileOptionFails(t *testing.T) {
 	o := options.NewOptions()
 	o.Cookie.Secret = cookieSecret
@@ -226,6 +240,32 @@ func TestCookieRefreshMustBeLessThanCookieExpire(t *testing.T) {
 	assert.Equal(t, nil, Validate(o))
 }
 
+func TestCodeChallengeMethod(t *testing.T) {
+	o := testOptions()
+	o.CodeChallengeMethod = "S256"
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.CodeChallengeMethod = "plain"
+	err := Validate(o)
+	assert.NotEqual(t, nil, err)
+	assert.Contains(t, err.Error(), "unsupported code-challenge-method \"plain\"")
+}
+
+func TestKeycloakScope(t *testing.T) {
+	o := testOptions()
+	o.ProviderType = "keycloak"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, "openid api", o.GetProvider().Data().Scope)
+
+	o = testOptions()
+	o.ProviderType = "keycloak"
+	o.Scope = "api"
+	err := Validate(o)
+	assert.NotEqual(t, nil, err)
+	assert.Contains(t, err.Error(), "keycloak provider requires the openid scope")
+}
+
 func TestBase64CookieSecret(t *testing.T) {
 	o := testOptions()
 	assert.Equal(t, nil, Validate(o))
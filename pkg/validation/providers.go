@@ -0,0 +1,129 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/coreos/go-oidc"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/providers"
+)
+
+// providerIDRegex limits provider IDs to characters that can be carried in
+// the OAuth state
+var providerIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// parseProviders builds the providers configured in the alpha config. The
+// first provider is the default provider, used when a request does not choose
+// one, eg. for bearer tokens.
+func parseProviders(o *options.Options, msgs []string) []string {
+	ids := make(map[string]struct{})
+	configured := []providers.Provider{}
+	verifiers := map[string]*oidc.IDTokenVerifier{}
+
+	for i, provider := range o.Providers {
+		if provider.ID == "" {
+			msgs = append(msgs, "provider has empty id: ids are required for all providers")
+			continue
+		}
+		if !providerIDRegex.MatchString(provider.ID) {
+			msgs = append(msgs, fmt.Sprintf("provider id %q is invalid: ids may only contain letters, digits, '-' and '_'", provider.ID))
+			continue
+		}
+		if _, ok := ids[provider.ID]; ok {
+			msgs = append(msgs, fmt.Sprintf("multiple providers found with id %q: provider ids must be unique", provider.ID))
+			continue
+		}
+		ids[provider.ID] = struct{}{}
+
+		if provider.Type == "" {
+			msgs = append(msgs, fmt.Sprintf("provider %q has empty provider type: types are required for all providers", provider.ID))
+			continue
+		}
+
+		po := providerOptions(o, provider)
+		providerMsgs := validateClient(po)
+		providerMsgs, err := configureOIDC(po, providerMsgs)
+		if err != nil {
+			providerMsgs = append(providerMsgs, err.Error())
+		} else {
+			providerMsgs = parseProviderInfo(po, providerMsgs)
+		}
+		if len(providerMsgs) > 0 {
+			for _, msg := range providerMsgs {
+				msgs = append(msgs, fmt.Sprintf("provider %q: %s", provider.ID, msg))
+			}
+			continue
+		}
+
+		p := po.GetProvider()
+		p.Data().ID = provider.ID
+		if provider.Name != "" {
+			p.Data().ProviderName = provider.Name
+		}
+		if i == 0 {
+			o.SetProvider(p)
+			o.SetOIDCVerifier(po.GetOIDCVerifier())
+		}
+		if po.GetOIDCVerifier() != nil {
+			verifiers[provider.ID] = po.GetOIDCVerifier()
+		}
+		configured = append(configured, p)
+	}
+
+	o.SetProviders(configured)
+	o.SetProviderOIDCVerifiers(verifiers)
+	return msgs
+}
+
+// providerOptions builds the options for a provider from the legacy options
+// by replacing the client and endpoint settings with those of the provider.
+// Other provider specific settings, eg. `--github-user`, apply to every
+// provider of that type.
+func providerOptions(o *options.Options, provider options.Provider) *options.Options {
+	po := *o
+	po.ProviderType = provider.Type
+	po.ProviderName = provider.Name
+	po.ClientID = provider.ClientID
+	po.ClientSecret = provider.ClientSecret
+	po.ClientSecretFile = provider.ClientSecretFile
+	po.Scope = provider.Scope
+	po.OIDCIssuerURL = provider.OIDCIssuerURL
+	po.LoginURL = provider.LoginURL
+	po.RedeemURL = provider.RedeemURL
+	po.ProfileURL = provider.ProfileURL
+	po.ValidateURL = provider.ValidateURL
+	po.Introspection.URL = provider.IntrospectionURL
+	po.SAMLIdPMetadataFile = provider.SAMLIdPMetadataFile
+
+	// Endpoints of the legacy provider are discovered or set by flags that
+	// have no equivalent for the provider
+	po.LogoutURL = ""
+	po.ProtectedResource = ""
+	po.OIDCJwksURL = ""
+	po.SkipOIDCDiscovery = false
+
+	if provider.AzureTenant != "" {
+		po.AzureTenant = provider.AzureTenant
+	}
+	if provider.GitHubOrg != "" {
+		po.GitHubOrg = provider.GitHubOrg
+	}
+	if provider.GitHubTeam != "" {
+		po.GitHubTeam = provider.GitHubTeam
+	}
+	if provider.SAMLEmailAttribute != "" {
+		po.SAMLEmailAttribute = provider.SAMLEmailAttribute
+	}
+	if provider.SAMLUserAttribute != "" {
+		po.SAMLUserAttribute = provider.SAMLUserAttribute
+	}
+	if provider.SAMLGroupsAttribute != "" {
+		po.SAMLGroupsAttribute = provider.SAMLGroupsAttribute
+	}
+
+	po.SetProvider(nil)
+	po.SetOIDCVerifier(nil)
+	return &po
+}
@@ -0,0 +1,113 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Providers", func() {
+	type parseProvidersTableInput struct {
+		providers   options.Providers
+		errStrings  []string
+		expectedIDs []string
+	}
+
+	employees := options.Provider{
+		ID:           "employees",
+		Type:         "azure",
+		Name:         "Employees",
+		ClientID:     "azure-client",
+		ClientSecret: "azure-secret",
+	}
+	contractors := options.Provider{
+		ID:           "contractors",
+		Type:         "github",
+		ClientID:     "github-client",
+		ClientSecret: "github-secret",
+		GitHubOrg:    "example",
+	}
+
+	DescribeTable("parseProviders",
+		func(o *parseProvidersTableInput) {
+			opts := options.NewOptions()
+			opts.Providers = o.providers
+
+			Expect(parseProviders(opts, []string{})).To(ConsistOf(o.errStrings))
+
+			ids := []string{}
+			for _, provider := range opts.GetProviders() {
+				ids = append(ids, provider.Data().ID)
+			}
+			Expect(ids).To(Equal(o.expectedIDs))
+			if len(o.expectedIDs) > 0 {
+				Expect(opts.GetProvider().Data().ID).To(Equal(o.expectedIDs[0]))
+			}
+		},
+		Entry("with valid providers", &parseProvidersTableInput{
+			providers:   options.Providers{employees, contractors},
+			errStrings:  []string{},
+			expectedIDs: []string{"employees", "contractors"},
+		}),
+		Entry("with an empty ID", &parseProvidersTableInput{
+			providers: options.Providers{
+				{Type: "github", ClientID: "github-client", ClientSecret: "github-secret"},
+			},
+			errStrings:  []string{"provider has empty id: ids are required for all providers"},
+			expectedIDs: []string{},
+		}),
+		Entry("with an invalid ID", &parseProvidersTableInput{
+			providers: options.Providers{
+				{ID: "git:hub", Type: "github", ClientID: "github-client", ClientSecret: "github-secret"},
+			},
+			errStrings:  []string{"provider id \"git:hub\" is invalid: ids may only contain letters, digits, '-' and '_'"},
+			expectedIDs: []string{},
+		}),
+		Entry("with duplicate IDs", &parseProvidersTableInput{
+			providers:   options.Providers{employees, employees},
+			errStrings:  []string{"multiple providers found with id \"employees\": provider ids must be unique"},
+			expectedIDs: []string{"employees"},
+		}),
+		Entry("with an empty type", &parseProvidersTableInput{
+			providers: options.Providers{
+				{ID: "contractors", ClientID: "github-client", ClientSecret: "github-secret"},
+			},
+			errStrings:  []string{"provider \"contractors\" has empty provider type: types are required for all providers"},
+			expectedIDs: []string{},
+		}),
+		Entry("with missing client credentials", &parseProvidersTableInput{
+			providers: options.Providers{
+				employees,
+				{ID: "contractors", Type: "github"},
+			},
+			errStrings: []string{
+				"provider \"contractors\": missing setting: client-id",
+				"provider \"contractors\": missing setting: client-secret or client-secret-file",
+			},
+			expectedIDs: []string{"employees"},
+		}),
+		Entry("with an OIDC provider without an issuer", &parseProvidersTableInput{
+			providers: options.Providers{
+				{ID: "partners", Type: "oidc", ClientID: "oidc-client", ClientSecret: "oidc-secret"},
+			},
+			errStrings:  []string{"provider \"partners\": oidc provider requires an oidc issuer URL"},
+			expectedIDs: []string{},
+		}),
+		Entry("with a SAML provider", &parseProvidersTableInput{
+			providers: options.Providers{
+				employees,
+				{ID: "partners", Type: "saml", ClientID: "oauth2-proxy", SAMLIdPMetadataFile: "../saml/testdata/idp_metadata.xml"},
+			},
+			errStrings:  []string{},
+			expectedIDs: []string{"employees", "partners"},
+		}),
+		Entry("with a SAML provider without IdP metadata", &parseProvidersTableInput{
+			providers: options.Providers{
+				{ID: "partners", Type: "saml", ClientID: "oauth2-proxy"},
+			},
+			errStrings:  []string{"provider \"partners\": saml provider requires an IdP metadata file"},
+			expectedIDs: []string{},
+		}),
+	)
+})
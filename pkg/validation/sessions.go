@@ -1,9 +1,11 @@
 package validation
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 )
 
 func validateSessionCookieMinimal(o *options.Options) []string {
@@ -30,3 +32,113 @@ func validateSessionCookieMinimal(o *options.Options) []string {
 	}
 	return msgs
 }
+
+func validateSessionFile(o *options.Options) []string {
+	if o.Session.Type != options.FileSessionStoreType {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Session.File.Path == "" {
+		msgs = append(msgs, "missing setting for file session store: session-file-path")
+	}
+	if o.Session.File.SweepInterval <= time.Duration(0) {
+		msgs = append(msgs, "session_file_sweep_interval must be greater than 0")
+	}
+	return msgs
+}
+
+func validateSessionMemory(o *options.Options) []string {
+	if o.Session.Type != options.MemorySessionStoreType {
+		return []string{}
+	}
+
+	if o.Session.Memory.MaxEntries < 1 {
+		return []string{"session_memory_max_entries must be greater than 0"}
+	}
+	return []string{}
+}
+
+func validateSessionLifetime(o *options.Options) []string {
+	msgs := []string{}
+	if o.Session.MaxLifetime < time.Duration(0) {
+		msgs = append(msgs, "session_max_lifetime must not be negative")
+	}
+	if o.Session.IdleTimeout < time.Duration(0) {
+		msgs = append(msgs, "session_idle_timeout must not be negative")
+	}
+	if o.Session.IdleTimeout <= time.Duration(0) {
+		return msgs
+	}
+
+	if o.Session.ActivityUpdateInterval <= time.Duration(0) {
+		msgs = append(msgs, "session_activity_update_interval must be greater than 0")
+	}
+	if o.Session.ActivityUpdateInterval >= o.Session.IdleTimeout {
+		msgs = append(msgs, fmt.Sprintf(
+			"session_activity_update_interval (%s) must be less than session_idle_timeout (%s)",
+			o.Session.ActivityUpdateInterval, o.Session.IdleTimeout))
+	}
+	return msgs
+}
+
+func validateSessionTicketRotation(o *options.Options) []string {
+	msgs := []string{}
+	if o.Session.TicketRotationInterval < time.Duration(0) {
+		msgs = append(msgs, "session_ticket_rotation_interval must not be negative")
+	}
+	if o.Session.TicketRotationGracePeriod < time.Duration(0) {
+		msgs = append(msgs, "session_ticket_rotation_grace_period must not be negative")
+	}
+	if o.Session.TicketRotationInterval > time.Duration(0) &&
+		o.Session.TicketRotationGracePeriod >= o.Session.TicketRotationInterval {
+		msgs = append(msgs, fmt.Sprintf(
+			"session_ticket_rotation_grace_period (%s) must be less than session_ticket_rotation_interval (%s)",
+			o.Session.TicketRotationGracePeriod, o.Session.TicketRotationInterval))
+	}
+	return msgs
+}
+
+func validateSessionBinding(o *options.Options) []string {
+	switch o.Session.Binding.IP {
+	case "", options.ExactIPSessionBinding, options.PrefixIPSessionBinding:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("session_binding_ip (%s) must be one of %q or %q",
+			o.Session.Binding.IP, options.ExactIPSessionBinding, options.PrefixIPSessionBinding)}
+	}
+}
+
+func validateSessionAdmin(o *options.Options) []string {
+	if o.AdminToken == "" {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if len(o.AdminToken) < 16 {
+		msgs = append(msgs, "admin_token must be at least 16 characters")
+	}
+	if o.Session.Type == options.CookieSessionStoreType {
+		msgs = append(msgs, "admin_token requires a server side session store, the cookie session store can't be administered")
+	}
+	return msgs
+}
+
+func validateSessionCookieKeyring(o *options.Options) []string {
+	if o.Session.Cookie.KeyringFile == "" {
+		if o.Session.Cookie.KeyringMigration {
+			return []string{"session_cookie_keyring_migration requires session_cookie_keyring_file"}
+		}
+		return []string{}
+	}
+	if o.Session.Type != options.CookieSessionStoreType {
+		return []string{"session_cookie_keyring_file only applies to the cookie session store, server side session stores encrypt every session with the secret from its ticket and can't use a keyring"}
+	}
+
+	keyring, err := encryption.NewKeyring(o.Session.Cookie.KeyringFile)
+	if err != nil {
+		return []string{fmt.Sprintf("could not load session_cookie_keyring_file (%s): %v", o.Session.Cookie.KeyringFile, err)}
+	}
+	o.Session.Cookie.SetKeyring(keyring)
+	return []string{}
+}
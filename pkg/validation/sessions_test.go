@@ -1,6 +1,9 @@
 package validation
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -119,3 +122,412 @@ func Test_validateSessionCookieMinimal(t *testing.T) {
 		})
 	}
 }
+
+func Test_validateSessionFile(t *testing.T) {
+	const (
+		missingPathMsg   = "missing setting for file session store: session-file-path"
+		sweepIntervalMsg = "session_file_sweep_interval must be greater than 0"
+	)
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"Cookie session store": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+			},
+			errStrings: []string{},
+		},
+		"File session store": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.FileSessionStoreType,
+					File: options.FileStoreOptions{
+						Path:          "/var/lib/oauth2-proxy",
+						SweepInterval: time.Minute,
+					},
+				},
+			},
+			errStrings: []string{},
+		},
+		"File session store without a path or sweep interval": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.FileSessionStoreType,
+				},
+			},
+			errStrings: []string{missingPathMsg, sweepIntervalMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionFile(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
+
+func Test_validateSessionMemory(t *testing.T) {
+	const maxEntriesMsg = "session_memory_max_entries must be greater than 0"
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"Cookie session store": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Memory session store": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.MemorySessionStoreType,
+					Memory: options.MemoryStoreOptions{
+						MaxEntries: 1000,
+					},
+				},
+			},
+			errStrings: []string{},
+		},
+		"Memory session store without any entries": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.MemorySessionStoreType,
+				},
+			},
+			errStrings: []string{maxEntriesMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionMemory(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
+
+func Test_validateSessionLifetime(t *testing.T) {
+	const (
+		negativeMaxLifetimeMsg = "session_max_lifetime must not be negative"
+		negativeIdleTimeoutMsg = "session_idle_timeout must not be negative"
+		updateIntervalMsg      = "session_activity_update_interval must be greater than 0"
+		longUpdateIntervalMsg  = "session_activity_update_interval (1h0m0s) must be less than session_idle_timeout (30m0s)"
+	)
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"No limits": {
+			opts:       &options.Options{},
+			errStrings: []string{},
+		},
+		"Max lifetime and idle timeout": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					MaxLifetime:            12 * time.Hour,
+					IdleTimeout:            30 * time.Minute,
+					ActivityUpdateInterval: time.Minute,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Negative max lifetime and idle timeout": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					MaxLifetime: -time.Hour,
+					IdleTimeout: -time.Hour,
+				},
+			},
+			errStrings: []string{negativeMaxLifetimeMsg, negativeIdleTimeoutMsg},
+		},
+		"Idle timeout without an activity update interval": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					IdleTimeout: 30 * time.Minute,
+				},
+			},
+			errStrings: []string{updateIntervalMsg},
+		},
+		"Activity update interval longer than the idle timeout": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					IdleTimeout:            30 * time.Minute,
+					ActivityUpdateInterval: time.Hour,
+				},
+			},
+			errStrings: []string{longUpdateIntervalMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionLifetime(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
+
+func Test_validateSessionTicketRotation(t *testing.T) {
+	const (
+		negativeIntervalMsg    = "session_ticket_rotation_interval must not be negative"
+		negativeGracePeriodMsg = "session_ticket_rotation_grace_period must not be negative"
+		longGracePeriodMsg     = "session_ticket_rotation_grace_period (10m0s) must be less than session_ticket_rotation_interval (5m0s)"
+	)
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"Rotation on refresh only": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					TicketRotationGracePeriod: 30 * time.Second,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Rotation interval with a grace period": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					TicketRotationInterval:    5 * time.Minute,
+					TicketRotationGracePeriod: 30 * time.Second,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Negative rotation interval and grace period": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					TicketRotationInterval:    -time.Minute,
+					TicketRotationGracePeriod: -time.Second,
+				},
+			},
+			errStrings: []string{negativeIntervalMsg, negativeGracePeriodMsg},
+		},
+		"Grace period longer than the rotation interval": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					TicketRotationInterval:    5 * time.Minute,
+					TicketRotationGracePeriod: 10 * time.Minute,
+				},
+			},
+			errStrings: []string{longGracePeriodMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionTicketRotation(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
+
+func Test_validateSessionBinding(t *testing.T) {
+	const invalidIPBindingMsg = "session_binding_ip (subnet) must be one of \"exact\" or \"prefix\""
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"No binding": {
+			opts:       &options.Options{},
+			errStrings: []string{},
+		},
+		"Exact IP binding": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Binding: options.SessionBindingOptions{IP: "exact"},
+				},
+			},
+			errStrings: []string{},
+		},
+		"IP prefix and User-Agent binding": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Binding: options.SessionBindingOptions{IP: "prefix", UserAgent: true},
+				},
+			},
+			errStrings: []string{},
+		},
+		"Invalid IP binding": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Binding: options.SessionBindingOptions{IP: "subnet"},
+				},
+			},
+			errStrings: []string{invalidIPBindingMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionBinding(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
+
+func Test_validateSessionAdmin(t *testing.T) {
+	const (
+		shortTokenMsg  = "admin_token must be at least 16 characters"
+		cookieStoreMsg = "admin_token requires a server side session store, the cookie session store can't be administered"
+	)
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+	}{
+		"No admin token": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Admin token with a redis session store": {
+			opts: &options.Options{
+				AdminToken: "0123456789abcdef",
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Short admin token with a cookie session store": {
+			opts: &options.Options{
+				AdminToken: "secret",
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+			},
+			errStrings: []string{shortTokenMsg, cookieStoreMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionAdmin(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+		})
+	}
+}
+
+func Test_validateSessionCookieKeyring(t *testing.T) {
+	const (
+		migrationMsg   = "session_cookie_keyring_migration requires session_cookie_keyring_file"
+		cookieStoreMsg = "session_cookie_keyring_file only applies to the cookie session store, server side session stores encrypt every session with the secret from its ticket and can't use a keyring"
+		missingFileMsg = "could not load session_cookie_keyring_file (/path/does/not/exist): " +
+			"error opening keyring file: open /path/does/not/exist: no such file or directory"
+	)
+
+	dir, err := ioutil.TempDir("", "oauth2-proxy-validation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyringFile := filepath.Join(dir, "keyring")
+	err = ioutil.WriteFile(keyringFile, []byte("key-1,0123456789abcdefghijklmnopqrstuv\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		opts       *options.Options
+		errStrings []string
+		keyring    bool
+	}{
+		"No keyring file": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+				},
+			},
+			errStrings: []string{},
+		},
+		"Keyring file with a cookie session store": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+					Cookie: options.CookieStoreOptions{
+						KeyringFile: keyringFile,
+					},
+				},
+			},
+			errStrings: []string{},
+			keyring:    true,
+		},
+		"Keyring migration with a keyring file": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+					Cookie: options.CookieStoreOptions{
+						KeyringFile:      keyringFile,
+						KeyringMigration: true,
+					},
+				},
+			},
+			errStrings: []string{},
+			keyring:    true,
+		},
+		"Keyring migration without a keyring file": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+					Cookie: options.CookieStoreOptions{
+						KeyringMigration: true,
+					},
+				},
+			},
+			errStrings: []string{migrationMsg},
+		},
+		"Keyring file with a redis session store": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.RedisSessionStoreType,
+					Cookie: options.CookieStoreOptions{
+						KeyringFile: keyringFile,
+					},
+				},
+			},
+			errStrings: []string{cookieStoreMsg},
+		},
+		"Missing keyring file": {
+			opts: &options.Options{
+				Session: options.SessionOptions{
+					Type: options.CookieSessionStoreType,
+					Cookie: options.CookieStoreOptions{
+						KeyringFile: "/path/does/not/exist",
+					},
+				},
+			},
+			errStrings: []string{missingFileMsg},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			errStrings := validateSessionCookieKeyring(tc.opts)
+			g := NewWithT(t)
+			g.Expect(errStrings).To(ConsistOf(tc.errStrings))
+			g.Expect(tc.opts.Session.Cookie.GetKeyring() != nil).To(Equal(tc.keyring))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/go-oidc"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/providers"
+)
+
+// providerSet holds the providers users can sign in with. The first provider
+// is the default provider, used when no provider is chosen.
+type providerSet []providers.Provider
+
+// get returns the provider with the ID, or the default provider when the ID
+// is empty
+func (s providerSet) get(id string) (providers.Provider, error) {
+	if id == "" && len(s) > 0 {
+		return s[0], nil
+	}
+	for _, provider := range s {
+		if provider.Data().ID == id {
+			return provider, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown provider %q", id)
+}
+
+// RefreshSessionIfNeeded refreshes the session with the provider that
+// created it
+func (s providerSet) RefreshSessionIfNeeded(ctx context.Context, session *sessionsapi.SessionState) (bool, error) {
+	provider, err := s.get(session.ProviderID)
+	if err != nil {
+		return false, err
+	}
+	return provider.RefreshSessionIfNeeded(ctx, session)
+}
+
+// ValidateSessionState validates the session with the provider that created
+// it. Sessions created by providers that are no longer configured are invalid.
+func (s providerSet) ValidateSessionState(ctx context.Context, session *sessionsapi.SessionState) bool {
+	provider, err := s.get(session.ProviderID)
+	if err != nil {
+		logger.Printf("Error validating session: %v", err)
+		return false
+	}
+	return provider.ValidateSessionState(ctx, session)
+}
+
+// newProviderSet builds the providerSet from the default provider and the
+// other providers of the options
+func newProviderSet(opts *options.Options) providerSet {
+	s := providerSet{opts.GetProvider()}
+	if len(opts.GetProviders()) > 1 {
+		s = append(s, opts.GetProviders()[1:]...)
+	}
+	return s
+}
+
+// oidcIssuer is the issuer of the OIDC tokens of a provider, with the
+// verifier of its tokens
+type oidcIssuer struct {
+	url      string
+	provider providers.Provider
+	verifier *oidc.IDTokenVerifier
+	// anyIssuer is set when the issuer of tokens is not checked, so the
+	// tokens of this provider can't be told apart by their issuer
+	anyIssuer bool
+}
+
+// issues reports whether tokens with the iss claim may have been issued by
+// the issuer
+func (i oidcIssuer) issues(iss string) bool {
+	return i.anyIssuer || i.url == iss
+}
+
+// tokenToSession creates a session from a bearer token with the provider of
+// the issuer, recording its ID so the session is refreshed and validated by
+// the same provider
+func (i oidcIssuer) tokenToSession(ctx context.Context, rawIDToken string, idToken *oidc.IDToken) (*sessionsapi.SessionState, error) {
+	session, err := i.provider.CreateSessionStateFromBearerToken(ctx, rawIDToken, idToken)
+	if err != nil {
+		return nil, err
+	}
+	session.ProviderID = i.provider.Data().ID
+	return session, nil
+}
+
+// newOIDCIssuers lists the issuers of the providers that verify OIDC tokens,
+// starting with the default provider
+func newOIDCIssuers(opts *options.Options, s providerSet) []oidcIssuer {
+	if len(opts.Providers) == 0 {
+		if opts.GetOIDCVerifier() == nil {
+			return nil
+		}
+		return []oidcIssuer{{
+			url:       opts.OIDCIssuerURL,
+			provider:  opts.GetProvider(),
+			verifier:  opts.GetOIDCVerifier(),
+			anyIssuer: opts.InsecureOIDCSkipIssuerVerification,
+		}}
+	}
+
+	issuers := []oidcIssuer{}
+	verifiers := opts.GetProviderOIDCVerifiers()
+	for _, providerOpts := range opts.Providers {
+		verifier, ok := verifiers[providerOpts.ID]
+		if !ok {
+			continue
+		}
+		provider, err := s.get(providerOpts.ID)
+		if err != nil {
+			continue
+		}
+		issuers = append(issuers, oidcIssuer{
+			url:       providerOpts.OIDCIssuerURL,
+			provider:  provider,
+			verifier:  verifier,
+			anyIssuer: opts.InsecureOIDCSkipIssuerVerification,
+		})
+	}
+	return issuers
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProviderWithID(id string, validToken bool) *TestProvider {
+	provider := NewTestProvider(&url.URL{Host: id + ".example.com"}, id+"@example.com")
+	provider.ID = id
+	provider.ValidToken = validToken
+	return provider
+}
+
+func TestProviderSetGet(t *testing.T) {
+	employees := newTestProviderWithID("employees", true)
+	contractors := newTestProviderWithID("contractors", true)
+	s := providerSet{employees, contractors}
+
+	provider, err := s.get("")
+	assert.NoError(t, err)
+	assert.Equal(t, employees, provider)
+
+	provider, err = s.get("contractors")
+	assert.NoError(t, err)
+	assert.Equal(t, contractors, provider)
+
+	_, err = s.get("unknown")
+	assert.EqualError(t, err, `unknown provider "unknown"`)
+}
+
+func TestProviderSetValidateSessionState(t *testing.T) {
+	s := providerSet{
+		newTestProviderWithID("employees", false),
+		newTestProviderWithID("contractors", true),
+	}
+
+	testCases := map[string]struct {
+		providerID string
+		expected   bool
+	}{
+		"Session of the default provider": {
+			providerID: "",
+			expected:   false,
+		},
+		"Session of another provider": {
+			providerID: "contractors",
+			expected:   true,
+		},
+		"Session of a provider that is no longer configured": {
+			providerID: "removed",
+			expected:   false,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			session := &sessions.SessionState{ProviderID: tc.providerID}
+			assert.Equal(t, tc.expected, s.ValidateSessionState(context.Background(), session))
+		})
+	}
+
+	_, err := s.RefreshSessionIfNeeded(context.Background(), &sessions.SessionState{ProviderID: "removed"})
+	assert.EqualError(t, err, `unknown provider "removed"`)
+}
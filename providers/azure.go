@@ -3,13 +3,16 @@ package providers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/bitly/go-simplejson"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
@@ -19,6 +22,16 @@ import (
 type AzureProvider struct {
 	*ProviderData
 	Tenant string
+
+	// Groups are the object IDs of the groups allowed to sign in, the user
+	// must be a member of at least one of them
+	Groups []string
+	// AppRoles are the app roles allowed to sign in, the user must be
+	// assigned at least one of them
+	AppRoles []string
+	// GraphURL is the Microsoft Graph endpoint listing the groups of the
+	// user, when they don't fit in the ID token
+	GraphURL *url.URL
 }
 
 var _ Provider = (*AzureProvider)(nil)
@@ -26,6 +39,14 @@ var _ Provider = (*AzureProvider)(nil)
 const (
 	azureProviderName = "Azure"
 	azureDefaultScope = "openid"
+
+	// azureRolePrefix is added to the app roles of the user in the session
+	// groups, to tell them apart from the group object IDs
+	azureRolePrefix = "role:"
+
+	// azureGraphMaxPages limits how many pages of groups are requested from
+	// Microsoft Graph for a single user
+	azureGraphMaxPages = 50
 )
 
 var (
@@ -53,6 +74,14 @@ var (
 		Path:   "/v1.0/me",
 	}
 
+	// Default Graph URL for Azure.
+	// Pre-parsed URL of https://graph.microsoft.com/v1.0/me/memberOf.
+	azureDefaultGraphURL = &url.URL{
+		Scheme: "https",
+		Host:   "graph.microsoft.com",
+		Path:   "/v1.0/me/memberOf",
+	}
+
 	// Default ProtectedResource URL for Azure.
 	// Pre-parsed URL of https://graph.microsoft.com.
 	azureDefaultProtectResourceURL = &url.URL{
@@ -79,6 +108,7 @@ func NewAzureProvider(p *ProviderData) *AzureProvider {
 	return &AzureProvider{
 		ProviderData: p,
 		Tenant:       "common",
+		GraphURL:     azureDefaultGraphURL,
 	}
 }
 
@@ -95,6 +125,38 @@ func (p *AzureProvider) Configure(tenant string) {
 	overrideTenantURL(p.RedeemURL, azureDefaultRedeemURL, tenant, "token")
 }
 
+// GetLogoutURL returns the Azure AD logout URL for the tenant unless a
+// logout URL has been configured
+func (p *AzureProvider) GetLogoutURL(s *sessions.SessionState, postLogoutRedirectURI string) string {
+	if p.LogoutURL != nil && p.LogoutURL.String() != "" {
+		return p.ProviderData.GetLogoutURL(s, postLogoutRedirectURI)
+	}
+
+	a := url.URL{
+		Scheme: "https",
+		Host:   "login.microsoftonline.com",
+		Path:   "/" + p.Tenant + "/oauth2/logout",
+	}
+	if postLogoutRedirectURI != "" {
+		params := url.Values{}
+		params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+		a.RawQuery = params.Encode()
+	}
+	return a.String()
+}
+
+// SetGroupRestriction restricts sign in to members of at least one of the
+// groups, by object ID
+func (p *AzureProvider) SetGroupRestriction(groups []string) {
+	p.Groups = groups
+}
+
+// SetAppRoleRestriction restricts sign in to users assigned at least one of
+// the app roles
+func (p *AzureProvider) SetAppRoleRestriction(appRoles []string) {
+	p.AppRoles = appRoles
+}
+
 func overrideTenantURL(current, defaultURL *url.URL, tenant, path string) {
 	if current == nil || current.String() == "" || current.String() == defaultURL.String() {
 		*current = url.URL{
@@ -104,7 +166,7 @@ func overrideTenantURL(current, defaultURL *url.URL, tenant, path string) {
 	}
 }
 
-func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (s *sessions.SessionState, err error) {
 	if code == "" {
 		err = errors.New("missing code")
 		return
@@ -117,9 +179,12 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (s
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
 	params.Add("client_id", p.ClientID)
-	params.Add("client_secret", clientSecret)
+	addClientSecret(params, clientSecret)
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		params.Add("code_verifier", codeVerifier)
+	}
 	if p.ProtectedResource != nil && p.ProtectedResource.String() != "" {
 		params.Add("resource", p.ProtectedResource.String())
 	}
@@ -151,9 +216,250 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (s
 		ExpiresOn:    &expires,
 		RefreshToken: jsonResponse.RefreshToken,
 	}
+
+	err = p.setMembership(ctx, s)
+	if err != nil {
+		return nil, err
+	}
 	return
 }
 
+// RefreshSessionIfNeeded checks if the session has expired and uses the
+// RefreshToken to fetch a new access token if required. The groups and app
+// roles of the user are checked again after the refresh.
+func (p *AzureProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	if s == nil || (s.ExpiresOn != nil && s.ExpiresOn.After(time.Now())) || s.RefreshToken == "" {
+		return false, nil
+	}
+
+	origExpiration := s.ExpiresOn
+	err := p.redeemRefreshToken(ctx, s)
+	if err != nil {
+		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+	}
+
+	logger.Printf("refreshed access token %s (expired on %s)", s, origExpiration)
+	return true, nil
+}
+
+// redeemRefreshToken refreshes the session with the default refresh_token
+// grant, reading the expiry from expires_on, and checks the groups and app
+// roles of the user again
+func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.SessionState) error {
+	newIDToken, err := p.ProviderData.redeemRefreshTokenWith(ctx, s, expiresOnTokenTimes)
+	if err != nil {
+		return err
+	}
+
+	if !newIDToken {
+		// Without a new ID token the groups are checked with Microsoft Graph
+		if len(p.Groups) > 0 && !p.ValidateSessionState(ctx, s) {
+			return errors.New("user is no longer a member of any of the allowed groups")
+		}
+		return nil
+	}
+	return p.setMembership(ctx, s)
+}
+
+// expiresOnTokenTimes returns the session times from the expires_on field of
+// Azure token responses, which Azure returns instead of a numeric expires_in
+func expiresOnTokenTimes(body []byte) (*time.Time, *time.Time, error) {
+	var jsonResponse struct {
+		ExpiresOn int64 `json:"expires_on,string"`
+	}
+	if err := json.Unmarshal(body, &jsonResponse); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling expires_on: %v", err)
+	}
+	created := time.Now()
+	expires := time.Unix(jsonResponse.ExpiresOn, 0)
+	return &created, &expires, nil
+}
+
+// ValidateSessionState checks the access token is still valid. When sign in
+// is restricted to groups, the groups of the user are requested again from
+// Microsoft Graph, which also validates the access token, and the user must
+// still be a member of at least one of them.
+func (p *AzureProvider) ValidateSessionState(ctx context.Context, s *sessions.SessionState) bool {
+	_, roles := splitAzureGroups(s.Groups)
+	if !p.hasAllowedAppRole(roles) {
+		logger.Printf("%s is no longer assigned any of the allowed app roles", s.Email)
+		return false
+	}
+
+	if len(p.Groups) == 0 {
+		return p.ProviderData.ValidateSessionState(ctx, s)
+	}
+
+	groups, err := p.getGraphGroups(ctx, s.AccessToken)
+	if err != nil {
+		logger.Printf("failed to get the groups of %s from Microsoft Graph: %v", s.Email, err)
+		return false
+	}
+	if !p.hasAllowedGroup(groups) {
+		logger.Printf("%s is no longer a member of any of the allowed groups", s.Email)
+		return false
+	}
+	return true
+}
+
+// setMembership sets the session groups from the groups and app roles of
+// the ID token and checks them against the configured restrictions. Groups
+// are requested from Microsoft Graph when there are too many groups for the
+// ID token.
+func (p *AzureProvider) setMembership(ctx context.Context, s *sessions.SessionState) error {
+	restricted := len(p.Groups) > 0 || len(p.AppRoles) > 0
+	claims, err := azureClaimsFromIDToken(s.IDToken)
+	if err != nil {
+		if restricted {
+			return fmt.Errorf("could not read groups and app roles from id_token: %v", err)
+		}
+		// Without restrictions the groups are informational only
+		return nil
+	}
+
+	groups := claims.groups
+	if claims.groupsOverage && len(p.Groups) > 0 {
+		groups, err = p.getGraphGroups(ctx, s.AccessToken)
+		if err != nil {
+			return fmt.Errorf("could not get groups from Microsoft Graph: %v", err)
+		}
+	}
+
+	if !p.hasAllowedGroup(groups) {
+		return errors.New("user is not a member of any of the allowed groups")
+	}
+	if !p.hasAllowedAppRole(claims.roles) {
+		return errors.New("user is not assigned any of the allowed app roles")
+	}
+
+	s.Groups = joinAzureGroups(groups, claims.roles)
+	return nil
+}
+
+// hasAllowedGroup checks the user is a member of one of the allowed groups
+func (p *AzureProvider) hasAllowedGroup(groups []string) bool {
+	return len(p.Groups) == 0 || containsAny(p.Groups, groups)
+}
+
+// hasAllowedAppRole checks the user is assigned one of the allowed app roles
+func (p *AzureProvider) hasAllowedAppRole(roles []string) bool {
+	return len(p.AppRoles) == 0 || containsAny(p.AppRoles, roles)
+}
+
+// getGraphGroups requests the object IDs of the groups the user is a member
+// of from Microsoft Graph, following every page of the results
+func (p *AzureProvider) getGraphGroups(ctx context.Context, accessToken string) ([]string, error) {
+	if accessToken == "" {
+		return nil, errors.New("missing access token")
+	}
+
+	params := url.Values{}
+	params.Set("$select", "id")
+	endpoint := *p.GraphURL
+	endpoint.RawQuery = params.Encode()
+	next := endpoint.String()
+
+	groups := []string{}
+	for page := 0; next != ""; page++ {
+		if page == azureGraphMaxPages {
+			return nil, fmt.Errorf("user is a member of more than %d pages of groups", azureGraphMaxPages)
+		}
+
+		var response struct {
+			Value []struct {
+				ID string `json:"id"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		err := requests.New(next).
+			WithContext(ctx).
+			WithHeaders(getAzureHeader(accessToken)).
+			Do().
+			UnmarshalInto(&response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range response.Value {
+			groups = append(groups, group.ID)
+		}
+		next = response.NextLink
+	}
+	return groups, nil
+}
+
+// azureClaims are the groups and app roles claims of an Azure ID token
+type azureClaims struct {
+	groups []string
+	roles  []string
+
+	// groupsOverage is set when the user is a member of too many groups for
+	// them to be included in the ID token
+	groupsOverage bool
+}
+
+// azureClaimsFromIDToken reads the groups and app roles from the ID token.
+// The token is not verified, which is allowed since it was received directly
+// from the token endpoint.
+func azureClaimsFromIDToken(rawIDToken string) (*azureClaims, error) {
+	if rawIDToken == "" {
+		return nil, errors.New("missing id_token")
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(rawIDToken, claims); err != nil {
+		return nil, err
+	}
+
+	c := &azureClaims{
+		groups: groupsFromClaim(claims["groups"]),
+		roles:  groupsFromClaim(claims["roles"]),
+	}
+	if claimNames, ok := claims["_claim_names"].(map[string]interface{}); ok {
+		_, c.groupsOverage = claimNames["groups"]
+	}
+	if hasGroups, ok := claims["hasgroups"].(bool); ok && hasGroups {
+		c.groupsOverage = true
+	}
+	return c, nil
+}
+
+// joinAzureGroups combines the groups and the prefixed app roles of the user
+// into the session groups
+func joinAzureGroups(groups []string, roles []string) []string {
+	joined := make([]string, 0, len(groups)+len(roles))
+	joined = append(joined, groups...)
+	for _, role := range roles {
+		joined = append(joined, azureRolePrefix+role)
+	}
+	return joined
+}
+
+// splitAzureGroups separates the session groups into the groups and the app
+// roles of the user
+func splitAzureGroups(joined []string) (groups []string, roles []string) {
+	for _, group := range joined {
+		if strings.HasPrefix(group, azureRolePrefix) {
+			roles = append(roles, strings.TrimPrefix(group, azureRolePrefix))
+		} else {
+			groups = append(groups, group)
+		}
+	}
+	return groups, roles
+}
+
+// containsAny checks whether any of the values is one of the allowed values
+func containsAny(allowed []string, values []string) bool {
+	for _, value := range values {
+		for _, a := range allowed {
+			if value == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func getAzureHeader(accessToken string) http.Header {
 	header := make(http.Header)
 	header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
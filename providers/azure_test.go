@@ -2,12 +2,17 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/assert"
 )
@@ -120,6 +125,19 @@ func testAzureBackend(payload string) *httptest.Server {
 		}))
 }
 
+func TestAzureProviderGetLogoutURL(t *testing.T) {
+	p := testAzureProvider("")
+	p.Configure("abcdef")
+
+	session := &sessions.SessionState{IDToken: "id_token"}
+	assert.Equal(t, "https://login.microsoftonline.com/abcdef/oauth2/logout?post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F",
+		p.GetLogoutURL(session, "https://example.com/"))
+
+	p.LogoutURL = &url.URL{Scheme: "https", Host: "logout.example.com", Path: "/logout"}
+	assert.Equal(t, "https://logout.example.com/logout?id_token_hint=id_token&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2F",
+		p.GetLogoutURL(session, "https://example.com/"))
+}
+
 func TestAzureProviderGetEmailAddress(t *testing.T) {
 	b := testAzureBackend(`{ "mail": "user@windows.net" }`)
 	defer b.Close()
@@ -207,9 +225,319 @@ func TestAzureProviderRedeemReturnsIdToken(t *testing.T) {
 	bURL, _ := url.Parse(b.URL)
 	p := testAzureProvider(bURL.Host)
 	p.Data().RedeemURL.Path = "/common/oauth2/token"
-	s, err := p.Redeem(context.Background(), "https://localhost", "1234")
+	s, err := p.Redeem(context.Background(), "https://localhost", "1234", "", "")
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "testtoken1234", s.IDToken)
 	assert.Equal(t, timestamp, s.ExpiresOn.UTC())
 	assert.Equal(t, "refresh1234", s.RefreshToken)
 }
+
+// testAzureGraphBackend serves the token response and the pages of groups
+// from Microsoft Graph, linking each page to the next
+func testAzureGraphBackend(tokenResponse string, pages [][]string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				w.Write([]byte(tokenResponse))
+				return
+			}
+			if r.URL.Path != "/v1.0/me/memberOf" || r.URL.Query().Get("$select") != "id" {
+				w.WriteHeader(404)
+				return
+			}
+			if !IsAuthorizedInHeader(r.Header) {
+				w.WriteHeader(401)
+				return
+			}
+
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			response := map[string]interface{}{}
+			values := []map[string]string{}
+			for _, group := range pages[page] {
+				values = append(values, map[string]string{"id": group})
+			}
+			response["value"] = values
+			if page+1 < len(pages) {
+				response["@odata.nextLink"] = fmt.Sprintf("%s/v1.0/me/memberOf?$select=id&page=%d", server.URL, page+1)
+			}
+			json.NewEncoder(w).Encode(response)
+		}))
+	return server
+}
+
+// azureTokenResponse builds a token response with the ID token
+func azureTokenResponse(idToken string) string {
+	return fmt.Sprintf(`{"access_token": %q, "id_token": %q, "expires_on": "1136239445"}`,
+		authorizedAccessToken, idToken)
+}
+
+func newAzureIDToken(claims jwt.MapClaims) string {
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	return idToken
+}
+
+func TestAzureProviderRedeemMembership(t *testing.T) {
+	overage := jwt.MapClaims{
+		"_claim_names":   map[string]interface{}{"groups": "src1"},
+		"_claim_sources": map[string]interface{}{"src1": map[string]interface{}{"endpoint": "https://graph.windows.net"}},
+	}
+
+	testCases := map[string]struct {
+		groups         []string
+		appRoles       []string
+		claims         jwt.MapClaims
+		graphPages     [][]string
+		expectedError  string
+		expectedGroups []string
+	}{
+		"Without restrictions": {
+			claims:         jwt.MapClaims{"groups": []string{"group-1"}, "roles": []string{"Reader"}},
+			expectedGroups: []string{"group-1", "role:Reader"},
+		},
+		"Member of an allowed group": {
+			groups:         []string{"group-2", "group-3"},
+			claims:         jwt.MapClaims{"groups": []string{"group-1", "group-2"}},
+			expectedGroups: []string{"group-1", "group-2"},
+		},
+		"Not a member of an allowed group": {
+			groups:        []string{"group-3"},
+			claims:        jwt.MapClaims{"groups": []string{"group-1", "group-2"}},
+			expectedError: "user is not a member of any of the allowed groups",
+		},
+		"Assigned an allowed app role": {
+			appRoles:       []string{"Admin"},
+			claims:         jwt.MapClaims{"roles": "Admin"},
+			expectedGroups: []string{"role:Admin"},
+		},
+		"Not assigned an allowed app role": {
+			appRoles:      []string{"Admin"},
+			claims:        jwt.MapClaims{"groups": []string{"Admin"}, "roles": []string{"Reader"}},
+			expectedError: "user is not assigned any of the allowed app roles",
+		},
+		"Member of an allowed group without an allowed app role": {
+			groups:        []string{"group-1"},
+			appRoles:      []string{"Admin"},
+			claims:        jwt.MapClaims{"groups": []string{"group-1"}},
+			expectedError: "user is not assigned any of the allowed app roles",
+		},
+		"Groups overage with an allowed group on the last page": {
+			groups:         []string{"group-5"},
+			claims:         overage,
+			graphPages:     [][]string{{"group-1", "group-2"}, {"group-3", "group-4"}, {"group-5"}},
+			expectedGroups: []string{"group-1", "group-2", "group-3", "group-4", "group-5"},
+		},
+		"Groups overage without an allowed group": {
+			groups:        []string{"group-6"},
+			claims:        overage,
+			graphPages:    [][]string{{"group-1", "group-2"}, {"group-3"}},
+			expectedError: "user is not a member of any of the allowed groups",
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			b := testAzureGraphBackend(azureTokenResponse(newAzureIDToken(tc.claims)), tc.graphPages)
+			defer b.Close()
+
+			bURL, _ := url.Parse(b.URL)
+			p := testAzureProvider(bURL.Host)
+			p.GraphURL = &url.URL{Scheme: bURL.Scheme, Host: bURL.Host, Path: "/v1.0/me/memberOf"}
+			p.SetGroupRestriction(tc.groups)
+			p.SetAppRoleRestriction(tc.appRoles)
+
+			s, err := p.Redeem(context.Background(), "https://localhost", "1234", "", "")
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				assert.Nil(t, s)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedGroups, s.Groups)
+		})
+	}
+}
+
+func TestAzureProviderValidateSessionState(t *testing.T) {
+	testCases := map[string]struct {
+		groups        []string
+		appRoles      []string
+		accessToken   string
+		sessionGroups []string
+		graphPages    [][]string
+		expected      bool
+	}{
+		"Still a member of an allowed group": {
+			groups:        []string{"group-3"},
+			accessToken:   authorizedAccessToken,
+			sessionGroups: []string{"group-1"},
+			graphPages:    [][]string{{"group-1"}, {"group-3"}},
+			expected:      true,
+		},
+		"No longer a member of an allowed group": {
+			groups:        []string{"group-1"},
+			accessToken:   authorizedAccessToken,
+			sessionGroups: []string{"group-1"},
+			graphPages:    [][]string{{"group-2"}},
+			expected:      false,
+		},
+		"Invalid access token": {
+			groups:        []string{"group-1"},
+			accessToken:   "invalid_token",
+			sessionGroups: []string{"group-1"},
+			graphPages:    [][]string{{"group-1"}},
+			expected:      false,
+		},
+		"Without an allowed app role": {
+			groups:        []string{"group-1"},
+			appRoles:      []string{"Admin"},
+			accessToken:   authorizedAccessToken,
+			sessionGroups: []string{"group-1", "role:Reader"},
+			graphPages:    [][]string{{"group-1"}},
+			expected:      false,
+		},
+		"With an allowed app role": {
+			groups:        []string{"group-1"},
+			appRoles:      []string{"Admin"},
+			accessToken:   authorizedAccessToken,
+			sessionGroups: []string{"group-1", "role:Admin"},
+			graphPages:    [][]string{{"group-1"}},
+			expected:      true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			b := testAzureGraphBackend(azureTokenResponse(""), tc.graphPages)
+			defer b.Close()
+
+			bURL, _ := url.Parse(b.URL)
+			p := testAzureProvider(bURL.Host)
+			p.GraphURL = &url.URL{Scheme: bURL.Scheme, Host: bURL.Host, Path: "/v1.0/me/memberOf"}
+			p.SetGroupRestriction(tc.groups)
+			p.SetAppRoleRestriction(tc.appRoles)
+
+			session := &sessions.SessionState{
+				Email:       "user@windows.net",
+				AccessToken: tc.accessToken,
+				Groups:      tc.sessionGroups,
+			}
+			assert.Equal(t, tc.expected, p.ValidateSessionState(context.Background(), session))
+		})
+	}
+}
+
+func TestAzureProviderRefreshSessionIfNeeded(t *testing.T) {
+	expiresOn := time.Now().Add(time.Hour).Unix()
+	tokenResponse := func(refreshToken string, claims jwt.MapClaims) string {
+		idToken := ""
+		if claims != nil {
+			idToken = newAzureIDToken(claims)
+		}
+		return fmt.Sprintf(`{"access_token": %q, "refresh_token": %q, "id_token": %q, "expires_on": "%d"}`,
+			authorizedAccessToken, refreshToken, idToken, expiresOn)
+	}
+
+	testCases := map[string]struct {
+		groups               []string
+		tokenResponse        string
+		graphPages           [][]string
+		expectedError        bool
+		expectedRefreshToken string
+		expectedGroups       []string
+	}{
+		"With a rotated refresh token": {
+			tokenResponse:        tokenResponse("new_refresh_token", jwt.MapClaims{"groups": []string{"group-2"}}),
+			expectedRefreshToken: "new_refresh_token",
+			expectedGroups:       []string{"group-2"},
+		},
+		"Without a rotated refresh token": {
+			tokenResponse:        tokenResponse("", jwt.MapClaims{"groups": []string{"group-1"}}),
+			expectedRefreshToken: refreshToken,
+			expectedGroups:       []string{"group-1"},
+		},
+		"Still a member of an allowed group": {
+			groups:               []string{"group-1"},
+			tokenResponse:        tokenResponse("new_refresh_token", jwt.MapClaims{"groups": []string{"group-1"}}),
+			expectedRefreshToken: "new_refresh_token",
+			expectedGroups:       []string{"group-1"},
+		},
+		"No longer a member of an allowed group": {
+			groups:        []string{"group-1"},
+			tokenResponse: tokenResponse("new_refresh_token", jwt.MapClaims{"groups": []string{"group-2"}}),
+			expectedError: true,
+		},
+		"Still a member of an allowed group without an ID token": {
+			groups:               []string{"group-1"},
+			tokenResponse:        tokenResponse("new_refresh_token", nil),
+			graphPages:           [][]string{{"group-1"}},
+			expectedRefreshToken: "new_refresh_token",
+			expectedGroups:       []string{"group-1"},
+		},
+		"No longer a member of an allowed group without an ID token": {
+			groups:        []string{"group-1"},
+			tokenResponse: tokenResponse("new_refresh_token", nil),
+			graphPages:    [][]string{{"group-2"}},
+			expectedError: true,
+		},
+		"With an invalid token response": {
+			tokenResponse: `{"error": "invalid_grant"`,
+			expectedError: true,
+		},
+		"Without an access token": {
+			tokenResponse: fmt.Sprintf(`{"refresh_token": "new_refresh_token", "expires_on": "%d"}`, expiresOn),
+			expectedError: true,
+		},
+		"With the expires_in string of the v1 endpoint": {
+			tokenResponse: fmt.Sprintf(`{"access_token": %q, "id_token": %q, "expires_in": "3599", "expires_on": "%d"}`,
+				authorizedAccessToken, newAzureIDToken(jwt.MapClaims{"groups": []string{"group-1"}}), expiresOn),
+			expectedRefreshToken: refreshToken,
+			expectedGroups:       []string{"group-1"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			b := testAzureGraphBackend(tc.tokenResponse, tc.graphPages)
+			defer b.Close()
+
+			bURL, _ := url.Parse(b.URL)
+			p := testAzureProvider(bURL.Host)
+			p.GraphURL = &url.URL{Scheme: bURL.Scheme, Host: bURL.Host, Path: "/v1.0/me/memberOf"}
+			p.SetGroupRestriction(tc.groups)
+
+			expired := time.Now().Add(-time.Minute)
+			session := &sessions.SessionState{
+				Email:        "user@windows.net",
+				AccessToken:  "expired_access_token",
+				RefreshToken: refreshToken,
+				ExpiresOn:    &expired,
+				Groups:       []string{"group-1"},
+			}
+			refreshed, err := p.RefreshSessionIfNeeded(context.Background(), session)
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.False(t, refreshed)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, refreshed)
+			assert.Equal(t, authorizedAccessToken, session.AccessToken)
+			assert.Equal(t, tc.expectedRefreshToken, session.RefreshToken)
+			assert.Equal(t, expiresOn, session.ExpiresOn.Unix())
+			assert.Equal(t, tc.expectedGroups, session.Groups)
+		})
+	}
+}
+
+func TestAzureProviderRefreshSessionIfNeededNotExpired(t *testing.T) {
+	p := testAzureProvider("")
+
+	expires := time.Now().Add(time.Hour)
+	refreshed, err := p.RefreshSessionIfNeeded(context.Background(), &sessions.SessionState{
+		RefreshToken: refreshToken,
+		ExpiresOn:    &expires,
+	})
+	assert.NoError(t, err)
+	assert.False(t, refreshed)
+}
@@ -42,7 +42,7 @@ func NewGitLabProvider(p *ProviderData) *GitLabProvider {
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (s *sessions.SessionState, err error) {
 	clientSecret, err := p.GetClientSecret()
 	if err != nil {
 		return
@@ -51,19 +51,25 @@ func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code string) (
 	c := oauth2.Config{
 		ClientID:     p.ClientID,
 		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: p.RedeemURL.String(),
-		},
-		RedirectURL: redirectURL,
+		Endpoint:     p.tokenEndpoint(clientSecret),
+		RedirectURL:  redirectURL,
 	}
-	token, err := c.Exchange(ctx, code)
+	var opts []oauth2.AuthCodeOption
+	if codeVerifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	}
+	token, err := c.Exchange(ctx, code, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange: %v", err)
 	}
-	s, err = p.createSessionState(ctx, token)
+	rawIDToken, idToken, err := p.verifyIDToken(ctx, token)
 	if err != nil {
 		return nil, fmt.Errorf("unable to update session: %v", err)
 	}
+	if err = verifyNonce(nonce, idToken.Nonce); err != nil {
+		return nil, fmt.Errorf("could not verify id_token: %v", err)
+	}
+	s = p.createSessionState(token, rawIDToken, idToken)
 	return
 }
 
@@ -94,9 +100,7 @@ func (p *GitLabProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 	c := oauth2.Config{
 		ClientID:     p.ClientID,
 		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: p.RedeemURL.String(),
-		},
+		Endpoint:     p.tokenEndpoint(clientSecret),
 	}
 	t := &oauth2.Token{
 		RefreshToken: s.RefreshToken,
@@ -106,10 +110,11 @@ func (p *GitLabProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 	if err != nil {
 		return fmt.Errorf("failed to get token: %v", err)
 	}
-	newSession, err := p.createSessionState(ctx, token)
+	rawIDToken, idToken, err := p.verifyIDToken(ctx, token)
 	if err != nil {
 		return fmt.Errorf("unable to update session: %v", err)
 	}
+	newSession := p.createSessionState(token, rawIDToken, idToken)
 	s.AccessToken = newSession.AccessToken
 	s.IDToken = newSession.IDToken
 	s.RefreshToken = newSession.RefreshToken
@@ -182,18 +187,22 @@ func (p *GitLabProvider) verifyEmailDomain(userInfo *gitlabUserInfo) error {
 	return fmt.Errorf("user email is not one of the valid domains '%v'", p.EmailDomains)
 }
 
-func (p *GitLabProvider) createSessionState(ctx context.Context, token *oauth2.Token) (*sessions.SessionState, error) {
+// verifyIDToken extracts the ID token from the token response and verifies it
+func (p *GitLabProvider) verifyIDToken(ctx context.Context, token *oauth2.Token) (string, *oidc.IDToken, error) {
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
-		return nil, fmt.Errorf("token response did not contain an id_token")
+		return "", nil, fmt.Errorf("token response did not contain an id_token")
 	}
 
 	// Parse and verify ID Token payload.
 	idToken, err := p.Verifier.Verify(ctx, rawIDToken)
 	if err != nil {
-		return nil, fmt.Errorf("could not verify id_token: %v", err)
+		return "", nil, fmt.Errorf("could not verify id_token: %v", err)
 	}
+	return rawIDToken, idToken, nil
+}
 
+func (p *GitLabProvider) createSessionState(token *oauth2.Token, rawIDToken string, idToken *oidc.IDToken) *sessions.SessionState {
 	created := time.Now()
 	return &sessions.SessionState{
 		AccessToken:  token.AccessToken,
@@ -201,7 +210,7 @@ func (p *GitLabProvider) createSessionState(ctx context.Context, token *oauth2.T
 		RefreshToken: token.RefreshToken,
 		CreatedAt:    &created,
 		ExpiresOn:    &idToken.Expiry,
-	}, nil
+	}
 }
 
 // ValidateSessionState checks that the session's IDToken is still valid
@@ -238,6 +247,16 @@ func (p *GitLabProvider) GetEmailAddress(ctx context.Context, s *sessions.Sessio
 	return userInfo.Email, nil
 }
 
+// GetGroups returns the groups the Account is a member of
+func (p *GitLabProvider) GetGroups(ctx context.Context, s *sessions.SessionState) ([]string, error) {
+	userInfo, err := p.getUserInfo(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve user info: %v", err)
+	}
+
+	return userInfo.Groups, nil
+}
+
 // GetUserName returns the Account user name
 func (p *GitLabProvider) GetUserName(ctx context.Context, s *sessions.SessionState) (string, error) {
 	userInfo, err := p.getUserInfo(ctx, s)
@@ -2,11 +2,13 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	oidc "github.com/coreos/go-oidc"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/stretchr/testify/assert"
 )
@@ -108,6 +110,19 @@ func TestGitLabProviderUsername(t *testing.T) {
 	assert.Equal(t, "FooBar", username)
 }
 
+func TestGitLabProviderGroups(t *testing.T) {
+	b := testGitLabBackend()
+	defer b.Close()
+
+	bURL, _ := url.Parse(b.URL)
+	p := testGitLabProvider(bURL.Host)
+
+	session := &sessions.SessionState{AccessToken: "gitlab_access_token"}
+	groups, err := p.GetGroups(context.Background(), session)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"foo", "bar"}, groups)
+}
+
 func TestGitLabProviderGroupMembershipValid(t *testing.T) {
 	b := testGitLabBackend()
 	defer b.Close()
@@ -165,3 +180,33 @@ func TestGitLabProviderEmailDomainInvalid(t *testing.T) {
 	_, err := p.GetEmailAddress(context.Background(), session)
 	assert.NotEqual(t, nil, err)
 }
+
+func TestGitLabProviderRedeemNonce(t *testing.T) {
+	claims := defaultIDToken
+	claims.Nonce = "nonce"
+	idToken, _ := newSignedTestIDToken(claims)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   10,
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+	})
+	serverURL, server := newOIDCServer(body)
+	defer server.Close()
+
+	p := testGitLabProvider(serverURL.Host)
+	p.ClientID = clientID
+	p.ClientSecret = secret
+	p.Verifier = oidc.NewVerifier(
+		"https://issuer.example.com",
+		fakeKeySetStub{},
+		&oidc.Config{ClientID: clientID},
+	)
+
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "", "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, idToken, session.IDToken)
+
+	_, err = p.Redeem(context.Background(), "http://redirect/", "code1234", "", "other-nonce")
+	assert.Error(t, err)
+}
@@ -29,6 +29,9 @@ type GoogleProvider struct {
 	// GroupValidator is a function that determines if the passed email is in
 	// the configured Google group.
 	GroupValidator func(string) bool
+	// groupsGetter is a function that returns the configured Google groups
+	// the passed email is a member of.
+	groupsGetter func(string) []string
 }
 
 var _ Provider = (*GoogleProvider)(nil)
@@ -118,7 +121,7 @@ func claimsFromIDToken(idToken string) (*claims, error) {
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (s *sessions.SessionState, err error) {
 	if code == "" {
 		err = errors.New("missing code")
 		return
@@ -131,9 +134,12 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
 	params.Add("client_id", p.ClientID)
-	params.Add("client_secret", clientSecret)
+	addClientSecret(params, clientSecret)
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		params.Add("code_verifier", codeVerifier)
+	}
 
 	var jsonResponse struct {
 		AccessToken  string `json:"access_token"`
@@ -181,6 +187,9 @@ func (p *GoogleProvider) SetGroupRestriction(groups []string, adminEmail string,
 	p.GroupValidator = func(email string) bool {
 		return userInGroup(adminService, groups, email)
 	}
+	p.groupsGetter = func(email string) []string {
+		return userGroups(adminService, groups, email)
+	}
 }
 
 func getAdminService(adminEmail string, credentialsReader io.Reader) *admin.Service {
@@ -205,50 +214,70 @@ func getAdminService(adminEmail string, credentialsReader io.Reader) *admin.Serv
 
 func userInGroup(service *admin.Service, groups []string, email string) bool {
 	for _, group := range groups {
-		// Use the HasMember API to checking for the user's presence in each group or nested subgroups
-		req := service.Members.HasMember(group, email)
-		r, err := req.Do()
-		if err != nil {
-			gerr, ok := err.(*googleapi.Error)
-			switch {
-			case ok && gerr.Code == 404:
-				logger.Printf("error checking membership in group %s: group does not exist", group)
-			case ok && gerr.Code == 400:
-				// It is possible for Members.HasMember to return false even if the email is a group member.
-				// One case that can cause this is if the user email is from a different domain than the group,
-				// e.g. "member@otherdomain.com" in the group "group@mydomain.com" will result in a 400 error
-				// from the HasMember API. In that case, attempt to query the member object directly from the group.
-				req := service.Members.Get(group, email)
-				r, err := req.Do()
-
-				if err != nil {
-					logger.Printf("error using get API to check member %s of google group %s: user not in the group", email, group)
-					continue
-				}
-
-				// If the non-domain user is found within the group, still verify that they are "ACTIVE".
-				// Do not count the user as belonging to a group if they have another status ("ARCHIVED", "SUSPENDED", or "UNKNOWN").
-				if r.Status == "ACTIVE" {
-					return true
-				}
-			default:
-				logger.Printf("error checking group membership: %v", err)
-			}
-			continue
-		}
-		if r.IsMember {
+		if isGroupMember(service, group, email) {
 			return true
 		}
 	}
 	return false
 }
 
+func userGroups(service *admin.Service, groups []string, email string) []string {
+	var memberOf []string
+	for _, group := range groups {
+		if isGroupMember(service, group, email) {
+			memberOf = append(memberOf, group)
+		}
+	}
+	return memberOf
+}
+
+func isGroupMember(service *admin.Service, group, email string) bool {
+	// Use the HasMember API to checking for the user's presence in each group or nested subgroups
+	req := service.Members.HasMember(group, email)
+	r, err := req.Do()
+	if err != nil {
+		gerr, ok := err.(*googleapi.Error)
+		switch {
+		case ok && gerr.Code == 404:
+			logger.Printf("error checking membership in group %s: group does not exist", group)
+		case ok && gerr.Code == 400:
+			// It is possible for Members.HasMember to return false even if the email is a group member.
+			// One case that can cause this is if the user email is from a different domain than the group,
+			// e.g. "member@otherdomain.com" in the group "group@mydomain.com" will result in a 400 error
+			// from the HasMember API. In that case, attempt to query the member object directly from the group.
+			req := service.Members.Get(group, email)
+			r, err := req.Do()
+
+			if err != nil {
+				logger.Printf("error using get API to check member %s of google group %s: user not in the group", email, group)
+				return false
+			}
+
+			// If the non-domain user is found within the group, still verify that they are "ACTIVE".
+			// Do not count the user as belonging to a group if they have another status ("ARCHIVED", "SUSPENDED", or "UNKNOWN").
+			return r.Status == "ACTIVE"
+		default:
+			logger.Printf("error checking group membership: %v", err)
+		}
+		return false
+	}
+	return r.IsMember
+}
+
 // ValidateGroup validates that the provided email exists in the configured Google
 // group(s).
 func (p *GoogleProvider) ValidateGroup(email string) bool {
 	return p.GroupValidator(email)
 }
 
+// GetGroups returns the configured Google group(s) the Account is a member of
+func (p *GoogleProvider) GetGroups(ctx context.Context, s *sessions.SessionState) ([]string, error) {
+	if p.groupsGetter == nil {
+		return p.ProviderData.GetGroups(ctx, s)
+	}
+	return p.groupsGetter(s.Email), nil
+}
+
 // RefreshSessionIfNeeded checks if the session has expired and uses the
 // RefreshToken to fetch a new ID token if required
 func (p *GoogleProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions.SessionState) (bool, error) {
@@ -284,7 +313,7 @@ func (p *GoogleProvider) redeemRefreshToken(ctx context.Context, refreshToken st
 
 	params := url.Values{}
 	params.Add("client_id", p.ClientID)
-	params.Add("client_secret", clientSecret)
+	addClientSecret(params, clientSecret)
 	params.Add("refresh_token", refreshToken)
 	params.Add("grant_type", "refresh_token")
 
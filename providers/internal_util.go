@@ -2,6 +2,8 @@ package providers
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"net/url"
 
@@ -74,3 +76,12 @@ func validateToken(ctx context.Context, p Provider, accessToken string, header h
 	logger.Printf("token validation request failed: status %d - %s", result.StatusCode(), result.Body())
 	return false
 }
+
+// verifyNonce checks the nonce claim of an ID token matches the nonce sent
+// in the authentication request
+func verifyNonce(nonce, tokenNonce string) error {
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(tokenNonce)) != 1 {
+		return errors.New("nonce validation failed")
+	}
+	return nil
+}
@@ -2,8 +2,12 @@ package providers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
 
+	oidc "github.com/coreos/go-oidc"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
@@ -12,13 +16,15 @@ import (
 type KeycloakProvider struct {
 	*ProviderData
 	Group string
+
+	Verifier *oidc.IDTokenVerifier
 }
 
 var _ Provider = (*KeycloakProvider)(nil)
 
 const (
 	keycloakProviderName = "Keycloak"
-	keycloakDefaultScope = "api"
+	keycloakDefaultScope = "openid api"
 )
 
 var (
@@ -63,6 +69,48 @@ func (p *KeycloakProvider) SetGroup(group string) {
 	p.Group = group
 }
 
+// Redeem exchanges the OAuth2 authentication token for an access token. When
+// a nonce was sent in the authentication request, the token response must
+// contain an ID token with a matching nonce.
+func (p *KeycloakProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (*sessions.SessionState, error) {
+	s, err := p.ProviderData.Redeem(ctx, redirectURL, code, codeVerifier, nonce)
+	if err != nil || nonce == "" {
+		return s, err
+	}
+	if s.IDToken == "" {
+		return nil, errors.New("token response did not contain an id_token to verify the nonce, the openid scope is required")
+	}
+
+	tokenNonce, err := p.idTokenNonce(ctx, s.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify id_token: %v", err)
+	}
+	if err := verifyNonce(nonce, tokenNonce); err != nil {
+		return nil, fmt.Errorf("could not verify id_token: %v", err)
+	}
+	return s, nil
+}
+
+// idTokenNonce returns the nonce claim of the ID token. Without a configured
+// verifier the token is not checked against the issuer keys, which is allowed
+// since it was received directly from the token endpoint.
+func (p *KeycloakProvider) idTokenNonce(ctx context.Context, rawIDToken string) (string, error) {
+	if p.Verifier != nil {
+		idToken, err := p.Verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return "", err
+		}
+		return idToken.Nonce, nil
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(rawIDToken, claims); err != nil {
+		return "", err
+	}
+	nonce, _ := claims["nonce"].(string)
+	return nonce, nil
+}
+
 func (p *KeycloakProvider) GetEmailAddress(ctx context.Context, s *sessions.SessionState) (string, error) {
 	json, err := requests.New(p.ValidateURL.String()).
 		WithContext(ctx).
@@ -97,3 +145,22 @@ func (p *KeycloakProvider) GetEmailAddress(ctx context.Context, s *sessions.Sess
 
 	return json.Get("email").String()
 }
+
+// GetGroups returns the groups the Account is a member of
+func (p *KeycloakProvider) GetGroups(ctx context.Context, s *sessions.SessionState) ([]string, error) {
+	json, err := requests.New(p.ValidateURL.String()).
+		WithContext(ctx).
+		SetHeader("Authorization", "Bearer "+s.AccessToken).
+		Do().
+		UnmarshalJSON()
+	if err != nil {
+		logger.Printf("failed making request %s", err)
+		return nil, err
+	}
+
+	groups, ok := json.CheckGet("groups")
+	if !ok {
+		return nil, nil
+	}
+	return groups.StringArray()
+}
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -62,7 +63,7 @@ func TestKeycloakProviderDefaults(t *testing.T) {
 		p.Data().RedeemURL.String())
 	assert.Equal(t, "https://keycloak.org/api/v3/user",
 		p.Data().ValidateURL.String())
-	assert.Equal(t, "api", p.Data().Scope)
+	assert.Equal(t, "openid api", p.Data().Scope)
 }
 
 func TestNewKeycloakProvider(t *testing.T) {
@@ -75,7 +76,7 @@ func TestNewKeycloakProvider(t *testing.T) {
 	g.Expect(providerData.RedeemURL.String()).To(Equal("https://keycloak.org/oauth/token"))
 	g.Expect(providerData.ProfileURL.String()).To(Equal(""))
 	g.Expect(providerData.ValidateURL.String()).To(Equal("https://keycloak.org/api/v3/user"))
-	g.Expect(providerData.Scope).To(Equal("api"))
+	g.Expect(providerData.Scope).To(Equal("openid api"))
 }
 
 func TestKeycloakProviderOverrides(t *testing.T) {
@@ -161,3 +162,73 @@ func TestKeycloakProviderGetEmailAddressEmailNotPresentInPayload(t *testing.T) {
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, "", email)
 }
+
+func TestKeycloakProviderGetGroups(t *testing.T) {
+	b := testKeycloakBackend("{\"email\": \"michael.bland@gsa.gov\", \"groups\": [\"test-grp1\", \"test-grp2\"]}")
+	defer b.Close()
+
+	bURL, _ := url.Parse(b.URL)
+	p := testKeycloakProvider(bURL.Host, "")
+
+	session := CreateAuthorizedSession()
+	groups, err := p.GetGroups(context.Background(), session)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"test-grp1", "test-grp2"}, groups)
+}
+
+func TestKeycloakProviderRedeemNonce(t *testing.T) {
+	claims := defaultIDToken
+	claims.Nonce = "nonce"
+	idToken, _ := newSignedTestIDToken(claims)
+
+	testCases := map[string]struct {
+		idToken       string
+		nonce         string
+		expectedError bool
+	}{
+		"Matching nonce": {
+			idToken:       idToken,
+			nonce:         "nonce",
+			expectedError: false,
+		},
+		"Mismatched nonce": {
+			idToken:       idToken,
+			nonce:         "other-nonce",
+			expectedError: true,
+		},
+		"No ID token": {
+			idToken:       "",
+			nonce:         "nonce",
+			expectedError: true,
+		},
+		"No ID token or nonce": {
+			idToken:       "",
+			nonce:         "",
+			expectedError: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(redeemTokenResponse{
+				AccessToken: accessToken,
+				ExpiresIn:   10,
+				TokenType:   "Bearer",
+				IDToken:     tc.idToken,
+			})
+			serverURL, server := newOIDCServer(body)
+			defer server.Close()
+
+			p := testKeycloakProvider(serverURL.Host, "")
+			session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "", tc.nonce)
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Nil(t, session)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, accessToken, session.AccessToken)
+				assert.Equal(t, tc.idToken, session.IDToken)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
 	"gopkg.in/square/go-jose.v2"
 )
@@ -23,16 +24,13 @@ import (
 type LoginGovProvider struct {
 	*ProviderData
 
-	// TODO (@timothy-spencer): Ideally, the nonce would be in the session state, but the session state
-	// is created only upon code redemption, not during the auth, when this must be supplied.
-	Nonce     string
 	JWTKey    *rsa.PrivateKey
 	PubJWKURL *url.URL
 }
 
 var _ Provider = (*LoginGovProvider)(nil)
 
-// For generating a nonce
+// For generating a JWT ID
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func randSeq(n int) string {
@@ -86,7 +84,6 @@ func NewLoginGovProvider(p *ProviderData) *LoginGovProvider {
 	})
 	return &LoginGovProvider{
 		ProviderData: p,
-		Nonce:        randSeq(32),
 	}
 }
 
@@ -104,7 +101,7 @@ type loginGovCustomClaims struct {
 }
 
 // checkNonce checks the nonce in the id_token
-func checkNonce(idToken string, p *LoginGovProvider) (err error) {
+func checkNonce(idToken, nonce string, p *LoginGovProvider) (err error) {
 	token, err := jwt.ParseWithClaims(idToken, &loginGovCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		resp, myerr := http.Get(p.PubJWKURL.String())
 		if myerr != nil {
@@ -134,11 +131,7 @@ func checkNonce(idToken string, p *LoginGovProvider) (err error) {
 	}
 
 	claims := token.Claims.(*loginGovCustomClaims)
-	if claims.Nonce != p.Nonce {
-		err = fmt.Errorf("nonce validation failed")
-		return
-	}
-	return
+	return verifyNonce(nonce, claims.Nonce)
 }
 
 func emailFromUserInfo(ctx context.Context, accessToken string, userInfoEndpoint string) (string, error) {
@@ -172,7 +165,7 @@ func emailFromUserInfo(ctx context.Context, accessToken string, userInfoEndpoint
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *LoginGovProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *LoginGovProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (s *sessions.SessionState, err error) {
 	if code == "" {
 		err = errors.New("missing code")
 		return
@@ -196,6 +189,9 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, redirectURL, code string)
 	params.Add("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		params.Add("code_verifier", codeVerifier)
+	}
 
 	// Get the token from the body that we got from the token endpoint.
 	var jsonResponse struct {
@@ -216,7 +212,7 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, redirectURL, code string)
 	}
 
 	// check nonce here
-	err = checkNonce(jsonResponse.IDToken, p)
+	err = checkNonce(jsonResponse.IDToken, nonce, p)
 	if err != nil {
 		return
 	}
@@ -243,7 +239,7 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, redirectURL, code string)
 }
 
 // GetLoginURL overrides GetLoginURL to add login.gov parameters
-func (p *LoginGovProvider) GetLoginURL(redirectURI, state string) string {
+func (p *LoginGovProvider) GetLoginURL(redirectURI, state, codeChallenge, nonce string) string {
 	a := *p.LoginURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
@@ -252,12 +248,16 @@ func (p *LoginGovProvider) GetLoginURL(redirectURI, state string) string {
 	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
 	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", encryption.CodeChallengeMethodS256)
+	}
 	acr := p.AcrValues
 	if acr == "" {
 		acr = "http://idmanagement.gov/ns/assurance/loa/1"
 	}
 	params.Add("acr_values", acr)
-	params.Add("nonce", p.Nonce)
+	params.Add("nonce", nonce)
 	a.RawQuery = params.Encode()
 	return a.String()
 }
@@ -62,7 +62,6 @@ func newLoginGovProvider() (l *LoginGovProvider, serverKey *MyKeyData, err error
 			ValidateURL:  &url.URL{},
 			Scope:        ""})
 	l.JWTKey = privateKey
-	l.Nonce = "fakenonce"
 	return
 }
 
@@ -190,7 +189,7 @@ func TestLoginGovProviderSessionData(t *testing.T) {
 	p.PubJWKURL, pubjwkserver = newLoginGovServer(pubjwkbody)
 	defer pubjwkserver.Close()
 
-	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "", "fakenonce")
 	assert.NoError(t, err)
 	assert.NotEqual(t, session, nil)
 	assert.Equal(t, "timothy.spencer@gsa.gov", session.Email)
@@ -284,7 +283,7 @@ func TestLoginGovProviderBadNonce(t *testing.T) {
 	p.PubJWKURL, pubjwkserver = newLoginGovServer(pubjwkbody)
 	defer pubjwkserver.Close()
 
-	_, err = p.Redeem(context.Background(), "http://redirect/", "code1234")
+	_, err = p.Redeem(context.Background(), "http://redirect/", "code1234", "", "fakenonce")
 
 	// The "badfakenonce" in the idtoken above should cause this to error out
 	assert.Error(t, err)
@@ -11,10 +11,14 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
 )
 
-const emailClaim = "email"
+const (
+	emailClaim  = "email"
+	groupsClaim = "groups"
+)
 
 // OIDCProvider represents an OIDC based Identity Provider
 type OIDCProvider struct {
@@ -23,6 +27,11 @@ type OIDCProvider struct {
 	Verifier             *oidc.IDTokenVerifier
 	AllowUnverifiedEmail bool
 	UserIDClaim          string
+
+	// GroupsClaim is the name of the claim holding the groups of the user,
+	// or a dotted path to a nested claim. When it is empty, the groups claim
+	// of the ID Token is used without falling back to the userinfo endpoint.
+	GroupsClaim string
 }
 
 // NewOIDCProvider initiates a new OIDCProvider
@@ -34,7 +43,7 @@ func NewOIDCProvider(p *ProviderData) *OIDCProvider {
 var _ Provider = (*OIDCProvider)(nil)
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (s *sessions.SessionState, err error) {
 	clientSecret, err := p.GetClientSecret()
 	if err != nil {
 		return
@@ -43,12 +52,14 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (s
 	c := oauth2.Config{
 		ClientID:     p.ClientID,
 		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: p.RedeemURL.String(),
-		},
-		RedirectURL: redirectURL,
+		Endpoint:     p.tokenEndpoint(clientSecret),
+		RedirectURL:  redirectURL,
+	}
+	var opts []oauth2.AuthCodeOption
+	if codeVerifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 	}
-	token, err := c.Exchange(ctx, code)
+	token, err := c.Exchange(ctx, code, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange: %v", err)
 	}
@@ -60,6 +71,9 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (s
 	} else if idToken == nil {
 		return nil, fmt.Errorf("token response did not contain an id_token")
 	}
+	if err = verifyNonce(nonce, idToken.Nonce); err != nil {
+		return nil, fmt.Errorf("could not verify id_token: %v", err)
+	}
 
 	s, err = p.createSessionState(ctx, token, idToken)
 	if err != nil {
@@ -94,9 +108,7 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 	c := oauth2.Config{
 		ClientID:     p.ClientID,
 		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: p.RedeemURL.String(),
-		},
+		Endpoint:     p.tokenEndpoint(clientSecret),
 	}
 	t := &oauth2.Token{
 		RefreshToken: s.RefreshToken,
@@ -125,6 +137,9 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 		s.Email = newSession.Email
 		s.User = newSession.User
 		s.PreferredUsername = newSession.PreferredUsername
+		s.Groups = newSession.Groups
+		s.OIDCSessionID = newSession.OIDCSessionID
+		s.Claims = newSession.Claims
 	}
 
 	s.AccessToken = newSession.AccessToken
@@ -208,6 +223,9 @@ func (p *OIDCProvider) createSessionStateInternal(ctx context.Context, rawIDToke
 
 	newSession.User = claims.Subject
 	newSession.PreferredUsername = claims.PreferredUsername
+	newSession.Groups = claims.Groups
+	newSession.OIDCSessionID = claims.SessionID
+	newSession.Claims = claims.AdditionalClaims
 
 	verifyEmail := (p.UserIDClaim == emailClaim) && !p.AllowUnverifiedEmail
 	if verifyEmail && claims.Verified != nil && !*claims.Verified {
@@ -242,6 +260,9 @@ func (p *OIDCProvider) findClaimsFromIDToken(ctx context.Context, idToken *oidc.
 		return nil, fmt.Errorf("failed to parse all id_token claims: %v", err)
 	}
 
+	userInfo := &userInfoClaims{ctx: ctx, profileURL: profileURL, accessToken: accessToken}
+	claims.Groups = p.findGroups(claims.rawClaims, userInfo)
+
 	userID := claims.rawClaims[p.UserIDClaim]
 	if userID != nil {
 		claims.UserID = fmt.Sprint(userID)
@@ -273,13 +294,132 @@ func (p *OIDCProvider) findClaimsFromIDToken(ctx context.Context, idToken *oidc.
 		claims.UserID = userID
 	}
 
+	claims.AdditionalClaims = p.findAdditionalClaims(claims.rawClaims, userInfo)
+
 	return claims, nil
 }
 
+// userInfoClaims fetches the claims from the userinfo endpoint the first
+// time they are needed
+type userInfoClaims struct {
+	ctx         context.Context
+	profileURL  string
+	accessToken string
+	claims      map[string]interface{}
+}
+
+// get returns the claims from the userinfo endpoint. There are no claims
+// when the endpoint or the access token are unknown, or the request fails.
+func (u *userInfoClaims) get() map[string]interface{} {
+	if u.claims != nil {
+		return u.claims
+	}
+
+	u.claims = make(map[string]interface{})
+	if u.profileURL == "" || u.accessToken == "" {
+		return u.claims
+	}
+	err := requests.New(u.profileURL).
+		WithContext(u.ctx).
+		WithHeaders(getOIDCHeader(u.accessToken)).
+		Do().
+		UnmarshalInto(&u.claims)
+	if err != nil {
+		logger.Printf("unable to fetch claims from userinfo endpoint: %v", err)
+	}
+	return u.claims
+}
+
+// findGroups extracts the groups from the configured groups claim of the ID
+// Token, falling back to the userinfo endpoint when the claim is missing from
+// the ID Token. Without a configured claim, only the groups claim of the ID
+// Token is used so that every sign in doesn't call the userinfo endpoint.
+func (p *OIDCProvider) findGroups(rawClaims map[string]interface{}, userInfo *userInfoClaims) []string {
+	if p.GroupsClaim == "" {
+		value, _ := claimFromPath(rawClaims, groupsClaim)
+		return groupsFromClaim(value)
+	}
+
+	value, ok := claimFromPath(rawClaims, p.GroupsClaim)
+	if !ok {
+		value, _ = claimFromPath(userInfo.get(), p.GroupsClaim)
+	}
+	return groupsFromClaim(value)
+}
+
+// findAdditionalClaims copies the configured additional claims from the ID
+// Token claims, falling back to the userinfo endpoint for any claims missing
+// from the ID Token
+func (p *OIDCProvider) findAdditionalClaims(rawClaims map[string]interface{}, userInfo *userInfoClaims) map[string]interface{} {
+	if len(p.AdditionalClaims) == 0 {
+		return nil
+	}
+
+	claims := make(map[string]interface{})
+	for _, name := range p.AdditionalClaims {
+		if value, ok := rawClaims[name]; ok {
+			claims[name] = value
+			continue
+		}
+		if value, ok := userInfo.get()[name]; ok {
+			claims[name] = value
+		}
+	}
+
+	if len(claims) == 0 {
+		return nil
+	}
+	return claims
+}
+
+// claimFromPath looks up a claim by its name or, when there is no claim with
+// that name, by a dotted path through nested claims, eg. realm_access.roles
+func claimFromPath(claims map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := claims[path]; ok {
+		return value, true
+	}
+
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = nested[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// groupsFromClaim converts a groups claim to a list of group names. The claim
+// may be a single string or an array of strings.
+func groupsFromClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, group := range v {
+			groups = append(groups, fmt.Sprint(group))
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
 type OIDCClaims struct {
 	rawClaims         map[string]interface{}
 	UserID            string
-	Subject           string `json:"sub"`
-	Verified          *bool  `json:"email_verified"`
-	PreferredUsername string `json:"preferred_username"`
+	Groups            []string               `json:"-"`
+	AdditionalClaims  map[string]interface{} `json:"-"`
+	Subject           string                 `json:"sub"`
+	SessionID         string                 `json:"sid"`
+	Verified          *bool                  `json:"email_verified"`
+	PreferredUsername string                 `json:"preferred_username"`
 }
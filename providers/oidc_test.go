@@ -29,10 +29,12 @@ const clientID = "https://test.myapp.com"
 const secret = "secret"
 
 type idTokenClaims struct {
-	Name    string `json:"name,omitempty"`
-	Email   string `json:"email,omitempty"`
-	Phone   string `json:"phone_number,omitempty"`
-	Picture string `json:"picture,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	Phone   string   `json:"phone_number,omitempty"`
+	Picture string   `json:"picture,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Nonce   string   `json:"nonce,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -49,6 +51,8 @@ var defaultIDToken idTokenClaims = idTokenClaims{
 	"janed@me.com",
 	"+4798765432",
 	"http://mugbook.com/janed/me.jpg",
+	[]string{"test:a", "test:b"},
+	"",
 	jwt.StandardClaims{
 		Audience:  "https://test.myapp.com",
 		ExpiresAt: time.Now().Add(time.Duration(5) * time.Minute).Unix(),
@@ -158,13 +162,14 @@ func TestOIDCProviderRedeem(t *testing.T) {
 	server, provider := newTestSetup(body)
 	defer server.Close()
 
-	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "", "")
 	assert.Equal(t, nil, err)
 	assert.Equal(t, defaultIDToken.Email, session.Email)
 	assert.Equal(t, accessToken, session.AccessToken)
 	assert.Equal(t, idToken, session.IDToken)
 	assert.Equal(t, refreshToken, session.RefreshToken)
 	assert.Equal(t, "123456789", session.User)
+	assert.Equal(t, []string{"test:a", "test:b"}, session.Groups)
 }
 
 func TestOIDCProviderRedeem_custom_userid(t *testing.T) {
@@ -182,11 +187,273 @@ func TestOIDCProviderRedeem_custom_userid(t *testing.T) {
 	provider.UserIDClaim = "phone_number"
 	defer server.Close()
 
-	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "", "")
 	assert.Equal(t, nil, err)
 	assert.Equal(t, defaultIDToken.Phone, session.Email)
 }
 
+func TestOIDCProviderRedeem_additionalClaims(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("content-type", "application/json")
+		if r.URL.Path == "/profile" {
+			_, _ = rw.Write([]byte(`{"department": "sales", "phone_number": "+4711111111"}`))
+			return
+		}
+		_, _ = rw.Write(body)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	provider := newOIDCProvider(serverURL)
+	provider.AdditionalClaims = []string{"phone_number", "department", "missing"}
+
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"phone_number": defaultIDToken.Phone,
+		"department":   "sales",
+	}, session.Claims)
+}
+
+func TestOIDCProviderRedeem_groupsClaim(t *testing.T) {
+	testCases := map[string]struct {
+		groupsClaim    string
+		claims         jwt.MapClaims
+		userInfo       string
+		expectedGroups []string
+	}{
+		"Default groups claim": {
+			groupsClaim:    "",
+			claims:         jwt.MapClaims{"groups": []string{"admins", "developers"}},
+			expectedGroups: []string{"admins", "developers"},
+		},
+		"Custom groups claim": {
+			groupsClaim:    "roles",
+			claims:         jwt.MapClaims{"roles": []string{"admin"}},
+			expectedGroups: []string{"admin"},
+		},
+		"String groups claim": {
+			groupsClaim:    "role",
+			claims:         jwt.MapClaims{"role": "admin"},
+			expectedGroups: []string{"admin"},
+		},
+		"Nested groups claim": {
+			groupsClaim: "realm_access.roles",
+			claims: jwt.MapClaims{"realm_access": map[string]interface{}{
+				"roles": []string{"offline_access", "admin"},
+			}},
+			expectedGroups: []string{"offline_access", "admin"},
+		},
+		"Deeply nested groups claim": {
+			groupsClaim: "resource_access.oauth2-proxy.roles",
+			claims: jwt.MapClaims{"resource_access": map[string]interface{}{
+				"oauth2-proxy": map[string]interface{}{"roles": []string{"editor"}},
+				"other":        map[string]interface{}{"roles": []string{"viewer"}},
+			}},
+			expectedGroups: []string{"editor"},
+		},
+		"Groups claim with dots in its name": {
+			groupsClaim:    "https://example.com/groups",
+			claims:         jwt.MapClaims{"https://example.com/groups": []string{"admins"}},
+			expectedGroups: []string{"admins"},
+		},
+		"Default groups claim missing from the id_token": {
+			groupsClaim:    "",
+			claims:         jwt.MapClaims{},
+			userInfo:       `{"groups": ["admins"]}`,
+			expectedGroups: nil,
+		},
+		"Explicit groups claim from the userinfo endpoint": {
+			groupsClaim:    "groups",
+			claims:         jwt.MapClaims{},
+			userInfo:       `{"groups": ["admins"]}`,
+			expectedGroups: []string{"admins"},
+		},
+		"Groups claim from the userinfo endpoint": {
+			groupsClaim:    "realm_access.roles",
+			claims:         jwt.MapClaims{},
+			userInfo:       `{"realm_access": {"roles": ["admin"]}}`,
+			expectedGroups: []string{"admin"},
+		},
+		"Missing groups claim": {
+			groupsClaim:    "realm_access.roles",
+			claims:         jwt.MapClaims{"realm_access": "admin"},
+			userInfo:       `{"email": "janed@me.com"}`,
+			expectedGroups: nil,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			claims := jwt.MapClaims{
+				"email": defaultIDToken.Email,
+				"sub":   defaultIDToken.Subject,
+				"aud":   defaultIDToken.Audience,
+				"iss":   defaultIDToken.Issuer,
+				"exp":   defaultIDToken.ExpiresAt,
+			}
+			for name, value := range tc.claims {
+				claims[name] = value
+			}
+			key, _ := rsa.GenerateKey(rand.Reader, 2048)
+			idToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+			assert.NoError(t, err)
+			body, _ := json.Marshal(redeemTokenResponse{
+				AccessToken:  accessToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+				IDToken:      idToken,
+			})
+
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Add("content-type", "application/json")
+				if r.URL.Path == "/profile" {
+					_, _ = rw.Write([]byte(tc.userInfo))
+					return
+				}
+				_, _ = rw.Write(body)
+			}))
+			defer server.Close()
+			serverURL, _ := url.Parse(server.URL)
+
+			provider := newOIDCProvider(serverURL)
+			provider.GroupsClaim = tc.groupsClaim
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "", "")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedGroups, session.Groups)
+		})
+	}
+}
+
+func TestOIDCProviderRedeemNonce(t *testing.T) {
+	testCases := map[string]struct {
+		tokenNonce    string
+		expectedError bool
+	}{
+		"Matching nonce": {
+			tokenNonce:    "nonce",
+			expectedError: false,
+		},
+		"Mismatched nonce": {
+			tokenNonce:    "other-nonce",
+			expectedError: true,
+		},
+		"Missing nonce": {
+			tokenNonce:    "",
+			expectedError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			claims := defaultIDToken
+			claims.Nonce = tc.tokenNonce
+			idToken, _ := newSignedTestIDToken(claims)
+			body, _ := json.Marshal(redeemTokenResponse{
+				AccessToken:  accessToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+				IDToken:      idToken,
+			})
+
+			server, provider := newTestSetup(body)
+			defer server.Close()
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "", "nonce")
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Nil(t, session)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, idToken, session.IDToken)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderRedeemPublicClient(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	var forms []url.Values
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		forms = append(forms, r.PostForm)
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		rw.Header().Add("content-type", "application/json")
+		_, _ = rw.Write(body)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	provider := newOIDCProvider(serverURL)
+	provider.ClientSecret = ""
+
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "verifier", "")
+	assert.NoError(t, err)
+	assert.Equal(t, idToken, session.IDToken)
+
+	err = provider.redeemRefreshToken(context.Background(), session)
+	assert.NoError(t, err)
+
+	if assert.Len(t, forms, 2) {
+		assert.Equal(t, "verifier", forms[0].Get("code_verifier"))
+		for i, form := range forms {
+			assert.Equal(t, clientID, form.Get("client_id"))
+			assert.NotContains(t, form, "client_secret")
+			assert.Equal(t, "", authorizations[i])
+		}
+	}
+}
+
+func TestOIDCProviderGroupsFromClaim(t *testing.T) {
+	testCases := map[string]struct {
+		claim    interface{}
+		expected []string
+	}{
+		"Missing claim": {
+			claim:    nil,
+			expected: nil,
+		},
+		"Single string": {
+			claim:    "admins",
+			expected: []string{"admins"},
+		},
+		"Array of strings": {
+			claim:    []interface{}{"admins", "developers"},
+			expected: []string{"admins", "developers"},
+		},
+		"Unsupported type": {
+			claim:    map[string]interface{}{"admins": true},
+			expected: nil,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, groupsFromClaim(tc.claim))
+		})
+	}
+}
+
 func TestOIDCProviderRefreshSessionIfNeededWithoutIdToken(t *testing.T) {
 
 	idToken, _ := newSignedTestIDToken(defaultIDToken)
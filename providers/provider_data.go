@@ -6,17 +6,23 @@ import (
 	"net/url"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"golang.org/x/oauth2"
 )
 
 // ProviderData contains information required to configure all implementations
 // of OAuth2 providers
 type ProviderData struct {
+	// ID identifies the provider when more than one is configured, it is
+	// recorded in the sessions the provider creates
+	ID                string
 	ProviderName      string
 	LoginURL          *url.URL
 	RedeemURL         *url.URL
+	LogoutURL         *url.URL
 	ProfileURL        *url.URL
 	ProtectedResource *url.URL
 	ValidateURL       *url.URL
+	IntrospectionURL  *url.URL
 	// Auth request params & related, see
 	//https://openid.net/specs/openid-connect-basic-1_0.html#rfc.section.2.1.1.1
 	AcrValues        string
@@ -26,6 +32,10 @@ type ProviderData struct {
 	ClientSecretFile string
 	Scope            string
 	Prompt           string
+
+	// AdditionalClaims are the names of claims, beyond those the provider
+	// uses itself, to be kept in the session
+	AdditionalClaims []string
 }
 
 // Data returns the ProviderData
@@ -45,6 +55,27 @@ func (p *ProviderData) GetClientSecret() (clientSecret string, err error) {
 	return string(fileClientSecret), nil
 }
 
+// tokenEndpoint returns the oauth2.Endpoint of the RedeemURL. Public clients
+// have no secret, so their client_id is sent in the request body and no
+// client_secret is sent.
+func (p *ProviderData) tokenEndpoint(clientSecret string) oauth2.Endpoint {
+	endpoint := oauth2.Endpoint{
+		TokenURL: p.RedeemURL.String(),
+	}
+	if clientSecret == "" {
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+	return endpoint
+}
+
+// addClientSecret adds the client_secret to the params of a token request,
+// unless the client is a public client without one
+func addClientSecret(params url.Values, clientSecret string) {
+	if clientSecret != "" {
+		params.Add("client_secret", clientSecret)
+	}
+}
+
 type providerDefaults struct {
 	name        string
 	loginURL    *url.URL
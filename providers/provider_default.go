@@ -3,21 +3,25 @@ package providers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/coreos/go-oidc"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
 )
 
 var _ Provider = (*ProviderData)(nil)
 
 // Redeem provides a default implementation of the OAuth2 token redemption process
-func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (s *sessions.SessionState, err error) {
 	if code == "" {
 		err = errors.New("missing code")
 		return
@@ -30,9 +34,12 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
 	params.Add("client_id", p.ClientID)
-	params.Add("client_secret", clientSecret)
+	addClientSecret(params, clientSecret)
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		params.Add("code_verifier", codeVerifier)
+	}
 	if p.ProtectedResource != nil && p.ProtectedResource.String() != "" {
 		params.Add("resource", p.ProtectedResource.String())
 	}
@@ -49,13 +56,19 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s
 
 	// blindly try json and x-www-form-urlencoded
 	var jsonResponse struct {
-		AccessToken string `json:"access_token"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		IDToken      string `json:"id_token"`
 	}
 	err = result.UnmarshalInto(&jsonResponse)
 	if err == nil {
 		s = &sessions.SessionState{
-			AccessToken: jsonResponse.AccessToken,
+			AccessToken:  jsonResponse.AccessToken,
+			IDToken:      jsonResponse.IDToken,
+			RefreshToken: jsonResponse.RefreshToken,
 		}
+		s.CreatedAt, s.ExpiresOn = tokenTimes(jsonResponse.ExpiresIn)
 		return
 	}
 
@@ -65,16 +78,29 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s
 		return
 	}
 	if a := v.Get("access_token"); a != "" {
-		created := time.Now()
-		s = &sessions.SessionState{AccessToken: a, CreatedAt: &created}
+		s = &sessions.SessionState{AccessToken: a, RefreshToken: v.Get("refresh_token")}
+		expiresIn, _ := strconv.ParseInt(v.Get("expires_in"), 10, 64)
+		s.CreatedAt, s.ExpiresOn = tokenTimes(expiresIn)
 	} else {
 		err = fmt.Errorf("no access token found %s", result.Body())
 	}
 	return
 }
 
+// tokenTimes returns the time a token was created, now, and when it expires
+// from its expires_in lifetime in seconds. Tokens without a lifetime have no
+// expiry.
+func tokenTimes(expiresIn int64) (*time.Time, *time.Time) {
+	created := time.Now()
+	if expiresIn <= 0 {
+		return &created, nil
+	}
+	expires := created.Add(time.Duration(expiresIn) * time.Second).Truncate(time.Second)
+	return &created, &expires
+}
+
 // GetLoginURL with typical oauth parameters
-func (p *ProviderData) GetLoginURL(redirectURI, state string) string {
+func (p *ProviderData) GetLoginURL(redirectURI, state, codeChallenge, nonce string) string {
 	a := *p.LoginURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
@@ -90,6 +116,32 @@ func (p *ProviderData) GetLoginURL(redirectURI, state string) string {
 	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
 	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", encryption.CodeChallengeMethodS256)
+	}
+	if nonce != "" {
+		params.Set("nonce", nonce)
+	}
+	a.RawQuery = params.Encode()
+	return a.String()
+}
+
+// GetLogoutURL with typical OIDC RP-initiated logout parameters. Returns an
+// empty string when no logout URL is configured.
+func (p *ProviderData) GetLogoutURL(s *sessions.SessionState, postLogoutRedirectURI string) string {
+	if p.LogoutURL == nil || p.LogoutURL.String() == "" {
+		return ""
+	}
+
+	a := *p.LogoutURL
+	params, _ := url.ParseQuery(a.RawQuery)
+	if s != nil && s.IDToken != "" {
+		params.Set("id_token_hint", s.IDToken)
+	}
+	if postLogoutRedirectURI != "" {
+		params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
 	a.RawQuery = params.Encode()
 	return a.String()
 }
@@ -109,6 +161,11 @@ func (p *ProviderData) GetPreferredUsername(ctx context.Context, s *sessions.Ses
 	return "", errors.New("not implemented")
 }
 
+// GetGroups returns the groups the Account is a member of
+func (p *ProviderData) GetGroups(ctx context.Context, s *sessions.SessionState) ([]string, error) {
+	return nil, errors.New("not implemented")
+}
+
 // ValidateGroup validates that the provided email exists in the configured provider
 // email group(s).
 func (p *ProviderData) ValidateGroup(email string) bool {
@@ -120,10 +177,99 @@ func (p *ProviderData) ValidateSessionState(ctx context.Context, s *sessions.Ses
 	return validateToken(ctx, p, s.AccessToken, nil)
 }
 
-// RefreshSessionIfNeeded should refresh the user's session if required and
-// do nothing if a refresh is not required
+// RefreshSessionIfNeeded checks if the session has expired and uses the
+// RefreshToken to fetch a new access token if required
 func (p *ProviderData) RefreshSessionIfNeeded(ctx context.Context, s *sessions.SessionState) (bool, error) {
-	return false, nil
+	if s == nil || (s.ExpiresOn != nil && s.ExpiresOn.After(time.Now())) || s.RefreshToken == "" {
+		return false, nil
+	}
+
+	origExpiration := s.ExpiresOn
+	err := p.redeemRefreshToken(ctx, s)
+	if err != nil {
+		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+	}
+
+	logger.Printf("refreshed access token %s (expired on %s)", s, origExpiration)
+	return true, nil
+}
+
+// redeemRefreshToken uses the refresh_token grant to fetch a new access token
+// and updates the session with it
+func (p *ProviderData) redeemRefreshToken(ctx context.Context, s *sessions.SessionState) error {
+	_, err := p.redeemRefreshTokenWith(ctx, s, expiresInTokenTimes)
+	return err
+}
+
+// refreshedTokenTimes returns the CreatedAt and ExpiresOn of a session from
+// the body of a token response, for providers that report the lifetime of
+// tokens differently
+type refreshedTokenTimes func(body []byte) (created, expires *time.Time, err error)
+
+// expiresInTokenTimes returns the session times from the standard expires_in
+// field of a token response
+func expiresInTokenTimes(body []byte) (*time.Time, *time.Time, error) {
+	var jsonResponse struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &jsonResponse); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling expires_in: %v", err)
+	}
+	created, expires := tokenTimes(jsonResponse.ExpiresIn)
+	return created, expires, nil
+}
+
+// redeemRefreshTokenWith uses the refresh_token grant to fetch a new access
+// token and updates the session with it, setting its times with the
+// refreshedTokenTimes. It reports whether a new ID token was returned.
+func (p *ProviderData) redeemRefreshTokenWith(ctx context.Context, s *sessions.SessionState, times refreshedTokenTimes) (bool, error) {
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return false, err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", p.ClientID)
+	addClientSecret(params, clientSecret)
+	params.Add("refresh_token", s.RefreshToken)
+	params.Add("grant_type", "refresh_token")
+	if p.ProtectedResource != nil && p.ProtectedResource.String() != "" {
+		params.Add("resource", p.ProtectedResource.String())
+	}
+
+	var jsonResponse struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+	}
+	result := requests.New(p.RedeemURL.String()).
+		WithContext(ctx).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		Do()
+	err = result.UnmarshalInto(&jsonResponse)
+	if err != nil {
+		return false, err
+	}
+	if jsonResponse.AccessToken == "" {
+		return false, errors.New("no access token in the token response")
+	}
+	created, expires, err := times(result.Body())
+	if err != nil {
+		return false, err
+	}
+
+	s.AccessToken = jsonResponse.AccessToken
+	s.CreatedAt, s.ExpiresOn = created, expires
+	if jsonResponse.IDToken != "" {
+		s.IDToken = jsonResponse.IDToken
+	}
+	// The refresh token is only returned when the provider rotates it
+	if jsonResponse.RefreshToken != "" {
+		s.RefreshToken = jsonResponse.RefreshToken
+	}
+	return jsonResponse.IDToken != "", nil
 }
 
 // CreateSessionStateFromBearerToken should be implemented to allow providers
@@ -2,6 +2,8 @@ package providers
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -21,6 +23,124 @@ func TestRefresh(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestRefreshSessionIfNeeded(t *testing.T) {
+	testCases := map[string]struct {
+		response             string
+		expectedError        bool
+		expectedRefreshToken string
+		expectedIDToken      string
+	}{
+		"With a rotated refresh token": {
+			response:             `{"access_token": "new_access_token", "refresh_token": "new_refresh_token", "expires_in": 3600}`,
+			expectedRefreshToken: "new_refresh_token",
+			expectedIDToken:      "id_token",
+		},
+		"Without a rotated refresh token": {
+			response:             `{"access_token": "new_access_token", "expires_in": 3600, "id_token": "new_id_token"}`,
+			expectedRefreshToken: "refresh_token",
+			expectedIDToken:      "new_id_token",
+		},
+		"Without an access token": {
+			response:      `{"expires_in": 3600}`,
+			expectedError: true,
+		},
+		"With an invalid response": {
+			response:      `{"error": "invalid_grant"`,
+			expectedError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			var form url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, r.ParseForm())
+				form = r.PostForm
+				w.Header().Set("Content-Type", "application/json")
+				_, err := w.Write([]byte(tc.response))
+				assert.NoError(t, err)
+			}))
+			defer server.Close()
+
+			redeemURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			p := &ProviderData{
+				ClientID:     "client_id",
+				ClientSecret: "secret",
+				RedeemURL:    redeemURL,
+			}
+
+			expired := time.Now().Add(-time.Minute)
+			session := &sessions.SessionState{
+				AccessToken:  "access_token",
+				IDToken:      "id_token",
+				RefreshToken: "refresh_token",
+				ExpiresOn:    &expired,
+			}
+			refreshed, err := p.RefreshSessionIfNeeded(context.Background(), session)
+			assert.Equal(t, "refresh_token", form.Get("grant_type"))
+			assert.Equal(t, "refresh_token", form.Get("refresh_token"))
+			assert.Equal(t, "client_id", form.Get("client_id"))
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.False(t, refreshed)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, refreshed)
+			assert.Equal(t, "new_access_token", session.AccessToken)
+			assert.Equal(t, tc.expectedRefreshToken, session.RefreshToken)
+			assert.Equal(t, tc.expectedIDToken, session.IDToken)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), *session.ExpiresOn, 5*time.Second)
+		})
+	}
+}
+
+func TestRedeemRefreshToken(t *testing.T) {
+	testCases := map[string]struct {
+		contentType string
+		response    string
+		expiresIn   time.Duration
+	}{
+		"JSON response": {
+			contentType: "application/json",
+			response:    `{"access_token": "access_token", "refresh_token": "refresh_token", "expires_in": 3600}`,
+			expiresIn:   time.Hour,
+		},
+		"Form encoded response": {
+			contentType: "application/x-www-form-urlencoded",
+			response:    "access_token=access_token&refresh_token=refresh_token&expires_in=1800",
+			expiresIn:   30 * time.Minute,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				_, err := w.Write([]byte(tc.response))
+				assert.NoError(t, err)
+			}))
+			defer server.Close()
+
+			redeemURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			p := &ProviderData{
+				RedeemURL:    redeemURL,
+				ClientSecret: "secret",
+			}
+
+			session, err := p.Redeem(context.Background(), "https://my.test.app/oauth", "code", "", "")
+			assert.NoError(t, err)
+			assert.Equal(t, "access_token", session.AccessToken)
+			assert.Equal(t, "refresh_token", session.RefreshToken)
+			assert.NotNil(t, session.CreatedAt)
+			assert.WithinDuration(t, time.Now().Add(tc.expiresIn), *session.ExpiresOn, 5*time.Second)
+		})
+	}
+}
+
 func TestAcrValuesNotConfigured(t *testing.T) {
 	p := &ProviderData{
 		LoginURL: &url.URL{
@@ -30,7 +150,7 @@ func TestAcrValuesNotConfigured(t *testing.T) {
 		},
 	}
 
-	result := p.GetLoginURL("https://my.test.app/oauth", "")
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "", "")
 	assert.NotContains(t, result, "acr_values")
 }
 
@@ -44,6 +164,136 @@ func TestAcrValuesConfigured(t *testing.T) {
 		AcrValues: "testValue",
 	}
 
-	result := p.GetLoginURL("https://my.test.app/oauth", "")
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "", "")
 	assert.Contains(t, result, "acr_values=testValue")
 }
+
+func TestCodeChallengeNotConfigured(t *testing.T) {
+	p := &ProviderData{
+		LoginURL: &url.URL{
+			Scheme: "http",
+			Host:   "my.test.idp",
+			Path:   "/oauth/authorize",
+		},
+	}
+
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "", "")
+	assert.NotContains(t, result, "code_challenge")
+}
+
+func TestCodeChallengeConfigured(t *testing.T) {
+	p := &ProviderData{
+		LoginURL: &url.URL{
+			Scheme: "http",
+			Host:   "my.test.idp",
+			Path:   "/oauth/authorize",
+		},
+	}
+
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "challenge", "")
+	assert.Contains(t, result, "code_challenge=challenge")
+	assert.Contains(t, result, "code_challenge_method=S256")
+}
+
+func TestNonceConfigured(t *testing.T) {
+	p := &ProviderData{
+		LoginURL: &url.URL{
+			Scheme: "http",
+			Host:   "my.test.idp",
+			Path:   "/oauth/authorize",
+		},
+	}
+
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "", "")
+	assert.NotContains(t, result, "nonce")
+
+	result = p.GetLoginURL("https://my.test.app/oauth", "", "", "testNonce")
+	assert.Contains(t, result, "nonce=testNonce")
+}
+
+func TestRedeemCodeVerifier(t *testing.T) {
+	var codeVerifier string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		codeVerifier = r.PostForm.Get("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"access_token": "access_token"}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		RedeemURL:    redeemURL,
+		ClientSecret: "secret",
+	}
+
+	session, err := p.Redeem(context.Background(), "https://my.test.app/oauth", "code", "verifier", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "access_token", session.AccessToken)
+	assert.Equal(t, "verifier", codeVerifier)
+}
+
+func TestRedeemPublicClient(t *testing.T) {
+	var forms []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		forms = append(forms, r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"access_token": "access_token", "refresh_token": "refresh_token", "expires_in": 3600}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	p := &ProviderData{
+		ClientID:  "public-client",
+		RedeemURL: redeemURL,
+	}
+
+	session, err := p.Redeem(context.Background(), "https://my.test.app/oauth", "code", "verifier", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "access_token", session.AccessToken)
+
+	expired := time.Now().Add(-time.Minute)
+	session.ExpiresOn = &expired
+	refreshed, err := p.RefreshSessionIfNeeded(context.Background(), session)
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+
+	if assert.Len(t, forms, 2) {
+		assert.Equal(t, "authorization_code", forms[0].Get("grant_type"))
+		assert.Equal(t, "verifier", forms[0].Get("code_verifier"))
+		assert.Equal(t, "refresh_token", forms[1].Get("grant_type"))
+		for _, form := range forms {
+			assert.Equal(t, "public-client", form.Get("client_id"))
+			assert.NotContains(t, form, "client_secret")
+		}
+	}
+}
+
+func TestLogoutURLNotConfigured(t *testing.T) {
+	p := &ProviderData{}
+
+	result := p.GetLogoutURL(&sessions.SessionState{IDToken: "id_token"}, "https://my.test.app/")
+	assert.Equal(t, "", result)
+}
+
+func TestLogoutURLConfigured(t *testing.T) {
+	p := &ProviderData{
+		LogoutURL: &url.URL{
+			Scheme:   "http",
+			Host:     "my.test.idp",
+			Path:     "/oauth/logout",
+			RawQuery: "client=app",
+		},
+	}
+
+	result := p.GetLogoutURL(&sessions.SessionState{IDToken: "id_token"}, "https://my.test.app/")
+	assert.Equal(t, "http://my.test.idp/oauth/logout?client=app&id_token_hint=id_token&post_logout_redirect_uri=https%3A%2F%2Fmy.test.app%2F", result)
+
+	result = p.GetLogoutURL(nil, "")
+	assert.Equal(t, "http://my.test.idp/oauth/logout?client=app", result)
+}
@@ -13,10 +13,12 @@ type Provider interface {
 	GetEmailAddress(ctx context.Context, s *sessions.SessionState) (string, error)
 	GetUserName(ctx context.Context, s *sessions.SessionState) (string, error)
 	GetPreferredUsername(ctx context.Context, s *sessions.SessionState) (string, error)
-	Redeem(ctx context.Context, redirectURI, code string) (*sessions.SessionState, error)
+	GetGroups(ctx context.Context, s *sessions.SessionState) ([]string, error)
+	Redeem(ctx context.Context, redirectURI, code, codeVerifier, nonce string) (*sessions.SessionState, error)
 	ValidateGroup(string) bool
 	ValidateSessionState(ctx context.Context, s *sessions.SessionState) bool
-	GetLoginURL(redirectURI, finalRedirect string) string
+	GetLoginURL(redirectURI, finalRedirect, codeChallenge, nonce string) string
+	GetLogoutURL(s *sessions.SessionState, postLogoutRedirectURI string) string
 	RefreshSessionIfNeeded(ctx context.Context, s *sessions.SessionState) (bool, error)
 	CreateSessionStateFromBearerToken(ctx context.Context, rawIDToken string, idToken *oidc.IDToken) (*sessions.SessionState, error)
 }
@@ -46,6 +48,8 @@ func New(provider string, p *ProviderData) Provider {
 		return NewNextcloudProvider(p)
 	case "digitalocean":
 		return NewDigitalOceanProvider(p)
+	case "saml":
+		return NewSAMLProvider(p)
 	default:
 		return NewGoogleProvider(p)
 	}
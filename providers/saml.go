@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/saml"
+)
+
+// SAMLProvider signs users in with a SAML 2.0 IdP. The proxy is the service
+// provider identified by the ClientID, the redirect URL is its assertion
+// consumer service.
+type SAMLProvider struct {
+	*ProviderData
+
+	ServiceProvider *saml.ServiceProvider
+
+	// EmailAttribute, UserAttribute and GroupsAttribute are the assertion
+	// attributes the session is built from. The NameID is the user when
+	// UserAttribute is empty.
+	EmailAttribute  string
+	UserAttribute   string
+	GroupsAttribute string
+}
+
+var _ Provider = (*SAMLProvider)(nil)
+
+const (
+	samlProviderName = "SAML"
+
+	// samlEmailNameIDFormat is the NameID format of email addresses, the
+	// NameID is the email when the email attribute is missing
+	samlEmailNameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+// NewSAMLProvider initiates a new SAMLProvider
+func NewSAMLProvider(p *ProviderData) *SAMLProvider {
+	p.setProviderDefaults(providerDefaults{
+		name: samlProviderName,
+	})
+	return &SAMLProvider{ProviderData: p}
+}
+
+// Configure sets the metadata of the IdP users are signed in with
+func (p *SAMLProvider) Configure(idp *saml.IdPMetadata) {
+	p.ServiceProvider = saml.NewServiceProvider(p.ClientID, idp)
+}
+
+// samlRequestID derives the ID of the AuthnRequest from the nonce kept in the
+// CSRF cookie, so that responses are only accepted by the browser the sign in
+// was started from
+func samlRequestID(nonce string) string {
+	// IDs must not start with a digit
+	return "id-" + nonce
+}
+
+// GetLoginURL returns the IdP URL an AuthnRequest is sent to, with the state
+// as the RelayState
+func (p *SAMLProvider) GetLoginURL(redirectURI, state, _, nonce string) string {
+	loginURL, err := p.ServiceProvider.AuthnRequestURL(redirectURI, samlRequestID(nonce), state)
+	if err != nil {
+		logger.Printf("Error creating SAML AuthnRequest: %v", err)
+		return ""
+	}
+	return loginURL
+}
+
+// Redeem verifies the SAMLResponse posted to the redirect URL and creates a
+// session from its assertion
+func (p *SAMLProvider) Redeem(ctx context.Context, redirectURL, samlResponse, codeVerifier, nonce string) (*sessions.SessionState, error) {
+	assertion, err := p.ServiceProvider.ParseResponse(samlResponse, redirectURL, samlRequestID(nonce))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML response: %v", err)
+	}
+	return p.sessionFromAssertion(assertion)
+}
+
+// sessionFromAssertion maps the attributes of the assertion to the session
+func (p *SAMLProvider) sessionFromAssertion(assertion *saml.Assertion) (*sessions.SessionState, error) {
+	created := time.Now()
+	s := &sessions.SessionState{
+		CreatedAt: &created,
+		ExpiresOn: assertion.SessionNotOnOrAfter,
+		EndsAt:    assertion.SessionNotOnOrAfter,
+		Email:     firstValue(assertion.Attributes[p.EmailAttribute]),
+		User:      assertion.NameID,
+		Groups:    assertion.Attributes[p.GroupsAttribute],
+	}
+	if s.Email == "" && assertion.NameIDFormat == samlEmailNameIDFormat {
+		s.Email = assertion.NameID
+	}
+	if s.Email == "" {
+		return nil, fmt.Errorf("assertion does not have the %q attribute", p.EmailAttribute)
+	}
+	if p.UserAttribute != "" {
+		s.User = firstValue(assertion.Attributes[p.UserAttribute])
+		if s.User == "" {
+			return nil, fmt.Errorf("assertion does not have the %q attribute", p.UserAttribute)
+		}
+	}
+	return s, nil
+}
+
+// firstValue returns the first value of an attribute, if any
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// ValidateSessionState checks the session has not passed the end the IdP set
+// for it. Assertions can't be validated with the IdP again.
+func (p *SAMLProvider) ValidateSessionState(ctx context.Context, s *sessions.SessionState) bool {
+	return !s.IsExpired()
+}
@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+const samlTestACSURL = "https://proxy.example.com/oauth2/callback"
+
+func testSAMLProvider(t *testing.T) *SAMLProvider {
+	idp, err := saml.LoadIdPMetadata("../pkg/saml/testdata/idp_metadata.xml")
+	assert.NoError(t, err)
+
+	p := NewSAMLProvider(&ProviderData{ClientID: "oauth2-proxy"})
+	p.EmailAttribute = "email"
+	p.GroupsAttribute = "groups"
+	p.Configure(idp)
+	return p
+}
+
+func TestSAMLProviderDefaults(t *testing.T) {
+	p := testSAMLProvider(t)
+	assert.Equal(t, "SAML", p.Data().ProviderName)
+	assert.Equal(t, "oauth2-proxy", p.ServiceProvider.EntityID)
+}
+
+func TestSAMLProviderGetLoginURL(t *testing.T) {
+	p := testSAMLProvider(t)
+
+	loginURL, err := url.Parse(p.GetLoginURL(samlTestACSURL, "state", "", "0123abcd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https", loginURL.Scheme)
+	assert.Equal(t, "idp.example.com", loginURL.Host)
+	assert.Equal(t, "/sso/redirect", loginURL.Path)
+	assert.Equal(t, "state", loginURL.Query().Get("RelayState"))
+	assert.NotEmpty(t, loginURL.Query().Get("SAMLRequest"))
+}
+
+func TestSAMLProviderRedeem(t *testing.T) {
+	p := testSAMLProvider(t)
+	p.UserAttribute = "uid"
+
+	data, err := ioutil.ReadFile("../pkg/saml/testdata/response_signed_assertion.xml")
+	assert.NoError(t, err)
+	samlResponse := base64.StdEncoding.EncodeToString(data)
+
+	s, err := p.Redeem(context.Background(), samlTestACSURL, samlResponse, "", "request1")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", s.Email)
+	assert.Equal(t, "jdoe", s.User)
+	assert.Equal(t, []string{"admins", "developers & testers"}, s.Groups)
+	assert.Equal(t, time.Date(2120, 10, 1, 8, 0, 0, 0, time.UTC), *s.ExpiresOn)
+	assert.Equal(t, time.Date(2120, 10, 1, 8, 0, 0, 0, time.UTC), *s.EndsAt)
+	assert.NotNil(t, s.CreatedAt)
+	assert.True(t, p.ValidateSessionState(context.Background(), s))
+
+	// The nonce binds the response to the AuthnRequest
+	_, err = p.Redeem(context.Background(), samlTestACSURL, samlResponse, "", "request2")
+	assert.Error(t, err)
+}
+
+func TestSAMLProviderSessionFromAssertion(t *testing.T) {
+	testCases := map[string]struct {
+		userAttribute   string
+		assertion       *saml.Assertion
+		expectedSession *sessions.SessionState
+		expectedError   string
+	}{
+		"With the email and groups attributes": {
+			assertion: &saml.Assertion{
+				NameID:     "a1b2c3",
+				Attributes: map[string][]string{"email": {"jane.doe@example.com"}, "groups": {"admins"}},
+			},
+			expectedSession: &sessions.SessionState{Email: "jane.doe@example.com", User: "a1b2c3", Groups: []string{"admins"}},
+		},
+		"With an email address NameID": {
+			assertion: &saml.Assertion{
+				NameID:       "jane.doe@example.com",
+				NameIDFormat: "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+				Attributes:   map[string][]string{},
+			},
+			expectedSession: &sessions.SessionState{Email: "jane.doe@example.com", User: "jane.doe@example.com"},
+		},
+		"With a user attribute": {
+			userAttribute: "uid",
+			assertion: &saml.Assertion{
+				NameID:     "a1b2c3",
+				Attributes: map[string][]string{"email": {"jane.doe@example.com"}, "uid": {"jdoe"}},
+			},
+			expectedSession: &sessions.SessionState{Email: "jane.doe@example.com", User: "jdoe"},
+		},
+		"Without an email": {
+			assertion: &saml.Assertion{
+				NameID:     "a1b2c3",
+				Attributes: map[string][]string{},
+			},
+			expectedError: `assertion does not have the "email" attribute`,
+		},
+		"Without the user attribute": {
+			userAttribute: "uid",
+			assertion: &saml.Assertion{
+				NameID:     "a1b2c3",
+				Attributes: map[string][]string{"email": {"jane.doe@example.com"}},
+			},
+			expectedError: `assertion does not have the "uid" attribute`,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			p := testSAMLProvider(t)
+			p.UserAttribute = tc.userAttribute
+
+			s, err := p.sessionFromAssertion(tc.assertion)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			s.CreatedAt = nil
+			assert.Equal(t, tc.expectedSession, s)
+		})
+	}
+}
+
+func TestSAMLProviderValidateSessionState(t *testing.T) {
+	p := testSAMLProvider(t)
+
+	expired := time.Now().Add(-time.Minute)
+	assert.False(t, p.ValidateSessionState(context.Background(), &sessions.SessionState{ExpiresOn: &expired}))
+	assert.True(t, p.ValidateSessionState(context.Background(), &sessions.SessionState{}))
+}
@@ -121,7 +121,13 @@ func getTemplates() *template.Template {
 	{{ if .SignInMessage }}
 	<p>{{.SignInMessage}}</p>
 	{{ end}}
+	{{ if .Providers }}
+	{{ range .Providers }}
+	<button type="submit" class="btn" name="provider" value="{{.ID}}">Sign in with {{.Name}}</button><br/>
+	{{ end }}
+	{{ else }}
 	<button type="submit" class="btn">Sign in with {{.ProviderName}}</button><br/>
+	{{ end }}
 	</form>
 	</div>
 
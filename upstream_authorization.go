@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// upstreamRule holds the authorization requirements for a single upstream
+type upstreamRule struct {
+	emails   map[string]struct{}
+	domains  []string
+	allowAll bool
+	groups   map[string]struct{}
+}
+
+// upstreamAuthorizer matches requests to the upstream that will serve them
+// and checks sessions against the authorization rules of that upstream
+type upstreamAuthorizer struct {
+	// mux mirrors the path matching of the upstream proxy so that the rule
+	// for the most specific upstream path is applied
+	mux   *http.ServeMux
+	rules map[string]*upstreamRule
+}
+
+// newUpstreamAuthorizer builds the authorization rules for the given upstreams
+func newUpstreamAuthorizer(upstreams options.Upstreams) *upstreamAuthorizer {
+	a := &upstreamAuthorizer{
+		mux:   http.NewServeMux(),
+		rules: make(map[string]*upstreamRule),
+	}
+
+	for _, upstream := range upstreams {
+		a.mux.Handle(upstream.Path, http.NotFoundHandler())
+		if len(upstream.AllowedEmails) == 0 && len(upstream.AllowedEmailDomains) == 0 && len(upstream.AllowedGroups) == 0 {
+			continue
+		}
+		a.rules[upstream.Path] = newUpstreamRule(upstream)
+	}
+	return a
+}
+
+func newUpstreamRule(upstream options.Upstream) *upstreamRule {
+	rule := &upstreamRule{
+		emails: make(map[string]struct{}, len(upstream.AllowedEmails)),
+		groups: make(map[string]struct{}, len(upstream.AllowedGroups)),
+	}
+	for _, email := range upstream.AllowedEmails {
+		rule.emails[strings.ToLower(email)] = struct{}{}
+	}
+	for _, domain := range upstream.AllowedEmailDomains {
+		if domain == "*" {
+			rule.allowAll = true
+			continue
+		}
+		rule.domains = append(rule.domains, fmt.Sprintf("@%s", strings.ToLower(domain)))
+	}
+	for _, group := range upstream.AllowedGroups {
+		rule.groups[group] = struct{}{}
+	}
+	return rule
+}
+
+// IsAuthorized checks whether the session may access the upstream that
+// serves the request. Upstreams without rules allow all sessions.
+func (a *upstreamAuthorizer) IsAuthorized(req *http.Request, session *sessionsapi.SessionState) bool {
+	_, pattern := a.mux.Handler(req)
+	rule, ok := a.rules[pattern]
+	if !ok {
+		return true
+	}
+	return rule.validEmail(session.Email) && rule.validGroups(session.Groups)
+}
+
+// IsAuthorizedForOriginalRequest checks whether the session may access the
+// upstream that serves the original request of an auth subrequest, as given
+// by the X-Original-URI or X-Forwarded-Uri header. When any upstream has rules
+// and the original URI is missing or invalid, the session is not authorized.
+func (a *upstreamAuthorizer) IsAuthorizedForOriginalRequest(req *http.Request, session *sessionsapi.SessionState) bool {
+	if len(a.rules) == 0 {
+		return true
+	}
+
+	uri := originalURI(req)
+	if uri == "" {
+		return false
+	}
+	originalURL, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return false
+	}
+
+	original := *req
+	original.URL = originalURL
+	return a.IsAuthorized(&original, session)
+}
+
+// originalURI returns the URI of the request that triggered an auth subrequest
+func originalURI(req *http.Request) string {
+	if uri := req.Header.Get("X-Original-URI"); uri != "" {
+		return uri
+	}
+	return req.Header.Get("X-Forwarded-Uri")
+}
+
+// validEmail checks the email against the allowed emails and domains.
+// When neither are configured, all emails are valid.
+func (r *upstreamRule) validEmail(email string) bool {
+	if r.allowAll || (len(r.emails) == 0 && len(r.domains) == 0) {
+		return true
+	}
+	if email == "" {
+		return false
+	}
+
+	email = strings.ToLower(email)
+	if _, ok := r.emails[email]; ok {
+		return true
+	}
+	for _, domain := range r.domains {
+		if strings.HasSuffix(email, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// validGroups checks that at least one of the groups is allowed.
+// When no groups are configured, all sessions are valid.
+func (r *upstreamRule) validGroups(groups []string) bool {
+	if len(r.groups) == 0 {
+		return true
+	}
+	for _, group := range groups {
+		if _, ok := r.groups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
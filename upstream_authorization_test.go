@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamAuthorizer(t *testing.T) {
+	authorizer := newUpstreamAuthorizer(options.Upstreams{
+		{
+			ID:   "public",
+			Path: "/",
+		},
+		{
+			ID:                  "admin",
+			Path:                "/admin/",
+			AllowedEmails:       []string{"Admin@example.com"},
+			AllowedEmailDomains: []string{"admins.example.com"},
+		},
+		{
+			ID:            "admin-reports",
+			Path:          "/admin/reports/",
+			AllowedGroups: []string{"reporters"},
+		},
+		{
+			ID:                  "dashboard",
+			Path:                "/dashboard/",
+			AllowedEmailDomains: []string{"*"},
+			AllowedGroups:       []string{"viewers", "editors"},
+		},
+	})
+
+	testCases := map[string]struct {
+		path       string
+		session    *sessions.SessionState
+		authorized bool
+	}{
+		"Upstream without rules": {
+			path:       "/index.html",
+			session:    &sessions.SessionState{Email: "user@example.com"},
+			authorized: true,
+		},
+		"Allowed email": {
+			path:       "/admin/users",
+			session:    &sessions.SessionState{Email: "admin@example.com"},
+			authorized: true,
+		},
+		"Allowed email domain": {
+			path:       "/admin/users",
+			session:    &sessions.SessionState{Email: "jane@admins.example.com"},
+			authorized: true,
+		},
+		"Email not allowed": {
+			path:       "/admin/users",
+			session:    &sessions.SessionState{Email: "user@example.com"},
+			authorized: false,
+		},
+		"Missing email": {
+			path:       "/admin/users",
+			session:    &sessions.SessionState{User: "admin"},
+			authorized: false,
+		},
+		"Most specific path applies": {
+			path:       "/admin/reports/daily",
+			session:    &sessions.SessionState{Email: "user@example.com", Groups: []string{"reporters"}},
+			authorized: true,
+		},
+		"Most specific path denies": {
+			path:       "/admin/reports/daily",
+			session:    &sessions.SessionState{Email: "admin@example.com"},
+			authorized: false,
+		},
+		"Allowed group": {
+			path:       "/dashboard/",
+			session:    &sessions.SessionState{Email: "user@example.com", Groups: []string{"staff", "editors"}},
+			authorized: true,
+		},
+		"Group not allowed": {
+			path:       "/dashboard/",
+			session:    &sessions.SessionState{Email: "user@example.com", Groups: []string{"staff"}},
+			authorized: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tc.path, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.authorized, authorizer.IsAuthorized(req, tc.session))
+		})
+	}
+}